@@ -16,6 +16,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 	// Web interface
 	app.Run(&cfg)
 }
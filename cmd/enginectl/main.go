@@ -0,0 +1,165 @@
+// Command enginectl is the admin CLI of the orchestration engine.
+//
+// Usage:
+//
+//	enginectl dlq steps [-workspace id] [-workflow type] [-limit n]
+//	enginectl dlq events [-limit n]
+//	enginectl dlq event <event-id>
+//	enginectl dlq replay-step [-input json] [-reason text] <step-id>
+//	enginectl dlq discard-step [-reason text] <step-id>
+//	enginectl dlq replay-event [-payload json] [-reason text] <event-id>
+//	enginectl dlq discard-event [-reason text] <event-id>
+//	enginectl workflow validate <file-or-dir>...
+//
+// The database is read from DATABASE_URL (or .env). Replays and discards are
+// audited, in step_logs for steps and outbox_event_audits for events, with
+// the actor taken from -actor or $USER. Validating workflow definitions needs
+// no database.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/deadletter"
+	englogger "github.com/alpinesboltltd/boltz-ai/internal/engine/logger"
+	engstore "github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/joho/godotenv"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stdout and stderr receive command output; tests capture them.
+var stdout, stderr io.Writer = os.Stdout, os.Stderr
+
+func main() {
+	godotenv.Load(".env")
+	log.SetFlags(0)
+
+	if len(os.Args) < 3 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "workflow":
+		if err := workflow(os.Args[2], os.Args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "dlq":
+	default:
+		usage()
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	store := engstore.NewPostgresStore(db)
+	// failures to record an audit are reported on stderr
+	engine.SetLogger(englogger.New(os.Stderr, nil))
+
+	if err := dlq(context.Background(), store, os.Args[2], os.Args[3:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: enginectl dlq <steps|events|event|replay-step|discard-step|replay-event|discard-event> [flags] [id]")
+	fmt.Fprintln(os.Stderr, "       enginectl workflow validate <file-or-dir>...")
+	os.Exit(2)
+}
+
+func dlq(ctx context.Context, store engine.StateStore, cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	workspace := fs.String("workspace", "", "only steps of runs in this workspace")
+	workflowType := fs.String("workflow", "", "only steps of runs of this workflow type")
+	limit := fs.Int("limit", 50, "maximum number of entries to list")
+	input := fs.String("input", "", "replacement step input (JSON)")
+	payload := fs.String("payload", "", "replacement event payload (JSON)")
+	reason := fs.String("reason", "", "reason recorded in the audit log")
+	actor := fs.String("actor", "cli:"+os.Getenv("USER"), "actor recorded in the audit log")
+	fs.Parse(args)
+
+	filter := engine.DeadLetterFilter{WorkspaceID: *workspace, WorkflowType: *workflowType, Limit: *limit}
+	req := deadletter.Request{Actor: *actor, Reason: *reason}
+
+	switch cmd {
+	case "steps":
+		steps, total, err := store.ListDeadLetterSteps(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]interface{}{"steps": steps, "total": total})
+	case "events":
+		events, total, err := store.ListDeadLetterEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]interface{}{"events": events, "total": total})
+	case "event":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("event: an event ID is required")
+		}
+		ev, err := store.LoadEvent(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if ev == nil {
+			return deadletter.ErrNotFound
+		}
+		audits, err := store.ListEventAudits(ctx, ev.ID)
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]interface{}{"event": ev, "audits": audits})
+	case "replay-step":
+		req.Input = json.RawMessage(*input)
+		return resolve(fs, func(id string) error { return deadletter.ReplayStep(ctx, store, id, req) })
+	case "discard-step":
+		return resolve(fs, func(id string) error { return deadletter.DiscardStep(ctx, store, id, req) })
+	case "replay-event":
+		req.Input = json.RawMessage(*payload)
+		return resolve(fs, func(id string) error { return deadletter.ReplayEvent(ctx, store, id, req) })
+	case "discard-event":
+		return resolve(fs, func(id string) error { return deadletter.DiscardEvent(ctx, store, id, req) })
+	}
+	usage()
+	return nil
+}
+
+// resolve applies fn to every ID argument and reports each outcome.
+func resolve(fs *flag.FlagSet, fn func(id string) error) error {
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%s: at least one ID is required", fs.Name())
+	}
+	failed := 0
+	for _, id := range fs.Args() {
+		if err := fn(id); err != nil {
+			fmt.Fprintf(stderr, "%s %s: %v\n", fs.Name(), id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "%s %s: ok\n", fs.Name(), id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, fs.NArg())
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
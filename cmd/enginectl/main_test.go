@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	engstore "github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/google/uuid"
+)
+
+// capture redirects stdout and stderr to buffers until the test ends.
+func capture(t *testing.T) (out, errOut *bytes.Buffer) {
+	out, errOut = &bytes.Buffer{}, &bytes.Buffer{}
+	prevOut, prevErr := stdout, stderr
+	stdout, stderr = out, errOut
+	t.Cleanup(func() { stdout, stderr = prevOut, prevErr })
+	return out, errOut
+}
+
+func failedEvent(t *testing.T, s *engstore.MemoryStore) *engine.OutboxEvent {
+	t.Helper()
+	msg := "no recipient"
+	ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: []byte(`{"to":""}`), State: engine.OutboxStateFailed, Attempts: 1, Error: &msg}
+	if err := s.EnqueueEvent(context.Background(), ev); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return ev
+}
+
+func TestDLQEventCommands(t *testing.T) {
+	s := engstore.NewMemoryStore()
+	ctx := context.Background()
+	ev, other := failedEvent(t, s), failedEvent(t, s)
+	out, errOut := capture(t)
+
+	if err := dlq(ctx, s, "events", nil); err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	var listed struct {
+		Events []engine.OutboxEvent `json:"events"`
+		Total  int64                `json:"total"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &listed); err != nil || listed.Total != 2 {
+		t.Fatalf("events output = %s, %v", out, err)
+	}
+
+	out.Reset()
+	err := dlq(ctx, s, "replay-event", []string{"-actor", "cli:ops", "-reason", "fixed", "-payload", `{"to":"c@example.com"}`, ev.ID, uuid.NewString()})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 failed") {
+		t.Fatalf("replay with an unknown ID = %v", err)
+	}
+	if !strings.Contains(out.String(), "replay-event "+ev.ID+": ok") || !strings.Contains(errOut.String(), "not found") {
+		t.Fatalf("replay output = %q, errors = %q", out, errOut)
+	}
+
+	out.Reset()
+	if err := dlq(ctx, s, "event", []string{ev.ID}); err != nil {
+		t.Fatalf("event: %v", err)
+	}
+	var shown struct {
+		Event  engine.OutboxEvent  `json:"event"`
+		Audits []engine.EventAudit `json:"audits"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &shown); err != nil {
+		t.Fatalf("decode event output %s: %v", out, err)
+	}
+	var payload map[string]string
+	_ = json.Unmarshal(shown.Event.Payload, &payload)
+	if shown.Event.State != engine.OutboxStatePending || payload["to"] != "c@example.com" {
+		t.Fatalf("replayed event = %+v", shown.Event)
+	}
+	if len(shown.Audits) != 1 || shown.Audits[0].Message != "dead-letter replay" || !strings.Contains(string(shown.Audits[0].Meta), `"cli:ops"`) {
+		t.Fatalf("audits = %+v", shown.Audits)
+	}
+
+	if err := dlq(ctx, s, "discard-event", []string{other.ID}); err != nil {
+		t.Fatalf("discard-event: %v", err)
+	}
+	if got, _ := s.LoadEvent(ctx, other.ID); got.State != engine.OutboxStateDiscarded {
+		t.Fatalf("discarded event = %s", got.State)
+	}
+	if err := dlq(ctx, s, "event", []string{uuid.NewString()}); err == nil {
+		t.Fatal("event with an unknown ID succeeded")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/dsl"
+	"github.com/alpinesboltltd/boltz-ai/workflows/csr"
+)
+
+// workflow runs the workflow subcommands. validate checks declarative
+// definitions against the actions the server registers, so a definition
+// that passes here loads at startup.
+func workflow(cmd string, args []string) error {
+	if cmd != "validate" {
+		usage()
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("validate: at least one file or directory is required")
+	}
+	actions := dsl.ActionNames(csr.Actions(csr.Deps{}))
+
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && dsl.IsDefinitionFile(e.Name()) {
+				files = append(files, filepath.Join(arg, e.Name()))
+			}
+		}
+	}
+
+	failed := 0
+	// the registry keeps one workflow per id and version
+	defined := make(map[string]string)
+	for _, f := range files {
+		def, err := dsl.LoadFile(f)
+		if err == nil {
+			err = dsl.Validate(def, actions)
+		}
+		if err == nil {
+			id := def.ID + "@" + def.Version
+			if first, ok := defined[id]; ok {
+				err = fmt.Errorf("%s: %s is already defined in %s", f, id, first)
+			} else {
+				defined[id] = f
+			}
+		}
+		if err != nil {
+			failed++
+			if verr, ok := err.(*dsl.ValidationError); ok {
+				fmt.Fprintf(stderr, "%s: invalid\n", f)
+				for _, p := range verr.Problems {
+					fmt.Fprintf(stderr, "  - %s\n", p)
+				}
+				continue
+			}
+			fmt.Fprintf(stderr, "%v\n", err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: ok (%s@%s, %d steps)\n", f, def.ID, def.Version, len(def.Steps))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d definitions are invalid", failed, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowValidateRejectsDuplicateVersions(t *testing.T) {
+	def, err := os.ReadFile("../../workflows/definitions/csr_triage.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.yaml", "b.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), def, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, errOut := capture(t)
+
+	if err := workflow("validate", []string{filepath.Join(dir, "a.yaml")}); err != nil {
+		t.Fatalf("validate one file: %v (%s)", err, errOut)
+	}
+	out.Reset()
+	if err := workflow("validate", []string{dir}); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatalf("validate duplicates = %v", err)
+	}
+	if !strings.Contains(out.String(), "a.yaml: ok") || !strings.Contains(errOut.String(), "is already defined in "+filepath.Join(dir, "a.yaml")) {
+		t.Fatalf("output = %q, errors = %q", out, errOut)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,20 +15,30 @@ import (
 
 	"github.com/alpinesboltltd/boltz-ai/internal/config"
 	"github.com/alpinesboltltd/boltz-ai/internal/crypto"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	engdeadline "github.com/alpinesboltltd/boltz-ai/internal/engine/deadline"
 	engdispatcher "github.com/alpinesboltltd/boltz-ai/internal/engine/dispatcher"
+	engdsl "github.com/alpinesboltltd/boltz-ai/internal/engine/dsl"
 	engexecutor "github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+	englogger "github.com/alpinesboltltd/boltz-ai/internal/engine/logger"
+	engmetrics "github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
+	engoutbox "github.com/alpinesboltltd/boltz-ai/internal/engine/outbox"
+	engqueue "github.com/alpinesboltltd/boltz-ai/internal/engine/queue"
+	engrequeue "github.com/alpinesboltltd/boltz-ai/internal/engine/requeue"
 	engscheduler "github.com/alpinesboltltd/boltz-ai/internal/engine/scheduler"
+	engsignal "github.com/alpinesboltltd/boltz-ai/internal/engine/signal"
 	engstore "github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	engtrigger "github.com/alpinesboltltd/boltz-ai/internal/engine/trigger"
 	engworkflow "github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
-	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
 	"github.com/alpinesboltltd/boltz-ai/internal/handler"
 	"github.com/alpinesboltltd/boltz-ai/internal/middleware"
-	aiprovider "github.com/alpinesboltltd/boltz-ai/internal/provider/ai-provider"
 	"github.com/alpinesboltltd/boltz-ai/internal/provider/smtp"
 	"github.com/alpinesboltltd/boltz-ai/internal/rag"
 	"github.com/alpinesboltltd/boltz-ai/internal/repository"
 	"github.com/alpinesboltltd/boltz-ai/internal/scraper"
 	"github.com/alpinesboltltd/boltz-ai/internal/seeder"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
 	"github.com/alpinesboltltd/boltz-ai/internal/usecase"
 	csrworkflow "github.com/alpinesboltltd/boltz-ai/workflows/csr"
 	"github.com/gin-gonic/gin"
@@ -37,6 +48,15 @@ func Run(cfg *config.Config) {
 	// initialize Encryption service
 	crypto.NewEncryptionKey([]byte(cfg.GCM_KEY))
 
+	// OpenTelemetry tracing; a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Endpoint: cfg.TracingEndpoint, Insecure: cfg.TracingInsecure,
+		ServiceName: cfg.TracingServiceName, SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
+	}
+
 	// Initialize database
 	db, err := repository.InitDB(cfg.DATABASE_URL)
 	if err != nil {
@@ -64,6 +84,7 @@ func Run(cfg *config.Config) {
 	userRepo := repository.NewUserRepository(db)
 	agentRepo := repository.NewAgentRepository(db)
 	systemRepo := repository.NewSystemRepository(db)
+	conversationRepo := repository.NewConversationRepository(db)
 	aiModelRepo := repository.NewAiModelRepository(db)
 	workspaceRepo := repository.NewWorkspaceRepository(db)
 
@@ -86,43 +107,97 @@ func Run(cfg *config.Config) {
 	}
 	chatService := usecase.NewChatService(agentRepo, systemRepo)
 
+	// Background job queues (ingestion, stats) share the database but not
+	// the orchestration engine
+	jobQueue := engqueue.NewPostgresQueue(db)
+	engmetrics.RegisterQueueStats(jobQueue)
+	trainingUsecase.WithQueue(jobQueue)
+	agentUsecase.WithQueue(jobQueue)
+	queueCtx, queueCancel := context.WithCancel(context.Background())
+	queueOpts := engqueue.Options{
+		Concurrency:  cfg.QueueConcurrency,
+		PollInterval: time.Duration(cfg.QueuePollIntervalMS) * time.Millisecond,
+		Visibility:   time.Duration(cfg.QueueVisibilitySeconds) * time.Second,
+	}
+	queueDone := []<-chan struct{}{
+		engqueue.Start(queueCtx, jobQueue, usecase.TrainingQueue, trainingUsecase.HandleTrainingJob, queueOpts),
+		engqueue.Start(queueCtx, jobQueue, usecase.AgentStatsQueue, agentUsecase.HandleStatsJob, queueOpts),
+	}
+
+	// Outbox events (emails, webhooks) are published by type; types
+	// without a publisher are dead-lettered, except lifecycle events, which
+	// dispatcher subscriptions read and webhooks may deliver
+	webhooks, err := engoutbox.ParseWebhookEndpoints(cfg.OutboxWebhooks)
+	if err != nil {
+		log.Fatalf("outbox: %v", err)
+	}
+	publishers := engoutbox.NewRegistry()
+	engoutbox.NewWebhookPublisher(webhooks, nil).Register(publishers)
+	publishers.Register(engoutbox.EventEmailSend, engoutbox.EmailPublisher(smtpClient))
+	publishers.Register(csrworkflow.EventConversationReply, csrworkflow.ReplyPublisher(conversationRepo))
+	publishers.Ignore("run.*", "step.*")
+	outboxCtx, outboxCancel := context.WithCancel(context.Background())
+	retry := engine.DefaultRetryPolicy
+	retry.MaxAttempts = cfg.OutboxMaxAttempts
+	outboxDone := engoutbox.StartPublisher(outboxCtx, db, publishers, engoutbox.Options{
+		PollInterval: time.Duration(cfg.OutboxPollIntervalMS) * time.Millisecond,
+		Retry:        retry,
+	})
+
 	// Optional: initialize orchestration engine (feature-flagged)
 	var (
-		schedCancel context.CancelFunc
-		schedDone   <-chan struct{}
+		schedCancel     context.CancelFunc
+		schedDone       <-chan struct{}
+		eventDispatcher *engdispatcher.Durable
+		workflowHandler *handler.WorkflowHandler
+		supportHandler  *handler.SupportHandler
 	)
 	if cfg.ENABLE_ORCHESTRATION {
 		// create store, registry, dispatcher, executor and start scheduler
-		store := engstore.NewPostgresStore(db)
+		limits, err := engine.ParseConcurrencyLimits(cfg.OrchestrationWorkspaceConcurrency, cfg.OrchestrationWorkspaceConcurrencyOverrides, cfg.OrchestrationWorkflowConcurrency)
+		if err != nil {
+			log.Fatalf("orchestration: %v", err)
+		}
+		rateLimits, err := engexecutor.ParseStepRateLimits(cfg.OrchestrationReplicaStepRateLimits)
+		if err != nil {
+			log.Fatalf("orchestration: %v", err)
+		}
+		// concurrency caps are enforced when steps are claimed
+		store := engstore.NewPostgresStore(db).WithLimits(limits)
+		// pending step counts are read from the store on every scrape
+		engmetrics.RegisterQueueDepth(store)
+		// structured engine logs on stdout, persisted per step for the run API
+		engineLogger := englogger.New(os.Stdout, store)
+		// monitors and helpers without a logger of their own use it too
+		engine.SetLogger(engineLogger)
 		reg := engworkflow.NewRegistry()
-		// register CSR workflow for MVP
-		reg.Register(csrworkflow.New())
-		disp := engdispatcher.NewInMemDispatcher()
-		// create an LLM manager and pass a small wrapper function to the executor
-		llmManager := aiprovider.NewLLMManager()
-		// LLM input struct for strict typing
+		handlers := engexecutor.NewHandlerRegistry()
+		// CSR drafts are written by the agent named in the step input, with
+		// its model, behavior and system instruction
 		type llmInput struct {
-			Prompt string `json:"prompt"`
+			Prompt  string `json:"prompt"`
+			AgentID string `json:"agent_id"`
 		}
 		llmFunc := func(ctx context.Context, input []byte) (string, error) {
 			var in llmInput
 			if err := json.Unmarshal(input, &in); err != nil {
-				return "", fmt.Errorf("invalid LLM input JSON: %w", err)
+				return "", engine.Permanent(fmt.Errorf("invalid LLM input JSON: %w", err))
 			}
 
-			// Ensure prompt exists and is non-empty
+			// Ensure prompt and agent exist
 			if strings.TrimSpace(in.Prompt) == "" {
-				return "", fmt.Errorf("missing or empty 'prompt' in LLM input")
+				return "", engine.Permanent(fmt.Errorf("missing or empty 'prompt' in LLM input"))
+			}
+			if in.AgentID == "" {
+				return "", engine.Permanent(fmt.Errorf("missing 'agent_id' in LLM input"))
 			}
 
-			msgs := []aiprovider.MultimodalMessage{{Role: aiprovider.RoleUser, Content: in.Prompt}}
-
-			// Use default config
-			res, err := llmManager.ProcessMultimodalMessage(entity.Agent{}, msgs, cfg.OPENAI_API_KEY, "", "")
-			if err != nil {
-				return "", err
+			res, err := chatService.Complete(ctx, in.AgentID, in.Prompt, cfg.OPENAI_API_KEY)
+			var appErr *appErrors.AppError
+			if errors.As(err, &appErr) && appErr.Type == appErrors.NotFoundError {
+				return "", engine.Permanent(err)
 			}
-			return res, nil
+			return res, err
 		}
 		// initialize RAG service for retrieve_context
 		cohereClient, err := rag.NewCohereClient(cfg.COHERE_API_KEY)
@@ -141,7 +216,34 @@ func Run(cfg *config.Config) {
 			}
 		}
 		ragService := rag.NewRAGService(cohereClient, ragRepo, mediaProcessor, vectorDB, cfg.VECTOR_DB_TYPE)
-		exec := engexecutor.NewDefaultExecutor(llmFunc, smtpClient, store, ragService)
+		// register CSR workflow and its step handlers for MVP
+		csrDeps := csrworkflow.Deps{
+			LLM: llmFunc, Store: store, RAG: ragService, Conversations: conversationRepo,
+			MinConfidence:       cfg.CSRMinConfidence,
+			ReviewTimeout:       time.Duration(cfg.HumanReviewTimeoutMinutes) * time.Minute,
+			ReviewTimeoutAction: cfg.HumanReviewTimeoutAction,
+			EscalateTo:          cfg.HumanReviewEscalationEmail,
+		}
+		csrworkflow.Register(reg, handlers, csrDeps)
+		// declarative workflows run the CSR handlers as actions
+		if cfg.WorkflowDefinitionsDir != "" {
+			defs, err := engdsl.LoadDir(cfg.WorkflowDefinitionsDir)
+			if err != nil {
+				log.Fatalf("failed to load workflow definitions: %v", err)
+			}
+			actions := csrworkflow.Actions(csrDeps)
+			for _, def := range defs {
+				if _, err := engdsl.Register(reg, handlers, def, actions); err != nil {
+					log.Fatalf("failed to register workflow definition: %v", err)
+				}
+				log.Printf("orchestration: registered declarative workflow %s@%s", def.ID, def.Version)
+			}
+		}
+		exec := engexecutor.NewDefaultExecutor(store, handlers, engineLogger).WithWorkflows(reg).WithStepRateLimits(rateLimits)
+		workflowUsecase := usecase.NewWorkflowUsecase(store, reg)
+		workflowHandler = handler.NewWorkflowHandler(workflowUsecase, workspaceUsecase)
+		// escalated conversations and inbound emails start CSR runs
+		supportHandler = handler.NewSupportHandler(usecase.NewSupportUsecase(conversationRepo, agentRepo, workflowUsecase, cfg.CSRReviewerEmail), workspaceUsecase, cfg.InboundEmailSecret)
 		// start scheduler with cancellable context
 		schedCtx, cancel := context.WithCancel(context.Background())
 		schedCancel = cancel
@@ -150,12 +252,50 @@ func Run(cfg *config.Config) {
 		if workerCount <= 0 {
 			workerCount = 4
 		}
-		done, err := engscheduler.Start(schedCtx, store, exec, reg, disp, workerCount)
+		// wake the scheduler through LISTEN/NOTIFY; polling remains as fallback
+		wake, err := engstore.ListenForSteps(schedCtx, cfg.DATABASE_URL)
+		if err != nil {
+			log.Printf("Warning: orchestration LISTEN unavailable, falling back to polling: %v", err)
+		}
+		workerID := cfg.OrchestrationWorkerID
+		if workerID == "" {
+			workerID = engscheduler.NewWorkerID()
+		}
+		if cfg.OrchestrationHeartbeatTTLSeconds <= 2*cfg.OrchestrationHeartbeatIntervalSeconds {
+			log.Printf("Warning: ORCHESTRATION_HEARTBEAT_TTL_SECONDS (%d) should be well above ORCHESTRATION_HEARTBEAT_INTERVAL_SECONDS (%d); running steps may be requeued",
+				cfg.OrchestrationHeartbeatTTLSeconds, cfg.OrchestrationHeartbeatIntervalSeconds)
+		}
+		log.Printf("orchestration: scheduler worker ID %s", workerID)
+		// lifecycle events are stored in the outbox and delivered to
+		// subscribers from there
+		disp := engdispatcher.NewDurable(store, workerID, engdispatcher.Options{
+			PollInterval: time.Duration(cfg.DispatcherPollIntervalMS) * time.Millisecond,
+			AckTimeout:   time.Duration(cfg.DispatcherAckTimeoutSeconds) * time.Second,
+		})
+		engine.SetDispatcher(disp)
+		eventDispatcher = disp
+		done, err := engscheduler.StartWithOptions(schedCtx, store, exec, reg, engscheduler.Options{
+			WorkerCount:       workerCount,
+			PollInterval:      time.Duration(cfg.OrchestrationPollIntervalMS) * time.Millisecond,
+			Wake:              wake,
+			Logger:            engineLogger,
+			WorkerID:          workerID,
+			HeartbeatInterval: time.Duration(cfg.OrchestrationHeartbeatIntervalSeconds) * time.Second,
+			ShutdownGrace:     time.Duration(cfg.OrchestrationShutdownGraceSeconds) * time.Second,
+		})
 		if err != nil {
 			log.Fatalf("orchestration: failed to start scheduler: %v", err)
 		} else {
 			schedDone = done
 		}
+		// requeue steps of replicas that died without releasing them
+		engrequeue.StartRequeueMonitor(schedCtx, store, reg, time.Duration(cfg.OrchestrationRequeueIntervalSeconds)*time.Second, cfg.OrchestrationHeartbeatTTLSeconds, 100)
+		// resolve human reviews that outlived their timeout
+		engsignal.StartTimeoutMonitor(schedCtx, store, reg, time.Minute, 100)
+		// start runs of due cron triggers
+		engtrigger.StartMonitor(schedCtx, store, reg, time.Duration(cfg.OrchestrationTriggerIntervalSeconds)*time.Second, 100)
+		// cancel runs that outlived their deadline
+		engdeadline.StartMonitor(schedCtx, store, reg, time.Duration(cfg.OrchestrationDeadlineIntervalSeconds)*time.Second, 100)
 	}
 
 	// Initialize handlers
@@ -171,13 +311,9 @@ func Run(cfg *config.Config) {
 	scraperService := scraper.NewService(nil)
 	scraperHandler := handler.NewScraperHandler(scraperService)
 
-	// Configure dispatcher delivery timeout from config
-	if cfg.DispatcherDeliveryTimeoutMS > 0 {
-		engdispatcher.DeliveryTimeout = time.Duration(cfg.DispatcherDeliveryTimeoutMS) * time.Millisecond
-	}
-
 	// Setup routes
 	r := gin.Default()
+	r.Use(tracing.Middleware())
 
 	// Shutdown middleware
 	shuttingDown := false
@@ -198,6 +334,14 @@ func Run(cfg *config.Config) {
 			"status": "ok",
 		})
 	})
+	// Prometheus metrics, optionally protected by a static bearer token
+	r.GET("/metrics", func(c *gin.Context) {
+		if cfg.MetricsToken != "" && c.GetHeader("Authorization") != "Bearer "+cfg.MetricsToken {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}, gin.WrapH(engmetrics.Handler()))
 
 	api := r.Group("/api/v1")
 
@@ -260,6 +404,7 @@ func Run(cfg *config.Config) {
 			// Agent stats
 			agent.GET("/:agentId/stats", agentHandler.GetAgentStats)
 			agent.DELETE("/:agentId/stats", agentHandler.DeleteAgentStats)
+			agent.POST("/:agentId/stats/recalculate", agentHandler.RecalculateAgentStats)
 
 			// Agent integration
 			agent.POST("/create/integration", agentHandler.CreateAgentIntegration)
@@ -314,6 +459,46 @@ func Run(cfg *config.Config) {
 			workspaces.GET("", workspaceHandler.GetUserWorkspaces)
 			workspaces.GET("/:id", workspaceHandler.GetWorkspace)
 		}
+
+		// Workflow runs (only when the orchestration engine is enabled)
+		if workflowHandler != nil {
+			workflows := api.Group("/workflows")
+			workflows.Use(middleware.AuthMiddleware([]byte(cfg.JWT_SECRET)))
+			{
+				workflows.GET("", workflowHandler.ListWorkflows)
+				workflows.POST("/runs", workflowHandler.StartRun)
+				workflows.GET("/runs", workflowHandler.ListRuns)
+				workflows.GET("/runs/:runId", workflowHandler.GetRun)
+				workflows.POST("/runs/:runId/cancel", workflowHandler.CancelRun)
+				workflows.POST("/runs/:runId/migrate", workflowHandler.MigrateRun)
+				workflows.POST("/runs/:runId/pause", workflowHandler.PauseRun)
+				workflows.POST("/runs/:runId/resume", workflowHandler.ResumeRun)
+				workflows.POST("/types/:workflowType/pause", workflowHandler.PauseWorkflow)
+				workflows.POST("/types/:workflowType/resume", workflowHandler.ResumeWorkflow)
+				workflows.POST("/runs/:runId/steps/:stepId/signal", workflowHandler.SignalStep)
+				workflows.GET("/approvals", workflowHandler.ListPendingApprovals)
+				workflows.POST("/triggers", workflowHandler.CreateTrigger)
+				workflows.GET("/triggers", workflowHandler.ListTriggers)
+				workflows.GET("/triggers/:triggerId", workflowHandler.GetTrigger)
+				workflows.PUT("/triggers/:triggerId", workflowHandler.UpdateTrigger)
+				workflows.DELETE("/triggers/:triggerId", workflowHandler.DeleteTrigger)
+				workflows.GET("/dead-letter/steps", workflowHandler.ListDeadLetterSteps)
+				workflows.POST("/dead-letter/steps/:stepId/replay", workflowHandler.ReplayStep)
+				workflows.POST("/dead-letter/steps/:stepId/discard", workflowHandler.DiscardStep)
+				workflows.GET("/dead-letter/events", workflowHandler.ListDeadLetterEvents)
+				workflows.GET("/dead-letter/events/:eventId", workflowHandler.GetEvent)
+				workflows.POST("/dead-letter/events/:eventId/replay", workflowHandler.ReplayEvent)
+				workflows.POST("/dead-letter/events/:eventId/discard", workflowHandler.DiscardEvent)
+			}
+
+			// Support: escalated conversations and inbound emails are
+			// answered by the CSR workflow
+			api.POST("/conversations/:conversationId/escalate", middleware.AuthMiddleware([]byte(cfg.JWT_SECRET)), supportHandler.EscalateConversation)
+			if cfg.InboundEmailSecret != "" {
+				// signed by the mail provider instead of a user token
+				api.POST("/support/inbound-email", supportHandler.ReceiveEmail)
+			}
+		}
 	}
 	ws := r.Group("/ws/v1")
 	{
@@ -347,7 +532,8 @@ func Run(cfg *config.Config) {
 	if cfg.ENABLE_ORCHESTRATION && schedCancel != nil {
 		schedCancel()
 		if schedDone != nil {
-			waitTimeout := 30 * time.Second
+			// steps get the shutdown grace, then are released
+			waitTimeout := time.Duration(cfg.OrchestrationShutdownGraceSeconds)*time.Second + 10*time.Second
 			select {
 			case <-schedDone:
 				log.Println("orchestration: scheduler shutdown completed")
@@ -355,6 +541,27 @@ func Run(cfg *config.Config) {
 				log.Printf("orchestration: scheduler shutdown timed out after %s", waitTimeout)
 			}
 		}
+		// durable subscriptions are released to the other replicas
+		eventDispatcher.Close()
+	}
+
+	// Stop the queue workers; unfinished jobs are redelivered after their
+	// lease lapses
+	queueCancel()
+	for _, done := range queueDone {
+		select {
+		case <-done:
+		case <-time.After(engqueue.DefaultShutdownGrace + 5*time.Second):
+			log.Println("queue: worker shutdown timed out")
+		}
+	}
+
+	// Let the outbox finish the batch it is publishing
+	outboxCancel()
+	select {
+	case <-outboxDone:
+	case <-time.After(30 * time.Second):
+		log.Println("outbox: publisher shutdown timed out")
 	}
 
 	// Graceful shutdown with 30 second timeout
@@ -364,6 +571,9 @@ func Run(cfg *config.Config) {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("tracing: flush on shutdown failed: %v", err)
+	}
 
 	log.Println("Server exited")
 }
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+)
+
 type Config struct {
 	Port                     string `env:"PORT,default=8080"`
 	OPENAI_API_KEY           string `env:"OPENAI_API_KEY,required"`
@@ -22,12 +27,116 @@ type Config struct {
 	SMTP_PASS                string `env:"SMTP_PASS,required"`
 	OTP_SECRET               string `env:"OTP_SECRET,required"`
 	ENABLE_ORCHESTRATION     bool   `env:"ENABLE_ORCHESTRATION,default=false"`
-	// DispatcherDeliveryTimeoutMS controls how long the in-memory dispatcher will
-	// wait for a subscriber to accept an event before considering it dropped.
-	// Value is in milliseconds.
-	DispatcherDeliveryTimeoutMS int `env:"DISPATCHER_DELIVERY_TIMEOUT_MS,default=100"`
+	// DispatcherPollIntervalMS is how often event subscriptions look for new
+	// events in the outbox.
+	DispatcherPollIntervalMS int `env:"DISPATCHER_POLL_INTERVAL_MS,default=500"`
+	// DispatcherAckTimeoutSeconds is how long a durable event subscription
+	// waits for its consumer to acknowledge events before delivering them
+	// again.
+	DispatcherAckTimeoutSeconds int `env:"DISPATCHER_ACK_TIMEOUT_SECONDS,default=30"`
 	// OrchestrationWorkerCount controls the number of concurrent workers for the engine.
 	OrchestrationWorkerCount int `env:"ORCHESTRATION_WORKER_COUNT,default=4"`
+	// OrchestrationPollIntervalMS is the scheduler's fallback polling interval;
+	// new steps normally wake it immediately through Postgres LISTEN/NOTIFY.
+	OrchestrationPollIntervalMS int `env:"ORCHESTRATION_POLL_INTERVAL_MS,default=500"`
+	// OrchestrationWorkerID names this replica as the owner of the steps it
+	// runs; empty generates an ID from the host name and process ID. Every
+	// replica needs its own.
+	OrchestrationWorkerID string `env:"ORCHESTRATION_WORKER_ID"`
+	// OrchestrationHeartbeatIntervalSeconds is how often running steps
+	// renew their lease. A step whose lease was not renewed for
+	// OrchestrationHeartbeatTTLSeconds is requeued by the requeue monitor,
+	// which runs every OrchestrationRequeueIntervalSeconds.
+	OrchestrationHeartbeatIntervalSeconds int `env:"ORCHESTRATION_HEARTBEAT_INTERVAL_SECONDS,default=30"`
+	OrchestrationHeartbeatTTLSeconds      int `env:"ORCHESTRATION_HEARTBEAT_TTL_SECONDS,default=120"`
+	OrchestrationRequeueIntervalSeconds   int `env:"ORCHESTRATION_REQUEUE_INTERVAL_SECONDS,default=30"`
+	// OrchestrationShutdownGraceSeconds bounds how long running steps may
+	// finish on shutdown before they are released to other replicas.
+	OrchestrationShutdownGraceSeconds int `env:"ORCHESTRATION_SHUTDOWN_GRACE_SECONDS,default=20"`
+	// MetricsToken, when set, is required as a bearer token on /metrics.
+	MetricsToken string `env:"METRICS_TOKEN"`
+	// OrchestrationTriggerIntervalSeconds controls how often due cron triggers
+	// are checked.
+	OrchestrationTriggerIntervalSeconds int `env:"ORCHESTRATION_TRIGGER_INTERVAL_SECONDS,default=30"`
+	// OrchestrationDeadlineIntervalSeconds controls how often runs are
+	// checked for a passed deadline.
+	OrchestrationDeadlineIntervalSeconds int `env:"ORCHESTRATION_DEADLINE_INTERVAL_SECONDS,default=30"`
+	// OrchestrationWorkspaceConcurrency caps the steps in progress per
+	// workspace across all replicas; zero means unlimited.
+	// OrchestrationWorkspaceConcurrencyOverrides sets caps for individual
+	// workspaces ("<workspace id>=8,...") and OrchestrationWorkflowConcurrency
+	// per workflow type ("csr=16,...").
+	OrchestrationWorkspaceConcurrency          int    `env:"ORCHESTRATION_WORKSPACE_CONCURRENCY,default=0"`
+	OrchestrationWorkspaceConcurrencyOverrides string `env:"ORCHESTRATION_WORKSPACE_CONCURRENCY_OVERRIDES"`
+	OrchestrationWorkflowConcurrency           string `env:"ORCHESTRATION_WORKFLOW_CONCURRENCY"`
+	// OrchestrationReplicaStepRateLimits caps the steps per second each
+	// replica starts for a connector, e.g. "llm=5,rag=20". It limits step
+	// starts, not the calls a step makes, and is not shared between replicas.
+	OrchestrationReplicaStepRateLimits string `env:"ORCHESTRATION_REPLICA_STEP_RATE_LIMITS"`
+	// QueueConcurrency is the number of messages each background job queue
+	// (training ingestion, stats recalculation) handles at once. Queue
+	// workers run whether or not orchestration is enabled.
+	QueueConcurrency int `env:"QUEUE_CONCURRENCY,default=2"`
+	// QueuePollIntervalMS is how often an idle queue worker polls.
+	QueuePollIntervalMS int `env:"QUEUE_POLL_INTERVAL_MS,default=1000"`
+	// QueueVisibilitySeconds is the lease on a dequeued message; it is
+	// extended while the job runs and redelivered if the process dies.
+	QueueVisibilitySeconds int `env:"QUEUE_VISIBILITY_SECONDS,default=300"`
+	// OutboxPollIntervalMS is how often the outbox publisher looks for due
+	// events. The publisher runs whether or not orchestration is enabled.
+	OutboxPollIntervalMS int `env:"OUTBOX_POLL_INTERVAL_MS,default=1000"`
+	// OutboxMaxAttempts is how many times an event is published before it
+	// is dead-lettered.
+	OutboxMaxAttempts int `env:"OUTBOX_MAX_ATTEMPTS,default=5"`
+	// OutboxWebhooks is a JSON array of HTTP endpoints outbox events are
+	// posted to, e.g.
+	// [{"url":"https://example.com/hooks","secret":"s3cret","events":["run.*"]}].
+	OutboxWebhooks string `env:"OUTBOX_WEBHOOKS"`
+	// HumanReviewTimeoutMinutes bounds how long a human_review step waits for
+	// a decision before HumanReviewTimeoutAction ("escalate" or "reject")
+	// applies. Zero waits forever.
+	HumanReviewTimeoutMinutes int    `env:"HUMAN_REVIEW_TIMEOUT_MINUTES,default=1440"`
+	HumanReviewTimeoutAction  string `env:"HUMAN_REVIEW_TIMEOUT_ACTION,default=escalate"`
+	// HumanReviewEscalationEmail receives overdue reviews when escalating.
+	// It is required with the escalate action; see Validate.
+	HumanReviewEscalationEmail string `env:"HUMAN_REVIEW_ESCALATION_EMAIL"`
+	// CSRReviewerEmail is notified of CSR drafts that need human review
+	// before they are sent. Without it reviews only show in the approvals
+	// inbox.
+	CSRReviewerEmail string `env:"CSR_REVIEWER_EMAIL"`
+	// CSRMinConfidence is the retrieval confidence (best knowledge base
+	// match, 0 to 1) a CSR draft needs to be sent without review.
+	CSRMinConfidence float64 `env:"CSR_MIN_CONFIDENCE,default=0.6"`
+	// InboundEmailSecret signs inbound support emails posted by the mail
+	// provider. The inbound email route is disabled when empty.
+	InboundEmailSecret string `env:"INBOUND_EMAIL_SECRET"`
+	// WorkflowDefinitionsDir holds declarative workflow definitions (YAML or
+	// JSON) registered at startup next to the Go workflows.
+	WorkflowDefinitionsDir string `env:"WORKFLOW_DEFINITIONS_DIR"`
+	// TracingEndpoint is the base URL of the OTLP/HTTP collector spans are
+	// exported to (e.g. http://otel-collector:4318). Tracing is a no-op when
+	// empty.
+	TracingEndpoint    string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TracingInsecure    bool    `env:"OTEL_EXPORTER_OTLP_INSECURE,default=false"`
+	TracingServiceName string  `env:"OTEL_SERVICE_NAME,default=boltz-ai"`
+	TracingSampleRatio float64 `env:"OTEL_TRACES_SAMPLE_RATIO,default=1"`
+}
+
+// Validate rejects settings that are well-formed but cannot work together.
+func (c *Config) Validate() error {
+	if !c.ENABLE_ORCHESTRATION || c.HumanReviewTimeoutMinutes <= 0 {
+		return nil
+	}
+	switch c.HumanReviewTimeoutAction {
+	case "reject":
+	case "escalate":
+		if c.HumanReviewEscalationEmail == "" {
+			return errors.New("HUMAN_REVIEW_TIMEOUT_ACTION=escalate requires HUMAN_REVIEW_ESCALATION_EMAIL; set it or use HUMAN_REVIEW_TIMEOUT_ACTION=reject")
+		}
+	default:
+		return fmt.Errorf("HUMAN_REVIEW_TIMEOUT_ACTION must be escalate or reject, got %q", c.HumanReviewTimeoutAction)
+	}
+	return nil
 }
 
 // Vector DB Types
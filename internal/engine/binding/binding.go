@@ -0,0 +1,259 @@
+// Package binding resolves step input templates. A template is JSON whose
+// strings may contain references to the run and its earlier steps:
+//
+//	{"prompt": "Reply to {{ run.payload.message }} using {{ steps.retrieve_context.output.context }}",
+//	 "ticket_id": "{{ run.payload.ticket_id }}"}
+//
+// Templates are resolved when a step is claimed, so they see the outputs of
+// every step that completed before it, however the run was planned.
+package binding
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// Scope is the data references resolve against; see NewScope.
+type Scope map[string]interface{}
+
+// NewScope exposes run.id, run.workspace_id, run.payload and, for every
+// step of the run, steps.<name>.status, .attempts and .output (the latest
+// record of a step wins).
+func NewScope(run *engine.WorkflowRun) Scope {
+	var payload interface{}
+	_ = json.Unmarshal(run.Payload, &payload)
+	steps := make(map[string]interface{}, len(run.Steps))
+	for _, st := range run.Steps {
+		var out interface{}
+		_ = json.Unmarshal(st.Result, &out)
+		steps[st.StepName] = map[string]interface{}{
+			"status":   st.Status,
+			"attempts": float64(st.Attempts),
+			"output":   out,
+		}
+	}
+	return Scope{
+		"run": map[string]interface{}{
+			"id":           run.ID,
+			"workspace_id": run.WorkspaceID,
+			"payload":      payload,
+		},
+		"steps": steps,
+	}
+}
+
+// Ref is a dotted path into a Scope such as run.payload.ticket_id or
+// steps.draft_response.output.draft. Numeric segments index arrays.
+type Ref []string
+
+// ParseRef parses a reference and checks that it starts with run or steps.
+func ParseRef(s string) (Ref, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty reference")
+	}
+	parts := strings.Split(s, ".")
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid reference %q", s)
+		}
+	}
+	switch parts[0] {
+	case "run":
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("reference %q must name a field of run", s)
+		}
+	case "steps":
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("reference %q must be steps.<name>.<field>", s)
+		}
+	default:
+		return nil, fmt.Errorf("reference %q must start with run. or steps.", s)
+	}
+	return Ref(parts), nil
+}
+
+// Step returns the step named by a steps.<name> reference, or "".
+func (r Ref) Step() string {
+	if len(r) > 1 && r[0] == "steps" {
+		return r[1]
+	}
+	return ""
+}
+
+func (r Ref) String() string { return strings.Join(r, ".") }
+
+// Resolve returns the value at r, or nil when any segment is missing.
+func (r Ref) Resolve(s Scope) interface{} {
+	var cur interface{} = map[string]interface{}(s)
+	for _, p := range r {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			cur = v[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			cur = v[i]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+// Render replaces the placeholders in every string of v. A string that is
+// exactly one placeholder becomes the referenced value; otherwise values
+// are interpolated, non-strings as JSON and missing references as "".
+// Substituted text is not scanned again, so payload text containing braces
+// is passed through verbatim.
+func Render(v interface{}, s Scope) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		return renderString(t, s)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			r, err := Render(x, s)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, x := range t {
+			r, err := Render(x, s)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func renderString(str string, s Scope) (interface{}, error) {
+	if m := placeholder.FindStringSubmatchIndex(str); m != nil && m[0] == 0 && m[1] == len(str) {
+		ref, err := ParseRef(str[m[2]:m[3]])
+		if err != nil {
+			return nil, err
+		}
+		return ref.Resolve(s), nil
+	}
+	var firstErr error
+	out := placeholder.ReplaceAllStringFunc(str, func(p string) string {
+		ref, err := ParseRef(placeholder.FindStringSubmatch(p)[1])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ""
+		}
+		switch v := ref.Resolve(s).(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	})
+	return out, firstErr
+}
+
+// Refs returns the references used in the placeholders of v.
+func Refs(v interface{}) ([]Ref, error) {
+	var refs []Ref
+	var walk func(interface{}) error
+	walk = func(v interface{}) error {
+		switch t := v.(type) {
+		case string:
+			for _, m := range placeholder.FindAllStringSubmatch(t, -1) {
+				ref, err := ParseRef(m[1])
+				if err != nil {
+					return fmt.Errorf("template %q: %w", strings.TrimSpace(m[0]), err)
+				}
+				refs = append(refs, ref)
+			}
+		case map[string]interface{}:
+			for _, x := range t {
+				if err := walk(x); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, x := range t {
+				if err := walk(x); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err := walk(v)
+	return refs, err
+}
+
+// Error reports why a template could not be bound.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "input binding: " + strings.Join(e.Problems, "; ")
+}
+
+// Bind renders template against run. Every referenced step must have
+// completed in run; missing fields of a completed step or of the payload
+// resolve to null.
+func Bind(template json.RawMessage, run *engine.WorkflowRun) (json.RawMessage, error) {
+	var v interface{}
+	if err := json.Unmarshal(template, &v); err != nil {
+		return nil, &Error{Problems: []string{fmt.Sprintf("template is not valid JSON: %v", err)}}
+	}
+	refs, err := Refs(v)
+	if err != nil {
+		return nil, &Error{Problems: []string{err.Error()}}
+	}
+	status := make(map[string]string, len(run.Steps))
+	for _, st := range run.Steps {
+		status[st.StepName] = st.Status
+	}
+	var problems []string
+	for _, ref := range refs {
+		name := ref.Step()
+		if name == "" {
+			continue
+		}
+		switch st, ok := status[name]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: run has no step %q", ref, name))
+		case st != engine.StepStatusCompleted:
+			problems = append(problems, fmt.Sprintf("%s: step %q is %s, not completed", ref, name, st))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	out, err := Render(v, NewScope(run))
+	if err != nil {
+		return nil, &Error{Problems: []string{err.Error()}}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, &Error{Problems: []string{err.Error()}}
+	}
+	return b, nil
+}
@@ -0,0 +1,48 @@
+package binding
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+func testRun() *engine.WorkflowRun {
+	return &engine.WorkflowRun{
+		ID:      "r1",
+		Payload: json.RawMessage(`{"ticket_id":"T-1","message":"why is {{ run.id }} in my bill?"}`),
+		Steps: []engine.WorkflowStepRecord{
+			{StepName: "retrieve_context", Status: engine.StepStatusCompleted, Result: json.RawMessage(`{"context":"policy","chunks":[{"score":0.8}]}`)},
+			{StepName: "human_review", Status: engine.StepStatusWaitingForSignal},
+		},
+	}
+}
+
+func TestBind(t *testing.T) {
+	tmpl := json.RawMessage(`{"ticket":"{{ run.payload.ticket_id }}","prompt":"Q: {{ run.payload.message }} C: {{steps.retrieve_context.output.context}}","top":"{{ steps.retrieve_context.output.chunks.0 }}","missing":"{{ run.payload.nope }}"}`)
+	got, err := Bind(tmpl, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// payload text is not scanned for placeholders again
+	want := `{"missing":null,"prompt":"Q: why is {{ run.id }} in my bill? C: policy","ticket":"T-1","top":{"score":0.8}}`
+	if string(got) != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestBindRequiresCompletedSteps(t *testing.T) {
+	_, err := Bind(json.RawMessage(`{"a":"{{ steps.human_review.output.draft }}","b":"{{ steps.draft.output }}"}`), testRun())
+	var be *Error
+	if !errors.As(err, &be) || len(be.Problems) != 2 {
+		t.Fatalf("expected two binding problems, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `step "human_review" is waiting`) || !strings.Contains(err.Error(), `no step "draft"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Bind(json.RawMessage(`{"a":"{{ payload.x }}"}`), testRun()); err == nil {
+		t.Fatal("expected an error for a reference outside run and steps")
+	}
+}
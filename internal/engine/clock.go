@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the engine the time. Timers, backoffs, deadlines and review
+// timeouts are computed from it, so tests can move time forward instead of
+// sleeping. The Postgres store compares against the database's now().
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the wall clock, used unless SetClock replaced it.
+var SystemClock Clock = systemClock{}
+
+var (
+	clockMu sync.RWMutex
+	clock   = SystemClock
+)
+
+// Now returns the time of the engine clock.
+func Now() time.Time {
+	clockMu.RLock()
+	defer clockMu.RUnlock()
+	return clock.Now()
+}
+
+// SetClock replaces the engine clock and returns a function restoring the
+// previous one. It is meant for tests; see package enginetest.
+func SetClock(c Clock) (restore func()) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	prev := clock
+	clock = c
+	return func() {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		clock = prev
+	}
+}
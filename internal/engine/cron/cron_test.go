@@ -0,0 +1,40 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, time.January, 30, 10, 17, 42, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 1, 30, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, 2, 2, 9, 0, 0, 0, time.UTC)},
+		{"30 8 1 * *", time.Date(2026, 2, 1, 8, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * 1", time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"@every 1h30m", time.Date(2026, 1, 30, 11, 47, 42, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tc.want) {
+			t.Errorf("Next(%q) = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "@every 10s"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
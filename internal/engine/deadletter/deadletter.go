@@ -0,0 +1,174 @@
+// Package deadletter inspects and recovers failed workflow steps and outbox
+// events. Every replay and discard is recorded so on-call actions can be
+// audited: in the step logs for steps, and as an EventAudit of the event
+// for outbox events.
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// Audit actions recorded in the meta of the audit entry.
+const (
+	ActionReplay  = "replay"
+	ActionDiscard = "discard"
+)
+
+var (
+	// ErrNotFound is returned when the step or event does not exist.
+	ErrNotFound = errors.New("deadletter: not found")
+	// ErrNotDead is returned when the step or event is not (or no longer) in
+	// the dead-letter queue, or the step's run was cancelled.
+	ErrNotDead = errors.New("deadletter: not in the dead-letter queue")
+	// ErrInvalidJSON is returned when an edited input or payload is not JSON.
+	ErrInvalidJSON = errors.New("deadletter: edited input must be valid JSON")
+)
+
+// Request describes a replay or discard. Input replaces the step input or
+// event payload on replay when set.
+type Request struct {
+	Actor  string
+	Reason string
+	Input  json.RawMessage
+}
+
+// ReplayStep moves a failed step back to pending so the scheduler runs it
+// again with fresh attempts.
+func ReplayStep(ctx context.Context, store engine.StateStore, stepID string, req Request) error {
+	step, err := loadStep(ctx, store, stepID)
+	if err != nil {
+		return err
+	}
+	if len(req.Input) > 0 && !json.Valid(req.Input) {
+		return ErrInvalidJSON
+	}
+	ok, err := store.ReplayStep(ctx, stepID, req.Input)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotDead
+	}
+	meta := auditMeta(ActionReplay, req, step.Attempts, step.Error, step.Input)
+	appendLog(ctx, store, stepID, ActionReplay, meta)
+	return nil
+}
+
+// DiscardStep removes a failed step from the dead-letter queue. Its run stays
+// failed.
+func DiscardStep(ctx context.Context, store engine.StateStore, stepID string, req Request) error {
+	step, err := loadStep(ctx, store, stepID)
+	if err != nil {
+		return err
+	}
+	ok, err := store.DiscardStep(ctx, stepID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotDead
+	}
+	meta := auditMeta(ActionDiscard, req, step.Attempts, step.Error, nil)
+	appendLog(ctx, store, stepID, ActionDiscard, meta)
+	return nil
+}
+
+// ReplayEvent moves a failed outbox event back to pending so the publisher
+// delivers it again.
+func ReplayEvent(ctx context.Context, store engine.StateStore, eventID string, req Request) error {
+	ev, err := loadEvent(ctx, store, eventID)
+	if err != nil {
+		return err
+	}
+	if len(req.Input) > 0 && !json.Valid(req.Input) {
+		return ErrInvalidJSON
+	}
+	ok, err := store.ReplayEvent(ctx, eventID, req.Input)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotDead
+	}
+	meta := auditMeta(ActionReplay, req, ev.Attempts, ev.Error, ev.Payload)
+	appendAudit(ctx, store, eventID, ActionReplay, meta)
+	return nil
+}
+
+// DiscardEvent removes a failed outbox event from the dead-letter queue.
+func DiscardEvent(ctx context.Context, store engine.StateStore, eventID string, req Request) error {
+	ev, err := loadEvent(ctx, store, eventID)
+	if err != nil {
+		return err
+	}
+	ok, err := store.DiscardEvent(ctx, eventID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotDead
+	}
+	meta := auditMeta(ActionDiscard, req, ev.Attempts, ev.Error, nil)
+	appendAudit(ctx, store, eventID, ActionDiscard, meta)
+	return nil
+}
+
+func loadStep(ctx context.Context, store engine.StateStore, stepID string) (*engine.WorkflowStepRecord, error) {
+	step, err := store.LoadStep(ctx, stepID)
+	if err != nil {
+		return nil, err
+	}
+	if step == nil {
+		return nil, ErrNotFound
+	}
+	return step, nil
+}
+
+func loadEvent(ctx context.Context, store engine.StateStore, eventID string) (*engine.OutboxEvent, error) {
+	ev, err := store.LoadEvent(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if ev == nil {
+		return nil, ErrNotFound
+	}
+	return ev, nil
+}
+
+// auditMeta describes the action with the failure it resolved. previous
+// holds the input or payload that was replaced by an edit.
+func auditMeta(action string, req Request, attempts int, lastErr *string, previous json.RawMessage) json.RawMessage {
+	meta := map[string]interface{}{"action": action, "actor": req.Actor, "attempts": attempts}
+	if req.Reason != "" {
+		meta["reason"] = req.Reason
+	}
+	if lastErr != nil {
+		meta["error"] = *lastErr
+	}
+	if len(req.Input) > 0 {
+		meta["edited"] = true
+		meta["previous"] = previous
+	}
+	b, _ := json.Marshal(meta)
+	return b
+}
+
+// appendLog audits an action on a step in its logs. The action has been
+// applied by then, so a failure to record it is logged rather than returned.
+func appendLog(ctx context.Context, store engine.StateStore, stepID, action string, meta json.RawMessage) {
+	if err := store.AppendLog(ctx, &engine.StepLog{ID: uuid.NewString(), StepID: stepID, Level: "warn", Message: "dead-letter " + action, Meta: meta}); err != nil {
+		engine.DefaultLogger().Error("deadletter: failed to append audit log", engine.F("step_id", stepID), engine.F("action", action), engine.Err(err))
+	}
+}
+
+// appendAudit audits an action on an outbox event, like appendLog.
+func appendAudit(ctx context.Context, store engine.StateStore, eventID, action string, meta json.RawMessage) {
+	if err := store.AppendEventAudit(ctx, &engine.EventAudit{ID: uuid.NewString(), EventID: eventID, Level: "warn", Message: "dead-letter " + action, Meta: meta}); err != nil {
+		engine.DefaultLogger().Error("deadletter: failed to append audit", engine.F("event_id", eventID), engine.F("action", action), engine.Err(err))
+	}
+}
@@ -0,0 +1,141 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/google/uuid"
+)
+
+// failedStep inserts a step that used up its attempts in a failed run.
+func failedStep(t *testing.T, s *store.MemoryStore) *engine.WorkflowStepRecord {
+	t.Helper()
+	ctx := context.Background()
+	run := &engine.WorkflowRun{ID: uuid.NewString(), WorkflowType: "csr", WorkflowVersion: "v1", Status: engine.RunStatusFailed, Payload: []byte(`{}`)}
+	if err := s.CreateRun(ctx, run); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	msg := "smtp unavailable"
+	step := &engine.WorkflowStepRecord{
+		ID: uuid.NewString(), RunID: run.ID, StepName: "send_response", Seq: 1, Status: engine.StepStatusFailed,
+		Input: []byte(`{"to":"old@example.com"}`), Attempts: 5, MaxAttempts: 5, Error: &msg,
+	}
+	if err := s.InsertSteps(ctx, []*engine.WorkflowStepRecord{step}); err != nil {
+		t.Fatalf("insert step: %v", err)
+	}
+	return step
+}
+
+func failedEvent(t *testing.T, s *store.MemoryStore) *engine.OutboxEvent {
+	t.Helper()
+	msg := "no recipient"
+	ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: []byte(`{"to":""}`), State: engine.OutboxStateFailed, Attempts: 1, Error: &msg}
+	if err := s.EnqueueEvent(context.Background(), ev); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return ev
+}
+
+func meta(t *testing.T, raw json.RawMessage) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatalf("decode audit meta %s: %v", raw, err)
+	}
+	return m
+}
+
+func TestReplayStepAuditsEdit(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := context.Background()
+	step := failedStep(t, s)
+	req := Request{Actor: "ops@example.com", Reason: "address fixed", Input: json.RawMessage(`{"to":"new@example.com"}`)}
+
+	if err := ReplayStep(ctx, s, step.ID, Request{Input: json.RawMessage(`{"to":`)}); !errors.Is(err, ErrInvalidJSON) {
+		t.Fatalf("replay with invalid input = %v", err)
+	}
+	if err := ReplayStep(ctx, s, step.ID, req); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	got, _ := s.LoadStep(ctx, step.ID)
+	if got.Status != engine.StepStatusPending || got.Attempts != 0 || string(got.Input) != `{"to":"new@example.com"}` {
+		t.Fatalf("replayed step = %s, attempts %d, input %s", got.Status, got.Attempts, got.Input)
+	}
+	if err := ReplayStep(ctx, s, step.ID, req); !errors.Is(err, ErrNotDead) {
+		t.Fatalf("replay twice = %v", err)
+	}
+	if err := ReplayStep(ctx, s, uuid.NewString(), req); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("replay unknown step = %v", err)
+	}
+
+	logs, _ := s.ListRunLogs(ctx, step.RunID)
+	if len(logs) != 1 || logs[0].Message != "dead-letter replay" || logs[0].StepID != step.ID {
+		t.Fatalf("step logs = %+v", logs)
+	}
+	m := meta(t, logs[0].Meta)
+	if m["actor"] != "ops@example.com" || m["reason"] != "address fixed" || m["error"] != "smtp unavailable" || m["edited"] != true || m["attempts"] != float64(5) {
+		t.Fatalf("audit = %v", m)
+	}
+	if prev, _ := json.Marshal(m["previous"]); string(prev) != `{"to":"old@example.com"}` {
+		t.Fatalf("previous input = %s", prev)
+	}
+}
+
+func TestDiscardStep(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := context.Background()
+	step := failedStep(t, s)
+
+	if err := DiscardStep(ctx, s, step.ID, Request{Actor: "ops@example.com"}); err != nil {
+		t.Fatalf("discard: %v", err)
+	}
+	if got, _ := s.LoadStep(ctx, step.ID); got.Status != engine.StepStatusDiscarded {
+		t.Fatalf("discarded step = %s", got.Status)
+	}
+	if err := DiscardStep(ctx, s, step.ID, Request{}); !errors.Is(err, ErrNotDead) {
+		t.Fatalf("discard twice = %v", err)
+	}
+	logs, _ := s.ListRunLogs(ctx, step.RunID)
+	if len(logs) != 1 || logs[0].Message != "dead-letter discard" {
+		t.Fatalf("step logs = %+v", logs)
+	}
+	if m := meta(t, logs[0].Meta); m["edited"] != nil || m["previous"] != nil {
+		t.Fatalf("discard audit = %v", m)
+	}
+}
+
+func TestEventActionsAreAuditedOnTheEvent(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := context.Background()
+	replayed, discarded := failedEvent(t, s), failedEvent(t, s)
+
+	if err := ReplayEvent(ctx, s, replayed.ID, Request{Actor: "ops@example.com", Input: json.RawMessage(`{"to":"c@example.com"}`)}); err != nil {
+		t.Fatalf("replay event: %v", err)
+	}
+	if err := DiscardEvent(ctx, s, discarded.ID, Request{Actor: "ops@example.com", Reason: "customer left"}); err != nil {
+		t.Fatalf("discard event: %v", err)
+	}
+	if err := DiscardEvent(ctx, s, replayed.ID, Request{}); !errors.Is(err, ErrNotDead) {
+		t.Fatalf("discard a replayed event = %v", err)
+	}
+	if err := ReplayEvent(ctx, s, uuid.NewString(), Request{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("replay unknown event = %v", err)
+	}
+
+	for id, want := range map[string]string{replayed.ID: "dead-letter replay", discarded.ID: "dead-letter discard"} {
+		audits, err := s.ListEventAudits(ctx, id)
+		if err != nil || len(audits) != 1 || audits[0].Message != want {
+			t.Fatalf("audits of %s = %+v, %v; want %q", id, audits, err, want)
+		}
+		if m := meta(t, audits[0].Meta); m["actor"] != "ops@example.com" || m["error"] != "no recipient" {
+			t.Fatalf("audit of %s = %v", id, m)
+		}
+	}
+	if got, _ := s.LoadEvent(ctx, replayed.ID); got.State != engine.OutboxStatePending || string(got.Payload) != `{"to":"c@example.com"}` {
+		t.Fatalf("replayed event = %+v", got)
+	}
+}
@@ -0,0 +1,113 @@
+// Package deadline enforces overall run deadlines: once the deadline of a
+// running run passes, the run is cancelled like an operator cancel (its
+// remaining steps and child runs are cancelled and completed steps
+// compensated) and a workflow.run.deadline_exceeded outbox event is enqueued.
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	"github.com/google/uuid"
+)
+
+// Notification is the payload of a workflow.run.deadline_exceeded event.
+type Notification struct {
+	RunID           string    `json:"run_id"`
+	WorkflowType    string    `json:"workflow_type"`
+	WorkflowVersion string    `json:"workflow_version"`
+	WorkspaceID     string    `json:"workspace_id,omitempty"`
+	Deadline        time.Time `json:"deadline"`
+	// CancelledSteps names the steps that had not finished.
+	CancelledSteps []string `json:"cancelled_steps"`
+}
+
+// StartMonitor periodically expires running runs whose deadline passed.
+func StartMonitor(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, interval time.Duration, batchSize int) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ExpireDue(ctx, store, reg, batchSize); err != nil {
+					engine.DefaultLogger().Error("deadline: list expired runs failed", engine.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+// ExpireDue expires up to batchSize running runs whose deadline passed.
+// Failures to expire a single run are logged.
+func ExpireDue(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, batchSize int) error {
+	runs, err := store.ListExpiredRuns(ctx, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if err := Expire(ctx, store, reg, run); err != nil {
+			engine.DefaultLogger().Error("deadline: failed to expire run",
+				engine.F("run_id", run.ID), engine.F("workflow_type", run.WorkflowType), engine.Err(err))
+		}
+	}
+	return nil
+}
+
+// Expire cancels run and enqueues its deadline notification in the same
+// transaction, so a run is never cancelled for its deadline without one. A
+// run that is no longer running is left alone.
+func Expire(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, run *engine.WorkflowRun) error {
+	var n *Notification
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		cancelled, err := workflow.Cancel(ctx, store, reg, run.ID)
+		if err != nil || !cancelled {
+			return err
+		}
+		run, err := store.LoadRun(ctx, run.ID)
+		if err != nil || run == nil {
+			return err
+		}
+		n = notification(run)
+		payload, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		key := "run:" + run.ID + ":deadline"
+		return store.EnqueueEvent(ctx, &engine.OutboxEvent{
+			ID: uuid.NewString(), EventType: engine.EventRunDeadlineExceeded, Payload: payload,
+			State: engine.OutboxStatePending, IdempotencyKey: &key, TraceContext: run.TraceContext,
+		})
+	})
+	if err != nil || n == nil {
+		return err
+	}
+	engine.DefaultLogger().Info("deadline: run exceeded its deadline",
+		engine.F("run_id", run.ID), engine.F("workflow_type", run.WorkflowType), engine.F("cancelled_steps", len(n.CancelledSteps)))
+	return nil
+}
+
+// notification describes a run just cancelled for its deadline.
+func notification(run *engine.WorkflowRun) *Notification {
+	n := &Notification{
+		RunID: run.ID, WorkflowType: run.WorkflowType, WorkflowVersion: run.WorkflowVersion,
+		WorkspaceID: run.WorkspaceID, CancelledSteps: []string{},
+	}
+	if run.Deadline != nil {
+		n.Deadline = *run.Deadline
+	}
+	for _, st := range run.Steps {
+		if st.Status == engine.StepStatusCancelled {
+			n.CancelledSteps = append(n.CancelledSteps, st.StepName)
+		}
+	}
+	return n
+}
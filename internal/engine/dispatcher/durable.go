@@ -0,0 +1,347 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// Defaults for Options fields left zero.
+const (
+	DefaultPollInterval = 500 * time.Millisecond
+	DefaultBatchSize    = 100
+	DefaultAckTimeout   = 30 * time.Second
+	DefaultLeaseTTL     = 30 * time.Second
+	DefaultBuffer       = 100
+)
+
+// redeliveries counts how often a durable subscription went back to its
+// acknowledged offset because its consumer fell behind.
+var redeliveries uint64
+
+// Redeliveries returns the number of redeliveries observed so far.
+func Redeliveries() uint64 { return atomic.LoadUint64(&redeliveries) }
+
+// Options tunes a Durable dispatcher.
+type Options struct {
+	// PollInterval is how often subscriptions look for new events, renew
+	// their lease and persist acknowledgements.
+	PollInterval time.Duration
+	// BatchSize is how many events a subscription reads at a time.
+	BatchSize int
+	// AckTimeout is how long a durable subscription waits for its consumer
+	// to acknowledge delivered events before it delivers them again.
+	AckTimeout time.Duration
+	// LeaseTTL is how long a durable subscription stays with this
+	// dispatcher without being renewed. Another replica takes it over once
+	// the lease lapses.
+	LeaseTTL time.Duration
+	// Buffer is the capacity of subscriber channels.
+	Buffer int
+	// Logger receives the dispatcher's logs. Nil means
+	// engine.DefaultLogger().
+	Logger engine.Logger
+}
+
+func (o *Options) defaults() {
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultPollInterval
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.AckTimeout <= 0 {
+		o.AckTimeout = DefaultAckTimeout
+	}
+	if o.LeaseTTL <= 0 {
+		o.LeaseTTL = DefaultLeaseTTL
+	}
+	if o.Buffer <= 0 {
+		o.Buffer = DefaultBuffer
+	}
+	if o.Logger == nil {
+		o.Logger = engine.DefaultLogger()
+	}
+}
+
+// Durable is a Dispatcher that stores events in the outbox and delivers
+// them from there, so nothing is lost on restart and a slow subscriber
+// holds its events back instead of dropping them. Subscribers are
+// polled, so they see an event up to a PollInterval after the transaction
+// that dispatched it commits.
+//
+// Subscribe gives a subscription that lives as long as the process and
+// starts at the latest event. SubscribeDurable gives a named subscription
+// whose acknowledged offset is kept in the store; it resumes where it left
+// off and is delivered by one replica at a time.
+type Durable struct {
+	store  engine.EventLog
+	owner  string
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDurable returns a dispatcher storing events in store. owner identifies
+// this replica as the holder of durable subscriptions; every replica needs
+// its own.
+func NewDurable(store engine.EventLog, owner string, opts Options) *Durable {
+	opts.defaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Durable{store: store, owner: owner, opts: opts, ctx: ctx, cancel: cancel}
+}
+
+// Dispatch stores ev. An event with an idempotency key already stored is
+// not stored again.
+func (d *Durable) Dispatch(ctx context.Context, ev engine.OutboxEvent) error {
+	if ev.ID == "" {
+		ev.ID = uuid.NewString()
+	}
+	if ev.State == "" {
+		ev.State = engine.OutboxStatePending
+	}
+	return d.store.EnqueueEvent(ctx, &ev)
+}
+
+// Subscribe delivers the events dispatched from now on whose type matches
+// pattern, e.g. "run.*", until the dispatcher is closed.
+func (d *Durable) Subscribe(pattern string) (<-chan engine.OutboxEvent, error) {
+	after, err := d.store.LastEventSeq(d.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan engine.OutboxEvent, d.opts.Buffer)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(ch)
+		ticker := time.NewTicker(d.opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			events, err := d.store.ReadEvents(d.ctx, after, pattern, d.opts.BatchSize)
+			if err != nil && d.ctx.Err() == nil {
+				d.opts.Logger.Error("dispatcher: read events failed", engine.F("pattern", pattern), engine.Err(err))
+			}
+			for _, ev := range events {
+				select {
+				case ch <- *ev:
+					after = ev.Seq
+				case <-d.ctx.Done():
+					return
+				}
+			}
+			if len(events) == d.opts.BatchSize {
+				continue
+			}
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// SubscribeOptions configures a durable subscription.
+type SubscribeOptions struct {
+	// FromBeginning starts a new subscription at the oldest stored event
+	// rather than at the latest. It has no effect on a subscription that
+	// already exists.
+	FromBeginning bool
+}
+
+// Subscription is a durable subscription. Its deliveries arrive on C until
+// it is closed, its context is cancelled or the dispatcher is closed.
+type Subscription struct {
+	Name string
+	C    <-chan Delivery
+
+	acked  int64
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops the subscription, persists its last acknowledgement and
+// releases it to other replicas.
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
+
+func (s *Subscription) ack(seq int64) {
+	for {
+		cur := atomic.LoadInt64(&s.acked)
+		if seq <= cur || atomic.CompareAndSwapInt64(&s.acked, cur, seq) {
+			return
+		}
+	}
+}
+
+// Delivery is an event delivered to a durable subscription.
+type Delivery struct {
+	engine.OutboxEvent
+	// Redelivered is set when the event was delivered before but not
+	// acknowledged within the ack timeout.
+	Redelivered bool
+
+	sub *Subscription
+}
+
+// Ack acknowledges the event and every event delivered before it. The
+// subscription persists the offset in the background.
+func (dl Delivery) Ack() { dl.sub.ack(dl.Seq) }
+
+// SubscribeDurable starts delivering the events of the subscription named
+// name whose type matches pattern, creating the subscription if needed.
+// Events are delivered at least once: those not acknowledged within
+// AckTimeout are delivered again, as are those not acknowledged when the
+// subscription moves to another replica.
+func (d *Durable) SubscribeDurable(ctx context.Context, name, pattern string, opts SubscribeOptions) (*Subscription, error) {
+	if name == "" {
+		return nil, errors.New("dispatcher: subscription name is required")
+	}
+	var start int64
+	if !opts.FromBeginning {
+		var err error
+		if start, err = d.store.LastEventSeq(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := d.store.EnsureSubscription(ctx, &engine.EventSubscription{Name: name, Pattern: pattern, AckedSeq: start}); err != nil {
+		return nil, err
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	ch := make(chan Delivery, d.opts.Buffer)
+	sub := &Subscription{Name: name, C: ch, cancel: cancel, done: make(chan struct{})}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(sub.done)
+		defer close(ch)
+		defer stop()
+		d.deliver(subCtx, sub, pattern, ch)
+	}()
+	return sub, nil
+}
+
+// deliver runs a durable subscription while its context lasts. Each round
+// it takes or renews the lease, persists the consumer's acknowledgements,
+// rewinds to the acknowledged offset if the consumer stopped making
+// progress, and sends the next events. A full channel only delays the
+// round, so the lease stays renewed while the consumer catches up.
+func (d *Durable) deliver(ctx context.Context, sub *Subscription, pattern string, ch chan<- Delivery) {
+	var (
+		held      bool
+		renewAt   time.Time
+		committed int64 // offset persisted in the store
+		sent      int64 // last event sent since the subscription was taken or rewound
+		maxSent   int64 // last event ever sent, to flag redeliveries
+		progress  time.Time
+	)
+	defer func() {
+		if !held {
+			return
+		}
+		bg := context.Background()
+		if a := atomic.LoadInt64(&sub.acked); a > committed {
+			if _, err := d.store.AckSubscription(bg, sub.Name, d.owner, a); err != nil {
+				d.opts.Logger.Error("dispatcher: ack subscription failed", engine.F("subscription", sub.Name), engine.Err(err))
+			}
+		}
+		if err := d.store.ReleaseSubscription(bg, sub.Name, d.owner); err != nil {
+			d.opts.Logger.Error("dispatcher: release subscription failed", engine.F("subscription", sub.Name), engine.Err(err))
+		}
+	}()
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		if !held || !now.Before(renewAt) {
+			leased, err := d.store.LeaseSubscription(ctx, sub.Name, d.owner, d.opts.LeaseTTL)
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					d.opts.Logger.Error("dispatcher: lease subscription failed", engine.F("subscription", sub.Name), engine.Err(err))
+				}
+				held = false
+			case leased == nil:
+				held = false
+			default:
+				if !held {
+					committed, sent = leased.AckedSeq, leased.AckedSeq
+				}
+				held, renewAt = true, now.Add(d.opts.LeaseTTL/3)
+			}
+		}
+		if held {
+			if a := atomic.LoadInt64(&sub.acked); a > committed {
+				ok, err := d.store.AckSubscription(ctx, sub.Name, d.owner, a)
+				switch {
+				case err != nil:
+					if ctx.Err() == nil {
+						d.opts.Logger.Error("dispatcher: ack subscription failed", engine.F("subscription", sub.Name), engine.Err(err))
+					}
+				case !ok:
+					// another replica took the subscription over
+					held = false
+				default:
+					committed, progress = a, now
+				}
+			}
+		}
+		if held {
+			if sent <= committed {
+				sent, progress = committed, now
+			} else if now.Sub(progress) >= d.opts.AckTimeout {
+				atomic.AddUint64(&redeliveries, 1)
+				d.opts.Logger.Warn("dispatcher: redelivering events not acknowledged in time", engine.F("subscription", sub.Name), engine.F("acked_seq", committed), engine.F("ack_timeout", d.opts.AckTimeout))
+				sent, progress = committed, now
+			}
+			events, err := d.store.ReadEvents(ctx, sent, pattern, d.opts.BatchSize)
+			if err != nil && ctx.Err() == nil {
+				d.opts.Logger.Error("dispatcher: read events failed", engine.F("subscription", sub.Name), engine.Err(err))
+			}
+			more := len(events) == d.opts.BatchSize
+		send:
+			for _, ev := range events {
+				select {
+				case ch <- Delivery{OutboxEvent: *ev, Redelivered: ev.Seq <= maxSent, sub: sub}:
+					sent = ev.Seq
+					if sent > maxSent {
+						maxSent = sent
+					}
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					// the consumer is behind; renew and commit, then go on
+					more = true
+					break send
+				}
+			}
+			if more {
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops every subscription and waits for them to release their
+// leases.
+func (d *Durable) Close() error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
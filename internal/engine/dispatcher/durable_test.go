@@ -0,0 +1,117 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+)
+
+var testOptions = Options{PollInterval: 5 * time.Millisecond, AckTimeout: 100 * time.Millisecond, LeaseTTL: time.Second}
+
+func dispatch(t *testing.T, d *Durable, eventTypes ...string) {
+	t.Helper()
+	for _, typ := range eventTypes {
+		if err := d.Dispatch(context.Background(), engine.OutboxEvent{EventType: typ, Payload: []byte(`{}`)}); err != nil {
+			t.Fatalf("dispatch %s: %v", typ, err)
+		}
+	}
+}
+
+func receive(t *testing.T, sub *Subscription) Delivery {
+	t.Helper()
+	select {
+	case dl, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return dl
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery")
+	}
+	return Delivery{}
+}
+
+func TestDurableSubscriptionResumesFromAcknowledgedOffset(t *testing.T) {
+	s := store.NewMemoryStore()
+	d := NewDurable(s, "worker-a", testOptions)
+	defer d.Close()
+	dispatch(t, d, engine.EventRunStarted, engine.EventStepCompleted, engine.EventRunFailed, engine.EventRunCompleted)
+
+	sub, err := d.SubscribeDurable(context.Background(), "audit", "run.*", SubscribeOptions{FromBeginning: true})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	first := receive(t, sub)
+	second := receive(t, sub)
+	if first.EventType != engine.EventRunStarted || second.EventType != engine.EventRunFailed {
+		t.Fatalf("deliveries = %s, %s; want the run events in order", first.EventType, second.EventType)
+	}
+	second.Ack()
+	sub.Close()
+
+	// another replica picks up after the acknowledged event
+	other := NewDurable(s, "worker-b", testOptions)
+	defer other.Close()
+	sub, err = other.SubscribeDurable(context.Background(), "audit", "run.*", SubscribeOptions{FromBeginning: true})
+	if err != nil {
+		t.Fatalf("subscribe again: %v", err)
+	}
+	defer sub.Close()
+	if dl := receive(t, sub); dl.EventType != engine.EventRunCompleted || dl.Redelivered {
+		t.Fatalf("delivery after resuming = %s (redelivered %v), want %s", dl.EventType, dl.Redelivered, engine.EventRunCompleted)
+	}
+}
+
+func TestDurableRedeliversUnacknowledgedEvents(t *testing.T) {
+	d := NewDurable(store.NewMemoryStore(), "worker-a", testOptions)
+	defer d.Close()
+	sub, err := d.SubscribeDurable(context.Background(), "slow", ">", SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Close()
+	before := Redeliveries()
+	dispatch(t, d, engine.EventRunStarted)
+
+	first := receive(t, sub)
+	again := receive(t, sub)
+	if again.ID != first.ID || first.Redelivered || !again.Redelivered {
+		t.Fatalf("deliveries = %s (redelivered %v), %s (redelivered %v); want the same event redelivered", first.ID, first.Redelivered, again.ID, again.Redelivered)
+	}
+	if Redeliveries() <= before {
+		t.Fatal("redelivery not counted")
+	}
+	again.Ack()
+	dispatch(t, d, engine.EventRunCompleted)
+	if dl := receive(t, sub); dl.EventType != engine.EventRunCompleted {
+		t.Fatalf("delivery after the ack = %s", dl.EventType)
+	}
+}
+
+func TestDurableSubscribeWaitsForSlowSubscribers(t *testing.T) {
+	opts := testOptions
+	opts.Buffer = 1
+	d := NewDurable(store.NewMemoryStore(), "worker-a", opts)
+	defer d.Close()
+	ch, err := d.Subscribe("step.*")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		dispatch(t, d, engine.EventStepCompleted, engine.EventRunStarted)
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case ev := <-ch:
+			if ev.EventType != engine.EventStepCompleted {
+				t.Fatalf("event %d = %s", i, ev.EventType)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d was not delivered", i)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package dispatcher
 
 import (
 	"context"
-	"log"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -87,10 +86,10 @@ func (d *InMemDispatcher) Dispatch(ctx context.Context, ev engine.OutboxEvent) e
 				case <-ctx.Done():
 					// context cancelled before delivery; count as dropped
 					atomic.AddUint64(&droppedDeliveries, 1)
-					log.Printf("dispatcher: delivery cancelled for event=%s subscriber=%d: %v", ev.EventType, idx, ctx.Err())
+					engine.DefaultLogger().Warn("dispatcher: delivery cancelled", engine.F("event_type", ev.EventType), engine.F("subscriber", idx), engine.Err(ctx.Err()))
 				case <-time.After(DeliveryTimeout):
 					atomic.AddUint64(&droppedDeliveries, 1)
-					log.Printf("dispatcher: dropped event=%s for subscriber=%d after timeout=%s", ev.EventType, idx, DeliveryTimeout)
+					engine.DefaultLogger().Warn("dispatcher: event dropped", engine.F("event_type", ev.EventType), engine.F("subscriber", idx), engine.F("timeout", DeliveryTimeout))
 				}
 			} else {
 				// No context provided, use simple timeout
@@ -98,7 +97,7 @@ func (d *InMemDispatcher) Dispatch(ctx context.Context, ev engine.OutboxEvent) e
 				case ch <- ev:
 				case <-time.After(DeliveryTimeout):
 					atomic.AddUint64(&droppedDeliveries, 1)
-					log.Printf("dispatcher: dropped event=%s for subscriber=%d after timeout=%s", ev.EventType, idx, DeliveryTimeout)
+					engine.DefaultLogger().Warn("dispatcher: event dropped", engine.F("event_type", ev.EventType), engine.F("subscriber", idx), engine.F("timeout", DeliveryTimeout))
 				}
 			}
 		}
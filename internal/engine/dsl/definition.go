@@ -0,0 +1,306 @@
+// Package dsl loads declarative workflow definitions written in YAML or JSON
+// and runs them as engine.Workflow implementations, so workflows can be
+// authored without writing Go:
+//
+//	id: lead_followup
+//	version: v1
+//	steps:
+//	  - name: score_lead
+//	    action: llm.draft
+//	    input:
+//	      prompt: "Score this lead from 0 to 100: {{ run.payload.notes }}"
+//	    next:
+//	      - if: steps.score_lead.output.score >= 80
+//	        goto: [notify_owner, enrich]   # parallel branches
+//	      - goto: nurture
+//	  - name: notify_owner
+//	    action: email.send
+//	    input: {to: "{{ run.payload.owner }}", subject: Hot lead, body: "{{ run.payload.name }}"}
+//	  - name: enrich
+//	    ...
+//	  - name: summarize
+//	    action: llm.draft
+//	    after: [notify_owner, enrich]      # join
+//
+// Each step runs an action from the catalog passed to Register, waits for a
+// duration (wait), runs an action per element of a list (map) or starts a
+// run of another workflow and waits for it (run). A step runs at most once per
+// run; the first matching transition of a completed step picks the next
+// step(s). Steps listed in after run once all of them completed, or once
+// every branch that can still reach them has finished. A step with
+// compensate is undone by another action if the run fails or is cancelled.
+// Running runs of the versions listed in migrate_from can be moved to a
+// definition, provided every step they ran is still declared.
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is a declarative workflow.
+type Definition struct {
+	ID          string `yaml:"id" json:"id"`
+	Version     string `yaml:"version" json:"version"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// Start lists the steps a run begins with; the first step when empty.
+	Start Names      `yaml:"start,omitempty" json:"start,omitempty"`
+	Steps []StepSpec `yaml:"steps" json:"steps"`
+	// Timeout bounds every run (e.g. 72h): once it passes, the remaining
+	// steps are cancelled.
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// MigrateFrom lists the versions whose running runs may be moved to
+	// this version.
+	MigrateFrom Names `yaml:"migrate_from,omitempty" json:"migrate_from,omitempty"`
+}
+
+// StepSpec declares one step.
+type StepSpec struct {
+	Name string `yaml:"name" json:"name"`
+	// Action names the handler that executes the step.
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+	// Input is passed to the action as JSON. Strings may reference the run
+	// payload and earlier outputs as {{ run.payload.x }} or
+	// {{ steps.<name>.output.x }}; a string that is a single reference is
+	// replaced by the referenced value itself.
+	Input interface{} `yaml:"input,omitempty" json:"input,omitempty"`
+	// Wait makes the step a durable timer of the given duration (e.g. 72h)
+	// instead of an action.
+	Wait  string     `yaml:"wait,omitempty" json:"wait,omitempty"`
+	Retry *RetrySpec `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// After makes the step a join of the listed steps.
+	After Names        `yaml:"after,omitempty" json:"after,omitempty"`
+	Next  []Transition `yaml:"next,omitempty" json:"next,omitempty"`
+	// Compensate undoes the step if the run fails or is cancelled after
+	// the step completed.
+	Compensate *CompensateSpec `yaml:"compensate,omitempty" json:"compensate,omitempty"`
+	// Map runs an action once per element of an array instead.
+	Map *MapSpec `yaml:"map,omitempty" json:"map,omitempty"`
+	// Run starts a run of another workflow instead and waits for it.
+	Run *RunSpec `yaml:"run,omitempty" json:"run,omitempty"`
+}
+
+// MapSpec fans a step out: Action runs once per element of Items (a list or
+// a reference to one) with input {"index", "item"}, at most Concurrency at a
+// time. Aggregate, when set, runs on {"items": [outputs]} once all
+// completed and provides the step's output.
+type MapSpec struct {
+	Items       interface{} `yaml:"items" json:"items"`
+	Action      string      `yaml:"action" json:"action"`
+	Concurrency int         `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+	Aggregate   string      `yaml:"aggregate,omitempty" json:"aggregate,omitempty"`
+}
+
+// RunSpec starts a child run of Workflow with Payload, which may reference
+// the run and its steps. The step outputs {"run_id", "status", "output"}.
+type RunSpec struct {
+	Workflow string      `yaml:"workflow" json:"workflow"`
+	Payload  interface{} `yaml:"payload,omitempty" json:"payload,omitempty"`
+}
+
+// CompensateSpec runs Action to undo a step. Input may reference the run
+// and its steps like a step input; when omitted the action receives the
+// undone step as {"step", "step_id", "input", "output"}.
+type CompensateSpec struct {
+	Action string      `yaml:"action" json:"action"`
+	Input  interface{} `yaml:"input,omitempty" json:"input,omitempty"`
+}
+
+// Handler names of the compensation, map items and map aggregate of a step;
+// the dot keeps them apart from declared step names.
+func compensationStep(step string) string { return "compensate." + step }
+func itemStep(step string) string         { return "item." + step }
+func aggregateStep(step string) string    { return "aggregate." + step }
+
+// declaredStep returns the declared step a step record belongs to.
+func declaredStep(name string) string {
+	for _, prefix := range []string{"compensate.", "item.", "aggregate."} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// Transition moves to Goto when If holds, or unconditionally when If is
+// empty. Several targets start in parallel.
+type Transition struct {
+	If   string `yaml:"if,omitempty" json:"if,omitempty"`
+	Goto Names  `yaml:"goto" json:"goto"`
+}
+
+// RetrySpec overrides the engine's default retry policy for a step.
+type RetrySpec struct {
+	MaxAttempts      int      `yaml:"max_attempts" json:"max_attempts"`
+	InitialBackoff   string   `yaml:"initial_backoff,omitempty" json:"initial_backoff,omitempty"`
+	MaxBackoff       string   `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+	Jitter           float64  `yaml:"jitter,omitempty" json:"jitter,omitempty"`
+	RetryableClasses []string `yaml:"retryable_classes,omitempty" json:"retryable_classes,omitempty"`
+}
+
+// Names is a list of step names that may be written as a single string.
+type Names []string
+
+func (n *Names) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*n = Names{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*n = list
+	return nil
+}
+
+func (n *Names) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*n = Names{one}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*n = list
+	return nil
+}
+
+// Step returns the spec of the named step.
+func (d *Definition) Step(name string) (*StepSpec, bool) {
+	for i := range d.Steps {
+		if d.Steps[i].Name == name {
+			return &d.Steps[i], true
+		}
+	}
+	return nil, false
+}
+
+func (r *RetrySpec) policy() (*engine.RetryPolicy, error) {
+	p := &engine.RetryPolicy{MaxAttempts: r.MaxAttempts, Jitter: r.Jitter, RetryableClasses: r.RetryableClasses}
+	var err error
+	if r.InitialBackoff != "" {
+		if p.InitialBackoff, err = time.ParseDuration(r.InitialBackoff); err != nil {
+			return nil, fmt.Errorf("initial_backoff: %w", err)
+		}
+	}
+	if r.MaxBackoff != "" {
+		if p.MaxBackoff, err = time.ParseDuration(r.MaxBackoff); err != nil {
+			return nil, fmt.Errorf("max_backoff: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Parse decodes a definition. JSON is accepted as well, being a subset of
+// YAML; unknown fields are rejected.
+func Parse(data []byte) (*Definition, error) {
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	var def Definition
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("dsl: %w", err)
+	}
+	for i := range def.Steps {
+		def.Steps[i].Input = normalize(def.Steps[i].Input)
+		if c := def.Steps[i].Compensate; c != nil {
+			c.Input = normalize(c.Input)
+		}
+		if m := def.Steps[i].Map; m != nil {
+			m.Items = normalize(m.Items)
+		}
+		if r := def.Steps[i].Run; r != nil {
+			r.Payload = normalize(r.Payload)
+		}
+	}
+	return &def, nil
+}
+
+// normalize converts values decoded from YAML into their encoding/json
+// equivalents, so references resolve against them like against step outputs.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, x := range t {
+			t[k] = normalize(x)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			m[fmt.Sprint(k)] = normalize(x)
+		}
+		return m
+	case []interface{}:
+		for i, x := range t {
+			t[i] = normalize(x)
+		}
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	}
+	return v
+}
+
+// LoadFile parses the definition in path.
+func LoadFile(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return def, nil
+}
+
+// IsDefinitionFile reports whether path has a definition file extension.
+func IsDefinitionFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadDir parses every .yaml, .yml and .json file in dir, in name order. Two
+// files defining the same id and version are an error.
+func LoadDir(dir string) ([]*Definition, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var defs []*Definition
+	defined := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() || !IsDefinitionFile(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		def, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		id := def.ID + "@" + def.Version
+		if first, ok := defined[id]; ok {
+			return nil, fmt.Errorf("dsl: %s: %s is already defined in %s", path, id, first)
+		}
+		defined[id] = path
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
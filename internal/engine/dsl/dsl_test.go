@@ -0,0 +1,394 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/enginetest"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+)
+
+const triage = `
+id: triage
+version: v1
+start: [fetch, retrieve]
+steps:
+  - name: fetch
+    action: ticket.fetch
+    input: "{{ run.payload }}"
+  - name: retrieve
+    action: rag.retrieve
+    input: {query: "{{ run.payload.message }}"}
+  - name: draft
+    action: llm.draft
+    after: [fetch, retrieve]
+    input:
+      prompt: "Reply to {{ run.payload.message }} using {{ steps.retrieve.output.context }}"
+    next:
+      - if: steps.retrieve.output.score < 0.6
+        goto: review
+      - goto: send
+  - name: review
+    action: human.review
+    input: {draft: "{{ steps.draft.output.draft }}"}
+  - name: send
+    action: email.send
+    input: {body: "{{ steps.draft.output.draft }}", meta: "{{ steps.retrieve.output }}"}
+    compensate:
+      action: email.send
+      input: {body: "Please disregard: {{ steps.draft.output.draft }}"}
+`
+
+var actions = map[string]bool{"ticket.fetch": true, "rag.retrieve": true, "llm.draft": true, "human.review": true, "email.send": true}
+
+func plan(t *testing.T, w *Workflow, run *engine.WorkflowRun) []engine.WorkflowStepDef {
+	t.Helper()
+	defs, err := w.Plan(context.Background(), run)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	return defs
+}
+
+func names(defs []engine.WorkflowStepDef) string {
+	var n []string
+	for _, d := range defs {
+		n = append(n, d.StepName)
+	}
+	return strings.Join(n, ",")
+}
+
+// input returns the input def would run with against run as it is now.
+func input(t *testing.T, run *engine.WorkflowRun, def engine.WorkflowStepDef) string {
+	t.Helper()
+	if len(def.Template) == 0 {
+		return string(def.Input)
+	}
+	b, err := binding.Bind(def.Template, run)
+	if err != nil {
+		t.Fatalf("bind %s: %v", def.StepName, err)
+	}
+	return string(b)
+}
+
+func complete(run *engine.WorkflowRun, name, result string) {
+	run.Steps = append(run.Steps, engine.WorkflowStepRecord{StepName: name, Status: engine.StepStatusCompleted, Result: json.RawMessage(result), UpdatedAt: time.Now()})
+}
+
+func TestPlanParallelJoinAndCondition(t *testing.T) {
+	def, err := Parse([]byte(triage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(def, actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &engine.WorkflowRun{ID: "r1", Payload: json.RawMessage(`{"message":"refund please"}`)}
+
+	defs := plan(t, w, run)
+	if names(defs) != "fetch,retrieve" {
+		t.Fatalf("start steps = %s", names(defs))
+	}
+	if input(t, run, defs[0]) != `{"message":"refund please"}` || input(t, run, defs[1]) != `{"query":"refund please"}` {
+		t.Fatalf("inputs = %s, %s", defs[0].Template, defs[1].Template)
+	}
+
+	// the join waits for both branches
+	complete(run, "fetch", `{}`)
+	complete(run, "retrieve", `{"context":"policy","score":0.4}`)
+	defs = plan(t, w, run)
+	if names(defs) != "draft" {
+		t.Fatalf("after branches = %s", names(defs))
+	}
+	if got := input(t, run, defs[0]); got != `{"prompt":"Reply to refund please using policy"}` {
+		t.Fatalf("draft input = %s", got)
+	}
+
+	complete(run, "draft", `{"draft":"Sure"}`)
+	if got := names(plan(t, w, run)); got != "review" {
+		t.Fatalf("low score routes to %s, want review", got)
+	}
+
+	run.Steps[1].Result = json.RawMessage(`{"context":"policy","score":0.9}`)
+	defs = plan(t, w, run)
+	if names(defs) != "send" {
+		t.Fatalf("high score routes to %s, want send", names(defs))
+	}
+	if got := input(t, run, defs[0]); got != `{"body":"Sure","meta":{"context":"policy","score":0.9}}` {
+		t.Fatalf("send input = %s", got)
+	}
+	if c := defs[0].Compensate; c == nil || c.StepName != "compensate.send" || string(c.Input) != `{"body":"Please disregard: {{ steps.draft.output.draft }}"}` {
+		t.Fatalf("send compensation = %+v", c)
+	}
+
+	complete(run, "send", `{}`)
+	if defs := plan(t, w, run); len(defs) != 0 {
+		t.Fatalf("finished run planned %s", names(defs))
+	}
+}
+
+func TestJoinRunsWhenBranchIsSkipped(t *testing.T) {
+	def, err := Parse([]byte(`
+id: j
+version: v1
+steps:
+  - name: a
+    action: x
+    next:
+      - if: run.payload.deep
+        goto: [b, c]
+      - goto: c
+  - name: b
+    action: x
+    next: [{goto: b2}]
+  - name: b2
+    action: x
+  - name: c
+    action: x
+  - name: done
+    action: x
+    after: [b2, c]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(def, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deep := &engine.WorkflowRun{Payload: json.RawMessage(`{"deep":true}`)}
+	complete(deep, "a", `{}`)
+	if got := names(plan(t, w, deep)); got != "b,c" {
+		t.Fatalf("deep = %s", got)
+	}
+	complete(deep, "b", `{}`)
+	complete(deep, "c", `{}`)
+	if got := names(plan(t, w, deep)); got != "b2" {
+		t.Fatalf("join must wait for b2, planned %s", got)
+	}
+
+	shallow := &engine.WorkflowRun{Payload: json.RawMessage(`{"deep":false}`)}
+	complete(shallow, "a", `{}`)
+	complete(shallow, "c", `{}`)
+	if got := names(plan(t, w, shallow)); got != "done" {
+		t.Fatalf("shallow = %s, want done", got)
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	def, err := Parse([]byte(`
+id: bad
+version: v1
+timeout: forever
+steps:
+  - name: a
+    action: nope
+    input: {x: "{{ steps.missing.output }}"}
+    next:
+      - if: "steps.a.output.score <"
+        goto: b
+  - name: b
+    wait: soon
+  - name: orphan
+    action: llm.draft
+    compensate: {action: calendar.delete}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Validate(def, actions)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{`unknown action "nope"`, `unknown step "missing"`, "condition", `wait "soon"`, `unknown compensate action "calendar.delete"`, `timeout "forever"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	def.Steps[0].Action, def.Steps[0].Input, def.Steps[0].Next[0].If, def.Steps[1].Wait = "llm.draft", nil, "", "1h"
+	def.Steps[1].Next, def.Steps[2].Compensate, def.Timeout = []Transition{{Goto: Names{"a"}}}, nil, "72h"
+	err = Validate(def, actions)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	s := binding.Scope{"run": map[string]interface{}{"payload": map[string]interface{}{"tier": "gold", "n": 3.0, "tags": []interface{}{"a"}}}}
+	for src, want := range map[string]bool{
+		"run.payload.tier == 'gold'":                    true,
+		`run.payload.tier != "gold"`:                    false,
+		"run.payload.n >= 3 && run.payload.n < 4":       true,
+		"!(run.payload.n > 3) || run.payload.missing":   true,
+		"run.payload.missing < 1":                       false,
+		"run.payload.missing == null":                   true,
+		"run.payload.tags.0 == 'a' && run.payload.tags": true,
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got := Eval(e, s); got != want {
+			t.Errorf("%s = %v, want %v", src, got, want)
+		}
+	}
+	for _, src := range []string{"run.payload.n =", "a.b == 1", "(run.payload.n", "run.payload.n = 1"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("%s: expected parse error", src)
+		}
+	}
+}
+
+func TestMapAndRunSteps(t *testing.T) {
+	def, err := Parse([]byte(`
+id: campaign
+version: v1
+steps:
+  - name: read_sheet
+    action: x
+    next: [{goto: enrich}]
+  - name: enrich
+    map: {items: "{{ steps.read_sheet.output.rows }}", action: x, concurrency: 5, aggregate: y}
+    next: [{goto: followup}]
+  - name: followup
+    run: {workflow: lead_followup, payload: {leads: "{{ steps.enrich.output.items }}"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(def, map[string]bool{"x": true, "y": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &engine.WorkflowRun{}
+	complete(run, "read_sheet", `{"rows":[{"email":"a@example.com"}]}`)
+	defs := plan(t, w, run)
+	if defs[0].Kind != engine.StepKindMap || input(t, run, defs[0]) != `{"aggregate":"aggregate.enrich","concurrency":5,"item_step":"item.enrich","items":[{"email":"a@example.com"}]}` {
+		t.Fatalf("map step = %s %s", defs[0].Kind, input(t, run, defs[0]))
+	}
+	complete(run, "enrich", `{"items":[1]}`)
+	defs = plan(t, w, run)
+	if defs[0].Kind != engine.StepKindChildRun || input(t, run, defs[0]) != `{"payload":{"leads":[1]},"workflow_type":"lead_followup"}` {
+		t.Fatalf("run step = %s %s", defs[0].Kind, input(t, run, defs[0]))
+	}
+
+	def.Steps[1].Action = "x"
+	if err := Validate(def, nil); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("expected a mutually exclusive error, got %v", err)
+	}
+	def.Steps[1].Map.Items, def.Steps[1].Action = 3, ""
+	if err := Validate(def, nil); err == nil || !strings.Contains(err.Error(), "map items must be a list") {
+		t.Fatalf("expected a map items error, got %v", err)
+	}
+}
+
+func TestMigrateFrom(t *testing.T) {
+	def, err := Parse([]byte(`
+id: m
+version: v2
+migrate_from: v1
+steps:
+  - name: a
+    action: x
+    next: [{goto: b}]
+  - name: b
+    action: x
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(def, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &engine.WorkflowRun{}
+	complete(run, "a", `{}`)
+	if err := w.Migrate(context.Background(), run, "v1"); err != nil {
+		t.Fatalf("migrate from v1: %v", err)
+	}
+	if err := w.Migrate(context.Background(), run, "v0"); err == nil {
+		t.Fatal("migrated from a version not listed in migrate_from")
+	}
+	complete(run, "gone", `{}`)
+	if err := w.Migrate(context.Background(), run, "v1"); err == nil || !strings.Contains(err.Error(), `"gone"`) {
+		t.Fatalf("expected an undeclared step error, got %v", err)
+	}
+}
+
+// Branches advance independently: one drafts while the other sits on a
+// timer, and the join runs once both are done.
+func TestParallelBranchesRunConcurrentlyAndJoin(t *testing.T) {
+	def, err := Parse([]byte(`
+id: outreach
+version: v1
+start: [research, cooldown]
+steps:
+  - name: research
+    action: lead.research
+    next: [{goto: draft}]
+  - name: draft
+    action: lead.draft
+  - name: cooldown
+    wait: 1h
+  - name: send
+    action: lead.send
+    after: [draft, cooldown]
+    input: {body: "{{ steps.draft.output.body }}"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := enginetest.New(t)
+	var sent string
+	ok := func(output string) engine.StepHandler {
+		return executor.NewHandler("", func(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+			return engine.StepResult{Success: true, Output: json.RawMessage(output)}, nil
+		})
+	}
+	actions := map[string]engine.StepHandler{
+		"lead.research": ok(`{"company":"Acme"}`),
+		"lead.draft":    ok(`{"body":"Hi Acme"}`),
+		"lead.send": executor.NewHandler("", func(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+			var in struct{ Body string }
+			_ = json.Unmarshal(ec.Step.Input, &in)
+			sent = in.Body
+			return engine.StepResult{Success: true}, nil
+		}),
+	}
+	if _, err := Register(h.Registry, h.Handlers, def, actions); err != nil {
+		t.Fatal(err)
+	}
+	// a definition may not replace a registered workflow of the same version
+	if _, err := Register(h.Registry, h.Handlers, def, actions); err == nil || !strings.Contains(err.Error(), "outreach@v1 is already registered") {
+		t.Fatalf("registering outreach@v1 twice: %v", err)
+	}
+	run := h.Start("outreach", map[string]string{"lead": "acme"})
+
+	// the draft branch finishes while the other one waits on its timer
+	h.Drain()
+	h.RequireStepStatus(run.ID, "draft", engine.StepStatusCompleted)
+	h.RequireStepStatus(run.ID, "cooldown", engine.StepStatusPending)
+	h.RequireSteps(run.ID, "research", "cooldown", "draft")
+
+	h.Advance(time.Hour)
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+	h.RequireSteps(run.ID, "research", "cooldown", "draft", "send")
+	if sent != "Hi Acme" {
+		t.Fatalf("join sent %q", sent)
+	}
+	seqs := make(map[int]bool)
+	for _, st := range h.Run(run.ID).Steps {
+		if seqs[st.Seq] {
+			t.Fatalf("seq %d allocated twice", st.Seq)
+		}
+		seqs[st.Seq] = true
+	}
+}
@@ -0,0 +1,315 @@
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+)
+
+// Conditions are small boolean expressions over references into the run:
+//
+//	steps.retrieve_context.output.chunks.0.score < 0.6 || !steps.retrieve_context.output.chunks
+//	run.payload.tier == 'enterprise' && steps.score_lead.output.score >= 80
+//
+// Supported are ||, &&, !, parentheses, the comparisons == != < <= > >=,
+// number, 'string' / "string", true, false and null literals and references.
+// References are those of package binding. A reference that does not
+// resolve evaluates to null; ordering comparisons involving null are false.
+
+// Expr is a compiled condition.
+type Expr interface {
+	eval(s binding.Scope) interface{}
+}
+
+type ref struct{ binding.Ref }
+
+func (r ref) eval(s binding.Scope) interface{} { return r.Resolve(s) }
+
+type literal struct{ v interface{} }
+
+func (l literal) eval(binding.Scope) interface{} { return l.v }
+
+type not struct{ x Expr }
+
+func (n not) eval(s binding.Scope) interface{} { return !truthy(n.x.eval(s)) }
+
+type binary struct {
+	op   string
+	l, r Expr
+}
+
+func (b binary) eval(s binding.Scope) interface{} {
+	switch b.op {
+	case "||":
+		return truthy(b.l.eval(s)) || truthy(b.r.eval(s))
+	case "&&":
+		return truthy(b.l.eval(s)) && truthy(b.r.eval(s))
+	}
+	return compare(b.op, b.l.eval(s), b.r.eval(s))
+}
+
+// Eval evaluates a compiled condition.
+func Eval(e Expr, s binding.Scope) bool { return truthy(e.eval(s)) }
+
+// Refs returns every reference used in e.
+func Refs(e Expr) []binding.Ref {
+	switch x := e.(type) {
+	case ref:
+		return []binding.Ref{x.Ref}
+	case not:
+		return Refs(x.x)
+	case binary:
+		return append(Refs(x.l), Refs(x.r)...)
+	}
+	return nil
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
+
+func compare(op string, l, r interface{}) bool {
+	if lf, ok := l.(float64); ok {
+		if rf, ok := r.(float64); ok {
+			switch op {
+			case "==":
+				return lf == rf
+			case "!=":
+				return lf != rf
+			case "<":
+				return lf < rf
+			case "<=":
+				return lf <= rf
+			case ">":
+				return lf > rf
+			case ">=":
+				return lf >= rf
+			}
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch op {
+			case "<":
+				return ls < rs
+			case "<=":
+				return ls <= rs
+			case ">":
+				return ls > rs
+			case ">=":
+				return ls >= rs
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	return false
+}
+
+func equal(l, r interface{}) bool {
+	lb, err1 := json.Marshal(l)
+	rb, err2 := json.Marshal(r)
+	return err1 == nil && err2 == nil && string(lb) == string(rb)
+}
+
+// ParseExpr compiles a condition.
+func ParseExpr(src string) (Expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.toks[p.pos].text, src)
+	}
+	return e, nil
+}
+
+type token struct {
+	kind byte // 'o' operator, 'n' number, 's' string, 'i' identifier/reference
+	text string
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, token{'o', string(c)})
+			i++
+		case strings.ContainsRune("=!<>&|", c):
+			op := string(c)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("invalid operator %q in %q", op, src)
+			}
+			toks = append(toks, token{'o', op})
+			i += len(op)
+		case c == '\'' || c == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != c {
+				j++
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("unterminated string in %q", src)
+			}
+			toks = append(toks, token{'s', string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{'n', string(rs[i:j])})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '.' || rs[j] == '-') {
+				j++
+			}
+			toks = append(toks, token{'i', string(rs[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", c, src)
+		}
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek(op string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == 'o' && p.toks[p.pos].text == op
+}
+
+func (p *parser) or() (Expr, error) {
+	l, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var r Expr
+		if r, err = p.and(); err == nil {
+			l = binary{op: "||", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) and() (Expr, error) {
+	l, err := p.unary()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var r Expr
+		if r, err = p.unary(); err == nil {
+			l = binary{op: "&&", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.peek("!") {
+		p.pos++
+		x, err := p.unary()
+		return not{x}, err
+	}
+	l, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek(op) {
+			p.pos++
+			r, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			return binary{op: op, l: l, r: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) operand() (Expr, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case 'n':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return literal{f}, nil
+	case 's':
+		return literal{t.text}, nil
+	case 'i':
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		r, err := binding.ParseRef(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return ref{r}, nil
+	}
+	if t.text == "(" {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
@@ -0,0 +1,239 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+)
+
+// ValidationError lists every problem found in a definition.
+type ValidationError struct {
+	ID       string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("dsl: invalid workflow %q: %s", e.ID, strings.Join(e.Problems, "; "))
+}
+
+// Validate checks a definition: identity, timeout, migration sources,
+// unique step names, known actions (when actions is non-nil), transition and
+// join targets, conditions, input templates and compensations, and that the
+// step graph is acyclic and every step is reachable from the start steps.
+func Validate(def *Definition, actions map[string]bool) error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if def.ID == "" {
+		addf("id is required")
+	}
+	if def.Version == "" {
+		addf("version is required")
+	}
+	if len(def.Steps) == 0 {
+		addf("at least one step is required")
+	}
+	if def.Timeout != "" {
+		if d, err := time.ParseDuration(def.Timeout); err != nil || d <= 0 {
+			addf("timeout %q is not a positive duration", def.Timeout)
+		}
+	}
+	for _, v := range def.MigrateFrom {
+		if v == "" || v == def.Version {
+			addf("migrate_from: invalid version %q", v)
+		}
+	}
+
+	names := make(map[string]bool, len(def.Steps))
+	for _, st := range def.Steps {
+		switch {
+		case st.Name == "":
+			addf("a step has no name")
+		case strings.ContainsAny(st.Name, ". {}"):
+			addf("step %q: names may not contain dots, spaces or braces", st.Name)
+		case names[st.Name]:
+			addf("step %q is declared twice", st.Name)
+		}
+		names[st.Name] = true
+	}
+	checkRef := func(where string, ref binding.Ref) {
+		if name := ref.Step(); name != "" && !names[name] {
+			addf("%s: reference %s names unknown step %q", where, ref, name)
+		}
+	}
+
+	for _, name := range def.Start {
+		if !names[name] {
+			addf("start: unknown step %q", name)
+		}
+	}
+
+	for _, st := range def.Steps {
+		where := fmt.Sprintf("step %q", st.Name)
+		kinds := 0
+		for _, set := range []bool{st.Action != "", st.Wait != "", st.Map != nil, st.Run != nil} {
+			if set {
+				kinds++
+			}
+		}
+		checkAction := func(what, action string) {
+			switch {
+			case action == "":
+				addf("%s: %s is required", where, what)
+			case actions != nil && !actions[action]:
+				addf("%s: unknown %s %q", where, what, action)
+			}
+		}
+		checkInput := func(what string, v interface{}) {
+			refs, err := binding.Refs(v)
+			if err != nil {
+				addf("%s: %s %v", where, what, err)
+			}
+			for _, ref := range refs {
+				checkRef(where+" "+what, ref)
+			}
+		}
+		switch {
+		case kinds > 1:
+			addf("%s: action, wait, map and run are mutually exclusive", where)
+		case st.Wait != "":
+			if d, err := time.ParseDuration(st.Wait); err != nil || d <= 0 {
+				addf("%s: wait %q is not a positive duration", where, st.Wait)
+			}
+		case st.Map != nil:
+			checkAction("map action", st.Map.Action)
+			if st.Map.Aggregate != "" {
+				checkAction("map aggregate", st.Map.Aggregate)
+			}
+			if st.Map.Concurrency < 0 {
+				addf("%s: map concurrency must not be negative", where)
+			}
+			if _, ok := st.Map.Items.(string); !ok {
+				if _, ok := st.Map.Items.([]interface{}); !ok {
+					addf("%s: map items must be a list or a reference to one", where)
+				}
+			}
+			checkInput("map items", st.Map.Items)
+		case st.Run != nil:
+			if st.Run.Workflow == "" {
+				addf("%s: run workflow is required", where)
+			}
+			checkInput("run payload", st.Run.Payload)
+		default:
+			checkAction("action", st.Action)
+		}
+		if st.Retry != nil {
+			if _, err := st.Retry.policy(); err != nil {
+				addf("%s: retry %v", where, err)
+			}
+		}
+		checkInput("input", st.Input)
+		if c := st.Compensate; c != nil {
+			checkAction("compensate action", c.Action)
+			checkInput("compensate input", c.Input)
+		}
+		for _, dep := range st.After {
+			if !names[dep] || dep == st.Name {
+				addf("%s: after names invalid step %q", where, dep)
+			}
+		}
+		for i, tr := range st.Next {
+			if len(tr.Goto) == 0 {
+				addf("%s: next[%d] has no goto", where, i)
+			}
+			for _, target := range tr.Goto {
+				if !names[target] || target == st.Name {
+					addf("%s: next[%d] goes to invalid step %q", where, i, target)
+				}
+			}
+			if tr.If == "" {
+				if i < len(st.Next)-1 {
+					addf("%s: next[%d] has no condition, so later transitions never apply", where, i)
+				}
+				continue
+			}
+			e, err := ParseExpr(tr.If)
+			if err != nil {
+				addf("%s: next[%d] condition: %v", where, i, err)
+				continue
+			}
+			for _, ref := range Refs(e) {
+				checkRef(fmt.Sprintf("%s next[%d]", where, i), ref)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		problems = append(problems, graphProblems(def)...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{ID: def.ID, Problems: problems}
+	}
+	return nil
+}
+
+// graphProblems reports cycles and steps unreachable from the start steps.
+// Edges run from a step to its transition targets and to the joins that
+// wait for it.
+func graphProblems(def *Definition) []string {
+	edges := make(map[string][]string)
+	for _, st := range def.Steps {
+		for _, tr := range st.Next {
+			edges[st.Name] = append(edges[st.Name], tr.Goto...)
+		}
+		for _, dep := range st.After {
+			edges[dep] = append(edges[dep], st.Name)
+		}
+	}
+
+	var problems []string
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var visit func(string) bool
+	visit = func(n string) bool {
+		switch state[n] {
+		case visiting:
+			problems = append(problems, fmt.Sprintf("step %q is part of a cycle; steps run at most once per run", n))
+			return false
+		case done:
+			return true
+		}
+		state[n] = visiting
+		for _, m := range edges[n] {
+			if !visit(m) {
+				return false
+			}
+		}
+		state[n] = done
+		return true
+	}
+	for _, name := range startSteps(def) {
+		if !visit(name) {
+			return problems
+		}
+	}
+	for _, st := range def.Steps {
+		if state[st.Name] == unvisited {
+			problems = append(problems, fmt.Sprintf("step %q is unreachable from the start steps", st.Name))
+		}
+	}
+	return problems
+}
+
+func startSteps(def *Definition) []string {
+	if len(def.Start) > 0 {
+		return def.Start
+	}
+	if len(def.Steps) == 0 {
+		return nil
+	}
+	return []string{def.Steps[0].Name}
+}
@@ -0,0 +1,326 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+)
+
+// Workflow is the engine.Workflow of a validated Definition.
+type Workflow struct {
+	def   *Definition
+	conds map[string][]Expr // per step, one per transition; nil when unconditional
+	retry map[string]*engine.RetryPolicy
+	waits map[string]time.Duration
+	edges map[string][]string
+	// timeout is the run timeout; zero when unset
+	timeout time.Duration
+	// parallel is set when the definition starts or moves to several steps
+	// at once
+	parallel bool
+}
+
+// New validates def against the known action names (any action when nil)
+// and compiles it.
+func New(def *Definition, actions map[string]bool) (*Workflow, error) {
+	if err := Validate(def, actions); err != nil {
+		return nil, err
+	}
+	w := &Workflow{
+		def:   def,
+		conds: make(map[string][]Expr),
+		retry: make(map[string]*engine.RetryPolicy),
+		waits: make(map[string]time.Duration),
+		edges: make(map[string][]string),
+	}
+	if def.Timeout != "" {
+		w.timeout, _ = time.ParseDuration(def.Timeout)
+	}
+	w.parallel = len(startSteps(def)) > 1
+	for _, st := range def.Steps {
+		for _, tr := range st.Next {
+			w.parallel = w.parallel || len(tr.Goto) > 1
+			var e Expr
+			if tr.If != "" {
+				e, _ = ParseExpr(tr.If)
+			}
+			w.conds[st.Name] = append(w.conds[st.Name], e)
+			w.edges[st.Name] = append(w.edges[st.Name], tr.Goto...)
+		}
+		for _, dep := range st.After {
+			w.edges[dep] = append(w.edges[dep], st.Name)
+		}
+		if st.Retry != nil {
+			w.retry[st.Name], _ = st.Retry.policy()
+		}
+		if st.Wait != "" {
+			w.waits[st.Name], _ = time.ParseDuration(st.Wait)
+		}
+	}
+	return w, nil
+}
+
+func (w *Workflow) ID() string { return w.def.ID }
+
+func (w *Workflow) Version() string { return w.def.Version }
+
+// Migrate accepts runs of the versions listed in migrate_from whose steps
+// are all still declared.
+func (w *Workflow) Migrate(ctx context.Context, run *engine.WorkflowRun, from string) error {
+	accepted := false
+	for _, v := range w.def.MigrateFrom {
+		accepted = accepted || v == from
+	}
+	if !accepted {
+		return fmt.Errorf("dsl: %s %s does not migrate from %q", w.def.ID, w.def.Version, from)
+	}
+	for _, st := range run.Steps {
+		if _, ok := w.def.Step(declaredStep(st.StepName)); !ok {
+			return fmt.Errorf("dsl: step %q of the run is not declared in %s %s", st.StepName, w.def.ID, w.def.Version)
+		}
+	}
+	return nil
+}
+
+// RunTimeout implements engine.RunTimeout.
+func (w *Workflow) RunTimeout() time.Duration { return w.timeout }
+
+// ParallelBranches implements engine.ParallelPlanner: runs of a definition
+// with parallel branches are re-planned while other branches are in flight.
+func (w *Workflow) ParallelBranches() bool { return w.parallel }
+
+// Definition returns the definition the workflow was compiled from.
+func (w *Workflow) Definition() *Definition { return w.def }
+
+// Plan schedules the start steps of a new run and afterwards every step that
+// a completed step transitions to, or a join whose awaited steps have all
+// finished, that has not run yet. Steps of other branches may still be in
+// flight. Inputs referencing the run or other steps
+// are planned as templates and bound when the step is claimed. A failed
+// step ends the run.
+func (w *Workflow) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	if run == nil {
+		return nil, fmt.Errorf("run is nil")
+	}
+	scope := binding.NewScope(run)
+	if len(run.Steps) == 0 {
+		due := make(map[string]time.Time)
+		for _, name := range startSteps(w.def) {
+			due[name] = run.CreatedAt
+		}
+		return w.defs(startSteps(w.def), due)
+	}
+
+	latest := make(map[string]*engine.WorkflowStepRecord, len(run.Steps))
+	for i := range run.Steps {
+		st := &run.Steps[i]
+		if st.Status == engine.StepStatusFailed {
+			return nil, nil
+		}
+		latest[st.StepName] = st
+	}
+	completed := func(name string) bool {
+		st := latest[name]
+		return st != nil && st.Status == engine.StepStatusCompleted
+	}
+
+	// candidates and the time they became due, used as timer start
+	due := make(map[string]time.Time)
+	mark := func(name string, at time.Time) {
+		if latest[name] != nil {
+			return
+		}
+		if prev, ok := due[name]; !ok || at.After(prev) {
+			due[name] = at
+		}
+	}
+	for _, st := range w.def.Steps {
+		if !completed(st.Name) {
+			continue
+		}
+		for i, tr := range st.Next {
+			if c := w.conds[st.Name][i]; c == nil || Eval(c, scope) {
+				for _, target := range tr.Goto {
+					mark(target, latest[st.Name].UpdatedAt)
+				}
+				break
+			}
+		}
+	}
+	for _, st := range w.def.Steps {
+		for _, dep := range st.After {
+			if completed(dep) {
+				mark(st.Name, latest[dep].UpdatedAt)
+			}
+		}
+	}
+
+	var ready []string
+	for _, st := range w.def.Steps {
+		if _, ok := due[st.Name]; !ok {
+			continue
+		}
+		if w.joinReady(st, latest, due) {
+			ready = append(ready, st.Name)
+		}
+	}
+	return w.defs(ready, due)
+}
+
+// joinReady reports whether every step st waits for has finished or can no
+// longer run, i.e. has not started and is unreachable from the other
+// candidates and the steps still in flight.
+func (w *Workflow) joinReady(st StepSpec, latest map[string]*engine.WorkflowStepRecord, due map[string]time.Time) bool {
+	var pending []string
+	for _, dep := range st.After {
+		switch {
+		case latest[dep] == nil:
+			pending = append(pending, dep)
+		case !finished(latest[dep].Status):
+			return false
+		}
+	}
+	if len(pending) == 0 {
+		return true
+	}
+	var from []string
+	for name := range due {
+		if name != st.Name {
+			from = append(from, name)
+		}
+	}
+	for name, rec := range latest {
+		if !finished(rec.Status) {
+			from = append(from, name)
+		}
+	}
+	reach := w.reachable(from)
+	for _, dep := range pending {
+		if reach[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// finished reports whether a step with status will not run again.
+func finished(status string) bool {
+	switch status {
+	case engine.StepStatusCompleted, engine.StepStatusFailed, engine.StepStatusCancelled, engine.StepStatusDiscarded:
+		return true
+	}
+	return false
+}
+
+func (w *Workflow) reachable(from []string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := append([]string{}, from...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		queue = append(queue, w.edges[n]...)
+	}
+	return seen
+}
+
+func (w *Workflow) defs(names []string, due map[string]time.Time) ([]engine.WorkflowStepDef, error) {
+	defs := make([]engine.WorkflowStepDef, 0, len(names))
+	for _, name := range names {
+		st, _ := w.def.Step(name)
+		if d, ok := w.waits[name]; ok {
+			start := due[name]
+			if start.IsZero() {
+				start = engine.Now()
+			}
+			defs = append(defs, engine.Timer(name, start.Add(d)))
+			continue
+		}
+		def := engine.WorkflowStepDef{StepName: name, Input: []byte(`{}`), Retry: w.retry[name]}
+		input := st.Input
+		switch {
+		case st.Map != nil:
+			def.Kind = engine.StepKindMap
+			m := map[string]interface{}{"items": st.Map.Items, "item_step": itemStep(name), "concurrency": st.Map.Concurrency}
+			if st.Map.Aggregate != "" {
+				m["aggregate"] = aggregateStep(name)
+			}
+			input = m
+		case st.Run != nil:
+			def.Kind = engine.StepKindChildRun
+			input = map[string]interface{}{"workflow_type": st.Run.Workflow, "payload": st.Run.Payload}
+		}
+		if input != nil {
+			b, err := json.Marshal(input)
+			if err != nil {
+				return nil, fmt.Errorf("dsl: encode input of step %q: %w", name, err)
+			}
+			// inputs with references are bound when the step is claimed
+			if refs, _ := binding.Refs(input); len(refs) > 0 {
+				def.Input, def.Template = nil, b
+			} else {
+				def.Input = b
+			}
+		}
+		if c := st.Compensate; c != nil {
+			def.Compensate = &engine.Compensation{StepName: compensationStep(name)}
+			if c.Input != nil {
+				b, err := json.Marshal(c.Input)
+				if err != nil {
+					return nil, fmt.Errorf("dsl: encode compensate input of step %q: %w", name, err)
+				}
+				def.Compensate.Input = b
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// Register compiles def, adds it to reg and registers a handler for each of
+// its steps that runs the step's action from actions, and one for each
+// compensation, map item and map aggregate. A definition whose ID and
+// version are already registered, e.g. by a Go workflow, is refused.
+func Register(reg engine.WorkflowRegistry, handlers *executor.HandlerRegistry, def *Definition, actions map[string]engine.StepHandler) (*Workflow, error) {
+	if _, ok := reg.GetVersion(def.ID, def.Version); ok {
+		return nil, fmt.Errorf("dsl: %s@%s is already registered", def.ID, def.Version)
+	}
+	w, err := New(def, ActionNames(actions))
+	if err != nil {
+		return nil, err
+	}
+	reg.Register(w)
+	for _, st := range def.Steps {
+		if st.Compensate != nil {
+			handlers.Register(def.ID, def.Version, executor.Rename(compensationStep(st.Name), actions[st.Compensate.Action]))
+		}
+		if m := st.Map; m != nil {
+			handlers.Register(def.ID, def.Version, executor.Rename(itemStep(st.Name), actions[m.Action]))
+			if m.Aggregate != "" {
+				handlers.Register(def.ID, def.Version, executor.Rename(aggregateStep(st.Name), actions[m.Aggregate]))
+			}
+		}
+		if st.Action == "" {
+			continue
+		}
+		handlers.Register(def.ID, def.Version, executor.Rename(st.Name, actions[st.Action]))
+	}
+	return w, nil
+}
+
+// ActionNames returns the set of names in an action catalog.
+func ActionNames(actions map[string]engine.StepHandler) map[string]bool {
+	names := make(map[string]bool, len(actions))
+	for name := range actions {
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,314 @@
+// Package enginetest runs workflows end to end without a database. A Harness
+// wires a MemoryStore, the default executor and the scheduler's step loop
+// to a fake clock, and drives registered workflows synchronously:
+//
+//	h := enginetest.New(t)
+//	csr.Register(h.Registry, h.Handlers, csr.Deps{Store: h.Store})
+//	run := h.Start("csr", payload)
+//	h.Drain()
+//	h.RequireStepStatus(run.ID, csr.StepHumanReview, engine.StepStatusWaitingForSignal)
+//	h.Signal(run.ID, csr.StepHumanReview, engine.Signal{Action: engine.SignalApprove})
+//	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+//
+// The harness replaces the process-wide engine clock until the test ends, so
+// tests using it must not run in parallel.
+package enginetest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/deadline"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+	englogger "github.com/alpinesboltltd/boltz-ai/internal/engine/logger"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/requeue"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/scheduler"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/signal"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/trigger"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+)
+
+// Epoch is the time the harness clock starts at.
+var Epoch = time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+
+// MaxSteps bounds how many steps Drain runs before it fails the test, to
+// catch workflows that never stop planning.
+const MaxSteps = 1000
+
+// WorkerID is the worker the harness claims steps as.
+const WorkerID = "enginetest"
+
+// HeartbeatTTL is how long a claimed step may go without a heartbeat before
+// Advance requeues it, as the requeue monitor does with the default
+// ORCHESTRATION_HEARTBEAT_TTL_SECONDS.
+const HeartbeatTTL = 2 * time.Minute
+
+// Harness drives workflows registered in Registry, with handlers from
+// Handlers, against Store.
+type Harness struct {
+	t        testing.TB
+	Store    *store.MemoryStore
+	Registry *workflow.Registry
+	Handlers *executor.HandlerRegistry
+	Clock    *Clock
+	Executor *executor.DefaultExecutor
+	Logger   engine.Logger
+}
+
+// New returns a harness with empty registries whose clock stands at Epoch.
+func New(t testing.TB) *Harness {
+	t.Helper()
+	h := &Harness{
+		t:        t,
+		Store:    store.NewMemoryStore(),
+		Registry: workflow.NewRegistry(),
+		Handlers: executor.NewHandlerRegistry(),
+		Clock:    NewClock(Epoch),
+	}
+	h.Logger = englogger.New(io.Discard, h.Store)
+	h.Executor = executor.NewDefaultExecutor(h.Store, h.Handlers, h.Logger).WithWorkflows(h.Registry)
+	t.Cleanup(engine.SetClock(h.Clock))
+	return h
+}
+
+// Register adds w to the registry and its step handlers, by step name, to
+// the handler registry.
+func (h *Harness) Register(w engine.Workflow, handlers ...engine.StepHandler) {
+	h.Registry.Register(w)
+	for _, handler := range handlers {
+		h.Handlers.Register(w.ID(), "", handler)
+	}
+}
+
+// Start starts a run of workflowType. payload is marshalled to JSON unless
+// it already is []byte or json.RawMessage.
+func (h *Harness) Start(workflowType string, payload interface{}) *engine.WorkflowRun {
+	h.t.Helper()
+	run := &engine.WorkflowRun{WorkflowType: workflowType, Payload: h.json(payload)}
+	h.StartRun(run)
+	return run
+}
+
+// StartRun starts run, e.g. one with a workspace, priority or deadline.
+func (h *Harness) StartRun(run *engine.WorkflowRun) {
+	h.t.Helper()
+	if err := workflow.StartRun(context.Background(), h.Store, h.Registry, run); err != nil {
+		h.t.Fatalf("start %s: %v", run.WorkflowType, err)
+	}
+}
+
+func (h *Harness) json(v interface{}) []byte {
+	h.t.Helper()
+	switch p := v.(type) {
+	case nil:
+		return []byte(`{}`)
+	case []byte:
+		return p
+	case json.RawMessage:
+		return p
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		h.t.Fatalf("marshal payload: %v", err)
+	}
+	return b
+}
+
+// Drain runs ready steps one at a time until none is left and returns how
+// many it ran. Steps that are delayed, such as retries and timers, wait for
+// Advance.
+func (h *Harness) Drain() int {
+	h.t.Helper()
+	total := 0
+	for {
+		n, err := scheduler.RunOnce(context.Background(), h.Store, h.Executor, h.Registry, scheduler.Options{WorkerID: WorkerID, Logger: h.Logger})
+		if err != nil {
+			h.t.Fatalf("run steps: %v", err)
+		}
+		if n == 0 {
+			return total
+		}
+		total += n
+		if total > MaxSteps {
+			h.t.Fatalf("workflows ran more than %d steps without settling", MaxSteps)
+		}
+	}
+}
+
+// Advance moves the clock forward by d, lets the monitors act on what came
+// due (stale steps, review timeouts, run deadlines and triggers) and drains
+// the steps that became ready.
+func (h *Harness) Advance(d time.Duration) int {
+	h.t.Helper()
+	h.Clock.Advance(d)
+	ctx := context.Background()
+	if _, err := requeue.RequeueStale(ctx, h.Store, h.Registry, int(HeartbeatTTL/time.Second), MaxSteps); err != nil {
+		h.t.Fatalf("requeue stale steps: %v", err)
+	}
+	if err := signal.ExpireDue(ctx, h.Store, h.Registry, MaxSteps); err != nil {
+		h.t.Fatalf("expire waiting steps: %v", err)
+	}
+	if err := deadline.ExpireDue(ctx, h.Store, h.Registry, MaxSteps); err != nil {
+		h.t.Fatalf("expire runs: %v", err)
+	}
+	if err := trigger.FireDue(ctx, h.Store, h.Registry, MaxSteps); err != nil {
+		h.t.Fatalf("fire triggers: %v", err)
+	}
+	return h.Drain()
+}
+
+// Signal delivers sig to the step of the run named stepName, which must be
+// waiting for a signal, and drains the steps the run resumes with.
+func (h *Harness) Signal(runID, stepName string, sig engine.Signal) {
+	h.t.Helper()
+	step := h.Step(runID, stepName)
+	if err := signal.Deliver(context.Background(), h.Store, h.Registry, step, sig); err != nil {
+		h.t.Fatalf("signal %s: %v", stepName, err)
+	}
+	h.Drain()
+}
+
+// Run loads the run with its steps.
+func (h *Harness) Run(runID string) *engine.WorkflowRun {
+	h.t.Helper()
+	run, err := h.Store.LoadRun(context.Background(), runID)
+	if err != nil || run == nil {
+		h.t.Fatalf("load run %s = %v, %v", runID, run, err)
+	}
+	return run
+}
+
+// Step returns the latest step of the run named stepName.
+func (h *Harness) Step(runID, stepName string) *engine.WorkflowStepRecord {
+	h.t.Helper()
+	run := h.Run(runID)
+	for i := len(run.Steps) - 1; i >= 0; i-- {
+		if run.Steps[i].StepName == stepName {
+			step := run.Steps[i]
+			return &step
+		}
+	}
+	h.t.Fatalf("run %s has no step %q; steps: %v", runID, stepName, describe(run))
+	return nil
+}
+
+// Output unmarshals the result of the run's step named stepName into v.
+func (h *Harness) Output(runID, stepName string, v interface{}) {
+	h.t.Helper()
+	step := h.Step(runID, stepName)
+	if err := json.Unmarshal(step.Result, v); err != nil {
+		h.t.Fatalf("decode result of %s (%s): %v", stepName, step.Result, err)
+	}
+}
+
+// Logs returns the step logs of the run, oldest first.
+func (h *Harness) Logs(runID string) []*engine.StepLog {
+	h.t.Helper()
+	logs, err := h.Store.ListRunLogs(context.Background(), runID)
+	if err != nil {
+		h.t.Fatalf("list logs of %s: %v", runID, err)
+	}
+	return logs
+}
+
+// Events returns the outbox events of type eventType, or all events when it
+// is empty, oldest first.
+func (h *Harness) Events(eventType string) []*engine.OutboxEvent {
+	var events []*engine.OutboxEvent
+	for _, ev := range h.Store.Events() {
+		if eventType == "" || ev.EventType == eventType {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// RequireRunStatus fails the test unless the run has status.
+func (h *Harness) RequireRunStatus(runID, status string) *engine.WorkflowRun {
+	h.t.Helper()
+	run := h.Run(runID)
+	if run.Status != status {
+		h.t.Fatalf("run %s status = %s, want %s; steps: %v", runID, run.Status, status, describe(run))
+	}
+	return run
+}
+
+// RequireStepStatus fails the test unless the run's latest step named
+// stepName has status.
+func (h *Harness) RequireStepStatus(runID, stepName, status string) *engine.WorkflowStepRecord {
+	h.t.Helper()
+	step := h.Step(runID, stepName)
+	if step.Status != status {
+		msg := ""
+		if step.Error != nil {
+			msg = ": " + *step.Error
+		}
+		h.t.Fatalf("step %s status = %s, want %s%s", stepName, step.Status, status, msg)
+	}
+	return step
+}
+
+// RequireSteps fails the test unless the run's steps have the given names,
+// in order.
+func (h *Harness) RequireSteps(runID string, names ...string) {
+	h.t.Helper()
+	got := stepNames(h.Run(runID))
+	if len(got) != len(names) {
+		h.t.Fatalf("run %s steps = %v, want %v", runID, got, names)
+	}
+	for i := range names {
+		if got[i] != names[i] {
+			h.t.Fatalf("run %s steps = %v, want %v", runID, got, names)
+		}
+	}
+}
+
+// RequireLog fails the test unless the run logged message.
+func (h *Harness) RequireLog(runID, message string) *engine.StepLog {
+	h.t.Helper()
+	logs := h.Logs(runID)
+	for _, l := range logs {
+		if l.Message == message {
+			return l
+		}
+	}
+	messages := make([]string, 0, len(logs))
+	for _, l := range logs {
+		messages = append(messages, l.Message)
+	}
+	h.t.Fatalf("run %s did not log %q; logs: %q", runID, message, messages)
+	return nil
+}
+
+// RequireEvents fails the test unless exactly n outbox events of eventType
+// were enqueued, and returns them.
+func (h *Harness) RequireEvents(eventType string, n int) []*engine.OutboxEvent {
+	h.t.Helper()
+	events := h.Events(eventType)
+	if len(events) != n {
+		h.t.Fatalf("%d %s events enqueued, want %d", len(events), eventType, n)
+	}
+	return events
+}
+
+func stepNames(run *engine.WorkflowRun) []string {
+	names := make([]string, 0, len(run.Steps))
+	for _, st := range run.Steps {
+		names = append(names, st.StepName)
+	}
+	return names
+}
+
+// describe lists the run's steps with their status for failure messages.
+func describe(run *engine.WorkflowRun) []string {
+	steps := make([]string, 0, len(run.Steps))
+	for _, st := range run.Steps {
+		steps = append(steps, st.StepName+"="+st.Status)
+	}
+	return steps
+}
@@ -0,0 +1,188 @@
+package enginetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/dispatcher"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/trigger"
+	"github.com/google/uuid"
+)
+
+// followUp fetches a lead, waits a day and enqueues a follow-up email.
+type followUp struct{}
+
+func (followUp) ID() string      { return "follow_up" }
+func (followUp) Version() string { return "v1" }
+
+func (followUp) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	if len(run.Steps) == 0 {
+		return []engine.WorkflowStepDef{{StepName: "fetch_lead", Seq: 1, Input: run.Payload}}, nil
+	}
+	last := run.Steps[len(run.Steps)-1]
+	if last.Status != engine.StepStatusCompleted {
+		return nil, nil
+	}
+	switch last.StepName {
+	case "fetch_lead":
+		wait := engine.Timer("wait", engine.Now().Add(24*time.Hour))
+		wait.Seq = 2
+		return []engine.WorkflowStepDef{wait}, nil
+	case "wait":
+		return []engine.WorkflowStepDef{{StepName: "send_follow_up", Seq: 3, Template: []byte(`{"to": "{{ steps.fetch_lead.output.email }}"}`)}}, nil
+	}
+	return nil, nil
+}
+
+func registerFollowUp(h *Harness, fetchFailures int) {
+	calls := 0
+	h.Register(followUp{},
+		executor.NewHandler("fetch_lead", func(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+			if calls++; calls <= fetchFailures {
+				return engine.StepResult{}, errors.New("crm unavailable")
+			}
+			ec.Logger().Info("lead fetched")
+			return engine.StepResult{Success: true, Output: []byte(`{"email":"lead@example.com"}`)}, nil
+		}),
+		executor.NewHandler("send_follow_up", func(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+			key := ec.IdempotencyKey("follow_up")
+			ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: ec.Step.Input, IdempotencyKey: &key}
+			if err := h.Store.EnqueueEvent(ctx, ev); err != nil {
+				return engine.StepResult{}, err
+			}
+			return engine.StepResult{Success: true}, nil
+		}),
+	)
+}
+
+func TestHarnessDrivesRetriesAndTimers(t *testing.T) {
+	h := New(t)
+	registerFollowUp(h, 1)
+	run := h.Start("follow_up", map[string]string{"lead_id": "l-1"})
+
+	// the first attempt fails and is retried after its backoff
+	h.Drain()
+	step := h.RequireStepStatus(run.ID, "fetch_lead", engine.StepStatusPending)
+	if step.Attempts != 1 || step.NextAttemptAt == nil || !step.NextAttemptAt.After(h.Clock.Now()) {
+		t.Fatalf("fetch_lead after a failure = attempts %d, next attempt %v", step.Attempts, step.NextAttemptAt)
+	}
+	h.RequireLog(run.ID, "step retry scheduled")
+
+	h.Advance(time.Minute)
+	h.RequireStepStatus(run.ID, "fetch_lead", engine.StepStatusCompleted)
+	h.RequireLog(run.ID, "lead fetched")
+	h.RequireStepStatus(run.ID, "wait", engine.StepStatusPending)
+	h.RequireEvents("email_send", 0)
+
+	// nothing happens before the timer is due
+	if n := h.Advance(23 * time.Hour); n != 0 {
+		t.Fatalf("%d steps ran before the timer was due", n)
+	}
+	h.Advance(time.Hour)
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+	h.RequireSteps(run.ID, "fetch_lead", "wait", "send_follow_up")
+
+	ev := h.RequireEvents("email_send", 1)[0]
+	var mail map[string]string
+	if err := json.Unmarshal(ev.Payload, &mail); err != nil || mail["to"] != "lead@example.com" {
+		t.Fatalf("follow-up email = %s, %v", ev.Payload, err)
+	}
+}
+
+func TestHarnessRunDeadline(t *testing.T) {
+	h := New(t)
+	registerFollowUp(h, 0)
+	deadline := h.Clock.Now().Add(time.Hour)
+	run := &engine.WorkflowRun{WorkflowType: "follow_up", Payload: []byte(`{}`), Deadline: &deadline}
+	h.StartRun(run)
+	h.Drain()
+	h.RequireStepStatus(run.ID, "wait", engine.StepStatusPending)
+
+	h.Advance(2 * time.Hour)
+	h.RequireRunStatus(run.ID, engine.RunStatusCancelled)
+	h.RequireStepStatus(run.ID, "wait", engine.StepStatusCancelled)
+	if got := len(h.Events("")); got != 1 {
+		t.Fatalf("%d events after the deadline, want the deadline notification only", got)
+	}
+}
+
+func TestHarnessPublishesLifecycleEvents(t *testing.T) {
+	h := New(t)
+	d := dispatcher.NewDurable(h.Store, WorkerID, dispatcher.Options{})
+	defer d.Close()
+	t.Cleanup(engine.SetDispatcher(d))
+	registerFollowUp(h, 0)
+	run := h.Start("follow_up", map[string]string{"lead_id": "l-1"})
+	h.Drain()
+	h.Advance(24 * time.Hour)
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+
+	var started engine.RunEvent
+	if err := json.Unmarshal(h.RequireEvents(engine.EventRunStarted, 1)[0].Payload, &started); err != nil || started.RunID != run.ID || started.WorkflowType != "follow_up" {
+		t.Fatalf("run.started = %+v, %v", started, err)
+	}
+	h.RequireEvents(engine.EventStepCompleted, 3)
+	h.RequireEvents(engine.EventRunCompleted, 1)
+	h.RequireEvents(engine.EventRunFailed, 0)
+}
+
+func TestHarnessFailsRunWhenWorkerDiesOnLastAttempt(t *testing.T) {
+	h := New(t)
+	d := dispatcher.NewDurable(h.Store, WorkerID, dispatcher.Options{})
+	defer d.Close()
+	t.Cleanup(engine.SetDispatcher(d))
+	registerFollowUp(h, engine.DefaultRetryPolicy.MaxAttempts-1)
+	run := h.Start("follow_up", map[string]string{"lead_id": "l-1"})
+	h.Drain()
+	for h.Step(run.ID, "fetch_lead").Attempts < engine.DefaultRetryPolicy.MaxAttempts-1 {
+		h.Advance(time.Hour)
+	}
+
+	// the last attempt is claimed by a worker that dies before finishing it
+	h.Clock.Advance(time.Hour)
+	steps, err := h.Store.ClaimNextSteps(context.Background(), "dead-worker", 1)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("claim last attempt = %d steps, %v", len(steps), err)
+	}
+
+	h.Advance(HeartbeatTTL + time.Second)
+	step := h.RequireStepStatus(run.ID, "fetch_lead", engine.StepStatusFailed)
+	if step.Attempts != engine.DefaultRetryPolicy.MaxAttempts {
+		t.Fatalf("fetch_lead failed after %d attempts", step.Attempts)
+	}
+	h.RequireRunStatus(run.ID, engine.RunStatusFailed)
+	var failed engine.StepEvent
+	if err := json.Unmarshal(h.RequireEvents(engine.EventStepFailed, 1)[0].Payload, &failed); err != nil || failed.StepID != step.ID || failed.WorkflowType != "follow_up" {
+		t.Fatalf("step.failed = %+v, %v", failed, err)
+	}
+	h.RequireEvents(engine.EventRunFailed, 1)
+}
+
+func TestHarnessFiresTriggersAndWakesTheirTimers(t *testing.T) {
+	h := New(t)
+	registerFollowUp(h, 0)
+	next := h.Clock.Now().Add(time.Hour).Truncate(time.Hour)
+	tr := &engine.WorkflowTrigger{ID: uuid.NewString(), WorkflowType: "follow_up", Schedule: "0 * * * *", Timezone: "UTC", Payload: []byte(`{}`), Enabled: true, NextRunAt: &next}
+	if err := h.Store.CreateTrigger(context.Background(), tr); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+
+	h.Advance(next.Sub(h.Clock.Now()))
+	runID := trigger.RunID(tr.ID, next)
+	wait := h.RequireStepStatus(runID, "wait", engine.StepStatusPending)
+	if wait.NextAttemptAt == nil || !wait.NextAttemptAt.Equal(next.Add(24*time.Hour)) {
+		t.Fatalf("timer wakes at %v, want a day after the activation", wait.NextAttemptAt)
+	}
+
+	// the timer wakes at its NextAttemptAt; hourly activations start their own runs meanwhile
+	h.Advance(24*time.Hour - time.Minute)
+	h.RequireStepStatus(runID, "wait", engine.StepStatusPending)
+	h.Advance(time.Minute)
+	h.RequireRunStatus(runID, engine.RunStatusCompleted)
+	h.RequireSteps(runID, "fetch_lead", "wait", "send_follow_up")
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Error classes used by retry policies.
+const (
+	ErrorClassTransient = "transient"
+	ErrorClassRateLimit = "rate_limit"
+	ErrorClassTimeout   = "timeout"
+	ErrorClassUnknown   = "unknown"
+	ErrorClassPermanent = "permanent"
+)
+
+// StepError is returned by step handlers to tell the scheduler whether a
+// failure may be retried.
+type StepError struct {
+	Class     string
+	Retryable bool
+	Err       error
+}
+
+func (e *StepError) Error() string { return e.Err.Error() }
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Retryable marks err as a retryable failure of the given class.
+func Retryable(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StepError{Class: class, Retryable: true, Err: err}
+}
+
+// Permanent marks err as a failure that must not be retried, e.g. invalid
+// input.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StepError{Class: ErrorClassPermanent, Retryable: false, Err: err}
+}
+
+// statusTooManyRequests matches HTTP 429 as a status code in an error
+// message, e.g. "status 429", "status code: 429" or "429 Too Many
+// Requests", and not a 429 that is part of an ID or a byte count.
+var statusTooManyRequests = regexp.MustCompile(`\b(status|code|http)\b[^a-z0-9]*429\b`)
+
+// ClassifyError returns the class of err and whether it may be retried.
+// Untyped errors are classified from well-known causes (deadlines, HTTP 429
+// status codes) and are otherwise retryable with class "unknown".
+func ClassifyError(err error) (string, bool) {
+	var se *StepError
+	if errors.As(err, &se) {
+		return se.Class, se.Retryable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout, true
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case statusTooManyRequests.MatchString(msg) || strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		return ErrorClassRateLimit, true
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded"):
+		return ErrorClassTimeout, true
+	}
+	return ErrorClassUnknown, true
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		class     string
+		retryable bool
+	}{
+		{errors.New("openai: 429 Too Many Requests"), ErrorClassRateLimit, true},
+		{errors.New("anthropic: unexpected status code: 429"), ErrorClassRateLimit, true},
+		{errors.New("POST https://api.example.com: HTTP 429"), ErrorClassRateLimit, true},
+		{errors.New("cohere: rate limit exceeded"), ErrorClassRateLimit, true},
+		{errors.New("ticket 429 not found"), ErrorClassUnknown, true},
+		{errors.New("upload of 44291 bytes failed"), ErrorClassUnknown, true},
+		{errors.New("status 4290"), ErrorClassUnknown, true},
+		{fmt.Errorf("fetch: %w", context.DeadlineExceeded), ErrorClassTimeout, true},
+		{errors.New("dial tcp: i/o timeout"), ErrorClassTimeout, true},
+		{Permanent(errors.New("status 429")), ErrorClassPermanent, false},
+		{fmt.Errorf("wrapped: %w", Retryable(ErrorClassTransient, errors.New("reset"))), ErrorClassTransient, true},
+	} {
+		class, retryable := ClassifyError(tc.err)
+		if class != tc.class || retryable != tc.retryable {
+			t.Errorf("ClassifyError(%q) = %s, %v; want %s, %v", tc.err, class, retryable, tc.class, tc.retryable)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Lifecycle events published through the engine dispatcher.
+const (
+	EventRunStarted    = "run.started"
+	EventRunCompleted  = "run.completed"
+	EventRunFailed     = "run.failed"
+	EventRunCancelled  = "run.cancelled"
+	EventRunRejected   = "run.rejected"
+	EventStepCompleted = "step.completed"
+	EventStepFailed    = "step.failed"
+)
+
+// RunEvent is the payload of the run.* events.
+type RunEvent struct {
+	RunID           string `json:"run_id"`
+	WorkflowType    string `json:"workflow_type"`
+	WorkflowVersion string `json:"workflow_version"`
+	WorkspaceID     string `json:"workspace_id,omitempty"`
+	ParentRunID     string `json:"parent_run_id,omitempty"`
+	Status          string `json:"status"`
+}
+
+// StepEvent is the payload of the step.* events.
+type StepEvent struct {
+	RunID        string  `json:"run_id"`
+	StepID       string  `json:"step_id"`
+	StepName     string  `json:"step_name"`
+	WorkflowType string  `json:"workflow_type,omitempty"`
+	Attempts     int     `json:"attempts"`
+	Error        *string `json:"error,omitempty"`
+}
+
+var (
+	dispatcherMu sync.RWMutex
+	dispatcher   Dispatcher
+)
+
+// SetDispatcher installs the dispatcher lifecycle events are published
+// through and returns a function restoring the previous one. Without one,
+// Emit does nothing.
+func SetDispatcher(d Dispatcher) (restore func()) {
+	dispatcherMu.Lock()
+	defer dispatcherMu.Unlock()
+	prev := dispatcher
+	dispatcher = d
+	return func() {
+		dispatcherMu.Lock()
+		defer dispatcherMu.Unlock()
+		dispatcher = prev
+	}
+}
+
+// Emit publishes an event of eventType through the engine dispatcher. key
+// makes it idempotent, so an event emitted again after a retry is stored
+// once. Callers emit with the context of the StateStore.Atomic call that
+// persists the state change the event reports, so a durable dispatcher on
+// the same database stores the event in that transaction and a failure
+// rolls the change back.
+func Emit(ctx context.Context, eventType, key string, payload interface{}) error {
+	dispatcherMu.RLock()
+	d := dispatcher
+	dispatcherMu.RUnlock()
+	if d == nil {
+		return nil
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", eventType, err)
+	}
+	k := eventType + ":" + key
+	return d.Dispatch(ctx, OutboxEvent{ID: uuid.NewString(), EventType: eventType, Payload: b, IdempotencyKey: &k})
+}
+
+// EmitRun publishes a run.* event for run.
+func EmitRun(ctx context.Context, eventType string, run *WorkflowRun) error {
+	return Emit(ctx, eventType, run.ID, RunEvent{
+		RunID: run.ID, WorkflowType: run.WorkflowType, WorkflowVersion: run.WorkflowVersion,
+		WorkspaceID: run.WorkspaceID, ParentRunID: run.ParentRunID, Status: run.Status,
+	})
+}
+
+// EmitStep publishes a step.* event for the current attempt of step.
+func EmitStep(ctx context.Context, eventType string, step *WorkflowStepRecord) error {
+	return Emit(ctx, eventType, step.ID+":"+strconv.Itoa(step.Attempts), StepEvent{
+		RunID: step.RunID, StepID: step.ID, StepName: step.StepName, WorkflowType: step.WorkflowType,
+		Attempts: step.Attempts, Error: step.Error,
+	})
+}
@@ -4,134 +4,128 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/engine"
-	"github.com/alpinesboltltd/boltz-ai/internal/entity"
-	"github.com/alpinesboltltd/boltz-ai/internal/provider/smtp"
-	"github.com/alpinesboltltd/boltz-ai/internal/rag"
-	"github.com/google/uuid"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
 )
 
+// DefaultExecutor dispatches each step to the handler registered for the
+// step's workflow, version and name. Unknown steps fail instead of silently
+// succeeding. Timer steps complete without a handler; child-run and map
+// steps are run by package workflow.
 type DefaultExecutor struct {
-	llmFunc    func(ctx context.Context, input []byte) (string, error)
-	smtpClient *smtp.Client
-	store      engine.StateStore
-	ragSvc     *rag.RAGService
+	store     engine.StateStore
+	handlers  *HandlerRegistry
+	logger    engine.Logger
+	workflows engine.WorkflowRegistry
+	limiters  map[string]*rate.Limiter
 }
 
-// NewDefaultExecutor accepts optional dependencies. llmFunc can be nil for placeholder behavior.
-func NewDefaultExecutor(llmFunc func(ctx context.Context, input []byte) (string, error), smtpClient *smtp.Client, store engine.StateStore, ragSvc *rag.RAGService) *DefaultExecutor {
-	return &DefaultExecutor{llmFunc: llmFunc, smtpClient: smtpClient, store: store, ragSvc: ragSvc}
+// NewDefaultExecutor returns an executor whose handlers receive logger bound
+// to their step. A nil logger discards handler logs.
+func NewDefaultExecutor(store engine.StateStore, handlers *HandlerRegistry, logger engine.Logger) *DefaultExecutor {
+	if logger == nil {
+		logger = engine.NopLogger{}
+	}
+	return &DefaultExecutor{store: store, handlers: handlers, logger: logger}
 }
 
-func (e *DefaultExecutor) RunStep(ctx context.Context, step *engine.WorkflowStepRecord) (engine.StepResult, error) {
-	switch step.StepName {
-	case "fetch_ticket":
-		// For now, simply echo the input as the fetched ticket payload.
-		// In a full implementation this would call a ticketing/CRM service.
-		var payload interface{}
-		if err := json.Unmarshal(step.Input, &payload); err != nil {
-			log.Printf("executor: fetch_ticket invalid input: %v", err)
-			return engine.StepResult{Success: false}, err
-		}
-		out, _ := json.Marshal(map[string]interface{}{"ticket": payload})
-		return engine.StepResult{Success: true, Output: out}, nil
+// WithWorkflows sets the registry child-run steps start their runs from.
+func (e *DefaultExecutor) WithWorkflows(reg engine.WorkflowRegistry) *DefaultExecutor {
+	e.workflows = reg
+	return e
+}
 
-	case "retrieve_context":
-		// Placeholder: in production this would run vector search / DB lookups.
-		// If a RAG service is available, call it with the provided query.
-		if e.ragSvc != nil {
-			var in map[string]string
-			_ = json.Unmarshal(step.Input, &in)
-			query := in["query"]
-			agentID := in["agent_id"]
-			// fallback: if query empty, use raw input as string
-			if query == "" {
-				query = string(step.Input)
-			}
-			ragQuery := entity.RAGQuery{Query: query, AgentID: agentID, TopK: 5}
-			resp, err := e.ragSvc.Query(ragQuery)
-			if err != nil {
-				log.Printf("executor: rag query error: %v", err)
-				return engine.StepResult{Success: false}, err
-			}
-			out, _ := json.Marshal(resp)
-			return engine.StepResult{Success: true, Output: out}, nil
-		}
-		// Return a simple context object when RAG is not configured.
-		ctxObj := map[string]string{"context": "no_additional_context_available"}
-		out, _ := json.Marshal(ctxObj)
-		return engine.StepResult{Success: true, Output: out}, nil
+// RunStep executes step inside a span that continues the trace stored on
+// its run, so every step of a run shares one trace whichever worker runs it.
+func (e *DefaultExecutor) RunStep(ctx context.Context, step *engine.WorkflowStepRecord) (res engine.StepResult, err error) {
+	run, err := e.store.LoadRun(ctx, step.RunID)
+	if err != nil {
+		return engine.StepResult{Success: false}, fmt.Errorf("executor: load run %s: %w", step.RunID, err)
+	}
+	if run == nil {
+		return engine.StepResult{Success: false}, fmt.Errorf("executor: run %s not found", step.RunID)
+	}
+
+	ctx, span := tracing.Start(tracing.Extract(ctx, run.TraceContext), "step "+step.StepName,
+		attribute.String("workflow.type", run.WorkflowType),
+		attribute.String("workflow.version", run.WorkflowVersion),
+		attribute.String("workflow.run_id", run.ID),
+		attribute.String("workflow.step_id", step.ID),
+		attribute.String("workflow.step_kind", step.Kind),
+		attribute.Int("workflow.step_attempt", step.Attempts),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// steps persisted before keys were assigned get the derived key
+	if step.IdempotencyKey == nil {
+		key := engine.StepKey(step)
+		step.IdempotencyKey = &key
+	}
 
-	case "draft_response":
-		// Use llmFunc if provided to generate draft text from step.Input
-		if e.llmFunc != nil {
-			resp, err := e.llmFunc(ctx, step.Input)
-			if err != nil {
-				log.Printf("executor: llm draft error: %v", err)
-				return engine.StepResult{Success: false}, err
-			}
-			// store LLM result as JSON string
-			out, _ := json.Marshal(map[string]string{"draft": resp})
-			return engine.StepResult{Success: true, Output: out}, nil
+	sl := e.logger.ForStep(step)
+
+	// templated inputs are bound against the run as it is now, so they see
+	// every step that completed before this one
+	if len(step.InputTemplate) > 0 {
+		input, err := binding.Bind(step.InputTemplate, run)
+		if err != nil {
+			sl.Error("input binding failed", engine.Err(err))
+			return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("executor: bind input of step %q: %w", step.StepName, err))
 		}
-		// fallback placeholder
-		out, _ := json.Marshal(map[string]string{"draft": "(llm disabled)"})
+		step.Input = input
+		sl.Info("input bound", engine.F("bytes", len(input)))
+	}
+
+	if step.Kind == engine.StepKindCompensation && step.CompensatesID != nil {
+		sl.Info("compensating step", engine.F("compensates_id", *step.CompensatesID))
+	}
+
+	// timers are claimable only once their wake time has passed
+	if step.Kind == engine.StepKindTimer {
+		now := engine.Now()
+		sl.Info("timer fired", engine.F("wake_at", step.NextAttemptAt))
+		out, _ := json.Marshal(map[string]time.Time{"fired_at": now})
 		return engine.StepResult{Success: true, Output: out}, nil
+	}
 
-	case "send_response":
-		// Expect input to contain to/subject/body/html; enqueue an outbox event
-		var payload map[string]string
-		if err := json.Unmarshal(step.Input, &payload); err != nil {
-			log.Printf("executor: invalid send_response input: %v", err)
-			return engine.StepResult{Success: false}, err
-		}
-		// build outbox payload
-		p, _ := json.Marshal(payload)
-		ev := &engine.OutboxEvent{
-			ID:        uuid.NewString(),
-			EventType: "email_send",
-			Payload:   p,
-			State:     "pending",
-			Published: false,
+	switch step.Kind {
+	case engine.StepKindChildRun:
+		if e.workflows == nil {
+			return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("executor: child-run step %q needs a workflow registry", step.StepName))
 		}
-		if e.store == nil {
-			return engine.StepResult{Success: false}, fmt.Errorf("no store configured for executor")
+		return workflow.RunChild(ctx, e.store, e.workflows, run, step)
+	case engine.StepKindMap:
+		var agg *engine.WorkflowStepRecord
+		res, agg, err = workflow.RunMap(ctx, e.store, run, step)
+		if err != nil || agg == nil {
+			return res, err
 		}
-		if err := e.store.EnqueueEvent(ctx, ev); err != nil {
-			log.Printf("executor: enqueue outbox error: %v", err)
-			return engine.StepResult{Success: false}, err
-		}
-		return engine.StepResult{Success: true, Output: []byte(`{"enqueued":true}`)}, nil
+		// the aggregate handler runs in place of the map step
+		step = agg
+	}
 
-	case "human_review":
-		if e.store == nil {
-			log.Printf("executor: human_review requires store but none configured")
-			return engine.StepResult{Success: false}, fmt.Errorf("state store not configured")
-		}
-		// Notify a human agent for review by enqueueing an email to the agent.
-		var payload map[string]string
-		if err := json.Unmarshal(step.Input, &payload); err != nil {
-			log.Printf("executor: human_review invalid input: %v", err)
-			return engine.StepResult{Success: false}, err
-		}
-		agentEmail := payload["agent_email"]
-		draft := payload["draft"]
-		ticketID := payload["ticket_id"]
-		subject := "CSR Review Required"
-		body := "A draft response requires your review.\n\nTicket: " + ticketID + "\n\nDraft:\n" + draft
-		mail := map[string]string{"to": agentEmail, "subject": subject, "body": body}
-		b, _ := json.Marshal(mail)
-		ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: b, State: "pending", Published: false}
-		if err := e.store.EnqueueEvent(ctx, ev); err != nil {
-			log.Printf("executor: enqueue human review email error: %v", err)
-			return engine.StepResult{Success: false}, err
-		}
-		return engine.StepResult{Success: true, Output: []byte(`{"enqueued_review":true}`)}, nil
+	h, ok := e.handlers.Lookup(run.WorkflowType, run.WorkflowVersion, step.StepName)
+	if !ok {
+		return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("executor: no handler registered for step %q of workflow %s@%s", step.StepName, run.WorkflowType, run.WorkflowVersion))
+	}
 
-	default:
-		// generic placeholder success for unknown steps
-		return engine.StepResult{Success: true, Output: []byte(`{"status":"ok"}`)}, nil
+	if err := e.throttle(h); err != nil {
+		return engine.StepResult{Success: false}, err
+	}
+
+	sl.Info("executing handler", engine.F("handler", h.Name()), engine.F("workflow_version", run.WorkflowVersion))
+	res, err = h.Execute(ctx, engine.ExecutionContext{Run: run, Step: step, Log: sl})
+	if err != nil {
+		return res, err
+	}
+	if !res.Success {
+		return res, fmt.Errorf("executor: step %q reported failure", step.StepName)
 	}
+	return res, nil
 }
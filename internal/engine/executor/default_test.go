@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/google/uuid"
+)
+
+func newStep(t *testing.T, st *store.MemoryStore, workflowType, version, stepName string) *engine.WorkflowStepRecord {
+	t.Helper()
+	ctx := context.Background()
+	run := &engine.WorkflowRun{ID: uuid.NewString(), WorkflowType: workflowType, WorkflowVersion: version, Status: engine.RunStatusRunning, Payload: []byte(`{}`)}
+	if err := st.CreateRun(ctx, run); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	step := &engine.WorkflowStepRecord{ID: uuid.NewString(), RunID: run.ID, StepName: stepName, Seq: 1, Status: engine.StepStatusInProgress, Input: []byte(`{}`)}
+	if err := st.InsertSteps(ctx, []*engine.WorkflowStepRecord{step}); err != nil {
+		t.Fatalf("insert step: %v", err)
+	}
+	return step
+}
+
+func TestRunStepDispatchesToRegisteredHandler(t *testing.T) {
+	st := store.NewMemoryStore()
+	reg := NewHandlerRegistry()
+	reg.Register("csr", "", named("draft", "any"))
+	reg.Register("csr", "v2", named("draft", "v2"))
+	e := NewDefaultExecutor(st, reg, nil)
+
+	res, err := e.RunStep(context.Background(), newStep(t, st, "csr", "v2", "draft"))
+	if err != nil || !res.Success || string(res.Output) != `"v2"` {
+		t.Fatalf("RunStep = %+v, %v; want the v2 handler", res, err)
+	}
+}
+
+func TestRunStepFailsUnknownSteps(t *testing.T) {
+	st := store.NewMemoryStore()
+	reg := NewHandlerRegistry()
+	reg.Register("csr", "", named("draft", "any"))
+	e := NewDefaultExecutor(st, reg, nil)
+
+	for _, step := range []*engine.WorkflowStepRecord{
+		newStep(t, st, "csr", "v1", "publish"),
+		newStep(t, st, "billing", "v1", "draft"),
+	} {
+		res, err := e.RunStep(context.Background(), step)
+		if err == nil || res.Success {
+			t.Fatalf("step %q = %+v, %v; want a failure", step.StepName, res, err)
+		}
+		if _, retryable := engine.ClassifyError(err); retryable {
+			t.Errorf("step %q failed with retryable error %v; an unknown step never succeeds", step.StepName, err)
+		}
+	}
+}
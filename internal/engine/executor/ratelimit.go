@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"golang.org/x/time/rate"
+)
+
+// Connector returns h labelled as calling connector (e.g. "llm"), so the
+// connector's rate limit applies to it.
+func Connector(connector string, h engine.StepHandler) engine.StepHandler {
+	return connectorHandler{StepHandler: h, connector: connector}
+}
+
+// Rename returns h registered under another step name, keeping its
+// connector.
+func Rename(name string, h engine.StepHandler) engine.StepHandler {
+	renamed := NewHandler(name, h.Execute)
+	if c, ok := h.(interface{ Connector() string }); ok {
+		return Connector(c.Connector(), renamed)
+	}
+	return renamed
+}
+
+type connectorHandler struct {
+	engine.StepHandler
+	connector string
+}
+
+func (h connectorHandler) Connector() string { return h.connector }
+
+// ParseStepRateLimits parses per-connector rate limits in steps started per
+// second, e.g. "llm=5,rag=20". Each connector may burst up to one second's
+// worth of steps. The limiters are local to the process, so with N replicas
+// a connector sees up to N times the rate; and they count steps, not the
+// calls a step makes.
+func ParseStepRateLimits(s string) (map[string]*rate.Limiter, error) {
+	perSecond, err := engine.ParseLimits(s)
+	if err != nil {
+		return nil, fmt.Errorf("rate limits: %w", err)
+	}
+	limiters := make(map[string]*rate.Limiter, len(perSecond))
+	for connector, r := range perSecond {
+		if r == 0 {
+			continue
+		}
+		limiters[connector] = rate.NewLimiter(rate.Limit(r), int(math.Max(1, math.Ceil(r))))
+	}
+	return limiters, nil
+}
+
+// WithStepRateLimits sets the per-connector rate limits applied before
+// running a handler of a limited connector. A step over the limit is not
+// started; it fails with an *engine.ThrottledError so the scheduler defers
+// it. Limits apply to the steps this replica starts.
+func (e *DefaultExecutor) WithStepRateLimits(limiters map[string]*rate.Limiter) *DefaultExecutor {
+	e.limiters = limiters
+	return e
+}
+
+// throttle takes a token for h's connector, or returns the error deferring
+// the step until one is available.
+func (e *DefaultExecutor) throttle(h engine.StepHandler) error {
+	c, ok := h.(interface{ Connector() string })
+	if !ok {
+		return nil
+	}
+	lim, ok := e.limiters[c.Connector()]
+	if !ok {
+		return nil
+	}
+	r := lim.Reserve()
+	if d := r.Delay(); d > 0 {
+		r.Cancel()
+		return &engine.ThrottledError{Connector: c.Connector(), RetryAfter: d}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+type handlerKey struct {
+	workflowID string
+	version    string
+	step       string
+}
+
+// HandlerRegistry maps (workflow, version, step name) to a StepHandler.
+// Workflows register their own handlers so adding a workflow never requires
+// touching the executor.
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[handlerKey]engine.StepHandler
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{handlers: make(map[handlerKey]engine.StepHandler)}
+}
+
+// Register adds h for workflowID. An empty version registers h for every
+// version of the workflow; a version-specific handler takes precedence.
+func (r *HandlerRegistry) Register(workflowID, version string, h engine.StepHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[handlerKey{workflowID: workflowID, version: version, step: h.Name()}] = h
+}
+
+// Lookup returns the handler for a step, preferring a version-specific
+// registration over a version-agnostic one.
+func (r *HandlerRegistry) Lookup(workflowID, version, stepName string) (engine.StepHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.handlers[handlerKey{workflowID: workflowID, version: version, step: stepName}]; ok {
+		return h, true
+	}
+	h, ok := r.handlers[handlerKey{workflowID: workflowID, step: stepName}]
+	return h, ok
+}
+
+type funcHandler struct {
+	name string
+	fn   func(ctx context.Context, execCtx engine.ExecutionContext) (engine.StepResult, error)
+}
+
+func (h funcHandler) Name() string { return h.name }
+
+func (h funcHandler) Execute(ctx context.Context, execCtx engine.ExecutionContext) (engine.StepResult, error) {
+	return h.fn(ctx, execCtx)
+}
+
+// NewHandler adapts a function to the engine.StepHandler interface.
+func NewHandler(name string, fn func(ctx context.Context, execCtx engine.ExecutionContext) (engine.StepResult, error)) engine.StepHandler {
+	return funcHandler{name: name, fn: fn}
+}
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+func named(name, tag string) engine.StepHandler {
+	return NewHandler(name, func(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+		return engine.StepResult{Success: true, Output: []byte(`"` + tag + `"`)}, nil
+	})
+}
+
+func TestHandlerRegistryPrefersVersionSpecificHandlers(t *testing.T) {
+	reg := NewHandlerRegistry()
+	reg.Register("csr", "", named("draft", "any"))
+	reg.Register("csr", "v2", named("draft", "v2"))
+	reg.Register("billing", "", named("charge", "billing"))
+
+	for _, tc := range []struct {
+		workflow, version, step string
+		want                    string
+	}{
+		{"csr", "v2", "draft", "v2"},
+		{"csr", "v1", "draft", "any"},
+		{"csr", "", "draft", "any"},
+		{"billing", "v7", "charge", "billing"},
+	} {
+		h, ok := reg.Lookup(tc.workflow, tc.version, tc.step)
+		if !ok {
+			t.Fatalf("no handler for %s@%s %s", tc.workflow, tc.version, tc.step)
+		}
+		res, _ := h.Execute(context.Background(), engine.ExecutionContext{})
+		if string(res.Output) != `"`+tc.want+`"` {
+			t.Errorf("%s@%s %s ran handler %s, want %s", tc.workflow, tc.version, tc.step, res.Output, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ workflow, version, step string }{
+		{"csr", "v2", "charge"},
+		{"billing", "v1", "draft"},
+		{"unknown", "v1", "draft"},
+	} {
+		if _, ok := reg.Lookup(tc.workflow, tc.version, tc.step); ok {
+			t.Errorf("handler found for %s@%s %s", tc.workflow, tc.version, tc.step)
+		}
+	}
+}
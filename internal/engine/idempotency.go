@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// StepKey returns the idempotency key of a step. It is derived from the run,
+// sequence and name only, so every attempt of the step (retries, requeues
+// after a worker crash, dead-letter replays with the same input) shares it.
+func StepKey(step *WorkflowStepRecord) string {
+	if step.IdempotencyKey != nil && *step.IdempotencyKey != "" {
+		return *step.IdempotencyKey
+	}
+	return stepKey(step)
+}
+
+// ReplayStepKey returns the idempotency key of step once a dead-letter replay
+// replaced its input. The key is derived from the new input, so the replayed
+// step's side effects are not deduplicated against those of the input it
+// failed with, while replaying the same edit twice still enqueues once.
+func ReplayStepKey(step *WorkflowStepRecord, input []byte) string {
+	sum := sha256.Sum256(input)
+	return fmt.Sprintf("%s:replay:%x", stepKey(step), sum[:8])
+}
+
+// BranchStepKey returns the idempotency key of the n-th step named name
+// planned for a run of a ParallelPlanner. It does not depend on the seq,
+// which the store allocates on insert, so two planners racing to plan the
+// same step derive the same key and the store inserts it once.
+func BranchStepKey(runID, name string, n int) string {
+	return fmt.Sprintf("%s:%s#%d", runID, name, n)
+}
+
+func stepKey(step *WorkflowStepRecord) string {
+	return fmt.Sprintf("%s:%d:%s", step.RunID, step.Seq, step.StepName)
+}
+
+// IdempotencyKey returns the key for a side effect of the executing step,
+// e.g. IdempotencyKey("email") for the outbox event it enqueues. Passing it
+// as OutboxEvent.IdempotencyKey makes a re-run of the step enqueue nothing.
+func (ec ExecutionContext) IdempotencyKey(effect string) string {
+	return StepKey(ec.Step) + ":" + effect
+}
@@ -1,13 +1,20 @@
 package engine
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 // Core engine interfaces (lightweight, expand as implementation progresses)
 
 type Workflow interface {
 	ID() string
 	Version() string
-	// Plan returns next step definitions (deterministic, pure)
+	// Plan returns next step definitions (deterministic, pure). It is called
+	// when a run starts and again after every step reaches a terminal state;
+	// run.Steps carries the steps persisted so far. Returning no steps ends
+	// the run.
 	Plan(ctx context.Context, run *WorkflowRun) ([]WorkflowStepDef, error)
 }
 
@@ -15,55 +22,324 @@ type WorkflowStep interface {
 	Name() string
 }
 
+// StepHandler executes one named step of a workflow. Handlers are registered
+// per workflow (and optionally version) and must be idempotent, since a step
+// can be re-run after a worker crash.
+type StepHandler interface {
+	Name() string
+	Execute(ctx context.Context, execCtx ExecutionContext) (StepResult, error)
+}
+
 type WorkflowStepDef struct {
 	StepName string
 	Seq      int
 	Input    []byte
+	// Template, when set, is bound into Input when the step is claimed, so
+	// it can reference the outputs of steps that have not run at plan time,
+	// e.g. {"body": "{{ steps.draft_response.output.draft }}"}. See package
+	// binding.
+	Template []byte
+	// Retry overrides DefaultRetryPolicy for this step.
+	Retry *RetryPolicy
+	// Kind is StepKindTask when empty. Timer steps park the run until WakeAt.
+	Kind   string
+	WakeAt *time.Time
+	// Compensate, when set, undoes the step once it completed if the run
+	// later fails or is cancelled. Compensations run one at a time, latest
+	// step first.
+	Compensate *Compensation
+	// Priority is added to the run's priority, e.g. to let the reply to a
+	// customer overtake bulk enrichment steps of the same run.
+	Priority int
+}
+
+// Timer returns a timer step that completes at wakeAt, e.g. to follow up on a
+// lead three days later. The wake time is persisted with the step, so timers
+// survive restarts.
+func Timer(name string, wakeAt time.Time) WorkflowStepDef {
+	return WorkflowStepDef{StepName: name, Kind: StepKindTimer, WakeAt: &wakeAt}
+}
+
+// ChildRun returns a step that starts a run of workflowType with payload and
+// completes with {"run_id", "status", "output"} once that run completed, the
+// output being the result of its last step. It fails if the child run fails
+// or is cancelled; cancelling the parent cancels the child.
+func ChildRun(name, workflowType string, payload json.RawMessage) WorkflowStepDef {
+	input, _ := json.Marshal(ChildRunInput{WorkflowType: workflowType, Payload: payload})
+	return WorkflowStepDef{StepName: name, Kind: StepKindChildRun, Input: input}
+}
+
+// Map returns a step that runs spec.ItemStep once per element of items, at
+// most spec.Concurrency at a time, e.g. one enrichment per lead of a sheet.
+// items is a JSON array or a JSON string holding a single reference such as
+// "{{ steps.read_sheet.output.rows }}", bound when the map step is claimed.
+// The map step fails once an item failed for good and its running items
+// finished; items not yet started are cancelled.
+func Map(name string, items json.RawMessage, spec MapSpec) WorkflowStepDef {
+	b, _ := json.Marshal(MapInput{MapSpec: spec, Items: items})
+	def := WorkflowStepDef{StepName: name, Kind: StepKindMap, Input: b}
+	if len(items) > 0 && items[0] == '"' {
+		def.Input, def.Template = nil, b
+	}
+	return def
 }
 
 type ExecutionContext struct {
 	Run  *WorkflowRun
 	Step *WorkflowStepRecord
+	// Log is bound to Step; entries are persisted as step logs.
+	Log Logger
+}
+
+// Logger returns ec.Log, or a NopLogger when none is set (e.g. in tests).
+func (ec ExecutionContext) Logger() Logger {
+	if ec.Log == nil {
+		return NopLogger{}
+	}
+	return ec.Log
 }
 
 type StepResult struct {
 	Success bool
 	Output  []byte
+	// Wait, when set, parks the step in waiting_for_signal instead of
+	// completing it.
+	Wait *WaitSpec
+	// AwaitChildren parks the step in waiting_for_children, with Output as
+	// its result, until its child run or map items finish.
+	AwaitChildren bool
 }
 
 type StateStore interface {
+	// Atomic runs fn in a transaction: the store calls fn makes with the
+	// context it is given, including those of another store on the same
+	// database, commit together or not at all.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
 	CreateRun(ctx context.Context, run *WorkflowRun) error
+	// LoadRun returns the run with its Steps populated, or nil if not found.
 	LoadRun(ctx context.Context, runID string) (*WorkflowRun, error)
+	// UpdateRun persists the run status.
+	UpdateRun(ctx context.Context, run *WorkflowRun) error
+	// MigrateRun persists the workflow version and payload of a run only
+	// while it is running on version from and none of its steps is in
+	// progress. It reports whether the run was updated.
+	MigrateRun(ctx context.Context, run *WorkflowRun, from string) (bool, error)
+	// ListRuns returns runs matching filter, newest first, and the total
+	// number of matches ignoring Limit/Offset. Steps are not populated.
+	ListRuns(ctx context.Context, filter RunFilter) ([]*WorkflowRun, int64, error)
+	// CancelRun marks a running run cancelled and moves its pending, held,
+	// waiting_for_children and waiting_for_signal steps to cancelled. It
+	// reports whether the run was still running.
+	CancelRun(ctx context.Context, runID string) (bool, error)
+	// PauseRun sets (paused) or clears the paused_at of a running run,
+	// waking the scheduler on resume. It reports whether the run was running
+	// and not already in the requested state.
+	PauseRun(ctx context.Context, runID string, paused bool) (bool, error)
+	// PauseWorkflow pauses every run of a workflow type, updating the
+	// reason of an existing pause.
+	PauseWorkflow(ctx context.Context, pause *WorkflowPause) error
+	// ResumeWorkflow removes the pause of a workflow type, waking the
+	// scheduler. It reports whether the type was paused.
+	ResumeWorkflow(ctx context.Context, workflowType string) (bool, error)
+	ListWorkflowPauses(ctx context.Context) ([]*WorkflowPause, error)
+	// ListExpiredRuns returns running runs whose deadline passed.
+	ListExpiredRuns(ctx context.Context, limit int) ([]*WorkflowRun, error)
+	// InsertSteps inserts steps. A step with a zero Seq gets the next seq of
+	// its run, allocated with the run locked so concurrent planners never
+	// collide, and is skipped when a step of the run already has its
+	// idempotency key, i.e. when a concurrent planner inserted it first.
 	InsertSteps(ctx context.Context, steps []*WorkflowStepRecord) error
+	// ClaimNextStep and ClaimNextSteps skip steps of paused runs and of
+	// paused workflow types.
 	ClaimNextStep(ctx context.Context, workerID string) (*WorkflowStepRecord, error)
-	UpdateStep(ctx context.Context, step *WorkflowStepRecord) error
+	// ClaimNextSteps claims up to n runnable steps in one round trip. The
+	// returned steps carry the WorkflowType of their run.
+	ClaimNextSteps(ctx context.Context, workerID string, n int) ([]*WorkflowStepRecord, error)
+	// UpdateStep persists the outcome of a claimed step only while workerID
+	// still holds the lease it claimed the step with. It reports whether the
+	// step was updated; false means the step was requeued, released or
+	// claimed again since, and the outcome must be dropped.
+	UpdateStep(ctx context.Context, step *WorkflowStepRecord, workerID string) (bool, error)
+	// TransitionStep persists status, result, error, next_attempt_at and
+	// lock_owner of a step only while its status is still from, waking the
+	// scheduler when the step becomes pending. It reports whether the step
+	// was updated.
+	TransitionStep(ctx context.Context, step *WorkflowStepRecord, from string) (bool, error)
 	AppendLog(ctx context.Context, log *StepLog) error
+	// ListRunLogs returns the logs of every step of a run in creation order.
+	ListRunLogs(ctx context.Context, runID string) ([]*StepLog, error)
+	// EnqueueEvent inserts an outbox event. An event whose IdempotencyKey is
+	// already used is not inserted and no error is returned.
 	EnqueueEvent(ctx context.Context, ev *OutboxEvent) error
+	// LoadEventByKey returns the outbox event with the given idempotency key,
+	// or nil if none was enqueued.
+	LoadEventByKey(ctx context.Context, key string) (*OutboxEvent, error)
 	// RequeueStaleSteps inspects in-progress steps whose last heartbeat is older
 	// than heartbeatTTL (seconds) and resets them to pending with incremented
-	// attempts and appropriate next_attempt_at/backoff. Returns number requeued.
-	RequeueStaleSteps(ctx context.Context, heartbeatTTLSeconds int, limit int) (int, error)
-	// HeartbeatStep updates the last_heartbeat timestamp for a specific step.
-	HeartbeatStep(ctx context.Context, stepID string) error
+	// attempts and appropriate next_attempt_at/backoff, revoking the lease of
+	// the worker that held them. A step with a RetryPolicy is backed off and
+	// limited by it, the abandonment counting as a transient error; steps
+	// out of attempts fail. Returns the number requeued and the steps that
+	// failed, which the caller must report and re-plan the runs of.
+	RequeueStaleSteps(ctx context.Context, heartbeatTTLSeconds int, limit int) (int, []*WorkflowStepRecord, error)
+	// UpdateWaitingStep persists status, result and next_attempt_at of a step
+	// only while it is still waiting_for_signal. It reports whether the step
+	// was updated, so concurrent signals resolve a step at most once.
+	UpdateWaitingStep(ctx context.Context, step *WorkflowStepRecord) (bool, error)
+	// ListWaitingSteps returns steps waiting for a signal, optionally limited
+	// to runs of one workspace.
+	ListWaitingSteps(ctx context.Context, workspaceID string) ([]*WorkflowStepRecord, error)
+	// ListExpiredWaitingSteps returns waiting steps whose deadline passed.
+	ListExpiredWaitingSteps(ctx context.Context, limit int) ([]*WorkflowStepRecord, error)
+	// HeartbeatStep extends the lease workerID holds on a claimed step. It
+	// reports false once the lease was lost.
+	HeartbeatStep(ctx context.Context, step *WorkflowStepRecord, workerID string) (bool, error)
+	// ReleaseSteps puts the steps workerID still holds back to pending
+	// without counting an attempt, so other workers can claim them right
+	// away. It returns the number of steps released.
+	ReleaseSteps(ctx context.Context, workerID string) (int, error)
+	// CountPendingSteps returns the number of pending steps per workflow
+	// type and readiness.
+	CountPendingSteps(ctx context.Context) ([]PendingCount, error)
+	// LoadStep returns a step by ID, or nil if not found.
+	LoadStep(ctx context.Context, stepID string) (*WorkflowStepRecord, error)
+	// ListDeadLetterSteps returns failed steps, most recently failed first,
+	// and the total number of matches ignoring Limit/Offset.
+	ListDeadLetterSteps(ctx context.Context, filter DeadLetterFilter) ([]*WorkflowStepRecord, int64, error)
+	// ReplayStep moves a failed step back to pending with its attempts reset,
+	// replacing its input and idempotency key (see ReplayStepKey) when input
+	// is non-empty, and reopens its failed run. It reports whether the step
+	// was still failed and its run neither cancelled nor compensated.
+	// Replaying a failed compensation step resumes the run's compensation
+	// instead.
+	ReplayStep(ctx context.Context, stepID string, input []byte) (bool, error)
+	// DiscardStep marks a failed step discarded. It reports whether the step
+	// was still failed.
+	DiscardStep(ctx context.Context, stepID string) (bool, error)
+	// LoadEvent returns an outbox event by ID, or nil if not found.
+	LoadEvent(ctx context.Context, eventID string) (*OutboxEvent, error)
+	// ListDeadLetterEvents returns failed outbox events, newest first, and
+	// the total number of matches ignoring Limit/Offset.
+	ListDeadLetterEvents(ctx context.Context, filter DeadLetterFilter) ([]*OutboxEvent, int64, error)
+	// ReplayEvent moves a failed outbox event back to pending, replacing its
+	// payload when payload is non-empty. It reports whether the event was
+	// still failed.
+	ReplayEvent(ctx context.Context, eventID string, payload []byte) (bool, error)
+	// DiscardEvent marks a failed outbox event discarded. It reports whether
+	// the event was still failed.
+	DiscardEvent(ctx context.Context, eventID string) (bool, error)
+	AppendEventAudit(ctx context.Context, audit *EventAudit) error
+	// ListEventAudits returns the audit entries of an outbox event in
+	// creation order.
+	ListEventAudits(ctx context.Context, eventID string) ([]*EventAudit, error)
+	CreateTrigger(ctx context.Context, trigger *WorkflowTrigger) error
+	// LoadTrigger returns a trigger by ID, or nil if not found.
+	LoadTrigger(ctx context.Context, triggerID string) (*WorkflowTrigger, error)
+	// ListTriggers returns the triggers of a workspace (all triggers when
+	// workspaceID is empty) ordered by creation time.
+	ListTriggers(ctx context.Context, workspaceID string) ([]*WorkflowTrigger, error)
+	// UpdateTrigger persists schedule, timezone, payload, enabled and
+	// next_run_at of a trigger.
+	UpdateTrigger(ctx context.Context, trigger *WorkflowTrigger) error
+	// DeleteTrigger reports whether the trigger existed.
+	DeleteTrigger(ctx context.Context, triggerID string) (bool, error)
+	// ListDueTriggers returns enabled triggers whose next_run_at has passed.
+	ListDueTriggers(ctx context.Context, limit int) ([]*WorkflowTrigger, error)
+	// MarkTriggerFired records runID as the run for the activation at
+	// scheduled and moves next_run_at to next. It only applies while
+	// next_run_at still equals scheduled and reports whether it did, so an
+	// activation is recorded once across schedulers.
+	MarkTriggerFired(ctx context.Context, triggerID string, scheduled time.Time, next *time.Time, runID string) (bool, error)
 }
 
 type Executor interface {
 	RunStep(ctx context.Context, step *WorkflowStepRecord) (StepResult, error)
 }
 
+// Dispatcher publishes events to the subscribers of their type. Subscribe
+// accepts a topic pattern (see MatchTopic).
 type Dispatcher interface {
 	Dispatch(ctx context.Context, ev OutboxEvent) error
 	Subscribe(eventType string) (<-chan OutboxEvent, error)
 }
 
+// EventLog reads the events stored in the outbox in the order they were
+// stored and keeps the offsets of durable subscriptions. Both stores
+// implement it.
+type EventLog interface {
+	EnqueueEvent(ctx context.Context, ev *OutboxEvent) error
+	// ReadEvents returns up to limit events with Seq above after whose type
+	// matches pattern, in Seq order.
+	ReadEvents(ctx context.Context, after int64, pattern string, limit int) ([]*OutboxEvent, error)
+	// LastEventSeq returns the Seq of the latest event, or 0.
+	LastEventSeq(ctx context.Context) (int64, error)
+	// EnsureSubscription creates sub unless a subscription of that name
+	// exists, in which case only its pattern is updated. It returns the
+	// stored subscription.
+	EnsureSubscription(ctx context.Context, sub *EventSubscription) (*EventSubscription, error)
+	// LeaseSubscription takes or renews owner's lease on a subscription for
+	// ttl. It returns nil while another owner holds an unexpired lease or
+	// when the subscription does not exist.
+	LeaseSubscription(ctx context.Context, name, owner string, ttl time.Duration) (*EventSubscription, error)
+	// AckSubscription moves the subscription's offset forward to seq while
+	// owner holds its lease, and reports whether it did.
+	AckSubscription(ctx context.Context, name, owner string, seq int64) (bool, error)
+	// ReleaseSubscription drops owner's lease on a subscription.
+	ReleaseSubscription(ctx context.Context, name, owner string) error
+	ListSubscriptions(ctx context.Context) ([]*EventSubscription, error)
+	// DeleteSubscription reports whether the subscription existed.
+	DeleteSubscription(ctx context.Context, name string) (bool, error)
+}
+
+// WorkflowRegistry holds every registered version of each workflow. Get
+// returns the latest version, which new runs start on; a run is always
+// planned by the version it started with (or was migrated to).
 type WorkflowRegistry interface {
 	Register(w Workflow)
 	Get(id string) (Workflow, bool)
+	GetVersion(id, version string) (Workflow, bool)
+	// Versions returns the registered versions of a workflow, oldest first.
+	Versions(id string) []string
+	// IDs returns the IDs of all registered workflows.
+	IDs() []string
+}
+
+// RunTimeout is implemented by workflows whose runs have a deadline by
+// default: StartRun sets the deadline of a run started without one to its
+// start time plus RunTimeout, when positive.
+type RunTimeout interface {
+	RunTimeout() time.Duration
+}
+
+// ParallelPlanner is implemented by workflows with parallel branches. When
+// ParallelBranches reports true, a run is re-planned after every step that
+// finishes rather than once no step is in flight, so a branch advances
+// while another waits on a timer or a review. Plan is then called with
+// steps still pending or running and must only return steps the run does
+// not have yet; the planned steps get their seq from the store.
+type ParallelPlanner interface {
+	ParallelBranches() bool
+}
+
+// Migrator is implemented by a workflow version that accepts runs started on
+// another version. Migrate is called with the run, its steps and the version
+// it is moving from before the run is switched over; it may rewrite
+// run.Payload and returns an error to refuse the run.
+type Migrator interface {
+	Migrate(ctx context.Context, run *WorkflowRun, from string) error
 }
 
+// Logger writes structured engine logs. A logger bound to a step with
+// ForStep also persists its entries as StepLogs of that step, which the run
+// API returns as the step's execution timeline.
 type Logger interface {
 	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
 	Error(msg string, fields ...Field)
+	// With returns a logger that adds fields to every entry.
+	With(fields ...Field) Logger
+	// ForStep returns a logger bound to step.
+	ForStep(step *WorkflowStepRecord) Logger
 }
 
 type Field struct {
@@ -71,7 +347,49 @@ type Field struct {
 	V interface{}
 }
 
+// F is shorthand for a Field.
+func F(k string, v interface{}) Field { return Field{K: k, V: v} }
+
+// Err returns an "error" field holding err's message.
+func Err(err error) Field {
+	if err == nil {
+		return Field{K: "error", V: nil}
+	}
+	return Field{K: "error", V: err.Error()}
+}
+
+// NopLogger discards every entry.
+type NopLogger struct{}
+
+func (NopLogger) Info(string, ...Field)                {}
+func (NopLogger) Warn(string, ...Field)                {}
+func (NopLogger) Error(string, ...Field)               {}
+func (l NopLogger) With(...Field) Logger               { return l }
+func (l NopLogger) ForStep(*WorkflowStepRecord) Logger { return l }
+
+// Queue is a durable job queue for background work that does not fit the
+// step model, such as document ingestion. Delivery is at least once:
+// handlers must tolerate a message being delivered again after a crash or a
+// lapsed lease. Like StateStore, the methods that can race report whether
+// they applied.
 type Queue interface {
-	Enqueue(ctx context.Context, qname string, payload []byte) error
-	Dequeue(ctx context.Context, qname string) ([]byte, error)
+	// Enqueue adds a message to queue qname. It is delivered once opts.Delay
+	// has passed.
+	Enqueue(ctx context.Context, qname string, payload []byte, opts EnqueueOptions) (*QueueMessage, error)
+	// Dequeue leases up to n visible messages of qname, oldest first, and
+	// hides them for visibility. Every delivery counts as an attempt; a
+	// message whose lease lapses after its last attempt is moved to the dead
+	// state instead of being delivered again.
+	Dequeue(ctx context.Context, qname string, n int, visibility time.Duration) ([]*QueueMessage, error)
+	// Ack deletes a message that was processed.
+	Ack(ctx context.Context, msg *QueueMessage) (bool, error)
+	// Nack returns a message to its queue after delay, or moves it to the
+	// dead state once it has used up its attempts.
+	Nack(ctx context.Context, msg *QueueMessage, delay time.Duration, reason string) (bool, error)
+	// DeadLetter moves a message that must not be retried to the dead state.
+	DeadLetter(ctx context.Context, msg *QueueMessage, reason string) (bool, error)
+	// Extend keeps a message hidden for another visibility from now.
+	Extend(ctx context.Context, msg *QueueMessage, visibility time.Duration) (bool, error)
+	// Stats counts the messages of every queue by state.
+	Stats(ctx context.Context) ([]QueueStats, error)
 }
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConcurrencyLimits cap the number of steps in progress at once, enforced
+// when steps are claimed. Zero means unlimited.
+type ConcurrencyLimits struct {
+	// Workspace caps the steps in progress per workspace; Workspaces
+	// overrides it for individual workspace IDs.
+	Workspace  int
+	Workspaces map[string]int
+	// Workflows caps the steps in progress per workflow type.
+	Workflows map[string]int
+}
+
+// Enabled reports whether any cap is set.
+func (l ConcurrencyLimits) Enabled() bool {
+	return l.Workspace > 0 || len(l.Workspaces) > 0 || len(l.Workflows) > 0
+}
+
+// ParseConcurrencyLimits builds ConcurrencyLimits from a default
+// per-workspace cap and the per-workspace and per-workflow overrides in
+// ParseLimits form.
+func ParseConcurrencyLimits(workspace int, workspaces, workflows string) (ConcurrencyLimits, error) {
+	l := ConcurrencyLimits{Workspace: workspace}
+	var err error
+	if l.Workspaces, err = parseCaps(workspaces); err != nil {
+		return l, fmt.Errorf("workspace concurrency: %w", err)
+	}
+	if l.Workflows, err = parseCaps(workflows); err != nil {
+		return l, fmt.Errorf("workflow concurrency: %w", err)
+	}
+	return l, nil
+}
+
+func parseCaps(s string) (map[string]int, error) {
+	limits, err := ParseLimits(s)
+	if err != nil || len(limits) == 0 {
+		return nil, err
+	}
+	caps := make(map[string]int, len(limits))
+	for name, v := range limits {
+		if v != float64(int(v)) {
+			return nil, fmt.Errorf("limit %s=%v must be a whole number", name, v)
+		}
+		caps[name] = int(v)
+	}
+	return caps, nil
+}
+
+// ThrottledError defers a step that was not started because the rate limit
+// of the connector it calls was reached. The scheduler puts the step back
+// until RetryAfter has passed without counting an attempt.
+type ThrottledError struct {
+	Connector  string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("connector %s is rate limited; retry in %s", e.Connector, e.RetryAfter)
+}
+
+// ParseLimits parses a comma-separated list of name=value pairs such as
+// "csr=8,lead_followup=2" or "llm=5,rag=20.5". An empty string yields an
+// empty map.
+func ParseLimits(s string) (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("limit %q is not name=value", pair)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("limit %q needs a non-negative number", pair)
+		}
+		limits[name] = v
+	}
+	return limits, nil
+}
@@ -0,0 +1,34 @@
+package engine
+
+import "sync"
+
+var (
+	loggerMu      sync.RWMutex
+	defaultLogger Logger = NopLogger{}
+)
+
+// DefaultLogger returns the engine logger used by monitors and helpers that
+// are not handed one, such as the signal, deadline and trigger monitors. It
+// discards entries until SetLogger replaces it.
+func DefaultLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return defaultLogger
+}
+
+// SetLogger replaces the default engine logger and returns a function
+// restoring the previous one. A nil logger discards entries.
+func SetLogger(l Logger) (restore func()) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	prev := defaultLogger
+	defaultLogger = l
+	return func() {
+		loggerMu.Lock()
+		defer loggerMu.Unlock()
+		defaultLogger = prev
+	}
+}
@@ -0,0 +1,119 @@
+// Package logger implements engine.Logger. Entries are written as one JSON
+// object per line; entries of a logger bound to a step are also persisted
+// through StateStore.AppendLog with the fields as Meta.
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// persistTimeout bounds AppendLog so a slow database never stalls a step.
+const persistTimeout = 5 * time.Second
+
+// JSONLogger is the engine's structured logger.
+type JSONLogger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	store  engine.StateStore
+	step   *engine.WorkflowStepRecord
+	fields []engine.Field
+}
+
+// New returns a logger writing to out (os.Stdout when nil). store may be nil,
+// in which case step-bound entries are only written to out.
+func New(out io.Writer, store engine.StateStore) *JSONLogger {
+	if out == nil {
+		out = os.Stdout
+	}
+	return &JSONLogger{mu: &sync.Mutex{}, out: out, store: store}
+}
+
+func (l *JSONLogger) Info(msg string, fields ...engine.Field)  { l.write("info", msg, fields) }
+func (l *JSONLogger) Warn(msg string, fields ...engine.Field)  { l.write("warn", msg, fields) }
+func (l *JSONLogger) Error(msg string, fields ...engine.Field) { l.write("error", msg, fields) }
+
+func (l *JSONLogger) With(fields ...engine.Field) engine.Logger {
+	c := *l
+	c.fields = append(append([]engine.Field{}, l.fields...), fields...)
+	return &c
+}
+
+func (l *JSONLogger) ForStep(step *engine.WorkflowStepRecord) engine.Logger {
+	c := *l
+	c.step = step
+	c.fields = append(append([]engine.Field{}, l.fields...),
+		engine.F("run_id", step.RunID), engine.F("step_id", step.ID), engine.F("step", step.StepName))
+	return &c
+}
+
+func (l *JSONLogger) write(level, msg string, fields []engine.Field) {
+	all := append(append([]engine.Field{}, l.fields...), fields...)
+
+	entry := make(map[string]interface{}, len(all)+3)
+	for _, f := range all {
+		entry[f.K] = value(f.V)
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":%q,"msg":%q,"log_error":%q}`, level, msg, err.Error()))
+	}
+	l.mu.Lock()
+	l.out.Write(append(line, '\n'))
+	l.mu.Unlock()
+
+	if l.step != nil && l.store != nil {
+		l.persist(level, msg, fields)
+	}
+}
+
+// persist stores the entry as a StepLog. Only the entry's own fields and
+// those added with With are kept in Meta; the step identity is implied.
+func (l *JSONLogger) persist(level, msg string, fields []engine.Field) {
+	meta := make(map[string]interface{})
+	for _, f := range append(append([]engine.Field{}, l.fields...), fields...) {
+		switch f.K {
+		case "run_id", "step_id", "step":
+			continue
+		}
+		meta[f.K] = value(f.V)
+	}
+	var b []byte
+	if len(meta) > 0 {
+		b, _ = json.Marshal(meta)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := l.store.AppendLog(ctx, &engine.StepLog{ID: uuid.NewString(), StepID: l.step.ID, Level: level, Message: msg, Meta: b}); err != nil {
+		// written unbound so the failure is not persisted in turn
+		c := *l
+		c.step = nil
+		c.write("error", "logger: failed to persist log", []engine.Field{engine.Err(err)})
+	}
+}
+
+// value makes errors and durations readable in JSON.
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case json.RawMessage:
+		if !json.Valid(t) {
+			return string(t)
+		}
+	}
+	return v
+}
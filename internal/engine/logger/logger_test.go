@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+type logStore struct {
+	engine.StateStore
+	logs []*engine.StepLog
+}
+
+func (s *logStore) AppendLog(ctx context.Context, l *engine.StepLog) error {
+	s.logs = append(s.logs, l)
+	return nil
+}
+
+func TestStepLoggerWritesJSONAndPersists(t *testing.T) {
+	var out bytes.Buffer
+	store := &logStore{}
+	step := &engine.WorkflowStepRecord{ID: "s1", RunID: "r1", StepName: "draft_response"}
+
+	lg := New(&out, store)
+	lg.Info("not bound to a step")
+	lg.With(engine.F("worker", "w1")).ForStep(step).Error("step failed", engine.Err(errors.New("429 too many requests")), engine.F("attempt", 2))
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lines[1], &entry); err != nil {
+		t.Fatalf("invalid JSON line %s: %v", lines[1], err)
+	}
+	for k, want := range map[string]interface{}{"level": "error", "msg": "step failed", "run_id": "r1", "step_id": "s1", "worker": "w1", "error": "429 too many requests", "attempt": float64(2)} {
+		if entry[k] != want {
+			t.Errorf("%s = %v, want %v", k, entry[k], want)
+		}
+	}
+
+	if len(store.logs) != 1 {
+		t.Fatalf("expected 1 persisted log, got %d", len(store.logs))
+	}
+	got := store.logs[0]
+	if got.StepID != "s1" || got.Level != "error" || got.Message != "step failed" {
+		t.Fatalf("persisted %+v", got)
+	}
+	var meta map[string]interface{}
+	_ = json.Unmarshal(got.Meta, &meta)
+	if meta["attempt"] != float64(2) || meta["worker"] != "w1" || meta["step_id"] != nil {
+		t.Fatalf("meta = %s", got.Meta)
+	}
+}
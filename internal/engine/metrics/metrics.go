@@ -0,0 +1,194 @@
+// Package metrics exposes Prometheus metrics of the orchestration engine and
+// the outbox publisher on a dedicated registry served by Handler.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/dispatcher"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Step outcomes used as the "outcome" label.
+const (
+	OutcomeCompleted = "completed"
+	OutcomeFailed    = "failed"
+	OutcomeRetried   = "retried"
+	OutcomeWaiting   = "waiting"
+	// OutcomeThrottled is a step deferred by a connector rate limit before
+	// it ran.
+	OutcomeThrottled = "throttled"
+)
+
+// Registry holds every engine metric plus the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	StepsClaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_steps_claimed_total",
+		Help: "Steps claimed by the scheduler.",
+	}, []string{"workflow", "step"})
+
+	StepsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_steps_finished_total",
+		Help: "Step executions by outcome (completed, failed, retried, waiting, throttled).",
+	}, []string{"workflow", "step", "outcome"})
+
+	StepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "engine_step_duration_seconds",
+		Help:    "Duration of step executions by outcome.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"workflow", "step", "outcome"})
+
+	StepsRequeued = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "engine_steps_requeued_total",
+		Help: "In-progress steps requeued after their heartbeat went stale.",
+	})
+
+	OutboxPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_outbox_published_total",
+		Help: "Outbox events published.",
+	}, []string{"event_type"})
+
+	OutboxFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_outbox_failures_total",
+		Help: "Outbox events that failed to publish and were dead-lettered.",
+	}, []string{"event_type"})
+
+	OutboxRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_outbox_retries_total",
+		Help: "Failed outbox publish attempts scheduled for a retry.",
+	}, []string{"event_type"})
+
+	OutboxRecovered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "engine_outbox_recovered_total",
+		Help: "Outbox events returned to pending after their publisher stopped while publishing them.",
+	})
+
+	OutboxPublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "engine_outbox_publish_duration_seconds",
+		Help:    "Time spent publishing an outbox event.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"event_type"})
+
+	OutboxLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "engine_outbox_lag_seconds",
+		Help:    "Time from enqueueing an outbox event to publishing it.",
+		Buckets: []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"event_type"})
+
+	QueueMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "engine_queue_messages_total",
+		Help: "Queue messages handled by outcome (acked, retried, dead, lease_lost).",
+	}, []string{"queue", "outcome"})
+
+	QueueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "engine_queue_handle_duration_seconds",
+		Help:    "Time spent handling a queue message.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 900},
+	}, []string{"queue"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		StepsClaimed, StepsFinished, StepDuration, StepsRequeued,
+		OutboxPublished, OutboxFailures, OutboxRetries, OutboxRecovered, OutboxPublishDuration, OutboxLag,
+		QueueMessages, QueueDuration,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "engine_dispatcher_dropped_deliveries_total",
+			Help: "Dispatcher deliveries dropped because a subscriber did not accept them in time.",
+		}, func() float64 { return float64(dispatcher.DroppedDeliveries()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "engine_dispatcher_redeliveries_total",
+			Help: "Times a durable subscription redelivered events its consumer did not acknowledge in time.",
+		}, func() float64 { return float64(dispatcher.Redeliveries()) }),
+	)
+}
+
+// ObserveStep records the outcome and duration of one step execution.
+func ObserveStep(step *engine.WorkflowStepRecord, outcome string, d time.Duration) {
+	StepsFinished.WithLabelValues(step.WorkflowType, step.StepName, outcome).Inc()
+	StepDuration.WithLabelValues(step.WorkflowType, step.StepName, outcome).Observe(d.Seconds())
+}
+
+// RegisterQueueDepth exports the number of pending steps per workflow,
+// counted from store at scrape time.
+func RegisterQueueDepth(store engine.StateStore) {
+	Registry.MustRegister(&queueDepth{store: store, desc: prometheus.NewDesc(
+		"engine_pending_steps",
+		"Steps waiting to be claimed, by workflow and readiness (ready, delayed by backoff or a timer, or paused).",
+		[]string{"workflow", "state"}, nil,
+	)})
+}
+
+type queueDepth struct {
+	store engine.StateStore
+	desc  *prometheus.Desc
+}
+
+func (q *queueDepth) Describe(ch chan<- *prometheus.Desc) { ch <- q.desc }
+
+func (q *queueDepth) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	counts, err := q.store.CountPendingSteps(ctx)
+	if err != nil {
+		engine.DefaultLogger().Error("metrics: count pending steps failed", engine.Err(err))
+		return
+	}
+	for _, c := range counts {
+		ch <- prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, float64(c.Count), c.WorkflowType, c.State)
+	}
+}
+
+// ObserveQueueMessage records the outcome and duration of handling one
+// queue message.
+func ObserveQueueMessage(queue, outcome string, d time.Duration) {
+	QueueMessages.WithLabelValues(queue, outcome).Inc()
+	QueueDuration.WithLabelValues(queue).Observe(d.Seconds())
+}
+
+// RegisterQueueStats exports the number of messages per queue and state
+// (ready, delayed, in_flight, dead), counted from q at scrape time.
+func RegisterQueueStats(q engine.Queue) {
+	Registry.MustRegister(&queueStats{queue: q, desc: prometheus.NewDesc(
+		"engine_queue_messages",
+		"Messages per job queue by state (ready, delayed, in_flight, dead).",
+		[]string{"queue", "state"}, nil,
+	)})
+}
+
+type queueStats struct {
+	queue engine.Queue
+	desc  *prometheus.Desc
+}
+
+func (q *queueStats) Describe(ch chan<- *prometheus.Desc) { ch <- q.desc }
+
+func (q *queueStats) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := q.queue.Stats(ctx)
+	if err != nil {
+		engine.DefaultLogger().Error("metrics: queue stats failed", engine.Err(err))
+		return
+	}
+	for _, st := range stats {
+		for state, n := range map[string]int64{"ready": st.Ready, "delayed": st.Delayed, "in_flight": st.InFlight, "dead": st.Dead} {
+			ch <- prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, float64(n), st.Queue, state)
+		}
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -11,3 +11,112 @@ type WorkflowRun = canonical.WorkflowRun
 type WorkflowStepRecord = canonical.WorkflowStepRecord
 type OutboxEvent = canonical.OutboxEvent
 type StepLog = canonical.StepLog
+type EventAudit = canonical.EventAudit
+type RunFilter = canonical.RunFilter
+type WaitSpec = canonical.WaitSpec
+type Signal = canonical.Signal
+type RetryPolicy = canonical.RetryPolicy
+type DeadLetterFilter = canonical.DeadLetterFilter
+type WorkflowTrigger = canonical.WorkflowTrigger
+type PendingCount = canonical.PendingCount
+type WorkflowPause = canonical.WorkflowPause
+type Compensation = canonical.Compensation
+type ChildRunInput = canonical.ChildRunInput
+type MapSpec = canonical.MapSpec
+type MapInput = canonical.MapInput
+type QueueMessage = canonical.QueueMessage
+type EnqueueOptions = canonical.EnqueueOptions
+type QueueStats = canonical.QueueStats
+type EventSubscription = canonical.EventSubscription
+
+// Run statuses stored in workflow_runs.status.
+const (
+	RunStatusRunning   = "running"
+	RunStatusCompleted = "completed"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+	// RunStatusRejected ends a run whose last step is a review that was
+	// rejected, by a reviewer or on timeout.
+	RunStatusRejected = "rejected"
+)
+
+// Step statuses stored in workflow_steps.status.
+const (
+	StepStatusPending    = "pending"
+	StepStatusInProgress = "in_progress"
+	StepStatusCompleted  = "completed"
+	StepStatusFailed     = "failed"
+	StepStatusCancelled  = "cancelled"
+	// StepStatusWaitingForSignal parks a step until a human signal (or its
+	// timeout) resolves it.
+	StepStatusWaitingForSignal = "waiting_for_signal"
+	// StepStatusDiscarded removes a failed step from the dead-letter queue
+	// without running it again.
+	StepStatusDiscarded = "discarded"
+	// StepStatusWaitingForChildren parks a child-run or map step until its
+	// child run or items finish.
+	StepStatusWaitingForChildren = "waiting_for_children"
+	// StepStatusHeld keeps a map item from being claimed until its map step
+	// has a free concurrency slot.
+	StepStatusHeld = "held"
+)
+
+// Outbox event states stored in outbox_events.state. Failed events form the
+// outbox dead-letter queue.
+const (
+	OutboxStatePending   = "pending"
+	OutboxStateInFlight  = "in_flight"
+	OutboxStatePublished = "published"
+	OutboxStateFailed    = "failed"
+	OutboxStateDiscarded = "discarded"
+)
+
+// Queue message states stored in queue_messages.state. Acknowledged
+// messages are deleted; dead messages used up their attempts or failed
+// permanently and are kept for inspection.
+const (
+	QueueStateQueued   = "queued"
+	QueueStateInFlight = "in_flight"
+	QueueStateDead     = "dead"
+)
+
+// DefaultQueueMaxAttempts applies to messages enqueued without MaxAttempts.
+const DefaultQueueMaxAttempts = 5
+
+// EventRunDeadlineExceeded is the outbox event enqueued when a run is
+// cancelled because its deadline passed.
+const EventRunDeadlineExceeded = "workflow.run.deadline_exceeded"
+
+// Step kinds stored in workflow_steps.kind. Task steps run a registered
+// StepHandler; timer steps complete on their own once next_attempt_at passes.
+// Compensation steps undo a completed step of a failed or cancelled run.
+// Child-run steps start a run of another workflow and complete with its
+// outcome; map steps run one item step per element of an array.
+const (
+	StepKindTask         = "task"
+	StepKindTimer        = "timer"
+	StepKindCompensation = "compensation"
+	StepKindChildRun     = "child_run"
+	StepKindMap          = "map"
+	StepKindMapItem      = "map_item"
+)
+
+// Compensation states stored in workflow_runs.compensation.
+const (
+	CompensationRunning   = "running"
+	CompensationCompleted = "completed"
+	CompensationFailed    = "failed"
+)
+
+// Signal actions accepted for waiting steps.
+const (
+	SignalApprove = "approve"
+	SignalReject  = "reject"
+	SignalEdit    = "edit"
+)
+
+// Timeout policies for waiting steps.
+const (
+	TimeoutReject   = "reject"
+	TimeoutEscalate = "escalate"
+)
@@ -13,40 +13,125 @@ type WorkflowRun struct {
 	ID              string          `json:"id"`
 	WorkflowType    string          `json:"workflow_type"`
 	WorkflowVersion string          `json:"workflow_version"`
+	WorkspaceID     string          `json:"workspace_id,omitempty"`
 	Status          string          `json:"status"`
 	Payload         json.RawMessage `json:"payload"`
-	CreatedAt       time.Time       `json:"created_at"`
-	UpdatedAt       time.Time       `json:"updated_at"`
+	// TraceContext is the W3C trace context the run was started in. Steps
+	// executed later, on any worker, continue that trace.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// Compensation is the state of undoing the completed steps of a failed
+	// or cancelled run: empty when nothing was undone, then "running",
+	// "completed" or "failed".
+	Compensation string `json:"compensation,omitempty"`
+	// ParentRunID and ParentStepID link a child run to the step of the run
+	// that started it.
+	ParentRunID  string `json:"parent_run_id,omitempty"`
+	ParentStepID string `json:"parent_step_id,omitempty"`
+	// Priority orders the run's steps at claim time; higher runs first.
+	Priority int `json:"priority"`
+	// PausedAt is set while the run is paused; its steps are not claimed.
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	// Deadline, when set, bounds the whole run: once it passes, the run is
+	// cancelled and a workflow.run.deadline_exceeded event is enqueued.
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// Steps holds the run's persisted steps ordered by seq. It is populated by
+	// StateStore.LoadRun so Plan can decide next steps from prior results.
+	Steps []WorkflowStepRecord `json:"steps,omitempty"`
 }
 
 type WorkflowStepRecord struct {
-	ID             string          `json:"id"`
-	RunID          string          `json:"run_id"`
-	StepName       string          `json:"step_name"`
-	Seq            int             `json:"seq"`
-	Status         string          `json:"status"`
-	Input          json.RawMessage `json:"input"`
+	ID       string `json:"id"`
+	RunID    string `json:"run_id"`
+	StepName string `json:"step_name"`
+	// WorkflowType is the type of the owning run. It is not stored on the
+	// step and only set by StateStore.ClaimNextSteps.
+	WorkflowType string          `json:"workflow_type,omitempty"`
+	Kind         string          `json:"kind,omitempty"`
+	Seq          int             `json:"seq"`
+	Status       string          `json:"status"`
+	Input        json.RawMessage `json:"input"`
+	// InputTemplate is bound into Input each time the step is claimed.
+	InputTemplate  json.RawMessage `json:"input_template,omitempty"`
 	Result         json.RawMessage `json:"result"`
 	Attempts       int             `json:"attempts"`
 	MaxAttempts    int             `json:"max_attempts"`
+	RetryPolicy    *RetryPolicy    `json:"retry_policy,omitempty"`
 	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
 	ClaimedAt      *time.Time      `json:"claimed_at"`
 	LastHeartbeat  *time.Time      `json:"last_heartbeat"`
 	LockOwner      *string         `json:"lock_owner"`
 	IdempotencyKey *string         `json:"idempotency_key"`
 	Error          *string         `json:"error"`
-	CreatedAt      time.Time       `json:"created_at"`
-	UpdatedAt      time.Time       `json:"updated_at"`
+	// Compensation undoes the step if its run later fails or is cancelled.
+	Compensation *Compensation `json:"compensation,omitempty"`
+	// CompensatesID is set on compensation steps to the step they undo.
+	CompensatesID *string `json:"compensates_id,omitempty"`
+	// ParentStepID is set on map items to their map step.
+	ParentStepID *string `json:"parent_step_id,omitempty"`
+	// Priority is the run's priority plus the step's own; pending steps are
+	// claimed highest first.
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Compensation names the handler that undoes a completed step, e.g. deleting
+// the calendar event a booking step created. It runs as a step of its own,
+// with the usual retries, logs and dead-lettering.
+type Compensation struct {
+	StepName string `json:"step_name"`
+	// Input is an input template (see package binding) bound when the
+	// compensation runs. When empty the handler receives the undone step as
+	// {"step": name, "step_id": id, "input": ..., "output": ...}.
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// ChildRunInput is the input of a child-run step.
+type ChildRunInput struct {
+	WorkflowType string          `json:"workflow_type"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+// MapSpec configures a map step.
+type MapSpec struct {
+	// ItemStep names the handler run once per element, with input
+	// {"index": i, "item": element}.
+	ItemStep string `json:"item_step"`
+	// Concurrency caps how many items run at once; zero runs all at once.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Aggregate, when set, names the handler run once every item completed,
+	// with input {"items": [item outputs in order]}; its output becomes the
+	// map step's. Without it the map step outputs {"items": [...]}.
+	Aggregate string `json:"aggregate,omitempty"`
+}
+
+// MapInput is the input of a map step.
+type MapInput struct {
+	MapSpec
+	Items json.RawMessage `json:"items"`
 }
 
 type OutboxEvent struct {
-	ID             string          `json:"id"`
+	ID string `json:"id"`
+	// Seq orders events in the order they were stored; durable
+	// subscriptions track their offset by it.
+	Seq            int64           `json:"seq"`
 	EventType      string          `json:"event_type"`
 	Payload        json.RawMessage `json:"payload"`
 	State          string          `json:"state"`
 	IdempotencyKey *string         `json:"idempotency_key"`
 	Published      bool            `json:"published"`
-	CreatedAt      time.Time       `json:"created_at"`
+	Attempts       int             `json:"attempts"`
+	Error          *string         `json:"error"`
+	// NextAttemptAt delays the next publish attempt of a pending event
+	// after a failed one.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// TraceContext links publishing to the trace of the step that enqueued
+	// the event.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
 }
 
 type StepLog struct {
@@ -57,3 +142,153 @@ type StepLog struct {
 	Meta      json.RawMessage `json:"meta"`
 	CreatedAt time.Time       `json:"created_at"`
 }
+
+// EventAudit is an audit entry of an outbox event, such as a dead-letter
+// replay or discard.
+type EventAudit struct {
+	ID        string          `json:"id"`
+	EventID   string          `json:"event_id"`
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Meta      json.RawMessage `json:"meta"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RunFilter narrows StateStore.ListRuns. Empty fields match everything.
+type RunFilter struct {
+	WorkspaceID  string
+	WorkflowType string
+	Status       string
+	ParentRunID  string
+	Limit        int
+	Offset       int
+}
+
+// WorkflowTrigger starts runs of a workflow on a cron schedule on behalf of a
+// workspace. NextRunAt is nil while the trigger is disabled.
+type WorkflowTrigger struct {
+	ID           string          `json:"id"`
+	WorkspaceID  string          `json:"workspace_id"`
+	WorkflowType string          `json:"workflow_type"`
+	Schedule     string          `json:"schedule"`
+	Timezone     string          `json:"timezone"`
+	Payload      json.RawMessage `json:"payload"`
+	Enabled      bool            `json:"enabled"`
+	NextRunAt    *time.Time      `json:"next_run_at"`
+	LastRunAt    *time.Time      `json:"last_run_at"`
+	LastRunID    *string         `json:"last_run_id"`
+	CreatedBy    string          `json:"created_by,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// WorkflowPause stops the steps of every run of a workflow type from being
+// claimed until it is removed.
+type WorkflowPause struct {
+	WorkflowType string    `json:"workflow_type"`
+	Reason       string    `json:"reason,omitempty"`
+	PausedBy     string    `json:"paused_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PendingCount is the number of pending steps of a workflow type in one
+// state: "ready" to be claimed, "delayed" by a backoff or timer, or
+// "paused" with its run or workflow type.
+type PendingCount struct {
+	WorkflowType string `json:"workflow_type"`
+	State        string `json:"state"`
+	Count        int64  `json:"count"`
+}
+
+// DeadLetterFilter narrows the dead-letter listings. WorkspaceID and
+// WorkflowType apply to steps only; outbox events are not workspace scoped.
+type DeadLetterFilter struct {
+	WorkspaceID  string
+	WorkflowType string
+	Limit        int
+	Offset       int
+}
+
+// WaitSpec describes why a step waits for a signal and what happens when the
+// deadline passes. It is stored as the step result while the step waits.
+type WaitSpec struct {
+	Reason         string     `json:"reason"`
+	TimeoutSeconds int        `json:"timeout_seconds,omitempty"`
+	Deadline       *time.Time `json:"deadline,omitempty"`
+	// OnTimeout is "reject" or "escalate". Escalation notifies EscalateTo
+	// once and restarts the timeout; a second expiry rejects.
+	OnTimeout  string `json:"on_timeout,omitempty"`
+	EscalateTo string `json:"escalate_to,omitempty"`
+	Escalated  bool   `json:"escalated,omitempty"`
+}
+
+// Signal resolves a step waiting for human input.
+type Signal struct {
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Actor   string          `json:"actor,omitempty"`
+}
+
+// RetryPolicy controls how a failed step is rescheduled. It is persisted with
+// the step so retries survive restarts.
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"max_attempts,omitempty"`
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `json:"max_backoff,omitempty"`
+	// Jitter adds up to this fraction of the backoff at random (0.2 = +20%).
+	Jitter float64 `json:"jitter,omitempty"`
+	// RetryableClasses limits retries to these error classes; empty allows
+	// every retryable error.
+	RetryableClasses []string `json:"retryable_classes,omitempty"`
+}
+
+// EventSubscription is a durable named subscription to the events whose
+// type matches Pattern. AckedSeq is the Seq of the last event it
+// acknowledged; delivery resumes after it. One owner at a time holds the
+// subscription's lease and delivers its events.
+type EventSubscription struct {
+	Name        string     `json:"name"`
+	Pattern     string     `json:"pattern"`
+	AckedSeq    int64      `json:"acked_seq"`
+	LeaseOwner  string     `json:"lease_owner,omitempty"`
+	LeasedUntil *time.Time `json:"leased_until,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// QueueMessage is a job on a named queue, for background work that does not
+// fit the step model. A dequeued message stays hidden until VisibleAt; if it
+// is neither acknowledged nor extended by then it is delivered again.
+type QueueMessage struct {
+	ID          string `json:"id"`
+	Queue       string `json:"queue"`
+	Payload     []byte `json:"payload"`
+	State       string `json:"state"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	// Receipt identifies the delivery that leased the message. Ack, Nack and
+	// Extend only apply while it is the message's current delivery.
+	Receipt   string    `json:"receipt,omitempty"`
+	VisibleAt time.Time `json:"visible_at"`
+	LastError *string   `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EnqueueOptions tunes Queue.Enqueue. Zero values deliver right away with
+// the default attempt limit.
+type EnqueueOptions struct {
+	Delay       time.Duration
+	MaxAttempts int
+}
+
+// QueueStats counts the messages of one queue by state. Delayed messages
+// are queued but not yet visible; OldestReadyAt tells how long the oldest
+// ready message has been waiting.
+type QueueStats struct {
+	Queue         string     `json:"queue"`
+	Ready         int64      `json:"ready"`
+	Delayed       int64      `json:"delayed"`
+	InFlight      int64      `json:"in_flight"`
+	Dead          int64      `json:"dead"`
+	OldestReadyAt *time.Time `json:"oldest_ready_at,omitempty"`
+}
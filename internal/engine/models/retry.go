@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaultInitialBackoff is used when a policy leaves InitialBackoff unset.
+const defaultInitialBackoff = 5 * time.Second
+
+// Allows reports whether errors of class may be retried under p. An empty
+// RetryableClasses list allows every retryable class.
+func (p RetryPolicy) Allows(class string) bool {
+	if len(p.RetryableClasses) == 0 {
+		return true
+	}
+	for _, c := range p.RetryableClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before retry number attempt (1-based):
+// InitialBackoff * 2^(attempt-1), capped at MaxBackoff, plus up to Jitter of
+// that delay chosen at random.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = defaultInitialBackoff
+	}
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
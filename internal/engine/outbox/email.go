@@ -0,0 +1,45 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// EventEmailSend is the event type of emails sent through the outbox.
+const EventEmailSend = "email_send"
+
+// Mailer sends email; *smtp.Client implements it.
+type Mailer interface {
+	Send(to, subject, body string) error
+	SendHTML(to, subject, htmlBody, textBody string) error
+}
+
+// EmailPayload is the payload of an email_send event.
+type EmailPayload struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// EmailPublisher sends email_send events through m. Events that are not a
+// valid email are dead-lettered; SMTP failures are retried.
+func EmailPublisher(m Mailer) Publisher {
+	return PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error {
+		var p EmailPayload
+		if err := json.Unmarshal(ev.Payload, &p); err != nil {
+			return engine.Permanent(fmt.Errorf("decode email: %w", err))
+		}
+		if p.To == "" {
+			return engine.Permanent(errors.New("email has no recipient"))
+		}
+		if p.HTML != "" {
+			return m.SendHTML(p.To, p.Subject, p.HTML, p.Body)
+		}
+		return m.Send(p.To, p.Subject, p.Body)
+	})
+}
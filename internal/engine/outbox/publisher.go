@@ -3,100 +3,240 @@ package outbox
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/logger"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
-	"github.com/alpinesboltltd/boltz-ai/internal/provider/smtp"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/gorm"
 )
 
-// Publisher publishes outbox events reliably. For now supports `email_send` events.
-func StartPublisher(ctx context.Context, db *gorm.DB, smtpClient *smtp.Client, batchSize int, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+// DefaultBatchSize is how many events are claimed at a time.
+const DefaultBatchSize = 50
+
+// DefaultPollInterval is how often the outbox is polled for due events.
+const DefaultPollInterval = time.Second
+
+// DefaultInFlightTimeout is how long an event may stay in_flight before it
+// is considered abandoned by a publisher that crashed.
+const DefaultInFlightTimeout = 5 * time.Minute
+
+type Options struct {
+	// BatchSize is how many events are claimed at a time. Zero means
+	// DefaultBatchSize.
+	BatchSize int
+	// PollInterval is how often the outbox is polled. Zero means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+	// Retry sets the backoff between attempts and the number of attempts
+	// before an event is dead-lettered. Zero means engine.DefaultRetryPolicy.
+	Retry engine.RetryPolicy
+	// InFlightTimeout is how long an event may stay in_flight before it is
+	// returned to pending, counting the attempt. Zero means
+	// DefaultInFlightTimeout; it must exceed the time a publisher takes.
+	InFlightTimeout time.Duration
+	// Logger receives publish outcomes. Nil logs JSON to stdout.
+	Logger engine.Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultPollInterval
+	}
+	if o.Retry.InitialBackoff <= 0 {
+		o.Retry = engine.DefaultRetryPolicy
+	}
+	if o.Retry.MaxAttempts <= 0 {
+		o.Retry.MaxAttempts = engine.DefaultRetryPolicy.MaxAttempts
+	}
+	if o.InFlightTimeout <= 0 {
+		o.InFlightTimeout = DefaultInFlightTimeout
+	}
+	if o.Logger == nil {
+		o.Logger = logger.New(nil, nil)
+	}
+	return o
+}
+
+// StartPublisher publishes due outbox events with the publishers in reg
+// until ctx is cancelled. Delivery is at least once: an event whose
+// publisher crashed mid-way is published again. The returned channel is
+// closed once the batch being published has finished.
+func StartPublisher(ctx context.Context, db *gorm.DB, reg *Registry, opts Options) <-chan struct{} {
+	opts = opts.withDefaults()
+	done := make(chan struct{})
+	ticker := time.NewTicker(opts.PollInterval)
 	go func() {
+		defer close(done)
 		defer ticker.Stop()
 		for {
+			// keep going while full batches are claimed
+			for {
+				n, err := PublishBatch(ctx, db, reg, opts)
+				if err != nil && ctx.Err() == nil {
+					opts.Logger.Error("outbox: publish batch failed", engine.Err(err))
+				}
+				if err != nil || n < opts.BatchSize || ctx.Err() != nil {
+					break
+				}
+			}
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				processBatch(ctx, db, smtpClient, batchSize)
 			}
 		}
 	}()
+	return done
 }
 
-func processBatch(ctx context.Context, db *gorm.DB, smtpClient *smtp.Client, batchSize int) {
-	// Claim batch
+const recoverQuery = `
+UPDATE outbox_events SET
+	state = CASE WHEN attempts + 1 >= @max_attempts THEN 'failed' ELSE 'pending' END,
+	attempts = attempts + 1,
+	error = 'publisher stopped while publishing the event',
+	claimed_at = NULL
+WHERE state = 'in_flight' AND (claimed_at IS NULL OR claimed_at < now() - make_interval(secs => @timeout))`
+
+const claimQuery = `
+UPDATE outbox_events SET state = 'in_flight', claimed_at = now()
+WHERE id IN (
+	SELECT id FROM outbox_events
+	WHERE state = 'pending' AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+	ORDER BY created_at
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// PublishBatch returns abandoned in_flight events to pending, then claims
+// up to opts.BatchSize due events and publishes them one after another. It
+// returns the number of events claimed. Tests use it to drive the outbox
+// deterministically.
+func PublishBatch(ctx context.Context, db *gorm.DB, reg *Registry, opts Options) (int, error) {
+	opts = opts.withDefaults()
+	res := db.WithContext(ctx).Exec(recoverQuery, map[string]interface{}{
+		"max_attempts": opts.Retry.MaxAttempts, "timeout": opts.InFlightTimeout.Seconds(),
+	})
+	if res.Error != nil {
+		return 0, fmt.Errorf("recover in-flight events: %w", res.Error)
+	}
+	if res.RowsAffected > 0 {
+		metrics.OutboxRecovered.Add(float64(res.RowsAffected))
+		opts.Logger.Warn("outbox: recovered events abandoned in flight", engine.F("count", res.RowsAffected))
+	}
+
 	var rows []entity.OutboxEvent
-	tx := db.WithContext(ctx).Begin()
-	if tx.Error != nil {
-		log.Printf("outbox: begin tx error: %v", tx.Error)
-		return
+	if err := db.WithContext(ctx).Raw(claimQuery, map[string]interface{}{"limit": opts.BatchSize}).Scan(&rows).Error; err != nil {
+		return 0, fmt.Errorf("claim events: %w", err)
 	}
-	// Select pending events with FOR UPDATE SKIP LOCKED
-	if err := tx.Raw(`SELECT * FROM outbox_events WHERE state = 'pending' ORDER BY created_at LIMIT ? FOR UPDATE SKIP LOCKED`, batchSize).Scan(&rows).Error; err != nil {
-		tx.Rollback()
-		log.Printf("outbox: select pending error: %v", err)
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+	// claimed events are finished even when ctx is cancelled meanwhile
+	pubCtx := context.WithoutCancel(ctx)
+	for i := range rows {
+		publishEvent(pubCtx, db, reg, opts, &rows[i])
+	}
+	return len(rows), nil
+}
+
+// publishEvent delivers one claimed event inside a span that continues the
+// trace of the step that enqueued it, then records the outcome.
+func publishEvent(ctx context.Context, db *gorm.DB, reg *Registry, opts Options, row *entity.OutboxEvent) {
+	lg := opts.Logger.With(engine.F("event_id", row.ID), engine.F("event_type", row.EventType))
+	p, ok := reg.Lookup(row.EventType)
+	if !ok && reg.Ignores(row.EventType) {
+		// events nobody publishes, such as lifecycle events only read by
+		// dispatcher subscriptions, are settled without delivery
+		if err := settle(ctx, db, row, `state = 'published', published = true`, nil); err != nil {
+			lg.Error("outbox: mark published failed", engine.Err(err))
+		}
 		return
 	}
-	if len(rows) == 0 {
-		tx.Rollback()
+	if !ok {
+		// a mistyped event type or a webhook type with no endpoint; kept
+		// in the dead-letter queue until replayed or discarded
+		metrics.OutboxFailures.WithLabelValues(row.EventType).Inc()
+		lg.Error("outbox: no publisher for event type")
+		if err := settle(ctx, db, row, `state = 'failed', attempts = attempts + 1, error = @error`,
+			map[string]interface{}{"error": fmt.Sprintf("no publisher for event type %q", row.EventType)}); err != nil {
+			lg.Error("outbox: dead-letter event failed", engine.Err(err))
+		}
 		return
 	}
-	ids := make([]string, 0, len(rows))
-	for _, r := range rows {
-		ids = append(ids, r.ID)
+
+	var carrier map[string]string
+	_ = json.Unmarshal(row.TraceContext, &carrier)
+	ctx, span := tracing.Start(tracing.Extract(ctx, carrier), "outbox.publish",
+		attribute.String("outbox.event_id", row.ID), attribute.String("outbox.event_type", row.EventType))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	ev := &engine.OutboxEvent{
+		ID: row.ID, Seq: row.Seq, EventType: row.EventType, Payload: row.Payload, State: row.State,
+		IdempotencyKey: row.IdempotencyKey, Attempts: row.Attempts, Error: row.Error, CreatedAt: row.CreatedAt,
 	}
-	// mark in_flight
-	if err := tx.Exec(`UPDATE outbox_events SET state='in_flight' WHERE id IN (?)`, ids).Error; err != nil {
-		tx.Rollback()
-		log.Printf("outbox: mark in_flight error: %v", err)
+	start := time.Now()
+	err = call(ctx, p, ev)
+	metrics.OutboxPublishDuration.WithLabelValues(row.EventType).Observe(time.Since(start).Seconds())
+	if err == nil {
+		if serr := settle(ctx, db, row, `state = 'published', published = true, error = NULL`, nil); serr != nil {
+			lg.Error("outbox: mark published failed", engine.Err(serr))
+			return
+		}
+		metrics.OutboxPublished.WithLabelValues(row.EventType).Inc()
+		metrics.OutboxLag.WithLabelValues(row.EventType).Observe(time.Since(row.CreatedAt).Seconds())
+		lg.Info("outbox: event published", engine.F("attempt", row.Attempts+1))
 		return
 	}
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("outbox: commit claim error: %v", err)
+
+	attempts := row.Attempts + 1
+	class, retryable := engine.ClassifyError(err)
+	if !retryable || !opts.Retry.Allows(class) || attempts >= opts.Retry.MaxAttempts {
+		// failed events stay in the outbox dead-letter queue until replayed or discarded
+		metrics.OutboxFailures.WithLabelValues(row.EventType).Inc()
+		lg.Error("outbox: publish failed", engine.Err(err), engine.F("attempt", attempts))
+		if serr := settle(ctx, db, row, `state = 'failed', attempts = attempts + 1, error = @error`,
+			map[string]interface{}{"error": err.Error()}); serr != nil {
+			lg.Error("outbox: dead-letter event failed", engine.Err(serr))
+		}
 		return
 	}
+	backoff := opts.Retry.Backoff(attempts)
+	metrics.OutboxRetries.WithLabelValues(row.EventType).Inc()
+	lg.Warn("outbox: publish retry scheduled", engine.Err(err), engine.F("attempt", attempts),
+		engine.F("max_attempts", opts.Retry.MaxAttempts), engine.F("backoff", backoff))
+	if serr := settle(ctx, db, row,
+		`state = 'pending', attempts = attempts + 1, error = @error, next_attempt_at = now() + make_interval(secs => @backoff)`,
+		map[string]interface{}{"error": err.Error(), "backoff": backoff.Seconds()}); serr != nil {
+		lg.Error("outbox: reschedule failed", engine.Err(serr))
+	}
+}
 
-	// process each event outside transaction
-	for _, ev := range rows {
-		switch ev.EventType {
-		case "email_send":
-			var payload struct {
-				To      string `json:"to"`
-				Subject string `json:"subject"`
-				Body    string `json:"body"`
-				HTML    string `json:"html,omitempty"`
-			}
-			if err := json.Unmarshal(ev.Payload, &payload); err != nil {
-				markFailed(db, ev.ID, err)
-				continue
-			}
-			var err error
-			if payload.HTML != "" {
-				err = smtpClient.SendHTML(payload.To, payload.Subject, payload.HTML, payload.Body)
-			} else {
-				err = smtpClient.Send(payload.To, payload.Subject, payload.Body)
-			}
-			if err != nil {
-				markFailed(db, ev.ID, err)
-				continue
-			}
-			if err := db.WithContext(ctx).Exec(`UPDATE outbox_events SET state='published', published = true WHERE id = ?`, ev.ID).Error; err != nil {
-				log.Printf("outbox: mark published error: %v", err)
-			}
-		default:
-			// unknown event types: mark published to avoid loops
-			if err := db.WithContext(ctx).Exec(`UPDATE outbox_events SET state='published', published = true WHERE id = ?`, ev.ID).Error; err != nil {
-				log.Printf("outbox: mark published unknown type error: %v", err)
-			}
-		}
+// settle applies set, with the named params it refers to, to a claimed
+// event unless it was recovered and claimed again in the meantime.
+func settle(ctx context.Context, db *gorm.DB, row *entity.OutboxEvent, set string, params map[string]interface{}) error {
+	if params == nil {
+		params = map[string]interface{}{}
 	}
+	params["id"], params["claimed_at"] = row.ID, row.ClaimedAt
+	return db.WithContext(ctx).Exec(`UPDATE outbox_events SET `+set+`, claimed_at = NULL
+		WHERE id = @id AND state = 'in_flight' AND claimed_at = @claimed_at`, params).Error
 }
 
-func markFailed(db *gorm.DB, id string, err error) {
-	log.Printf("outbox: publish failed for %s: %v", id, err)
-	_ = db.Exec(`UPDATE outbox_events SET state='failed' WHERE id = ?`, id).Error
+// call runs p, turning a panic into a retryable error.
+func call(ctx context.Context, p Publisher, ev *engine.OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("publisher panic: %v", r)
+		}
+	}()
+	return p.Publish(ctx, ev)
 }
@@ -0,0 +1,155 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// These tests need a disposable Postgres database. They run only when
+// ENGINE_TEST_DATABASE_URL is set, like the store tests, in a schema of
+// their own so they never publish other tests' events.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("ENGINE_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("Skipping outbox publisher tests. Set ENGINE_TEST_DATABASE_URL to enable.")
+	}
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := "outbox_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse ENGINE_TEST_DATABASE_URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := gorm.Open(postgres.Open(u.String()), cfg)
+	if err != nil {
+		t.Fatalf("connect to schema: %v", err)
+	}
+	if err := db.AutoMigrate(&entity.OutboxEvent{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func enqueue(t *testing.T, db *gorm.DB, eventType string) *entity.OutboxEvent {
+	t.Helper()
+	ev := &entity.OutboxEvent{ID: uuid.NewString(), EventType: eventType, Payload: []byte(`{}`), State: engine.OutboxStatePending}
+	if err := db.Create(ev).Error; err != nil {
+		t.Fatalf("enqueue %s: %v", eventType, err)
+	}
+	return ev
+}
+
+func reload(t *testing.T, db *gorm.DB, id string) *entity.OutboxEvent {
+	t.Helper()
+	var ev entity.OutboxEvent
+	if err := db.First(&ev, "id = ?", id).Error; err != nil {
+		t.Fatalf("load event: %v", err)
+	}
+	return &ev
+}
+
+func publish(t *testing.T, db *gorm.DB, reg *Registry, opts Options) int {
+	t.Helper()
+	n, err := PublishBatch(context.Background(), db, reg, opts)
+	if err != nil {
+		t.Fatalf("publish batch: %v", err)
+	}
+	return n
+}
+
+func TestPublishBatchRetriesThenDeadLetters(t *testing.T) {
+	db := testDB(t)
+	calls := 0
+	reg := NewRegistry()
+	reg.Register("flaky", PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error {
+		calls++
+		return errors.New("smtp unavailable")
+	}))
+	reg.Register("invalid", PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error {
+		return engine.Permanent(errors.New("no recipient"))
+	}))
+	reg.Ignore("run.*")
+	opts := Options{Retry: engine.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour}}
+	flaky, invalid := enqueue(t, db, "flaky"), enqueue(t, db, "invalid")
+	ignored, unknown := enqueue(t, db, "run.started"), enqueue(t, db, "emial_send")
+
+	if n := publish(t, db, reg, opts); n != 4 {
+		t.Fatalf("claimed %d events, want 4", n)
+	}
+	got := reload(t, db, flaky.ID)
+	if got.State != engine.OutboxStatePending || got.Attempts != 1 || got.NextAttemptAt == nil || got.Error == nil {
+		t.Fatalf("flaky event after one failure = %+v", got)
+	}
+	if got := reload(t, db, invalid.ID); got.State != engine.OutboxStateFailed || got.Attempts != 1 {
+		t.Fatalf("invalid event = %+v, want dead-lettered at once", got)
+	}
+	if got := reload(t, db, ignored.ID); got.State != engine.OutboxStatePublished {
+		t.Fatalf("ignored event = %+v, want settled", got)
+	}
+	if got := reload(t, db, unknown.ID); got.State != engine.OutboxStateFailed || got.Error == nil {
+		t.Fatalf("event without a publisher = %+v, want dead-lettered", got)
+	}
+
+	// nothing is due until the backoff has passed
+	if n := publish(t, db, reg, opts); n != 0 {
+		t.Fatalf("claimed %d events during the backoff", n)
+	}
+	if err := db.Exec(`UPDATE outbox_events SET next_attempt_at = now() WHERE id = ?`, flaky.ID).Error; err != nil {
+		t.Fatalf("expire backoff: %v", err)
+	}
+	publish(t, db, reg, opts)
+	if got := reload(t, db, flaky.ID); got.State != engine.OutboxStateFailed || got.Attempts != 2 || calls != 2 {
+		t.Fatalf("flaky event after %d calls = %+v, want dead-lettered after 2 attempts", calls, got)
+	}
+}
+
+func TestPublishBatchRecoversAbandonedEvents(t *testing.T) {
+	db := testDB(t)
+	published := 0
+	reg := NewRegistry()
+	reg.Register(EventEmailSend, PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error {
+		published++
+		return nil
+	}))
+	stale, recent := enqueue(t, db, EventEmailSend), enqueue(t, db, EventEmailSend)
+	if err := db.Exec(`UPDATE outbox_events SET state = 'in_flight', claimed_at = now() - interval '1 hour' WHERE id = ?`, stale.ID).Error; err != nil {
+		t.Fatalf("abandon event: %v", err)
+	}
+	if err := db.Exec(`UPDATE outbox_events SET state = 'in_flight', claimed_at = now() WHERE id = ?`, recent.ID).Error; err != nil {
+		t.Fatalf("claim event: %v", err)
+	}
+
+	if n := publish(t, db, reg, Options{InFlightTimeout: time.Minute}); n != 1 {
+		t.Fatalf("claimed %d events, want the abandoned one", n)
+	}
+	if got := reload(t, db, stale.ID); got.State != engine.OutboxStatePublished || got.Attempts != 1 || published != 1 {
+		t.Fatalf("abandoned event = %+v", got)
+	}
+	if got := reload(t, db, recent.ID); got.State != engine.OutboxStateInFlight {
+		t.Fatalf("event still being published = %+v", got)
+	}
+}
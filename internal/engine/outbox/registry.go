@@ -0,0 +1,100 @@
+package outbox
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// Publisher delivers an outbox event outside the service. Returning nil
+// marks the event published; an error marked with engine.Permanent
+// dead-letters it right away, and any other error retries it after a
+// backoff.
+type Publisher interface {
+	Publish(ctx context.Context, ev *engine.OutboxEvent) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface.
+type PublisherFunc func(ctx context.Context, ev *engine.OutboxEvent) error
+
+func (f PublisherFunc) Publish(ctx context.Context, ev *engine.OutboxEvent) error { return f(ctx, ev) }
+
+type patternPublisher struct {
+	pattern string
+	p       Publisher
+}
+
+// Registry maps event types to the publisher delivering them. Adding an
+// event type never requires touching the publisher loop.
+type Registry struct {
+	mu       sync.RWMutex
+	exact    map[string]Publisher
+	patterns []patternPublisher
+	ignored  []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{exact: make(map[string]Publisher)}
+}
+
+// Register adds p for eventType, which may also be a topic pattern such as
+// "run.*" (see engine.MatchTopic). Registering a type again replaces its
+// publisher.
+func (r *Registry) Register(eventType string, p Publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !isPattern(eventType) {
+		r.exact[eventType] = p
+		return
+	}
+	for i := range r.patterns {
+		if r.patterns[i].pattern == eventType {
+			r.patterns[i].p = p
+			return
+		}
+	}
+	r.patterns = append(r.patterns, patternPublisher{pattern: eventType, p: p})
+}
+
+// Lookup returns the publisher for eventType: the one registered for the
+// type itself, or else the first matching pattern registered.
+func (r *Registry) Lookup(eventType string) (Publisher, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, ok := r.exact[eventType]; ok {
+		return p, true
+	}
+	for _, pp := range r.patterns {
+		if engine.MatchTopic(pp.pattern, eventType) {
+			return pp.p, true
+		}
+	}
+	return nil, false
+}
+
+// Ignore lets events matching pattern that no publisher is registered for
+// be settled without delivery, e.g. lifecycle events only read by
+// dispatcher subscriptions. Other events without a publisher fail.
+func (r *Registry) Ignore(patterns ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ignored = append(r.ignored, patterns...)
+}
+
+// Ignores reports whether eventType matches a pattern passed to Ignore.
+func (r *Registry) Ignores(eventType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, pattern := range r.ignored {
+		if engine.MatchTopic(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPattern(eventType string) bool {
+	return eventType == "" || strings.ContainsAny(eventType, "*>")
+}
@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// Headers of webhook requests. HeaderSignature carries "t=<unix
+// seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed with the endpoint
+// secret>".
+const (
+	HeaderEvent     = "X-Boltz-Event"
+	HeaderEventID   = "X-Boltz-Event-Id"
+	HeaderSignature = "X-Boltz-Signature"
+)
+
+// DefaultWebhookTimeout bounds each webhook request when no client is given.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// WebhookEndpoint receives the events whose type matches one of Events,
+// which may be topic patterns such as "run.*".
+type WebhookEndpoint struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// WebhookBody is the JSON body posted to webhook endpoints.
+type WebhookBody struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ParseWebhookEndpoints parses a JSON array of endpoints, e.g.
+// `[{"url":"https://example.com/hooks","secret":"s3cret","events":["run.*"]}]`.
+// An empty string means no endpoints.
+func ParseWebhookEndpoints(s string) ([]WebhookEndpoint, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var endpoints []WebhookEndpoint
+	if err := json.Unmarshal([]byte(s), &endpoints); err != nil {
+		return nil, fmt.Errorf("webhooks: %w", err)
+	}
+	for _, ep := range endpoints {
+		switch {
+		case !strings.HasPrefix(ep.URL, "http://") && !strings.HasPrefix(ep.URL, "https://"):
+			return nil, fmt.Errorf("webhooks: invalid url %q", ep.URL)
+		case ep.Secret == "":
+			return nil, fmt.Errorf("webhooks: %s has no secret", ep.URL)
+		case len(ep.Events) == 0:
+			return nil, fmt.Errorf("webhooks: %s has no events", ep.URL)
+		}
+	}
+	return endpoints, nil
+}
+
+// WebhookPublisher posts events to HTTP endpoints, signed with each
+// endpoint's secret. An event goes to every endpoint subscribed to its type
+// and is retried as a whole when any of them fails, so endpoints must
+// deduplicate by HeaderEventID.
+type WebhookPublisher struct {
+	endpoints []WebhookEndpoint
+	client    *http.Client
+}
+
+// NewWebhookPublisher returns a publisher for endpoints. A nil client uses
+// one with DefaultWebhookTimeout.
+func NewWebhookPublisher(endpoints []WebhookEndpoint, client *http.Client) *WebhookPublisher {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	return &WebhookPublisher{endpoints: endpoints, client: client}
+}
+
+// Register registers w in reg for the events of every endpoint. Event types
+// with a publisher of their own, such as email_send, are not posted.
+func (w *WebhookPublisher) Register(reg *Registry) {
+	for _, ep := range w.endpoints {
+		for _, pattern := range ep.Events {
+			reg.Register(pattern, w)
+		}
+	}
+}
+
+func (w *WebhookPublisher) Publish(ctx context.Context, ev *engine.OutboxEvent) error {
+	body, err := json.Marshal(WebhookBody{ID: ev.ID, Type: ev.EventType, CreatedAt: ev.CreatedAt, Data: ev.Payload})
+	if err != nil {
+		return engine.Permanent(err)
+	}
+	var retry, permanent []error
+	for _, ep := range w.endpoints {
+		if !subscribed(ep, ev.EventType) {
+			continue
+		}
+		if err := w.post(ctx, ep, ev, body); err != nil {
+			if _, retryable := engine.ClassifyError(err); retryable {
+				retry = append(retry, err)
+			} else {
+				permanent = append(permanent, err)
+			}
+		}
+	}
+	switch {
+	case len(retry) > 0:
+		return errors.Join(append(retry, permanent...)...)
+	case len(permanent) > 0:
+		return engine.Permanent(errors.Join(permanent...))
+	}
+	return nil
+}
+
+func subscribed(ep WebhookEndpoint, eventType string) bool {
+	for _, pattern := range ep.Events {
+		if engine.MatchTopic(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// post delivers body to ep. Client errors other than 408 and 429 are
+// permanent: sending the same request again will not help.
+func (w *WebhookPublisher) post(ctx context.Context, ep WebhookEndpoint, ev *engine.OutboxEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return engine.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, ev.EventType)
+	req.Header.Set(HeaderEventID, ev.ID)
+	req.Header.Set(HeaderSignature, Sign(ep.Secret, time.Now(), body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return engine.Retryable(engine.ErrorClassTransient, fmt.Errorf("webhook %s: %w", ep.URL, err))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return engine.Retryable(engine.ErrorClassRateLimit, fmt.Errorf("webhook %s: %s", ep.URL, resp.Status))
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout:
+		return engine.Permanent(fmt.Errorf("webhook %s: %s", ep.URL, resp.Status))
+	}
+	return engine.Retryable(engine.ErrorClassTransient, fmt.Errorf("webhook %s: %s", ep.URL, resp.Status))
+}
+
+// Sign returns the HeaderSignature value for body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrInvalidSignature is returned by VerifySignature.
+var ErrInvalidSignature = errors.New("webhook: invalid signature")
+
+// VerifySignature checks a HeaderSignature value against body and secret,
+// rejecting signatures older than tolerance (zero disables the check).
+// Receivers written in Go can use it as is.
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if tolerance > 0 && time.Since(time.Unix(unix, 0)) > tolerance {
+		return fmt.Errorf("%w: too old", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+func TestRegistryPrefersExactTypes(t *testing.T) {
+	reg := NewRegistry()
+	named := func(name string) Publisher {
+		return PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error { return errors.New(name) })
+	}
+	reg.Register(">", named("all"))
+	reg.Register("run.*", named("runs"))
+	reg.Register("run.failed", named("failures"))
+
+	for eventType, want := range map[string]string{"run.failed": "failures", "run.started": "all", "email_send": "all"} {
+		p, ok := reg.Lookup(eventType)
+		if !ok {
+			t.Fatalf("no publisher for %s", eventType)
+		}
+		if err := p.Publish(context.Background(), &engine.OutboxEvent{}); err.Error() != want {
+			t.Errorf("publisher for %s = %s, want %s", eventType, err, want)
+		}
+	}
+	if _, ok := NewRegistry().Lookup("run.started"); ok {
+		t.Fatal("empty registry has a publisher")
+	}
+
+	// ignored topics still go to the publishers registered for them
+	reg.Ignore("run.*", "step.*")
+	if _, ok := reg.Lookup("run.started"); !ok || !reg.Ignores("step.failed") || reg.Ignores("email_send") {
+		t.Fatal("Ignore changed lookups or ignores other topics")
+	}
+}
+
+func TestWebhookPublisherSignsPayloads(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan request, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- request{r.Header, body}
+	}))
+	defer srv.Close()
+
+	endpoints, err := ParseWebhookEndpoints(`[
+		{"url": "` + srv.URL + `/runs", "secret": "runs-secret", "events": ["run.*"]},
+		{"url": "` + srv.URL + `/email", "secret": "email-secret", "events": ["email_send"]}
+	]`)
+	if err != nil {
+		t.Fatalf("parse endpoints: %v", err)
+	}
+	reg := NewRegistry()
+	NewWebhookPublisher(endpoints, nil).Register(reg)
+	p, ok := reg.Lookup(engine.EventRunFailed)
+	if !ok {
+		t.Fatal("webhook not registered for run events")
+	}
+	ev := &engine.OutboxEvent{ID: "ev-1", EventType: engine.EventRunFailed, Payload: []byte(`{"run_id":"r-1"}`), CreatedAt: time.Now()}
+	if err := p.Publish(context.Background(), ev); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	req := <-got
+	if req.header.Get(HeaderEvent) != engine.EventRunFailed || req.header.Get(HeaderEventID) != "ev-1" {
+		t.Fatalf("headers = %v", req.header)
+	}
+	if err := VerifySignature("runs-secret", req.header.Get(HeaderSignature), req.body, time.Minute); err != nil {
+		t.Fatalf("verify with the endpoint secret: %v", err)
+	}
+	if err := VerifySignature("email-secret", req.header.Get(HeaderSignature), req.body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("verify with another secret = %v", err)
+	}
+	var body WebhookBody
+	if err := json.Unmarshal(req.body, &body); err != nil || body.ID != "ev-1" || string(body.Data) != `{"run_id":"r-1"}` {
+		t.Fatalf("body = %s, %v", req.body, err)
+	}
+	if len(got) != 0 {
+		t.Fatal("event posted to an endpoint not subscribed to it")
+	}
+}
+
+func TestWebhookPublisherClassifiesFailures(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) }))
+	defer srv.Close()
+	w := NewWebhookPublisher([]WebhookEndpoint{{URL: srv.URL, Secret: "s", Events: []string{">"}}}, nil)
+	ev := &engine.OutboxEvent{ID: "ev-1", EventType: engine.EventRunStarted, Payload: []byte(`{}`)}
+
+	for _, tc := range []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusRequestTimeout, true},
+		{http.StatusBadRequest, false},
+		{http.StatusGone, false},
+	} {
+		status = tc.status
+		err := w.Publish(context.Background(), ev)
+		if err == nil {
+			t.Fatalf("status %d: no error", tc.status)
+		}
+		if _, retryable := engine.ClassifyError(err); retryable != tc.retryable {
+			t.Errorf("status %d: retryable = %v, want %v", tc.status, retryable, tc.retryable)
+		}
+	}
+}
+
+func TestParseWebhookEndpoints(t *testing.T) {
+	if eps, err := ParseWebhookEndpoints(" "); err != nil || eps != nil {
+		t.Fatalf("empty = %v, %v", eps, err)
+	}
+	for _, bad := range []string{
+		`{"url": "https://example.com"}`,
+		`[{"url": "ftp://example.com", "secret": "s", "events": ["run.*"]}]`,
+		`[{"url": "https://example.com", "events": ["run.*"]}]`,
+		`[{"url": "https://example.com", "secret": "s"}]`,
+	} {
+		if _, err := ParseWebhookEndpoints(bad); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
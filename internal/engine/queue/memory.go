@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// MemoryQueue is an engine.Queue held in memory with the same delivery
+// semantics as PostgresQueue, timed by the engine clock. It is meant for
+// tests and for running without a database; messages do not survive a
+// restart.
+type MemoryQueue struct {
+	mu   sync.Mutex
+	msgs map[string]*engine.QueueMessage
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{msgs: map[string]*engine.QueueMessage{}}
+}
+
+func copyMessage(m *engine.QueueMessage) *engine.QueueMessage {
+	c := *m
+	c.Payload = append([]byte(nil), m.Payload...)
+	if m.LastError != nil {
+		e := *m.LastError
+		c.LastError = &e
+	}
+	return &c
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, qname string, payload []byte, opts engine.EnqueueOptions) (*engine.QueueMessage, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = engine.DefaultQueueMaxAttempts
+	}
+	now := engine.Now()
+	msg := &engine.QueueMessage{
+		ID: uuid.NewString(), Queue: qname, Payload: append([]byte(nil), payload...),
+		State: engine.QueueStateQueued, MaxAttempts: opts.MaxAttempts,
+		VisibleAt: now.Add(opts.Delay), CreatedAt: now,
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.msgs[msg.ID] = msg
+	return copyMessage(msg), nil
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context, qname string, n int, visibility time.Duration) ([]*engine.QueueMessage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := engine.Now()
+	var due []*engine.QueueMessage
+	for _, m := range q.msgs {
+		if m.Queue != qname || m.State == engine.QueueStateDead || m.VisibleAt.After(now) {
+			continue
+		}
+		if m.Attempts >= m.MaxAttempts {
+			// only in-flight messages get here: their lease lapsed after
+			// the last attempt
+			msg := "visibility timeout"
+			if m.LastError == nil {
+				m.LastError = &msg
+			}
+			m.State, m.Receipt = engine.QueueStateDead, ""
+			continue
+		}
+		due = append(due, m)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].VisibleAt.Equal(due[j].VisibleAt) {
+			return due[i].VisibleAt.Before(due[j].VisibleAt)
+		}
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	if len(due) > n {
+		due = due[:n]
+	}
+	receipt := uuid.NewString()
+	out := make([]*engine.QueueMessage, 0, len(due))
+	for _, m := range due {
+		m.State, m.Receipt, m.VisibleAt = engine.QueueStateInFlight, receipt, now.Add(visibility)
+		m.Attempts++
+		out = append(out, copyMessage(m))
+	}
+	return out, nil
+}
+
+// leased returns msg's stored copy while msg is its current delivery. The
+// caller must hold q.mu.
+func (q *MemoryQueue) leased(msg *engine.QueueMessage) *engine.QueueMessage {
+	m, ok := q.msgs[msg.ID]
+	if !ok || m.State != engine.QueueStateInFlight || m.Receipt != msg.Receipt {
+		return nil
+	}
+	return m
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, msg *engine.QueueMessage) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.leased(msg) == nil {
+		return false, nil
+	}
+	delete(q.msgs, msg.ID)
+	return true, nil
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, msg *engine.QueueMessage, delay time.Duration, reason string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m := q.leased(msg)
+	if m == nil {
+		return false, nil
+	}
+	m.State = engine.QueueStateQueued
+	if m.Attempts >= m.MaxAttempts {
+		m.State = engine.QueueStateDead
+	}
+	m.Receipt, m.VisibleAt, m.LastError = "", engine.Now().Add(delay), &reason
+	return true, nil
+}
+
+func (q *MemoryQueue) DeadLetter(ctx context.Context, msg *engine.QueueMessage, reason string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m := q.leased(msg)
+	if m == nil {
+		return false, nil
+	}
+	m.State, m.Receipt, m.LastError = engine.QueueStateDead, "", &reason
+	return true, nil
+}
+
+func (q *MemoryQueue) Extend(ctx context.Context, msg *engine.QueueMessage, visibility time.Duration) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m := q.leased(msg)
+	if m == nil {
+		return false, nil
+	}
+	m.VisibleAt = engine.Now().Add(visibility)
+	return true, nil
+}
+
+func (q *MemoryQueue) Stats(ctx context.Context) ([]engine.QueueStats, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := engine.Now()
+	byQueue := map[string]*engine.QueueStats{}
+	for _, m := range q.msgs {
+		st := byQueue[m.Queue]
+		if st == nil {
+			st = &engine.QueueStats{Queue: m.Queue}
+			byQueue[m.Queue] = st
+		}
+		switch {
+		case m.State == engine.QueueStateDead:
+			st.Dead++
+		case m.State == engine.QueueStateInFlight:
+			st.InFlight++
+		case m.VisibleAt.After(now):
+			st.Delayed++
+		default:
+			st.Ready++
+			if st.OldestReadyAt == nil || m.VisibleAt.Before(*st.OldestReadyAt) {
+				at := m.VisibleAt
+				st.OldestReadyAt = &at
+			}
+		}
+	}
+	out := make([]engine.QueueStats, 0, len(byQueue))
+	for _, st := range byQueue {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Queue < out[j].Queue })
+	return out, nil
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/enginetest"
+)
+
+func newMemoryQueue(t *testing.T) (*MemoryQueue, *enginetest.Clock) {
+	clock := enginetest.NewClock(enginetest.Epoch)
+	t.Cleanup(engine.SetClock(clock))
+	return NewMemoryQueue(), clock
+}
+
+func dequeue(t *testing.T, q engine.Queue, qname string, n int, visibility time.Duration) []*engine.QueueMessage {
+	t.Helper()
+	msgs, err := q.Dequeue(context.Background(), qname, n, visibility)
+	if err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	return msgs
+}
+
+func stats(t *testing.T, q engine.Queue, qname string) engine.QueueStats {
+	t.Helper()
+	all, err := q.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	for _, st := range all {
+		if st.Queue == qname {
+			return st
+		}
+	}
+	return engine.QueueStats{Queue: qname}
+}
+
+func TestMemoryQueueDelayAndVisibility(t *testing.T) {
+	q, clock := newMemoryQueue(t)
+	ctx := context.Background()
+	if _, err := q.Enqueue(ctx, "jobs", []byte("later"), engine.EnqueueOptions{Delay: time.Minute}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := q.Enqueue(ctx, "jobs", []byte("now"), engine.EnqueueOptions{}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if st := stats(t, q, "jobs"); st.Ready != 1 || st.Delayed != 1 {
+		t.Fatalf("stats = %+v, want 1 ready and 1 delayed", st)
+	}
+
+	msgs := dequeue(t, q, "jobs", 10, 30*time.Second)
+	if len(msgs) != 1 || string(msgs[0].Payload) != "now" || msgs[0].Attempts != 1 {
+		t.Fatalf("first dequeue = %+v, want the undelayed message", msgs)
+	}
+	first := msgs[0]
+
+	// the lease hides the message; the delayed one is due after a minute
+	clock.Advance(time.Minute)
+	msgs = dequeue(t, q, "jobs", 10, 30*time.Second)
+	if len(msgs) != 2 {
+		t.Fatalf("second dequeue got %d messages, want the delayed and the lapsed one", len(msgs))
+	}
+	// the first delivery's receipt no longer applies
+	if ok, _ := q.Ack(ctx, first); ok {
+		t.Fatalf("ack with a stale receipt applied")
+	}
+	for _, m := range msgs {
+		if ok, err := q.Ack(ctx, m); !ok || err != nil {
+			t.Fatalf("ack %s = %v, %v", m.Payload, ok, err)
+		}
+	}
+	if st := stats(t, q, "jobs"); st != (engine.QueueStats{Queue: "jobs"}) {
+		t.Fatalf("stats after acks = %+v, want an empty queue", st)
+	}
+}
+
+func TestMemoryQueueExtendAndNack(t *testing.T) {
+	q, clock := newMemoryQueue(t)
+	ctx := context.Background()
+	if _, err := q.Enqueue(ctx, "jobs", []byte("x"), engine.EnqueueOptions{MaxAttempts: 2}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	msg := dequeue(t, q, "jobs", 1, time.Minute)[0]
+	clock.Advance(50 * time.Second)
+	if ok, err := q.Extend(ctx, msg, time.Minute); !ok || err != nil {
+		t.Fatalf("extend = %v, %v", ok, err)
+	}
+	clock.Advance(50 * time.Second)
+	if msgs := dequeue(t, q, "jobs", 1, time.Minute); len(msgs) != 0 {
+		t.Fatalf("extended message was delivered again")
+	}
+	if st := stats(t, q, "jobs"); st.InFlight != 1 {
+		t.Fatalf("stats = %+v, want 1 in flight", st)
+	}
+
+	if ok, err := q.Nack(ctx, msg, 10*time.Second, "boom"); !ok || err != nil {
+		t.Fatalf("nack = %v, %v", ok, err)
+	}
+	if msgs := dequeue(t, q, "jobs", 1, time.Minute); len(msgs) != 0 {
+		t.Fatalf("nacked message was delivered before its delay")
+	}
+	clock.Advance(10 * time.Second)
+	msg = dequeue(t, q, "jobs", 1, time.Minute)[0]
+	if msg.Attempts != 2 || msg.LastError == nil || *msg.LastError != "boom" {
+		t.Fatalf("redelivery = attempts %d, last error %v", msg.Attempts, msg.LastError)
+	}
+
+	// the last attempt's nack dead-letters the message
+	if ok, _ := q.Nack(ctx, msg, 0, "boom again"); !ok {
+		t.Fatalf("nack did not apply")
+	}
+	if msgs := dequeue(t, q, "jobs", 1, time.Minute); len(msgs) != 0 {
+		t.Fatalf("dead message was delivered")
+	}
+	if st := stats(t, q, "jobs"); st.Dead != 1 || st.Ready != 0 {
+		t.Fatalf("stats = %+v, want 1 dead", st)
+	}
+}
+
+func TestMemoryQueueLapsedLastAttempt(t *testing.T) {
+	q, clock := newMemoryQueue(t)
+	if _, err := q.Enqueue(context.Background(), "jobs", []byte("x"), engine.EnqueueOptions{MaxAttempts: 1}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	dequeue(t, q, "jobs", 1, time.Minute)
+	clock.Advance(time.Minute)
+	if msgs := dequeue(t, q, "jobs", 1, time.Minute); len(msgs) != 0 {
+		t.Fatalf("message was delivered past its attempts")
+	}
+	if st := stats(t, q, "jobs"); st.Dead != 1 {
+		t.Fatalf("stats = %+v, want 1 dead", st)
+	}
+}
@@ -0,0 +1,157 @@
+// Package queue implements engine.Queue on Postgres (and in memory for
+// tests) and runs workers that process its messages. It only needs the
+// queue_messages table, so usecases can rely on it without enabling the
+// orchestration engine.
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PostgresQueue is an engine.Queue backed by the queue_messages table.
+// Concurrent consumers lease disjoint messages with FOR UPDATE SKIP LOCKED.
+type PostgresQueue struct {
+	db *gorm.DB
+}
+
+func NewPostgresQueue(db *gorm.DB) *PostgresQueue {
+	return &PostgresQueue{db: db}
+}
+
+func toMessage(e *entity.QueueMessage) *engine.QueueMessage {
+	msg := &engine.QueueMessage{
+		ID: e.ID, Queue: e.Queue, Payload: e.Payload, State: e.State,
+		Attempts: e.Attempts, MaxAttempts: e.MaxAttempts, VisibleAt: e.VisibleAt,
+		LastError: e.LastError, CreatedAt: e.CreatedAt,
+	}
+	if e.Receipt != nil {
+		msg.Receipt = *e.Receipt
+	}
+	return msg
+}
+
+// enqueueQuery inserts a message that becomes visible @delay seconds after
+// the database's now(), the clock every other queue statement uses.
+const enqueueQuery = `INSERT INTO queue_messages (id, queue, state, payload, attempts, max_attempts, visible_at, created_at, updated_at)
+VALUES (@id, @queue, 'queued', @payload, 0, @max_attempts, now() + make_interval(secs => @delay), now(), now())
+RETURNING *`
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, qname string, payload []byte, opts engine.EnqueueOptions) (*engine.QueueMessage, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = engine.DefaultQueueMaxAttempts
+	}
+	var ent entity.QueueMessage
+	err := q.db.WithContext(ctx).Raw(enqueueQuery, map[string]interface{}{
+		"id": uuid.NewString(), "queue": qname, "payload": payload,
+		"max_attempts": opts.MaxAttempts, "delay": opts.Delay.Seconds(),
+	}).Scan(&ent).Error
+	if err != nil {
+		return nil, err
+	}
+	return toMessage(&ent), nil
+}
+
+// dequeueQuery first dead-letters in-flight messages of @queue whose lease
+// lapsed after their last attempt, then leases up to @n visible messages
+// with attempts left, oldest first. One receipt is shared by the batch; Ack
+// and friends match it together with the message ID.
+const dequeueQuery = `WITH lapsed AS (
+  UPDATE queue_messages
+  SET state = 'dead', receipt = NULL, last_error = COALESCE(last_error, 'visibility timeout'), updated_at = now()
+  WHERE queue = @queue AND state = 'in_flight' AND visible_at <= now() AND attempts >= max_attempts
+), c AS (
+  SELECT id FROM queue_messages
+  WHERE queue = @queue AND state IN ('queued', 'in_flight') AND visible_at <= now() AND attempts < max_attempts
+  ORDER BY visible_at, created_at
+  FOR UPDATE SKIP LOCKED
+  LIMIT @n
+)
+UPDATE queue_messages m
+SET state = 'in_flight', attempts = m.attempts + 1, receipt = @receipt,
+    visible_at = now() + make_interval(secs => @visibility), updated_at = now()
+FROM c WHERE m.id = c.id
+RETURNING m.*`
+
+func (q *PostgresQueue) Dequeue(ctx context.Context, qname string, n int, visibility time.Duration) ([]*engine.QueueMessage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var out []entity.QueueMessage
+	err := q.db.WithContext(ctx).Raw(dequeueQuery, map[string]interface{}{
+		"queue": qname, "n": n, "receipt": uuid.NewString(), "visibility": visibility.Seconds(),
+	}).Scan(&out).Error
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*engine.QueueMessage, 0, len(out))
+	for i := range out {
+		msgs = append(msgs, toMessage(&out[i]))
+	}
+	return msgs, nil
+}
+
+// leased scopes a statement to msg's current delivery.
+func (q *PostgresQueue) leased(ctx context.Context, msg *engine.QueueMessage) *gorm.DB {
+	return q.db.WithContext(ctx).Model(&entity.QueueMessage{}).
+		Where("id = ? AND receipt = ? AND state = ?", msg.ID, msg.Receipt, engine.QueueStateInFlight)
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, msg *engine.QueueMessage) (bool, error) {
+	res := q.db.WithContext(ctx).
+		Where("id = ? AND receipt = ? AND state = ?", msg.ID, msg.Receipt, engine.QueueStateInFlight).
+		Delete(&entity.QueueMessage{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, msg *engine.QueueMessage, delay time.Duration, reason string) (bool, error) {
+	res := q.leased(ctx, msg).Updates(map[string]interface{}{
+		"state":      gorm.Expr("CASE WHEN attempts >= max_attempts THEN ? ELSE ? END", engine.QueueStateDead, engine.QueueStateQueued),
+		"visible_at": gorm.Expr("now() + make_interval(secs => ?)", delay.Seconds()),
+		"receipt":    nil,
+		"last_error": reason,
+		"updated_at": gorm.Expr("now()"),
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (q *PostgresQueue) DeadLetter(ctx context.Context, msg *engine.QueueMessage, reason string) (bool, error) {
+	res := q.leased(ctx, msg).Updates(map[string]interface{}{
+		"state":      engine.QueueStateDead,
+		"receipt":    nil,
+		"last_error": reason,
+		"updated_at": gorm.Expr("now()"),
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (q *PostgresQueue) Extend(ctx context.Context, msg *engine.QueueMessage, visibility time.Duration) (bool, error) {
+	res := q.leased(ctx, msg).Updates(map[string]interface{}{
+		"visible_at": gorm.Expr("now() + make_interval(secs => ?)", visibility.Seconds()),
+		"updated_at": gorm.Expr("now()"),
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+const statsQuery = `SELECT queue,
+  count(*) FILTER (WHERE state = 'queued' AND visible_at <= now()) AS ready,
+  count(*) FILTER (WHERE state = 'queued' AND visible_at > now()) AS delayed,
+  count(*) FILTER (WHERE state = 'in_flight') AS in_flight,
+  count(*) FILTER (WHERE state = 'dead') AS dead,
+  min(visible_at) FILTER (WHERE state = 'queued' AND visible_at <= now()) AS oldest_ready_at
+FROM queue_messages
+GROUP BY queue
+ORDER BY queue`
+
+func (q *PostgresQueue) Stats(ctx context.Context) ([]engine.QueueStats, error) {
+	var out []engine.QueueStats
+	if err := q.db.WithContext(ctx).Raw(statsQuery).Scan(&out).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// These tests need a disposable Postgres database. They run only when
+// ENGINE_TEST_DATABASE_URL is set, like the store tests.
+func testQueue(t *testing.T) *PostgresQueue {
+	t.Helper()
+	url := os.Getenv("ENGINE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("Skipping Postgres queue tests. Set ENGINE_TEST_DATABASE_URL to enable.")
+	}
+	db, err := gorm.Open(postgres.Open(url), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := db.AutoMigrate(&entity.QueueMessage{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewPostgresQueue(db)
+}
+
+func TestPostgresQueueLifecycle(t *testing.T) {
+	q := testQueue(t)
+	ctx := context.Background()
+	qname := "test-" + uuid.NewString()
+
+	if _, err := q.Enqueue(ctx, qname, []byte("later"), engine.EnqueueOptions{Delay: time.Hour}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := q.Enqueue(ctx, qname, []byte{0, 1, 2}, engine.EnqueueOptions{MaxAttempts: 2}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if st := stats(t, q, qname); st.Ready != 1 || st.Delayed != 1 || st.OldestReadyAt == nil {
+		t.Fatalf("stats = %+v, want 1 ready and 1 delayed", st)
+	}
+
+	msgs := dequeue(t, q, qname, 10, time.Minute)
+	if len(msgs) != 1 || string(msgs[0].Payload) != "\x00\x01\x02" || msgs[0].Attempts != 1 || msgs[0].Receipt == "" {
+		t.Fatalf("dequeue = %+v, want the binary message leased", msgs)
+	}
+	msg := msgs[0]
+	if ok, err := q.Extend(ctx, msg, time.Minute); !ok || err != nil {
+		t.Fatalf("extend = %v, %v", ok, err)
+	}
+	if ok, err := q.Nack(ctx, msg, 0, "boom"); !ok || err != nil {
+		t.Fatalf("nack = %v, %v", ok, err)
+	}
+	if ok, _ := q.Ack(ctx, msg); ok {
+		t.Fatalf("ack applied after the message was nacked")
+	}
+
+	msg = dequeue(t, q, qname, 10, time.Minute)[0]
+	if msg.Attempts != 2 || msg.LastError == nil || *msg.LastError != "boom" {
+		t.Fatalf("redelivery = attempts %d, last error %v", msg.Attempts, msg.LastError)
+	}
+	if ok, _ := q.Nack(ctx, msg, 0, "boom again"); !ok {
+		t.Fatalf("nack did not apply")
+	}
+	if msgs := dequeue(t, q, qname, 10, time.Minute); len(msgs) != 0 {
+		t.Fatalf("dead message was delivered")
+	}
+	if st := stats(t, q, qname); st.Dead != 1 || st.Delayed != 1 || st.Ready != 0 {
+		t.Fatalf("stats = %+v, want 1 dead and 1 delayed", st)
+	}
+}
+
+func TestPostgresQueueDisjointLeases(t *testing.T) {
+	q := testQueue(t)
+	ctx := context.Background()
+	qname := "test-" + uuid.NewString()
+	for i := 0; i < 50; i++ {
+		if _, err := q.Enqueue(ctx, qname, []byte("x"), engine.EnqueueOptions{}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for w := 0; w < 5; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msgs, err := q.Dequeue(ctx, qname, 3, time.Minute)
+				if err != nil {
+					t.Errorf("dequeue: %v", err)
+					return
+				}
+				if len(msgs) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, m := range msgs {
+					if seen[m.ID] {
+						t.Errorf("message %s leased twice", m.ID)
+					}
+					seen[m.ID] = true
+				}
+				mu.Unlock()
+				for _, m := range msgs {
+					if ok, err := q.Ack(ctx, m); !ok || err != nil {
+						t.Errorf("ack = %v, %v", ok, err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 50 {
+		t.Fatalf("leased %d messages, want 50", len(seen))
+	}
+}
@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
+)
+
+// Handler processes one message. Returning nil acknowledges it; an error
+// marked with engine.Permanent dead-letters it, and any other error returns
+// it to the queue after a backoff.
+type Handler func(ctx context.Context, msg *engine.QueueMessage) error
+
+// DefaultPollInterval is how often an idle worker polls its queue.
+const DefaultPollInterval = time.Second
+
+// DefaultVisibility is how long a dequeued message stays hidden before it
+// is delivered again, unless the worker extends its lease.
+const DefaultVisibility = 5 * time.Minute
+
+// DefaultShutdownGrace bounds how long running handlers may finish once the
+// worker's context is cancelled.
+const DefaultShutdownGrace = 20 * time.Second
+
+// Message outcomes used as the "outcome" metric label.
+const (
+	OutcomeAcked   = "acked"
+	OutcomeRetried = "retried"
+	OutcomeDead    = "dead"
+	OutcomeLost    = "lease_lost"
+)
+
+type Options struct {
+	// Concurrency is the number of messages handled at once. Zero means 1.
+	Concurrency int
+	// PollInterval is how often the queue is polled while it is empty. Zero
+	// means DefaultPollInterval.
+	PollInterval time.Duration
+	// Visibility is the lease taken on each message. The worker extends it
+	// at half that interval while the handler runs. Zero means
+	// DefaultVisibility.
+	Visibility time.Duration
+	// Retry sets the backoff before a failed message is delivered again.
+	// The attempt limit is the message's own. Zero means
+	// engine.DefaultRetryPolicy.
+	Retry engine.RetryPolicy
+	// ShutdownGrace bounds how long running handlers may finish once ctx is
+	// cancelled; handlers still running then are cancelled and their
+	// messages are redelivered after their lease lapses. Zero means
+	// DefaultShutdownGrace.
+	ShutdownGrace time.Duration
+	// Logger receives the worker's logs. Nil means engine.DefaultLogger().
+	Logger engine.Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultPollInterval
+	}
+	if o.Visibility <= 0 {
+		o.Visibility = DefaultVisibility
+	}
+	if o.Retry.InitialBackoff <= 0 {
+		o.Retry = engine.DefaultRetryPolicy
+	}
+	if o.ShutdownGrace <= 0 {
+		o.ShutdownGrace = DefaultShutdownGrace
+	}
+	if o.Logger == nil {
+		o.Logger = engine.DefaultLogger()
+	}
+	return o
+}
+
+// Start handles the messages of queue qname with h until ctx is cancelled.
+// The returned channel is closed once running handlers have finished or
+// the shutdown grace has passed.
+func Start(ctx context.Context, q engine.Queue, qname string, h Handler, opts Options) <-chan struct{} {
+	opts = opts.withDefaults()
+	// handlers are detached from ctx so a shutdown lets them finish
+	abort, abortHandlers := context.WithCancel(context.Background())
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	freed := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	// fill leases messages for the free slots and reports whether it filled
+	// all of them, i.e. whether more messages may be waiting.
+	fill := func() bool {
+		free := cap(sem) - len(sem)
+		if free == 0 {
+			return false
+		}
+		msgs, err := q.Dequeue(ctx, qname, free, opts.Visibility)
+		if err != nil {
+			if ctx.Err() == nil {
+				opts.Logger.Error("queue: dequeue failed", engine.F("queue", qname), engine.Err(err))
+			}
+			return false
+		}
+		for _, msg := range msgs {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(msg *engine.QueueMessage) {
+				defer func() {
+					<-sem
+					wg.Done()
+					select {
+					case freed <- struct{}{}:
+					default:
+					}
+				}()
+				process(abort, q, h, opts, msg)
+			}(msg)
+		}
+		return len(msgs) > 0 && len(msgs) == free
+	}
+
+	ticker := time.NewTicker(opts.PollInterval)
+	go func() {
+		defer func() {
+			ticker.Stop()
+			finished := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(opts.ShutdownGrace):
+				opts.Logger.Warn("queue: cancelling handlers still running at shutdown", engine.F("queue", qname))
+				abortHandlers()
+				<-finished
+			}
+			abortHandlers()
+			close(done)
+		}()
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			for fill() {
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-freed:
+			}
+		}
+	}()
+	return done
+}
+
+// RunOnce leases up to opts.Concurrency messages of qname and handles them
+// one after another in the calling goroutine. It returns the number of
+// messages handled. Tests use it to drive a queue deterministically.
+func RunOnce(ctx context.Context, q engine.Queue, qname string, h Handler, opts Options) (int, error) {
+	opts = opts.withDefaults()
+	msgs, err := q.Dequeue(ctx, qname, opts.Concurrency, opts.Visibility)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		process(ctx, q, h, opts, msg)
+	}
+	return len(msgs), nil
+}
+
+// process runs h for msg while extending its lease, then acknowledges,
+// retries or dead-letters the message according to the outcome.
+func process(parent context.Context, q engine.Queue, h Handler, opts Options, msg *engine.QueueMessage) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	lg := opts.Logger.With(engine.F("queue", msg.Queue), engine.F("message_id", msg.ID))
+
+	// keep the message hidden while the handler runs; a lost lease means
+	// another consumer may already have it, so the handler is cancelled
+	lost := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(opts.Visibility / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				ok, err := q.Extend(context.Background(), msg, opts.Visibility)
+				if err != nil {
+					lg.Warn("queue: extend message lease failed", engine.Err(err))
+					continue
+				}
+				if !ok {
+					close(lost)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := call(ctx, h, msg)
+	close(stop)
+
+	outcome := OutcomeAcked
+	var applied bool
+	var qerr error
+	select {
+	case <-lost:
+		outcome = OutcomeLost
+		applied = true
+	default:
+		switch {
+		case err == nil:
+			applied, qerr = q.Ack(context.Background(), msg)
+		case isPermanent(err):
+			outcome = OutcomeDead
+			applied, qerr = q.DeadLetter(context.Background(), msg, err.Error())
+		default:
+			outcome = OutcomeRetried
+			if msg.Attempts >= msg.MaxAttempts {
+				outcome = OutcomeDead
+			}
+			applied, qerr = q.Nack(context.Background(), msg, opts.Retry.Backoff(msg.Attempts), err.Error())
+		}
+	}
+	switch {
+	case qerr != nil:
+		lg.Error("queue: settle message failed", engine.Err(qerr))
+	case !applied:
+		outcome = OutcomeLost
+	}
+	if outcome == OutcomeLost {
+		lg.Warn("queue: message lease lapsed before it was handled")
+	} else if err != nil {
+		lg.Warn("queue: message failed", engine.Err(err), engine.F("attempt", msg.Attempts), engine.F("max_attempts", msg.MaxAttempts), engine.F("outcome", outcome))
+	}
+	metrics.ObserveQueueMessage(msg.Queue, outcome, time.Since(start))
+}
+
+// call runs h, turning a panic into a retryable error.
+func call(ctx context.Context, h Handler, msg *engine.QueueMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return h(ctx, msg)
+}
+
+func isPermanent(err error) bool {
+	_, retryable := engine.ClassifyError(err)
+	return !retryable
+}
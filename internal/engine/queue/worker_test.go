@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+func TestRunOnceSettlesByOutcome(t *testing.T) {
+	q, clock := newMemoryQueue(t)
+	ctx := context.Background()
+	for _, p := range []string{"ok", "flaky", "invalid"} {
+		if _, err := q.Enqueue(ctx, "jobs", []byte(p), engine.EnqueueOptions{}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		clock.Advance(time.Second)
+	}
+	calls := map[string]int{}
+	h := func(ctx context.Context, msg *engine.QueueMessage) error {
+		calls[string(msg.Payload)]++
+		switch string(msg.Payload) {
+		case "flaky":
+			if calls["flaky"] == 1 {
+				return errors.New("upstream unavailable")
+			}
+		case "invalid":
+			return engine.Permanent(errors.New("bad payload"))
+		}
+		return nil
+	}
+	opts := Options{Concurrency: 10, Retry: engine.RetryPolicy{InitialBackoff: time.Minute}}
+
+	if n, err := RunOnce(ctx, q, "jobs", h, opts); n != 3 || err != nil {
+		t.Fatalf("RunOnce = %d, %v", n, err)
+	}
+	if st := stats(t, q, "jobs"); st.Delayed != 1 || st.Dead != 1 || st.Ready != 0 {
+		t.Fatalf("stats = %+v, want the flaky message delayed and the invalid one dead", st)
+	}
+	if n, _ := RunOnce(ctx, q, "jobs", h, opts); n != 0 {
+		t.Fatalf("retry ran before its backoff")
+	}
+	clock.Advance(time.Minute)
+	if n, _ := RunOnce(ctx, q, "jobs", h, opts); n != 1 || calls["flaky"] != 2 {
+		t.Fatalf("retry = %d messages, %d flaky calls", n, calls["flaky"])
+	}
+	if st := stats(t, q, "jobs"); st.Delayed != 0 || st.Dead != 1 {
+		t.Fatalf("stats = %+v, want only the dead message", st)
+	}
+}
+
+func TestStartHandlesEveryMessage(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 20; i++ {
+		if _, err := q.Enqueue(ctx, "jobs", []byte("x"), engine.EnqueueOptions{}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	var handled atomic.Int32
+	done := Start(ctx, q, "jobs", func(ctx context.Context, msg *engine.QueueMessage) error {
+		handled.Add(1)
+		return nil
+	}, Options{Concurrency: 4, PollInterval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for handled.Load() < 20 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := handled.Load(); n != 20 {
+		t.Fatalf("handled %d messages, want 20", n)
+	}
+	if st := stats(t, q, "jobs"); st != (engine.QueueStats{Queue: "jobs"}) {
+		t.Fatalf("stats = %+v, want an empty queue", st)
+	}
+}
@@ -2,19 +2,20 @@ package requeue
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
 )
 
 // StartRequeueMonitor starts a background ticker that calls into the StateStore
 // to requeue stale in-progress steps. heartbeatTTLSeconds controls how old a
 // heartbeat must be to consider a step stale. interval controls how often the
-// monitor runs.
-func StartRequeueMonitor(ctx context.Context, store engine.StateStore, interval time.Duration, heartbeatTTLSeconds int, batchSize int) {
+// monitor runs. Runs whose steps fail for good are re-planned through reg.
+func StartRequeueMonitor(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, interval time.Duration, heartbeatTTLSeconds int, batchSize int) {
 	if store == nil {
-		log.Printf("requeue: no store provided, monitor disabled")
+		engine.DefaultLogger().Warn("requeue: no store provided, monitor disabled")
 		return
 	}
 	if interval <= 0 {
@@ -28,15 +29,57 @@ func StartRequeueMonitor(ctx context.Context, store engine.StateStore, interval
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				n, err := store.RequeueStaleSteps(ctx, heartbeatTTLSeconds, batchSize)
+				n, err := RequeueStale(ctx, store, reg, heartbeatTTLSeconds, batchSize)
 				if err != nil {
-					log.Printf("requeue: error requeueing stale steps: %v", err)
+					engine.DefaultLogger().Error("requeue: requeue stale steps failed", engine.Err(err))
 					continue
 				}
 				if n > 0 {
-					log.Printf("requeue: requeued %d stale steps", n)
+					engine.DefaultLogger().Info("requeue: requeued stale steps", engine.F("steps", n), engine.F("heartbeat_ttl_seconds", heartbeatTTLSeconds))
 				}
 			}
 		}
 	}()
 }
+
+// RequeueStale requeues up to batchSize stale steps and returns how many it
+// requeued. Steps out of attempts fail like a step whose handler failed:
+// step.failed is published in the same transaction and their runs are
+// re-planned, so they compensate, fail and notify their parent. Failures to
+// re-plan a run are logged.
+func RequeueStale(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, heartbeatTTLSeconds int, batchSize int) (int, error) {
+	var (
+		requeued int
+		failed   []*engine.WorkflowStepRecord
+	)
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		var err error
+		requeued, failed, err = store.RequeueStaleSteps(ctx, heartbeatTTLSeconds, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, st := range failed {
+			if err := engine.EmitStep(ctx, engine.EventStepFailed, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	metrics.StepsRequeued.Add(float64(requeued))
+
+	advanced := make(map[string]bool, len(failed))
+	for _, st := range failed {
+		engine.DefaultLogger().Warn("requeue: stale step out of attempts, failed", engine.F("run_id", st.RunID), engine.F("step_id", st.ID), engine.F("attempts", st.Attempts))
+		if reg == nil || advanced[st.RunID] {
+			continue
+		}
+		advanced[st.RunID] = true
+		if err := workflow.Advance(ctx, store, reg, st.RunID); err != nil {
+			engine.DefaultLogger().Error("requeue: failed to advance run", engine.F("run_id", st.RunID), engine.F("step_id", st.ID), engine.Err(err))
+		}
+	}
+	return requeued, nil
+}
@@ -0,0 +1,11 @@
+package engine
+
+import "time"
+
+// DefaultRetryPolicy applies to steps planned without an explicit policy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     time.Hour,
+	Jitter:         0.2,
+}
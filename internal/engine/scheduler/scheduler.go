@@ -2,112 +2,435 @@ package scheduler
 
 import (
 	"context"
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/logger"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
 )
 
+// DefaultPollInterval is the fallback polling interval used when no wake
+// channel delivers a notification.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// DefaultHeartbeatInterval is how often a running step's lease is extended
+// when Options.HeartbeatInterval is zero.
+const DefaultHeartbeatInterval = 30 * time.Second
+
+// DefaultShutdownGrace is how long in-flight steps may finish on shutdown
+// when Options.ShutdownGrace is zero.
+const DefaultShutdownGrace = 20 * time.Second
+
+// Options tunes the scheduler loop.
+type Options struct {
+	WorkerCount int
+	// PollInterval is the fallback polling interval. Zero means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+	// Wake, when set, triggers an immediate claim (e.g. from Postgres
+	// LISTEN/NOTIFY). Polling is kept as a fallback for missed notifications.
+	Wake <-chan struct{}
+	// Logger receives the scheduler's logs; step outcomes are persisted as
+	// step logs. Nil means a JSON logger on stdout backed by the store.
+	Logger engine.Logger
+	// WorkerID identifies this scheduler as the owner of the steps it
+	// claims. It must be unique across replicas; empty means NewWorkerID().
+	WorkerID string
+	// HeartbeatInterval is how often the lease on a running step is
+	// extended. It must be well below the requeue monitor's heartbeat TTL.
+	HeartbeatInterval time.Duration
+	// ShutdownGrace bounds how long in-flight steps may finish once ctx is
+	// cancelled. Steps still running then are released for other replicas
+	// and their outcome is dropped.
+	ShutdownGrace time.Duration
+}
+
+// NewWorkerID returns a worker ID unique to this process: the host name,
+// the process ID and a random suffix.
+func NewWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "scheduler"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// lease is what a worker needs to keep and use its claim on a step.
+type lease struct {
+	workerID  string
+	heartbeat time.Duration
+	// abort is cancelled when the scheduler gives up on in-flight steps
+	abort context.Context
+}
+
 // Start begins a simple scheduler loop and worker dispatch. It returns a
 // done channel that will be closed once the scheduler stops and all in-flight
 // workers have finished. This allows callers to wait for graceful shutdown.
-func Start(ctx context.Context, store engine.StateStore, exec engine.Executor, reg engine.WorkflowRegistry, disp engine.Dispatcher, workerCount int) (<-chan struct{}, error) {
-	// Satisfy compiler for unused parameters (scaffold)
-	_ = reg
-	_ = disp
+// After each step is persisted the owning run is re-planned through reg so the
+// workflow can schedule its next steps or finish. Step events are published
+// through the engine dispatcher (see engine.SetDispatcher).
+func Start(ctx context.Context, store engine.StateStore, exec engine.Executor, reg engine.WorkflowRegistry, workerCount int) (<-chan struct{}, error) {
+	return StartWithOptions(ctx, store, exec, reg, Options{WorkerCount: workerCount})
+}
 
+// StartWithOptions is Start with wakeups and polling configured by opts. Each
+// claim fills every free worker slot at once, and the loop claims again as
+// soon as a worker finishes or a wakeup arrives.
+func StartWithOptions(ctx context.Context, store engine.StateStore, exec engine.Executor, reg engine.WorkflowRegistry, opts Options) (<-chan struct{}, error) {
+	workerCount := opts.WorkerCount
 	if workerCount <= 0 {
 		workerCount = 1
 	}
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	lg := opts.Logger
+	if lg == nil {
+		lg = logger.New(nil, store)
+	}
+	workerID := opts.WorkerID
+	if workerID == "" {
+		workerID = NewWorkerID()
+	}
+	heartbeat := opts.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	grace := opts.ShutdownGrace
+	if grace <= 0 {
+		grace = DefaultShutdownGrace
+	}
+	abort, abortSteps := context.WithCancel(context.Background())
+	l := lease{workerID: workerID, heartbeat: heartbeat, abort: abort}
 
 	// worker semaphore
 	sem := make(chan struct{}, workerCount)
 	var wg sync.WaitGroup
+	// freed is signalled whenever a worker returns its slot
+	freed := make(chan struct{}, 1)
 
 	done := make(chan struct{})
 
-	ticker := time.NewTicker(500 * time.Millisecond)
+	// claim fills the free worker slots and reports whether it filled all of
+	// them, i.e. whether more work may be waiting.
+	claim := func() bool {
+		free := cap(sem) - len(sem)
+		if free == 0 {
+			return false
+		}
+		steps, err := store.ClaimNextSteps(ctx, workerID, free)
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.Error("scheduler: claim failed", engine.Err(err))
+			}
+			return false
+		}
+		for _, s := range steps {
+			metrics.StepsClaimed.WithLabelValues(s.WorkflowType, s.StepName).Inc()
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(s *engine.WorkflowStepRecord) {
+				defer func() {
+					<-sem
+					wg.Done()
+					select {
+					case freed <- struct{}{}:
+					default:
+					}
+				}()
+				runStep(store, exec, reg, lg, l, s)
+			}(s)
+		}
+		return len(steps) > 0 && len(steps) == free
+	}
+
+	ticker := time.NewTicker(pollInterval)
 	go func() {
 		defer func() {
-			// wait for workers to finish
-			wg.Wait()
 			ticker.Stop()
+			// let in-flight workers finish, then release what is left
+			finished := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(grace):
+				n, err := store.ReleaseSteps(context.Background(), workerID)
+				if err != nil {
+					lg.Error("scheduler: failed to release steps on shutdown", engine.Err(err))
+				} else {
+					lg.Warn("scheduler: released steps still running at shutdown", engine.F("worker_id", workerID), engine.F("steps", n))
+				}
+				abortSteps()
+				<-finished
+			}
+			abortSteps()
 			close(done)
 		}()
 
 		for {
+			if ctx.Err() != nil {
+				// stop accepting new work and wait for in-flight workers
+				return
+			}
+			for claim() {
+			}
+
 			select {
 			case <-ctx.Done():
-				// stop accepting new work and wait for in-flight workers
 				return
 			case <-ticker.C:
-				// try claim a step
-				step, err := store.ClaimNextStep(ctx, "scheduler")
+			case <-opts.Wake:
+			case <-freed:
+			}
+		}
+	}()
+
+	return done, nil
+}
+
+// RunOnce claims up to opts.WorkerCount ready steps and runs them one after
+// another in the calling goroutine, persisting each outcome and re-planning
+// its run before the next. It returns the number of steps run. Tests and
+// tools use it to drive workflows deterministically; see package enginetest.
+func RunOnce(ctx context.Context, store engine.StateStore, exec engine.Executor, reg engine.WorkflowRegistry, opts Options) (int, error) {
+	n := opts.WorkerCount
+	if n <= 0 {
+		n = 1
+	}
+	lg := opts.Logger
+	if lg == nil {
+		lg = logger.New(nil, store)
+	}
+	l := lease{workerID: opts.WorkerID, heartbeat: opts.HeartbeatInterval, abort: ctx}
+	if l.workerID == "" {
+		l.workerID = NewWorkerID()
+	}
+	if l.heartbeat <= 0 {
+		l.heartbeat = DefaultHeartbeatInterval
+	}
+	steps, err := store.ClaimNextSteps(ctx, l.workerID, n)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range steps {
+		metrics.StepsClaimed.WithLabelValues(s.WorkflowType, s.StepName).Inc()
+		runStep(store, exec, reg, lg, l, s)
+	}
+	return len(steps), nil
+}
+
+// runStep executes a claimed step, persists its outcome and re-plans the run.
+func runStep(store engine.StateStore, exec engine.Executor, reg engine.WorkflowRegistry, lg engine.Logger, l lease, s *engine.WorkflowStepRecord) {
+	sl := lg.ForStep(s)
+	start := time.Now()
+	sl.Info("step started", engine.F("attempt", s.Attempts+1))
+
+	// The step context is detached from the scheduler's so a shutdown lets
+	// the step finish; it is only cancelled once the scheduler gives up on
+	// in-flight steps or the lease is lost. We add a hard timeout (e.g. 5
+	// minutes) to prevent zombies.
+	stepCtx, cancel := context.WithTimeout(l.abort, 5*time.Minute)
+	defer cancel()
+
+	// Start heartbeat ticker
+	hbDone := make(chan struct{})
+	go func() {
+		hbTicker := time.NewTicker(l.heartbeat)
+		defer hbTicker.Stop()
+		for {
+			select {
+			case <-hbDone:
+				return
+			case <-stepCtx.Done():
+				return
+			case <-hbTicker.C:
+				ok, err := store.HeartbeatStep(stepCtx, s, l.workerID)
 				if err != nil {
-					log.Printf("scheduler: claim error: %v", err)
+					sl.Warn("heartbeat failed", engine.Err(err))
 					continue
 				}
-				if step == nil {
-					continue
+				if !ok {
+					// requeued underneath us; whatever the step does now is dropped
+					sl.Warn("step lease lost, cancelling", engine.F("worker_id", l.workerID))
+					cancel()
+					return
 				}
-
-				sem <- struct{}{}
-				wg.Add(1)
-				go func(s *engine.WorkflowStepRecord) {
-					defer func() { <-sem; wg.Done() }()
-
-					// Create a detached context for the step execution so it isn't killed immediately on scheduler shutdown.
-					// We add a hard timeout (e.g. 5 minutes) to prevent zombies.
-					stepCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-					defer cancel()
-
-					// Start heartbeat ticker
-					hbDone := make(chan struct{})
-					go func() {
-						hbTicker := time.NewTicker(30 * time.Second)
-						defer hbTicker.Stop()
-						for {
-							select {
-							case <-hbDone:
-								return
-							case <-stepCtx.Done():
-								return
-							case <-hbTicker.C:
-								if err := store.HeartbeatStep(stepCtx, s.ID); err != nil {
-									log.Printf("scheduler: heartbeat failed for step %s: %v", s.ID, err)
-								}
-							}
-						}
-					}()
-
-					// run step
-					res, err := exec.RunStep(stepCtx, s)
-
-					// Stop heartbeat
-					close(hbDone)
-
-					if err != nil {
-						log.Printf("executor error for step %s: %v", s.ID, err)
-						// update step with failure
-						s.Status = "failed"
-						s.Error = &[]string{err.Error()}[0] // hack to get pointer to string
-						if updateErr := store.UpdateStep(context.Background(), s); updateErr != nil {
-							log.Printf("scheduler: failed to update failed step %s: %v", s.ID, updateErr)
-						}
-						return
-					}
-					// on success persist result and mark completed
-					s.Result = res.Output
-					s.Status = "completed"
-					if updateErr := store.UpdateStep(context.Background(), s); updateErr != nil {
-						log.Printf("scheduler: failed to update completed step %s: %v", s.ID, updateErr)
-					}
-					// Slightly different context for update to ensure it persists even during shutdown
-				}(step)
 			}
 		}
 	}()
 
-	return done, nil
+	// run step
+	res, err := exec.RunStep(stepCtx, s)
+
+	// Stop heartbeat
+	close(hbDone)
+
+	if err != nil {
+		var throttled *engine.ThrottledError
+		if errors.As(err, &throttled) {
+			if throttle(store, sl, l, s, throttled) {
+				metrics.ObserveStep(s, metrics.OutcomeThrottled, time.Since(start))
+				return
+			}
+		}
+		if retry(store, sl, l, s, err) {
+			metrics.ObserveStep(s, metrics.OutcomeRetried, time.Since(start))
+			return
+		}
+		metrics.ObserveStep(s, metrics.OutcomeFailed, time.Since(start))
+		sl.Error("step failed", engine.Err(err), engine.F("attempts", s.Attempts), engine.F("duration", time.Since(start)))
+		// update step with failure
+		s.Status = engine.StepStatusFailed
+		s.Error = &[]string{err.Error()}[0] // hack to get pointer to string
+		if !finish(store, sl, l, s, engine.EventStepFailed, "scheduler: failed to update failed step") {
+			return
+		}
+		advance(store, reg, lg, s)
+		return
+	}
+	// steps that wait for a human are parked until a signal arrives
+	if res.Wait != nil {
+		metrics.ObserveStep(s, metrics.OutcomeWaiting, time.Since(start))
+		s.Status = engine.StepStatusWaitingForSignal
+		s.Result, _ = json.Marshal(res.Wait)
+		s.NextAttemptAt = res.Wait.Deadline
+		if !save(store, sl, l, s, "scheduler: failed to park step") {
+			return
+		}
+		sl.Info("step waiting for signal", engine.F("reason", res.Wait.Reason), engine.F("deadline", res.Wait.Deadline))
+		return
+	}
+	// child-run and map steps are parked until their children finish; the
+	// run is advanced right away in case they already did
+	if res.AwaitChildren {
+		metrics.ObserveStep(s, metrics.OutcomeWaiting, time.Since(start))
+		s.Status = engine.StepStatusWaitingForChildren
+		s.Result = res.Output
+		if !save(store, sl, l, s, "scheduler: failed to park step") {
+			return
+		}
+		sl.Info("step waiting for children", engine.F("result", json.RawMessage(res.Output)))
+		advance(store, reg, lg, s)
+		return
+	}
+	// on success persist result and mark completed
+	metrics.ObserveStep(s, metrics.OutcomeCompleted, time.Since(start))
+	s.Result = res.Output
+	s.Status = engine.StepStatusCompleted
+	if !finish(store, sl, l, s, engine.EventStepCompleted, "scheduler: failed to update completed step") {
+		return
+	}
+	sl.Info("step completed", engine.F("duration", time.Since(start)))
+	advance(store, reg, lg, s)
+}
+
+// retry reschedules a failed step according to its retry policy and the
+// class of err. It returns false when the step must be marked failed:
+// permanent errors, classes the policy does not retry, or exhausted attempts.
+func retry(store engine.StateStore, sl engine.Logger, l lease, s *engine.WorkflowStepRecord, err error) bool {
+	policy := engine.DefaultRetryPolicy
+	if s.RetryPolicy != nil {
+		policy = *s.RetryPolicy
+	}
+	if s.MaxAttempts > 0 {
+		policy.MaxAttempts = s.MaxAttempts
+	}
+
+	class, retryable := engine.ClassifyError(err)
+	attempts := s.Attempts + 1
+	if !retryable || !policy.Allows(class) || attempts >= policy.MaxAttempts {
+		s.Attempts = attempts
+		return false
+	}
+
+	next := engine.Now().Add(policy.Backoff(attempts))
+	s.Status = engine.StepStatusPending
+	s.Attempts = attempts
+	s.NextAttemptAt = &next
+	s.LockOwner = nil
+	s.Error = &[]string{err.Error()}[0]
+	// a step whose outcome was not saved is not failed either: the requeue
+	// monitor or the new lease holder takes it from here
+	if !save(store, sl, l, s, "scheduler: failed to reschedule step") {
+		return true
+	}
+	sl.Warn("step retry scheduled", engine.Err(err), engine.F("class", class), engine.F("attempt", attempts),
+		engine.F("max_attempts", policy.MaxAttempts), engine.F("next_attempt_at", next))
+	return true
+}
+
+// throttle puts a step that was not started because of a rate limit back in
+// the queue until its connector has capacity again. No attempt is counted.
+func throttle(store engine.StateStore, sl engine.Logger, l lease, s *engine.WorkflowStepRecord, t *engine.ThrottledError) bool {
+	next := engine.Now().Add(t.RetryAfter)
+	s.Status = engine.StepStatusPending
+	s.NextAttemptAt = &next
+	s.LockOwner = nil
+	if !save(store, sl, l, s, "scheduler: failed to defer throttled step") {
+		return true
+	}
+	sl.Info("step throttled", engine.F("connector", t.Connector), engine.F("next_attempt_at", next))
+	return true
+}
+
+// save persists the outcome of s while the worker still holds its lease. It
+// reports false when the outcome was not persisted: on a store error, or
+// because the step was requeued or released and now belongs to another
+// attempt.
+func save(store engine.StateStore, sl engine.Logger, l lease, s *engine.WorkflowStepRecord, failure string) bool {
+	ok, err := store.UpdateStep(context.Background(), s, l.workerID)
+	if err != nil {
+		sl.Error(failure, engine.Err(err))
+		return false
+	}
+	if !ok {
+		sl.Warn("step lease lost, outcome dropped", engine.F("worker_id", l.workerID), engine.F("status", s.Status))
+	}
+	return ok
+}
+
+// finish saves s like save and publishes eventType for it in the same
+// transaction.
+func finish(store engine.StateStore, sl engine.Logger, l lease, s *engine.WorkflowStepRecord, eventType, failure string) bool {
+	saved := false
+	err := store.Atomic(context.Background(), func(ctx context.Context) error {
+		ok, err := store.UpdateStep(ctx, s, l.workerID)
+		if err != nil || !ok {
+			return err
+		}
+		saved = true
+		return engine.EmitStep(ctx, eventType, s)
+	})
+	if err != nil {
+		sl.Error(failure, engine.Err(err))
+		return false
+	}
+	if !saved {
+		sl.Warn("step lease lost, outcome dropped", engine.F("worker_id", l.workerID), engine.F("status", s.Status))
+	}
+	return saved
+}
+
+// advance re-plans the run owning s now that s has reached a terminal state.
+func advance(store engine.StateStore, reg engine.WorkflowRegistry, lg engine.Logger, s *engine.WorkflowStepRecord) {
+	if reg == nil {
+		return
+	}
+	if err := workflow.Advance(context.Background(), store, reg, s.RunID); err != nil {
+		lg.Error("scheduler: failed to advance run", engine.F("run_id", s.RunID), engine.F("step_id", s.ID), engine.Err(err))
+	}
 }
@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// benchStore serves a fixed queue of pending steps. Only the methods used by
+// the scheduler loop are implemented.
+type benchStore struct {
+	engine.StateStore
+	mu       sync.Mutex
+	pending  []*engine.WorkflowStepRecord
+	finished chan struct{}
+}
+
+func (s *benchStore) ClaimNextSteps(ctx context.Context, workerID string, n int) ([]*engine.WorkflowStepRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > len(s.pending) {
+		n = len(s.pending)
+	}
+	out := s.pending[:n]
+	s.pending = s.pending[n:]
+	return out, nil
+}
+
+func (s *benchStore) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (s *benchStore) UpdateStep(ctx context.Context, step *engine.WorkflowStepRecord, workerID string) (bool, error) {
+	s.finished <- struct{}{}
+	return true, nil
+}
+
+func (s *benchStore) HeartbeatStep(ctx context.Context, step *engine.WorkflowStepRecord, workerID string) (bool, error) {
+	return true, nil
+}
+
+// sleepExecutor simulates an I/O bound step such as an LLM or SMTP call.
+type sleepExecutor struct{ d time.Duration }
+
+func (e sleepExecutor) RunStep(ctx context.Context, step *engine.WorkflowStepRecord) (engine.StepResult, error) {
+	time.Sleep(e.d)
+	return engine.StepResult{Success: true, Output: []byte(`{}`)}, nil
+}
+
+// BenchmarkSchedulerThroughput measures steps/second for a 2ms step with
+// different worker counts. Before batched claims the scheduler claimed one
+// step per 500ms tick, capping throughput at ~2 steps/s for any worker count.
+func BenchmarkSchedulerThroughput(b *testing.B) {
+	const stepsPerRound = 512
+	for _, workers := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store := &benchStore{finished: make(chan struct{}, stepsPerRound)}
+				for j := 0; j < stepsPerRound; j++ {
+					store.pending = append(store.pending, &engine.WorkflowStepRecord{ID: fmt.Sprintf("s%d", j), StepName: "bench"})
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done, err := StartWithOptions(ctx, store, sleepExecutor{d: 2 * time.Millisecond}, nil, Options{WorkerCount: workers, Logger: engine.NopLogger{}})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < stepsPerRound; j++ {
+					<-store.finished
+				}
+				cancel()
+				<-done
+			}
+			b.ReportMetric(float64(stepsPerRound*b.N)/b.Elapsed().Seconds(), "steps/s")
+		})
+	}
+}
+
+func TestSchedulerWakeClaimsImmediately(t *testing.T) {
+	store := &benchStore{finished: make(chan struct{}, 1)}
+	wake := make(chan struct{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a poll interval far beyond the test timeout proves the wakeup did the work
+	done, err := StartWithOptions(ctx, store, sleepExecutor{}, nil, Options{WorkerCount: 1, PollInterval: time.Hour, Wake: wake, Logger: engine.NopLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	store.pending = append(store.pending, &engine.WorkflowStepRecord{ID: "s1", StepName: "wake"})
+	store.mu.Unlock()
+	wake <- struct{}{}
+
+	select {
+	case <-store.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("step was not claimed after wakeup")
+	}
+	cancel()
+	<-done
+}
+
+// failExecutor fails every step with err.
+type failExecutor struct{ err error }
+
+func (e failExecutor) RunStep(ctx context.Context, step *engine.WorkflowStepRecord) (engine.StepResult, error) {
+	return engine.StepResult{}, e.err
+}
+
+var testLease = lease{workerID: "worker", heartbeat: time.Minute, abort: context.Background()}
+
+func TestRunStepRetriesByErrorClass(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		attempts int
+		want     string
+	}{
+		{"rate limit is rescheduled", errors.New("openai: 429 Too Many Requests"), 0, engine.StepStatusPending},
+		{"permanent error fails", engine.Permanent(errors.New("bad input")), 0, engine.StepStatusFailed},
+		{"exhausted attempts fail", errors.New("timeout"), 4, engine.StepStatusFailed},
+	}
+	// the counters are process-wide, so only what this test adds is checked
+	retried := metrics.StepsFinished.WithLabelValues("", "retry", metrics.OutcomeRetried)
+	failed := metrics.StepsFinished.WithLabelValues("", "retry", metrics.OutcomeFailed)
+	retriedBefore, failedBefore := testutil.ToFloat64(retried), testutil.ToFloat64(failed)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &benchStore{finished: make(chan struct{}, 1)}
+			step := &engine.WorkflowStepRecord{ID: "s1", StepName: "retry", Attempts: tc.attempts, MaxAttempts: 5}
+			runStep(store, failExecutor{err: tc.err}, nil, engine.NopLogger{}, testLease, step)
+			if step.Status != tc.want {
+				t.Fatalf("status = %q, want %q", step.Status, tc.want)
+			}
+			if tc.want == engine.StepStatusPending && (step.NextAttemptAt == nil || !step.NextAttemptAt.After(time.Now())) {
+				t.Fatalf("next_attempt_at = %v, want a future time", step.NextAttemptAt)
+			}
+			if step.Attempts != tc.attempts+1 {
+				t.Fatalf("attempts = %d, want %d", step.Attempts, tc.attempts+1)
+			}
+		})
+	}
+
+	if n := testutil.ToFloat64(retried) - retriedBefore; n != 1 {
+		t.Fatalf("retried steps metric grew by %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(failed) - failedBefore; n != 2 {
+		t.Fatalf("failed steps metric grew by %v, want 2", n)
+	}
+}
+
+func TestRunStepDefersThrottledStepWithoutAnAttempt(t *testing.T) {
+	store := &benchStore{finished: make(chan struct{}, 1)}
+	owner := "scheduler"
+	step := &engine.WorkflowStepRecord{ID: "s1", StepName: "throttled", Attempts: 2, MaxAttempts: 3, LockOwner: &owner}
+	runStep(store, failExecutor{err: &engine.ThrottledError{Connector: "llm", RetryAfter: time.Second}}, nil, engine.NopLogger{}, testLease, step)
+	if step.Status != engine.StepStatusPending || step.Attempts != 2 || step.LockOwner != nil {
+		t.Fatalf("step = %s attempts %d owner %v, want pending, 2 attempts and no owner", step.Status, step.Attempts, step.LockOwner)
+	}
+	if step.NextAttemptAt == nil || !step.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("next_attempt_at = %v, want a future time", step.NextAttemptAt)
+	}
+}
+
+// releaseStore records the worker IDs steps are claimed and released with.
+type releaseStore struct {
+	benchStore
+	claimedBy  string
+	releasedBy string
+}
+
+func (s *releaseStore) ClaimNextSteps(ctx context.Context, workerID string, n int) ([]*engine.WorkflowStepRecord, error) {
+	s.mu.Lock()
+	if len(s.pending) > 0 {
+		s.claimedBy = workerID
+	}
+	s.mu.Unlock()
+	return s.benchStore.ClaimNextSteps(ctx, workerID, n)
+}
+
+func (s *releaseStore) ReleaseSteps(ctx context.Context, workerID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releasedBy = workerID
+	return 1, nil
+}
+
+// blockExecutor runs until its context is cancelled.
+type blockExecutor struct{ started chan struct{} }
+
+func (e blockExecutor) RunStep(ctx context.Context, step *engine.WorkflowStepRecord) (engine.StepResult, error) {
+	close(e.started)
+	<-ctx.Done()
+	return engine.StepResult{}, ctx.Err()
+}
+
+func TestShutdownReleasesStepsStillRunning(t *testing.T) {
+	store := &releaseStore{benchStore: benchStore{finished: make(chan struct{}, 1)}}
+	store.pending = []*engine.WorkflowStepRecord{{ID: "s1", StepName: "slow"}}
+	exec := blockExecutor{started: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := StartWithOptions(ctx, store, exec, nil, Options{WorkerCount: 1, WorkerID: "replica-a", ShutdownGrace: 50 * time.Millisecond, Logger: engine.NopLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-exec.started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop after the shutdown grace")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.claimedBy != "replica-a" || store.releasedBy != "replica-a" {
+		t.Fatalf("claimed by %q, released by %q, want replica-a", store.claimedBy, store.releasedBy)
+	}
+}
+
+func TestNewWorkerIDIsUnique(t *testing.T) {
+	if a, b := NewWorkerID(), NewWorkerID(); a == b {
+		t.Fatalf("worker IDs collide: %s", a)
+	}
+}
@@ -0,0 +1,168 @@
+package signal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/outbox"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	"github.com/google/uuid"
+)
+
+// timeoutActor is recorded as the actor of signals generated by the timeout
+// monitor.
+const timeoutActor = "system:timeout"
+
+var (
+	// ErrNotWaiting is returned when the step is not waiting for a signal,
+	// e.g. because another reviewer already resolved it.
+	ErrNotWaiting = errors.New("signal: step is not waiting for a signal")
+	// ErrInvalidAction is returned for actions other than approve/reject/edit.
+	ErrInvalidAction = errors.New("signal: invalid action")
+)
+
+// Decision is the result stored on a step once a signal resolves it.
+type Decision struct {
+	Decision string           `json:"decision"`
+	Payload  json.RawMessage  `json:"payload,omitempty"`
+	Actor    string           `json:"actor,omitempty"`
+	Wait     *engine.WaitSpec `json:"wait,omitempty"`
+}
+
+// Deliver resolves a waiting step with sig, records the decision in the step
+// logs and re-plans the run so it resumes.
+func Deliver(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, step *engine.WorkflowStepRecord, sig engine.Signal) error {
+	decision := ""
+	switch sig.Action {
+	case engine.SignalApprove:
+		decision = "approved"
+	case engine.SignalReject:
+		decision = "rejected"
+	case engine.SignalEdit:
+		if len(sig.Payload) == 0 {
+			return fmt.Errorf("%w: edit requires a payload", ErrInvalidAction)
+		}
+		decision = "edited"
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidAction, sig.Action)
+	}
+	if step.Status != engine.StepStatusWaitingForSignal {
+		return ErrNotWaiting
+	}
+
+	var wait engine.WaitSpec
+	_ = json.Unmarshal(step.Result, &wait)
+	result, err := json.Marshal(Decision{Decision: decision, Payload: sig.Payload, Actor: sig.Actor, Wait: &wait})
+	if err != nil {
+		return err
+	}
+
+	step.Status = engine.StepStatusCompleted
+	step.Result = result
+	step.NextAttemptAt = nil
+	err = store.Atomic(ctx, func(ctx context.Context) error {
+		ok, err := store.UpdateWaitingStep(ctx, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotWaiting
+		}
+		return engine.EmitStep(ctx, engine.EventStepCompleted, step)
+	})
+	if err != nil {
+		return err
+	}
+
+	meta, _ := json.Marshal(map[string]interface{}{"action": sig.Action, "actor": sig.Actor})
+	if err := store.AppendLog(ctx, &engine.StepLog{ID: uuid.NewString(), StepID: step.ID, Level: "info", Message: "signal " + decision, Meta: meta}); err != nil {
+		engine.DefaultLogger().Error("signal: failed to append log", engine.F("step_id", step.ID), engine.Err(err))
+	}
+	return workflow.Advance(ctx, store, reg, step.RunID)
+}
+
+// StartTimeoutMonitor periodically resolves waiting steps whose deadline has
+// passed, escalating or rejecting them according to their WaitSpec.
+func StartTimeoutMonitor(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, interval time.Duration, batchSize int) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ExpireDue(ctx, store, reg, batchSize); err != nil {
+					engine.DefaultLogger().Error("signal: list expired waiting steps failed", engine.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+// ExpireDue resolves up to batchSize waiting steps whose deadline has
+// passed. Failures to resolve a single step are logged.
+func ExpireDue(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, batchSize int) error {
+	steps, err := store.ListExpiredWaitingSteps(ctx, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, st := range steps {
+		if err := expire(ctx, store, reg, st); err != nil && !errors.Is(err, ErrNotWaiting) {
+			engine.DefaultLogger().Error("signal: failed to expire step", engine.F("run_id", st.RunID), engine.F("step_id", st.ID), engine.Err(err))
+		}
+	}
+	return nil
+}
+
+func expire(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, step *engine.WorkflowStepRecord) error {
+	var wait engine.WaitSpec
+	_ = json.Unmarshal(step.Result, &wait)
+
+	if wait.OnTimeout == engine.TimeoutEscalate && !wait.Escalated && wait.EscalateTo != "" {
+		mail, _ := json.Marshal(map[string]string{
+			"to":      wait.EscalateTo,
+			"subject": "Escalation: review overdue",
+			"body":    fmt.Sprintf("A review (%s) for workflow run %s has not been handled in time and was escalated to you.", wait.Reason, step.RunID),
+		})
+		key := engine.StepKey(step) + ":escalation"
+		ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: outbox.EventEmailSend, Payload: mail, State: "pending", IdempotencyKey: &key}
+
+		wait.Escalated = true
+		deadline := engine.Now().Add(time.Duration(wait.TimeoutSeconds) * time.Second)
+		wait.Deadline = &deadline
+		result, _ := json.Marshal(wait)
+		step.Result = result
+		step.NextAttemptAt = &deadline
+		// the email goes out only if the step is still waiting
+		err := store.Atomic(ctx, func(ctx context.Context) error {
+			ok, err := store.UpdateWaitingStep(ctx, step)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrNotWaiting
+			}
+			return store.EnqueueEvent(ctx, ev)
+		})
+		if err != nil {
+			return err
+		}
+		meta, _ := json.Marshal(map[string]string{"escalate_to": wait.EscalateTo})
+		_ = store.AppendLog(ctx, &engine.StepLog{ID: uuid.NewString(), StepID: step.ID, Level: "warn", Message: "signal timeout escalated", Meta: meta})
+		return nil
+	}
+
+	if wait.OnTimeout == engine.TimeoutEscalate && !wait.Escalated {
+		// nobody to escalate to; say so instead of rejecting silently
+		_ = store.AppendLog(ctx, &engine.StepLog{ID: uuid.NewString(), StepID: step.ID, Level: "warn", Message: "signal timeout escalation has no recipient, rejecting"})
+	}
+	return Deliver(ctx, store, reg, step, engine.Signal{Action: engine.SignalReject, Actor: timeoutActor})
+}
@@ -0,0 +1,246 @@
+package signal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+)
+
+// clock is a fake engine clock moved by the tests.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// approval asks for a review, then sends the draft unless it was rejected.
+type approval struct{}
+
+func (approval) ID() string      { return "approval" }
+func (approval) Version() string { return "v1" }
+
+func (approval) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	if len(run.Steps) == 0 {
+		return []engine.WorkflowStepDef{{StepName: "review", Input: run.Payload}}, nil
+	}
+	last := run.Steps[len(run.Steps)-1]
+	if last.StepName != "review" {
+		return nil, nil
+	}
+	var d Decision
+	if err := json.Unmarshal(last.Result, &d); err != nil {
+		return nil, err
+	}
+	if d.Decision == "rejected" {
+		return nil, nil
+	}
+	return []engine.WorkflowStepDef{{StepName: "send"}}, nil
+}
+
+type fixture struct {
+	store *store.MemoryStore
+	reg   *workflow.Registry
+	clock *clock
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{store: store.NewMemoryStore(), reg: workflow.NewRegistry(), clock: &clock{now: time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)}}
+	f.reg.Register(approval{})
+	t.Cleanup(engine.SetClock(f.clock))
+	return f
+}
+
+// park starts a run and parks its review step as the scheduler does for a
+// handler returning wait.
+func (f *fixture) park(t *testing.T, wait engine.WaitSpec) (*engine.WorkflowRun, *engine.WorkflowStepRecord) {
+	t.Helper()
+	ctx := context.Background()
+	run := &engine.WorkflowRun{WorkflowType: "approval", Payload: []byte(`{"draft":"Hello"}`)}
+	if err := workflow.StartRun(ctx, f.store, f.reg, run); err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	step := f.step(t, run.ID, "review")
+	if wait.TimeoutSeconds > 0 {
+		deadline := engine.Now().Add(time.Duration(wait.TimeoutSeconds) * time.Second)
+		wait.Deadline = &deadline
+	}
+	step.Status, step.NextAttemptAt = engine.StepStatusWaitingForSignal, wait.Deadline
+	step.Result, _ = json.Marshal(wait)
+	if ok, err := f.store.TransitionStep(ctx, step, engine.StepStatusPending); !ok || err != nil {
+		t.Fatalf("park review = %v, %v", ok, err)
+	}
+	return run, step
+}
+
+func (f *fixture) run(t *testing.T, runID string) *engine.WorkflowRun {
+	t.Helper()
+	run, err := f.store.LoadRun(context.Background(), runID)
+	if err != nil || run == nil {
+		t.Fatalf("load run = %v, %v", run, err)
+	}
+	return run
+}
+
+func (f *fixture) step(t *testing.T, runID, name string) *engine.WorkflowStepRecord {
+	t.Helper()
+	for _, st := range f.run(t, runID).Steps {
+		if st.StepName == name {
+			st := st
+			return &st
+		}
+	}
+	t.Fatalf("run %s has no step %q", runID, name)
+	return nil
+}
+
+func (f *fixture) decision(t *testing.T, runID string) Decision {
+	t.Helper()
+	var d Decision
+	if err := json.Unmarshal(f.step(t, runID, "review").Result, &d); err != nil {
+		t.Fatalf("decode decision: %v", err)
+	}
+	return d
+}
+
+func (f *fixture) logged(t *testing.T, runID, message string) bool {
+	t.Helper()
+	logs, err := f.store.ListRunLogs(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("list logs: %v", err)
+	}
+	for _, l := range logs {
+		if l.Message == message {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fixture) expire(t *testing.T) {
+	t.Helper()
+	if err := ExpireDue(context.Background(), f.store, f.reg, 100); err != nil {
+		t.Fatalf("expire due: %v", err)
+	}
+}
+
+func TestDeliverResumesRun(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	run, step := f.park(t, engine.WaitSpec{Reason: "approve draft"})
+
+	for _, sig := range []engine.Signal{{Action: "maybe"}, {Action: engine.SignalEdit}} {
+		if err := Deliver(ctx, f.store, f.reg, step, sig); !errors.Is(err, ErrInvalidAction) {
+			t.Fatalf("deliver %+v = %v, want ErrInvalidAction", sig, err)
+		}
+	}
+	edit := engine.Signal{Action: engine.SignalEdit, Payload: []byte(`{"draft":"Hi"}`), Actor: "agent@example.com"}
+	if err := Deliver(ctx, f.store, f.reg, step, edit); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	d := f.decision(t, run.ID)
+	if d.Decision != "edited" || d.Actor != "agent@example.com" || string(d.Payload) != `{"draft":"Hi"}` || d.Wait == nil || d.Wait.Reason != "approve draft" {
+		t.Fatalf("decision = %+v", d)
+	}
+	if got := f.step(t, run.ID, "send"); got.Status != engine.StepStatusPending {
+		t.Fatalf("run did not resume: send is %s", got.Status)
+	}
+	if !f.logged(t, run.ID, "signal edited") {
+		t.Fatal("decision not logged")
+	}
+
+	// a second reviewer loses the race
+	stale := *step
+	stale.Status = engine.StepStatusWaitingForSignal
+	if err := Deliver(ctx, f.store, f.reg, &stale, engine.Signal{Action: engine.SignalApprove}); !errors.Is(err, ErrNotWaiting) {
+		t.Fatalf("deliver twice = %v, want ErrNotWaiting", err)
+	}
+}
+
+func TestDeliverRejectEndsRunRejected(t *testing.T) {
+	f := newFixture(t)
+	run, step := f.park(t, engine.WaitSpec{Reason: "approve draft"})
+
+	if err := Deliver(context.Background(), f.store, f.reg, step, engine.Signal{Action: engine.SignalReject, Actor: "agent@example.com"}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := f.run(t, run.ID); got.Status != engine.RunStatusRejected || len(got.Steps) != 1 {
+		t.Fatalf("run = %s with %d steps, want rejected after the review", got.Status, len(got.Steps))
+	}
+}
+
+func TestExpireDueEscalatesThenRejects(t *testing.T) {
+	f := newFixture(t)
+	run, _ := f.park(t, engine.WaitSpec{Reason: "approve draft", TimeoutSeconds: 3600, OnTimeout: engine.TimeoutEscalate, EscalateTo: "lead@example.com"})
+
+	f.clock.advance(59 * time.Minute)
+	f.expire(t)
+	if got := f.step(t, run.ID, "review"); got.Status != engine.StepStatusWaitingForSignal {
+		t.Fatalf("review before its deadline = %s", got.Status)
+	}
+	if n := len(f.store.Events()); n != 0 {
+		t.Fatalf("%d events before the deadline", n)
+	}
+
+	f.clock.advance(time.Minute)
+	f.expire(t)
+	step := f.step(t, run.ID, "review")
+	if step.Status != engine.StepStatusWaitingForSignal || step.NextAttemptAt == nil || !step.NextAttemptAt.Equal(f.clock.Now().Add(time.Hour)) {
+		t.Fatalf("escalated review = %s until %v, want waiting another hour", step.Status, step.NextAttemptAt)
+	}
+	events := f.store.Events()
+	if len(events) != 1 || events[0].EventType != "email_send" || !strings.Contains(string(events[0].Payload), "lead@example.com") {
+		t.Fatalf("escalation events = %v", events)
+	}
+	if !f.logged(t, run.ID, "signal timeout escalated") {
+		t.Fatal("escalation not logged")
+	}
+
+	// the escalated review times out for good
+	f.clock.advance(time.Hour)
+	f.expire(t)
+	if d := f.decision(t, run.ID); d.Decision != "rejected" || d.Actor != timeoutActor {
+		t.Fatalf("decision = %+v", d)
+	}
+	if got := f.run(t, run.ID); got.Status != engine.RunStatusRejected {
+		t.Fatalf("run = %s, want rejected", got.Status)
+	}
+	if n := len(f.store.Events()); n != 1 {
+		t.Fatalf("%d events, want the escalation email only", n)
+	}
+}
+
+func TestExpireDueRejectsEscalationWithoutRecipient(t *testing.T) {
+	f := newFixture(t)
+	run, _ := f.park(t, engine.WaitSpec{Reason: "approve draft", TimeoutSeconds: 60, OnTimeout: engine.TimeoutEscalate})
+
+	f.clock.advance(time.Minute)
+	f.expire(t)
+	if d := f.decision(t, run.ID); d.Decision != "rejected" {
+		t.Fatalf("decision = %+v", d)
+	}
+	if !f.logged(t, run.ID, "signal timeout escalation has no recipient, rejecting") {
+		t.Fatal("missing recipient not logged")
+	}
+	if n := len(f.store.Events()); n != 0 {
+		t.Fatalf("%d events enqueued without a recipient", n)
+	}
+}
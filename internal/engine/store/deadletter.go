@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"gorm.io/gorm"
+)
+
+func toEngineEvent(e *entity.OutboxEvent) *eng.OutboxEvent {
+	return &eng.OutboxEvent{
+		ID: e.ID, Seq: e.Seq, EventType: e.EventType, Payload: e.Payload, State: e.State, IdempotencyKey: e.IdempotencyKey,
+		Published: e.Published, Attempts: e.Attempts, Error: e.Error, NextAttemptAt: e.NextAttemptAt,
+		TraceContext: decodeCarrier(e.TraceContext), CreatedAt: e.CreatedAt,
+	}
+}
+
+func (s *PostgresStore) LoadStep(ctx context.Context, stepID string) (*eng.WorkflowStepRecord, error) {
+	var ent entity.WorkflowStep
+	if err := s.conn(ctx).First(&ent, "id = ?", stepID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toEngineStep(&ent), nil
+}
+
+func (s *PostgresStore) ListDeadLetterSteps(ctx context.Context, filter eng.DeadLetterFilter) ([]*eng.WorkflowStepRecord, int64, error) {
+	q := s.conn(ctx).Model(&entity.WorkflowStep{}).
+		Where("workflow_steps.status = ?", eng.StepStatusFailed)
+	if filter.WorkspaceID != "" || filter.WorkflowType != "" {
+		q = q.Joins("JOIN workflow_runs ON workflow_runs.id = workflow_steps.run_id")
+		if filter.WorkspaceID != "" {
+			q = q.Where("workflow_runs.workspace_id = ?", filter.WorkspaceID)
+		}
+		if filter.WorkflowType != "" {
+			q = q.Where("workflow_runs.workflow_type = ?", filter.WorkflowType)
+		}
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	q = q.Order("workflow_steps.updated_at DESC")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		q = q.Offset(filter.Offset)
+	}
+	var ents []entity.WorkflowStep
+	if err := q.Find(&ents).Error; err != nil {
+		return nil, 0, err
+	}
+	return toEngineSteps(ents), total, nil
+}
+
+func (s *PostgresStore) ReplayStep(ctx context.Context, stepID string, input []byte) (bool, error) {
+	replayed := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		var step entity.WorkflowStep
+		err := tx.Raw(`SELECT * FROM workflow_steps WHERE id = ? AND status = ? FOR UPDATE`, stepID, eng.StepStatusFailed).Scan(&step).Error
+		if err != nil || step.ID == "" {
+			return err
+		}
+		// a cancelled run stays cancelled and a compensated run is not
+		// reopened; their failed steps can only be discarded. A replayed
+		// compensation step resumes the compensation instead.
+		reopen := tx.Model(&entity.WorkflowRun{}).
+			Where("id = ? AND status IN ? AND compensation = ''", step.RunID, []string{eng.RunStatusRunning, eng.RunStatusFailed})
+		runUpdates := map[string]interface{}{"status": eng.RunStatusRunning, "updated_at": time.Now()}
+		if step.Kind == eng.StepKindCompensation {
+			reopen = tx.Model(&entity.WorkflowRun{}).
+				Where("id = ? AND status IN ?", step.RunID, []string{eng.RunStatusFailed, eng.RunStatusCancelled})
+			runUpdates = map[string]interface{}{"compensation": eng.CompensationRunning, "updated_at": time.Now()}
+		}
+		res := reopen.Updates(runUpdates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		updates := map[string]interface{}{
+			"status":          eng.StepStatusPending,
+			"attempts":        0,
+			"next_attempt_at": nil,
+			"claimed_at":      nil,
+			"lock_owner":      nil,
+			"error":           nil,
+			"updated_at":      time.Now(),
+		}
+		if len(input) > 0 {
+			// a replacement input replaces the template it was bound from, and
+			// the step's side effects are no longer those of the old input
+			updates["input"] = input
+			updates["input_template"] = nil
+			updates["idempotency_key"] = eng.ReplayStepKey(toEngineStep(&step), input)
+		}
+		if err := tx.Model(&entity.WorkflowStep{}).Where("id = ?", stepID).Updates(updates).Error; err != nil {
+			return err
+		}
+		replayed = true
+		return notifyStepsReady(tx)
+	})
+	return replayed, err
+}
+
+func (s *PostgresStore) DiscardStep(ctx context.Context, stepID string) (bool, error) {
+	res := s.conn(ctx).Model(&entity.WorkflowStep{}).
+		Where("id = ? AND status = ?", stepID, eng.StepStatusFailed).
+		Updates(map[string]interface{}{"status": eng.StepStatusDiscarded, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) LoadEvent(ctx context.Context, eventID string) (*eng.OutboxEvent, error) {
+	var ent entity.OutboxEvent
+	if err := s.conn(ctx).First(&ent, "id = ?", eventID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toEngineEvent(&ent), nil
+}
+
+func (s *PostgresStore) ListDeadLetterEvents(ctx context.Context, filter eng.DeadLetterFilter) ([]*eng.OutboxEvent, int64, error) {
+	q := s.conn(ctx).Model(&entity.OutboxEvent{}).Where("state = ?", eng.OutboxStateFailed)
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	q = q.Order("created_at DESC")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		q = q.Offset(filter.Offset)
+	}
+	var ents []entity.OutboxEvent
+	if err := q.Find(&ents).Error; err != nil {
+		return nil, 0, err
+	}
+	events := make([]*eng.OutboxEvent, 0, len(ents))
+	for i := range ents {
+		events = append(events, toEngineEvent(&ents[i]))
+	}
+	return events, total, nil
+}
+
+func (s *PostgresStore) ReplayEvent(ctx context.Context, eventID string, payload []byte) (bool, error) {
+	updates := map[string]interface{}{
+		"state":           eng.OutboxStatePending,
+		"published":       false,
+		"attempts":        0,
+		"error":           nil,
+		"next_attempt_at": nil,
+	}
+	if len(payload) > 0 {
+		updates["payload"] = payload
+	}
+	res := s.conn(ctx).Model(&entity.OutboxEvent{}).
+		Where("id = ? AND state = ?", eventID, eng.OutboxStateFailed).
+		Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) AppendEventAudit(ctx context.Context, audit *eng.EventAudit) error {
+	ent := &entity.OutboxEventAudit{
+		ID: audit.ID, EventID: audit.EventID, Level: audit.Level, Message: audit.Message, Meta: audit.Meta, CreatedAt: time.Now(),
+	}
+	return s.conn(ctx).Create(ent).Error
+}
+
+func (s *PostgresStore) ListEventAudits(ctx context.Context, eventID string) ([]*eng.EventAudit, error) {
+	var ents []entity.OutboxEventAudit
+	if err := s.conn(ctx).Where("event_id = ?", eventID).Order("created_at").Find(&ents).Error; err != nil {
+		return nil, err
+	}
+	audits := make([]*eng.EventAudit, 0, len(ents))
+	for _, e := range ents {
+		audits = append(audits, &eng.EventAudit{
+			ID: e.ID, EventID: e.EventID, Level: e.Level, Message: e.Message, Meta: e.Meta, CreatedAt: e.CreatedAt,
+		})
+	}
+	return audits, nil
+}
+
+func (s *PostgresStore) DiscardEvent(ctx context.Context, eventID string) (bool, error) {
+	res := s.conn(ctx).Model(&entity.OutboxEvent{}).
+		Where("id = ? AND state = ?", eventID, eng.OutboxStateFailed).
+		Update("state", eng.OutboxStateDiscarded)
+	return res.RowsAffected > 0, res.Error
+}
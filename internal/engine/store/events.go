@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"math"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func toEngineSubscription(e *entity.EventSubscription) *eng.EventSubscription {
+	sub := &eng.EventSubscription{
+		Name: e.Name, Pattern: e.Pattern, AckedSeq: e.AckedSeq, LeasedUntil: e.LeasedUntil,
+		CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt,
+	}
+	if e.LeaseOwner != nil {
+		sub.LeaseOwner = *e.LeaseOwner
+	}
+	return sub
+}
+
+// ReadEvents returns events in commit order. Sequence numbers are taken when
+// an event is inserted, not when its transaction commits, so a reader that
+// moved past an event still being committed would skip it for good. Every
+// transaction that can still commit an event below another one had its ID
+// before that event's horizon was recorded, so ReadEvents stops at the
+// first event whose horizon is not behind every running transaction.
+// Events stored before horizons were recorded have none and never stop it.
+func (s *PostgresStore) ReadEvents(ctx context.Context, after int64, pattern string, limit int) ([]*eng.OutboxEvent, error) {
+	var rows []entity.OutboxEvent
+	err := s.conn(ctx).
+		Where("seq > ? AND event_type ~ ?", after, eng.TopicRegexp(pattern)).
+		Where("seq < (SELECT COALESCE(min(seq), ?) FROM outbox_events WHERE seq > ? AND horizon > pg_snapshot_xmin(pg_current_snapshot()))", int64(math.MaxInt64), after).
+		Order("seq").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*eng.OutboxEvent, 0, len(rows))
+	for i := range rows {
+		out = append(out, toEngineEvent(&rows[i]))
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) LastEventSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := s.conn(ctx).Model(&entity.OutboxEvent{}).Select("COALESCE(max(seq), 0)").Scan(&seq).Error
+	return seq, err
+}
+
+func (s *PostgresStore) EnsureSubscription(ctx context.Context, sub *eng.EventSubscription) (*eng.EventSubscription, error) {
+	ent := &entity.EventSubscription{Name: sub.Name, Pattern: sub.Pattern, AckedSeq: sub.AckedSeq}
+	err := s.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"pattern": sub.Pattern, "updated_at": gorm.Expr("now()")}),
+	}).Create(ent).Error
+	if err != nil {
+		return nil, err
+	}
+	var stored entity.EventSubscription
+	if err := s.conn(ctx).First(&stored, "name = ?", sub.Name).Error; err != nil {
+		return nil, err
+	}
+	return toEngineSubscription(&stored), nil
+}
+
+func (s *PostgresStore) LeaseSubscription(ctx context.Context, name, owner string, ttl time.Duration) (*eng.EventSubscription, error) {
+	var rows []entity.EventSubscription
+	err := s.conn(ctx).Model(&rows).
+		Clauses(clause.Returning{}).
+		Where("name = ? AND (lease_owner IS NULL OR lease_owner = ? OR leased_until < now())", name, owner).
+		Updates(map[string]interface{}{
+			"lease_owner":  owner,
+			"leased_until": gorm.Expr("now() + make_interval(secs => ?)", ttl.Seconds()),
+		}).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return toEngineSubscription(&rows[0]), nil
+}
+
+func (s *PostgresStore) AckSubscription(ctx context.Context, name, owner string, seq int64) (bool, error) {
+	res := s.conn(ctx).Model(&entity.EventSubscription{}).
+		Where("name = ? AND lease_owner = ? AND acked_seq < ?", name, owner, seq).
+		Updates(map[string]interface{}{"acked_seq": seq, "updated_at": gorm.Expr("now()")})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) ReleaseSubscription(ctx context.Context, name, owner string) error {
+	return s.conn(ctx).Model(&entity.EventSubscription{}).
+		Where("name = ? AND lease_owner = ?", name, owner).
+		Updates(map[string]interface{}{"lease_owner": nil, "leased_until": nil}).Error
+}
+
+func (s *PostgresStore) ListSubscriptions(ctx context.Context) ([]*eng.EventSubscription, error) {
+	var rows []entity.EventSubscription
+	if err := s.conn(ctx).Order("name").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]*eng.EventSubscription, 0, len(rows))
+	for i := range rows {
+		out = append(out, toEngineSubscription(&rows[i]))
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) DeleteSubscription(ctx context.Context, name string) (bool, error) {
+	res := s.conn(ctx).Delete(&entity.EventSubscription{}, "name = ?", name)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,1133 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
+)
+
+// MemoryStore is an in-memory StateStore for tests and local tools. It
+// follows the claim, lease, heartbeat and requeue semantics of
+// PostgresStore, including concurrency caps, but compares times against
+// the engine clock (engine.Now) so tests can move time forward. Stored
+// records are copied in and out, so callers never share them with the store.
+type MemoryStore struct {
+	mu sync.Mutex
+	// txMu serializes Atomic calls
+	txMu     sync.Mutex
+	limits   eng.ConcurrencyLimits
+	runs     map[string]*eng.WorkflowRun
+	runOrder []string
+	steps    map[string]*eng.WorkflowStepRecord
+	// stepOrder keeps insertion order, which breaks ties between steps
+	// created at the same engine time
+	stepOrder   []string
+	logs        []*eng.StepLog
+	eventAudits []*eng.EventAudit
+	events      []*eng.OutboxEvent
+	eventSeq    int64
+	subs        map[string]*eng.EventSubscription
+	pauses      map[string]*eng.WorkflowPause
+	triggers    map[string]*eng.WorkflowTrigger
+	trigOrder   []string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		runs:     make(map[string]*eng.WorkflowRun),
+		steps:    make(map[string]*eng.WorkflowStepRecord),
+		pauses:   make(map[string]*eng.WorkflowPause),
+		triggers: make(map[string]*eng.WorkflowTrigger),
+		subs:     make(map[string]*eng.EventSubscription),
+	}
+}
+
+// WithLimits sets the concurrency caps enforced when steps are claimed.
+func (s *MemoryStore) WithLimits(limits eng.ConcurrencyLimits) *MemoryStore {
+	s.limits = limits
+	return s
+}
+
+// memTxKey marks a context inside MemoryStore.Atomic; its value is the
+// store.
+type memTxKey struct{}
+
+// Atomic runs fn and, when it fails, restores the store to its state before
+// the call, like a rolled back transaction. Atomic calls are serialized and
+// a nested call rolls back to where it started, like a savepoint. Unlike
+// Postgres, writes made outside Atomic while fn runs are rolled back too.
+func (s *MemoryStore) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memTxKey{}) != s {
+		s.txMu.Lock()
+		defer s.txMu.Unlock()
+		ctx = context.WithValue(ctx, memTxKey{}, s)
+	}
+	snap := s.snapshot()
+	if err := fn(ctx); err != nil {
+		s.restore(snap)
+		return err
+	}
+	return nil
+}
+
+// snapshot copies the store's state for restore.
+func (s *MemoryStore) snapshot() *MemoryStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &MemoryStore{
+		runs:        make(map[string]*eng.WorkflowRun, len(s.runs)),
+		runOrder:    append([]string(nil), s.runOrder...),
+		steps:       make(map[string]*eng.WorkflowStepRecord, len(s.steps)),
+		stepOrder:   append([]string(nil), s.stepOrder...),
+		logs:        append([]*eng.StepLog(nil), s.logs...),
+		eventAudits: append([]*eng.EventAudit(nil), s.eventAudits...),
+		events:      make([]*eng.OutboxEvent, 0, len(s.events)),
+		eventSeq:    s.eventSeq,
+		subs:        make(map[string]*eng.EventSubscription, len(s.subs)),
+		pauses:      make(map[string]*eng.WorkflowPause, len(s.pauses)),
+		triggers:    make(map[string]*eng.WorkflowTrigger, len(s.triggers)),
+		trigOrder:   append([]string(nil), s.trigOrder...),
+	}
+	for id, r := range s.runs {
+		c.runs[id] = copyRun(r)
+	}
+	for id, st := range s.steps {
+		c.steps[id] = copyStep(st)
+	}
+	for _, ev := range s.events {
+		c.events = append(c.events, copyEvent(ev))
+	}
+	for id, sub := range s.subs {
+		c.subs[id] = copySubscription(sub)
+	}
+	for wt, p := range s.pauses {
+		pc := *p
+		c.pauses[wt] = &pc
+	}
+	for id, t := range s.triggers {
+		c.triggers[id] = copyTrigger(t)
+	}
+	return c
+}
+
+// restore puts back the state snap took.
+func (s *MemoryStore) restore(snap *MemoryStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs, s.runOrder = snap.runs, snap.runOrder
+	s.steps, s.stepOrder = snap.steps, snap.stepOrder
+	s.logs, s.eventAudits = snap.logs, snap.eventAudits
+	s.events, s.eventSeq = snap.events, snap.eventSeq
+	s.subs, s.pauses = snap.subs, snap.pauses
+	s.triggers, s.trigOrder = snap.triggers, snap.trigOrder
+}
+
+// Events returns every outbox event enqueued so far, oldest first.
+func (s *MemoryStore) Events() []*eng.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*eng.OutboxEvent, 0, len(s.events))
+	for _, ev := range s.events {
+		out = append(out, copyEvent(ev))
+	}
+	return out
+}
+
+func copyRun(r *eng.WorkflowRun) *eng.WorkflowRun {
+	c := *r
+	c.Steps = nil
+	return &c
+}
+
+func copyStep(st *eng.WorkflowStepRecord) *eng.WorkflowStepRecord {
+	c := *st
+	return &c
+}
+
+func copyEvent(ev *eng.OutboxEvent) *eng.OutboxEvent {
+	c := *ev
+	return &c
+}
+
+func copyTrigger(t *eng.WorkflowTrigger) *eng.WorkflowTrigger {
+	c := *t
+	return &c
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+// due reports whether a pending step's next attempt time has come.
+func due(st *eng.WorkflowStepRecord, now time.Time) bool {
+	return st.NextAttemptAt == nil || !st.NextAttemptAt.After(now)
+}
+
+// paused reports whether steps of run may not be claimed.
+func (s *MemoryStore) paused(run *eng.WorkflowRun) bool {
+	if run == nil {
+		return false
+	}
+	_, ok := s.pauses[run.WorkflowType]
+	return run.PausedAt != nil || ok
+}
+
+// stepsOf returns the steps of a run ordered by seq and creation.
+func (s *MemoryStore) stepsOf(runID string) []*eng.WorkflowStepRecord {
+	var out []*eng.WorkflowStepRecord
+	for _, id := range s.stepOrder {
+		if st := s.steps[id]; st.RunID == runID {
+			out = append(out, st)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Seq != out[j].Seq {
+			return out[i].Seq < out[j].Seq
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+func (s *MemoryStore) CreateRun(ctx context.Context, run *eng.WorkflowRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[run.ID]; ok {
+		return fmt.Errorf("store: run %s already exists", run.ID)
+	}
+	now := eng.Now()
+	c := copyRun(run)
+	c.CreatedAt, c.UpdatedAt = now, now
+	s.runs[run.ID] = c
+	s.runOrder = append(s.runOrder, run.ID)
+	run.CreatedAt, run.UpdatedAt = now, now
+	return nil
+}
+
+func (s *MemoryStore) LoadRun(ctx context.Context, runID string) (*eng.WorkflowRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[runID]
+	if !ok {
+		return nil, nil
+	}
+	run := copyRun(r)
+	steps := s.stepsOf(runID)
+	run.Steps = make([]eng.WorkflowStepRecord, 0, len(steps))
+	for _, st := range steps {
+		run.Steps = append(run.Steps, *st)
+	}
+	return run, nil
+}
+
+func (s *MemoryStore) UpdateRun(ctx context.Context, run *eng.WorkflowRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.runs[run.ID]; ok {
+		r.Status, r.Compensation, r.UpdatedAt = run.Status, run.Compensation, eng.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) MigrateRun(ctx context.Context, run *eng.WorkflowRun, from string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[run.ID]
+	if !ok || r.Status != eng.RunStatusRunning || r.WorkflowVersion != from {
+		return false, nil
+	}
+	for _, st := range s.stepsOf(run.ID) {
+		if st.Status == eng.StepStatusInProgress {
+			return false, nil
+		}
+	}
+	r.WorkflowVersion, r.Payload, r.UpdatedAt = run.WorkflowVersion, run.Payload, eng.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) ListRuns(ctx context.Context, filter eng.RunFilter) ([]*eng.WorkflowRun, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []*eng.WorkflowRun
+	// newest first; later insertions win ties
+	for i := len(s.runOrder) - 1; i >= 0; i-- {
+		r := s.runs[s.runOrder[i]]
+		if (filter.WorkspaceID != "" && r.WorkspaceID != filter.WorkspaceID) ||
+			(filter.WorkflowType != "" && r.WorkflowType != filter.WorkflowType) ||
+			(filter.Status != "" && r.Status != filter.Status) ||
+			(filter.ParentRunID != "" && r.ParentRunID != filter.ParentRunID) {
+			continue
+		}
+		matches = append(matches, r)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].CreatedAt.After(matches[j].CreatedAt) })
+	total := int64(len(matches))
+	matches = page(matches, filter.Limit, filter.Offset)
+	runs := make([]*eng.WorkflowRun, 0, len(matches))
+	for _, r := range matches {
+		runs = append(runs, copyRun(r))
+	}
+	return runs, total, nil
+}
+
+// page applies a limit and offset to a slice.
+func page[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return nil
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (s *MemoryStore) CancelRun(ctx context.Context, runID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[runID]
+	if !ok || r.Status != eng.RunStatusRunning {
+		return false, nil
+	}
+	now := eng.Now()
+	r.Status, r.UpdatedAt = eng.RunStatusCancelled, now
+	for _, st := range s.stepsOf(runID) {
+		switch st.Status {
+		case eng.StepStatusPending, eng.StepStatusHeld, eng.StepStatusWaitingForChildren, eng.StepStatusWaitingForSignal:
+			st.Status, st.UpdatedAt = eng.StepStatusCancelled, now
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) PauseRun(ctx context.Context, runID string, paused bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[runID]
+	if !ok || r.Status != eng.RunStatusRunning || (r.PausedAt != nil) == paused {
+		return false, nil
+	}
+	now := eng.Now()
+	r.PausedAt, r.UpdatedAt = nil, now
+	if paused {
+		r.PausedAt = timePtr(now)
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) PauseWorkflow(ctx context.Context, p *eng.WorkflowPause) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.pauses[p.WorkflowType]; ok {
+		existing.Reason, existing.PausedBy = p.Reason, p.PausedBy
+		p.CreatedAt = existing.CreatedAt
+		return nil
+	}
+	c := *p
+	c.CreatedAt = eng.Now()
+	s.pauses[p.WorkflowType] = &c
+	p.CreatedAt = c.CreatedAt
+	return nil
+}
+
+func (s *MemoryStore) ResumeWorkflow(ctx context.Context, workflowType string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pauses[workflowType]; !ok {
+		return false, nil
+	}
+	delete(s.pauses, workflowType)
+	return true, nil
+}
+
+func (s *MemoryStore) ListWorkflowPauses(ctx context.Context) ([]*eng.WorkflowPause, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pauses := make([]*eng.WorkflowPause, 0, len(s.pauses))
+	for _, p := range s.pauses {
+		c := *p
+		pauses = append(pauses, &c)
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i].WorkflowType < pauses[j].WorkflowType })
+	return pauses, nil
+}
+
+func (s *MemoryStore) ListExpiredRuns(ctx context.Context, limit int) ([]*eng.WorkflowRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	var expired []*eng.WorkflowRun
+	for _, id := range s.runOrder {
+		r := s.runs[id]
+		if r.Status == eng.RunStatusRunning && r.Deadline != nil && !r.Deadline.After(now) {
+			expired = append(expired, copyRun(r))
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool { return expired[i].Deadline.Before(*expired[j].Deadline) })
+	return page(expired, limit, 0), nil
+}
+
+func (s *MemoryStore) InsertSteps(ctx context.Context, steps []*eng.WorkflowStepRecord) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// steps are unique per run and seq, as enforced by ux_workflow_steps_run_seq
+	seqs := make(map[string]bool)
+	keys := make(map[string]bool)
+	last := make(map[string]int)
+	for _, st := range s.steps {
+		seqs[fmt.Sprintf("%s/%d", st.RunID, st.Seq)] = true
+		if st.IdempotencyKey != nil {
+			keys[st.RunID+"/"+*st.IdempotencyKey] = true
+		}
+		if st.Seq > last[st.RunID] {
+			last[st.RunID] = st.Seq
+		}
+	}
+	// steps without a seq are numbered after the last step of their run and
+	// skipped when a concurrent planner already inserted them
+	numbered := make([]*eng.WorkflowStepRecord, 0, len(steps))
+	for _, st := range steps {
+		if st.Seq == 0 {
+			if st.IdempotencyKey != nil && keys[st.RunID+"/"+*st.IdempotencyKey] {
+				continue
+			}
+			last[st.RunID]++
+			st.Seq = last[st.RunID]
+			if st.IdempotencyKey == nil {
+				key := eng.StepKey(st)
+				st.IdempotencyKey = &key
+			}
+		}
+		numbered = append(numbered, st)
+	}
+	steps = numbered
+	for _, st := range steps {
+		key := fmt.Sprintf("%s/%d", st.RunID, st.Seq)
+		if _, ok := s.steps[st.ID]; ok || seqs[key] {
+			return fmt.Errorf("store: duplicate step %s (run %s, seq %d)", st.ID, st.RunID, st.Seq)
+		}
+		seqs[key] = true
+	}
+	now := eng.Now()
+	for _, st := range steps {
+		c := copyStep(st)
+		if c.Kind == "" {
+			c.Kind = eng.StepKindTask
+		}
+		if c.MaxAttempts == 0 {
+			c.MaxAttempts = 5
+		}
+		c.WorkflowType = ""
+		c.CreatedAt, c.UpdatedAt = now, now
+		s.steps[c.ID] = c
+		s.stepOrder = append(s.stepOrder, c.ID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimNextStep(ctx context.Context, workerID string) (*eng.WorkflowStepRecord, error) {
+	steps, err := s.ClaimNextSteps(ctx, workerID, 1)
+	if err != nil || len(steps) == 0 {
+		return nil, err
+	}
+	return steps[0], nil
+}
+
+// ClaimNextSteps mirrors PostgresStore's claimQuery: ready steps are ranked
+// within their workspace and workflow type, a step is eligible while its
+// rank plus the steps already in progress stays within the caps, and
+// eligible steps are taken highest priority first, round-robin across
+// workspaces.
+func (s *MemoryStore) ClaimNextSteps(ctx context.Context, workerID string, n int) ([]*eng.WorkflowStepRecord, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+
+	type candidate struct {
+		step          *eng.WorkflowStepRecord
+		run           *eng.WorkflowRun
+		order         int
+		workspaceRank int
+		workflowRank  int
+	}
+	var ready []*candidate
+	runningWorkspace := make(map[string]int)
+	runningWorkflow := make(map[string]int)
+	for i, id := range s.stepOrder {
+		st := s.steps[id]
+		run := s.runs[st.RunID]
+		switch {
+		case run == nil:
+		case st.Status == eng.StepStatusInProgress:
+			runningWorkspace[run.WorkspaceID]++
+			runningWorkflow[run.WorkflowType]++
+		case st.Status == eng.StepStatusPending && due(st, now) && !s.paused(run):
+			ready = append(ready, &candidate{step: st, run: run, order: i})
+		}
+	}
+	less := func(a, b *candidate) bool {
+		if a.step.Priority != b.step.Priority {
+			return a.step.Priority > b.step.Priority
+		}
+		if a.step.Seq != b.step.Seq {
+			return a.step.Seq < b.step.Seq
+		}
+		if !a.step.CreatedAt.Equal(b.step.CreatedAt) {
+			return a.step.CreatedAt.Before(b.step.CreatedAt)
+		}
+		return a.order < b.order
+	}
+	sort.SliceStable(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+	workspaceRanks := make(map[string]int)
+	workflowRanks := make(map[string]int)
+	var eligible []*candidate
+	for _, c := range ready {
+		workspaceRanks[c.run.WorkspaceID]++
+		workflowRanks[c.run.WorkflowType]++
+		c.workspaceRank = workspaceRanks[c.run.WorkspaceID]
+		c.workflowRank = workflowRanks[c.run.WorkflowType]
+		if limit := s.workspaceCap(c.run.WorkspaceID); limit > 0 && c.workspaceRank+runningWorkspace[c.run.WorkspaceID] > limit {
+			continue
+		}
+		if limit := s.limits.Workflows[c.run.WorkflowType]; limit > 0 && c.workflowRank+runningWorkflow[c.run.WorkflowType] > limit {
+			continue
+		}
+		eligible = append(eligible, c)
+	}
+	sort.SliceStable(eligible, func(i, j int) bool {
+		a, b := eligible[i], eligible[j]
+		if a.step.Priority != b.step.Priority {
+			return a.step.Priority > b.step.Priority
+		}
+		if a.workspaceRank != b.workspaceRank {
+			return a.workspaceRank < b.workspaceRank
+		}
+		return less(a, b)
+	})
+
+	claimed := make([]*eng.WorkflowStepRecord, 0, n)
+	for _, c := range page(eligible, n, 0) {
+		owner := workerID
+		c.step.Status = eng.StepStatusInProgress
+		c.step.LockOwner = &owner
+		c.step.ClaimedAt, c.step.LastHeartbeat, c.step.UpdatedAt = timePtr(now), timePtr(now), now
+		out := copyStep(c.step)
+		out.WorkflowType = c.run.WorkflowType
+		claimed = append(claimed, out)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) workspaceCap(workspaceID string) int {
+	if limit, ok := s.limits.Workspaces[workspaceID]; ok {
+		return limit
+	}
+	return s.limits.Workspace
+}
+
+// leased returns the stored step while workerID still holds the lease step
+// was claimed with, as PostgresStore's leased scope does.
+func (s *MemoryStore) leased(step *eng.WorkflowStepRecord, workerID string) *eng.WorkflowStepRecord {
+	st, ok := s.steps[step.ID]
+	if !ok || st.Status != eng.StepStatusInProgress || st.LockOwner == nil || *st.LockOwner != workerID {
+		return nil
+	}
+	if step.ClaimedAt != nil && (st.ClaimedAt == nil || !st.ClaimedAt.Equal(*step.ClaimedAt)) {
+		return nil
+	}
+	return st
+}
+
+func (s *MemoryStore) UpdateStep(ctx context.Context, step *eng.WorkflowStepRecord, workerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.leased(step, workerID)
+	if st == nil {
+		return false, nil
+	}
+	st.Status, st.Result, st.Attempts = step.Status, step.Result, step.Attempts
+	st.NextAttemptAt, st.LockOwner, st.Error = step.NextAttemptAt, step.LockOwner, step.Error
+	st.UpdatedAt = eng.Now()
+	// the input bound from InputTemplate at claim time
+	if len(step.InputTemplate) > 0 && len(step.Input) > 0 {
+		st.Input = step.Input
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) TransitionStep(ctx context.Context, step *eng.WorkflowStepRecord, from string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.steps[step.ID]
+	if !ok || st.Status != from {
+		return false, nil
+	}
+	st.Status, st.Result, st.NextAttemptAt = step.Status, step.Result, step.NextAttemptAt
+	st.LockOwner, st.Error, st.UpdatedAt = step.LockOwner, step.Error, eng.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) AppendLog(ctx context.Context, logRec *eng.StepLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *logRec
+	c.CreatedAt = eng.Now()
+	s.logs = append(s.logs, &c)
+	return nil
+}
+
+func (s *MemoryStore) ListRunLogs(ctx context.Context, runID string) ([]*eng.StepLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var logs []*eng.StepLog
+	for _, l := range s.logs {
+		if st, ok := s.steps[l.StepID]; ok && st.RunID == runID {
+			c := *l
+			logs = append(logs, &c)
+		}
+	}
+	return logs, nil
+}
+
+func (s *MemoryStore) EnqueueEvent(ctx context.Context, ev *eng.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev.IdempotencyKey != nil {
+		for _, e := range s.events {
+			if e.IdempotencyKey != nil && *e.IdempotencyKey == *ev.IdempotencyKey {
+				return nil
+			}
+		}
+	}
+	c := copyEvent(ev)
+	if c.State == "" {
+		c.State = eng.OutboxStatePending
+	}
+	// events enqueued by a traced step are published in the same trace
+	if len(c.TraceContext) == 0 {
+		c.TraceContext = tracing.Inject(ctx)
+	}
+	c.CreatedAt = eng.Now()
+	s.eventSeq++
+	c.Seq = s.eventSeq
+	ev.Seq = c.Seq
+	s.events = append(s.events, c)
+	return nil
+}
+
+func (s *MemoryStore) LoadEventByKey(ctx context.Context, key string) (*eng.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.IdempotencyKey != nil && *e.IdempotencyKey == key {
+			return copyEvent(e), nil
+		}
+	}
+	return nil, nil
+}
+
+// RequeueStaleSteps applies PostgresStore's rules: the attempt is counted,
+// the step backs off for heartbeatTTLSeconds doubled per attempt (at most an
+// hour) and fails once it reaches its max attempts.
+func (s *MemoryStore) RequeueStaleSteps(ctx context.Context, heartbeatTTLSeconds int, limit int) (int, []*eng.WorkflowStepRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	stale := now.Add(-time.Duration(heartbeatTTLSeconds) * time.Second)
+	var rows []*eng.WorkflowStepRecord
+	for _, id := range s.stepOrder {
+		st := s.steps[id]
+		if st.Status == eng.StepStatusInProgress && (st.LastHeartbeat == nil || st.LastHeartbeat.Before(stale)) {
+			rows = append(rows, st)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].LastHeartbeat == nil || rows[j].LastHeartbeat == nil {
+			return rows[j].LastHeartbeat == nil && rows[i].LastHeartbeat != nil
+		}
+		return rows[i].LastHeartbeat.Before(*rows[j].LastHeartbeat)
+	})
+	rows = page(rows, limit, 0)
+
+	requeued := 0
+	var failed []*eng.WorkflowStepRecord
+	for _, st := range rows {
+		attempts := st.Attempts + 1
+		backoff, retry := staleBackoff(st, attempts, heartbeatTTLSeconds)
+		st.Attempts, st.LockOwner, st.UpdatedAt = attempts, nil, now
+		if !retry {
+			msg := staleStepError
+			st.Status, st.Error = eng.StepStatusFailed, &msg
+			c := copyStep(st)
+			if run := s.runs[st.RunID]; run != nil {
+				c.WorkflowType = run.WorkflowType
+			}
+			failed = append(failed, c)
+			continue
+		}
+		st.Status, st.ClaimedAt = eng.StepStatusPending, nil
+		st.NextAttemptAt = timePtr(now.Add(backoff))
+		requeued++
+	}
+	return requeued, failed, nil
+}
+
+func (s *MemoryStore) UpdateWaitingStep(ctx context.Context, step *eng.WorkflowStepRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.steps[step.ID]
+	if !ok || st.Status != eng.StepStatusWaitingForSignal {
+		return false, nil
+	}
+	st.Status, st.Result, st.NextAttemptAt, st.UpdatedAt = step.Status, step.Result, step.NextAttemptAt, eng.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) ListWaitingSteps(ctx context.Context, workspaceID string) ([]*eng.WorkflowStepRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*eng.WorkflowStepRecord
+	for _, id := range s.stepOrder {
+		st := s.steps[id]
+		if st.Status != eng.StepStatusWaitingForSignal {
+			continue
+		}
+		if run := s.runs[st.RunID]; workspaceID != "" && (run == nil || run.WorkspaceID != workspaceID) {
+			continue
+		}
+		out = append(out, copyStep(st))
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.Before(out[j].UpdatedAt) })
+	return out, nil
+}
+
+func (s *MemoryStore) ListExpiredWaitingSteps(ctx context.Context, limit int) ([]*eng.WorkflowStepRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	var out []*eng.WorkflowStepRecord
+	for _, id := range s.stepOrder {
+		st := s.steps[id]
+		if st.Status == eng.StepStatusWaitingForSignal && st.NextAttemptAt != nil && !st.NextAttemptAt.After(now) {
+			out = append(out, copyStep(st))
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].NextAttemptAt.Before(*out[j].NextAttemptAt) })
+	return page(out, limit, 0), nil
+}
+
+func (s *MemoryStore) HeartbeatStep(ctx context.Context, step *eng.WorkflowStepRecord, workerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.leased(step, workerID)
+	if st == nil {
+		return false, nil
+	}
+	st.LastHeartbeat = timePtr(eng.Now())
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseSteps(ctx context.Context, workerID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	released := 0
+	for _, st := range s.steps {
+		if st.Status == eng.StepStatusInProgress && st.LockOwner != nil && *st.LockOwner == workerID {
+			st.Status, st.LockOwner, st.ClaimedAt, st.UpdatedAt = eng.StepStatusPending, nil, nil, eng.Now()
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (s *MemoryStore) CountPendingSteps(ctx context.Context) ([]eng.PendingCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	counts := make(map[[2]string]int64)
+	for _, st := range s.steps {
+		run := s.runs[st.RunID]
+		if st.Status != eng.StepStatusPending || run == nil {
+			continue
+		}
+		state := "delayed"
+		switch {
+		case s.paused(run):
+			state = "paused"
+		case due(st, now):
+			state = "ready"
+		}
+		counts[[2]string{run.WorkflowType, state}]++
+	}
+	out := make([]eng.PendingCount, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, eng.PendingCount{WorkflowType: k[0], State: k[1], Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].WorkflowType != out[j].WorkflowType {
+			return out[i].WorkflowType < out[j].WorkflowType
+		}
+		return out[i].State < out[j].State
+	})
+	return out, nil
+}
+
+func (s *MemoryStore) LoadStep(ctx context.Context, stepID string) (*eng.WorkflowStepRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.steps[stepID]
+	if !ok {
+		return nil, nil
+	}
+	return copyStep(st), nil
+}
+
+func (s *MemoryStore) ListDeadLetterSteps(ctx context.Context, filter eng.DeadLetterFilter) ([]*eng.WorkflowStepRecord, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*eng.WorkflowStepRecord
+	for _, id := range s.stepOrder {
+		st := s.steps[id]
+		if st.Status != eng.StepStatusFailed {
+			continue
+		}
+		run := s.runs[st.RunID]
+		if (filter.WorkspaceID != "" || filter.WorkflowType != "") && run == nil {
+			continue
+		}
+		if (filter.WorkspaceID != "" && run.WorkspaceID != filter.WorkspaceID) ||
+			(filter.WorkflowType != "" && run.WorkflowType != filter.WorkflowType) {
+			continue
+		}
+		out = append(out, copyStep(st))
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return page(out, filter.Limit, filter.Offset), int64(len(out)), nil
+}
+
+func (s *MemoryStore) ReplayStep(ctx context.Context, stepID string, input []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.steps[stepID]
+	if !ok || st.Status != eng.StepStatusFailed {
+		return false, nil
+	}
+	run, ok := s.runs[st.RunID]
+	if !ok {
+		return false, nil
+	}
+	now := eng.Now()
+	// a cancelled run stays cancelled and a compensated run is not
+	// reopened; a replayed compensation step resumes the compensation
+	if st.Kind == eng.StepKindCompensation {
+		if run.Status != eng.RunStatusFailed && run.Status != eng.RunStatusCancelled {
+			return false, nil
+		}
+		run.Compensation = eng.CompensationRunning
+	} else {
+		if (run.Status != eng.RunStatusRunning && run.Status != eng.RunStatusFailed) || run.Compensation != "" {
+			return false, nil
+		}
+		run.Status = eng.RunStatusRunning
+	}
+	run.UpdatedAt = now
+	st.Status, st.Attempts = eng.StepStatusPending, 0
+	st.NextAttemptAt, st.ClaimedAt, st.LockOwner, st.Error = nil, nil, nil, nil
+	st.UpdatedAt = now
+	if len(input) > 0 {
+		// a replacement input replaces the template it was bound from, and
+		// the step's side effects are no longer those of the old input
+		key := eng.ReplayStepKey(st, input)
+		st.Input, st.InputTemplate, st.IdempotencyKey = input, nil, &key
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) DiscardStep(ctx context.Context, stepID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.steps[stepID]
+	if !ok || st.Status != eng.StepStatusFailed {
+		return false, nil
+	}
+	st.Status, st.UpdatedAt = eng.StepStatusDiscarded, eng.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) event(eventID string) *eng.OutboxEvent {
+	for _, e := range s.events {
+		if e.ID == eventID {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) LoadEvent(ctx context.Context, eventID string) (*eng.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.event(eventID); e != nil {
+		return copyEvent(e), nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) ListDeadLetterEvents(ctx context.Context, filter eng.DeadLetterFilter) ([]*eng.OutboxEvent, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*eng.OutboxEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if e := s.events[i]; e.State == eng.OutboxStateFailed {
+			out = append(out, copyEvent(e))
+		}
+	}
+	return page(out, filter.Limit, filter.Offset), int64(len(out)), nil
+}
+
+func (s *MemoryStore) ReplayEvent(ctx context.Context, eventID string, payload []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.event(eventID)
+	if e == nil || e.State != eng.OutboxStateFailed {
+		return false, nil
+	}
+	e.State, e.Published, e.Attempts, e.Error, e.NextAttemptAt = eng.OutboxStatePending, false, 0, nil, nil
+	if len(payload) > 0 {
+		e.Payload = payload
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) DiscardEvent(ctx context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.event(eventID)
+	if e == nil || e.State != eng.OutboxStateFailed {
+		return false, nil
+	}
+	e.State = eng.OutboxStateDiscarded
+	return true, nil
+}
+
+func (s *MemoryStore) AppendEventAudit(ctx context.Context, audit *eng.EventAudit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *audit
+	c.CreatedAt = eng.Now()
+	s.eventAudits = append(s.eventAudits, &c)
+	return nil
+}
+
+func (s *MemoryStore) ListEventAudits(ctx context.Context, eventID string) ([]*eng.EventAudit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var audits []*eng.EventAudit
+	for _, a := range s.eventAudits {
+		if a.EventID == eventID {
+			c := *a
+			audits = append(audits, &c)
+		}
+	}
+	return audits, nil
+}
+
+func (s *MemoryStore) CreateTrigger(ctx context.Context, t *eng.WorkflowTrigger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.triggers[t.ID]; ok {
+		return fmt.Errorf("store: trigger %s already exists", t.ID)
+	}
+	now := eng.Now()
+	c := copyTrigger(t)
+	if c.Timezone == "" {
+		c.Timezone = "UTC"
+	}
+	c.CreatedAt, c.UpdatedAt = now, now
+	s.triggers[t.ID] = c
+	s.trigOrder = append(s.trigOrder, t.ID)
+	t.CreatedAt, t.UpdatedAt = now, now
+	return nil
+}
+
+func (s *MemoryStore) LoadTrigger(ctx context.Context, triggerID string) (*eng.WorkflowTrigger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.triggers[triggerID]
+	if !ok {
+		return nil, nil
+	}
+	return copyTrigger(t), nil
+}
+
+func (s *MemoryStore) ListTriggers(ctx context.Context, workspaceID string) ([]*eng.WorkflowTrigger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*eng.WorkflowTrigger
+	for _, id := range s.trigOrder {
+		if t, ok := s.triggers[id]; ok && (workspaceID == "" || t.WorkspaceID == workspaceID) {
+			out = append(out, copyTrigger(t))
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) UpdateTrigger(ctx context.Context, t *eng.WorkflowTrigger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.triggers[t.ID]; ok {
+		c.Schedule, c.Timezone, c.Payload, c.Enabled = t.Schedule, t.Timezone, t.Payload, t.Enabled
+		c.NextRunAt, c.UpdatedAt = t.NextRunAt, eng.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteTrigger(ctx context.Context, triggerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.triggers[triggerID]; !ok {
+		return false, nil
+	}
+	delete(s.triggers, triggerID)
+	return true, nil
+}
+
+func (s *MemoryStore) ListDueTriggers(ctx context.Context, limit int) ([]*eng.WorkflowTrigger, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	var out []*eng.WorkflowTrigger
+	for _, id := range s.trigOrder {
+		if t, ok := s.triggers[id]; ok && t.Enabled && t.NextRunAt != nil && !t.NextRunAt.After(now) {
+			out = append(out, copyTrigger(t))
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].NextRunAt.Before(*out[j].NextRunAt) })
+	return page(out, limit, 0), nil
+}
+
+func (s *MemoryStore) MarkTriggerFired(ctx context.Context, triggerID string, scheduled time.Time, next *time.Time, runID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.triggers[triggerID]
+	if !ok || t.NextRunAt == nil || !t.NextRunAt.Equal(scheduled) {
+		return false, nil
+	}
+	id := runID
+	t.NextRunAt, t.LastRunAt, t.LastRunID, t.UpdatedAt = next, timePtr(scheduled), &id, eng.Now()
+	return true, nil
+}
+
+var _ eng.StateStore = (*MemoryStore)(nil)
+
+func copySubscription(sub *eng.EventSubscription) *eng.EventSubscription {
+	c := *sub
+	if sub.LeasedUntil != nil {
+		c.LeasedUntil = timePtr(*sub.LeasedUntil)
+	}
+	return &c
+}
+
+func (s *MemoryStore) ReadEvents(ctx context.Context, after int64, pattern string, limit int) ([]*eng.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*eng.OutboxEvent
+	for _, e := range s.events {
+		if len(out) == limit {
+			break
+		}
+		if e.Seq > after && eng.MatchTopic(pattern, e.EventType) {
+			out = append(out, copyEvent(e))
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) LastEventSeq(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eventSeq, nil
+}
+
+func (s *MemoryStore) EnsureSubscription(ctx context.Context, sub *eng.EventSubscription) (*eng.EventSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := eng.Now()
+	if stored, ok := s.subs[sub.Name]; ok {
+		stored.Pattern, stored.UpdatedAt = sub.Pattern, now
+		return copySubscription(stored), nil
+	}
+	c := &eng.EventSubscription{Name: sub.Name, Pattern: sub.Pattern, AckedSeq: sub.AckedSeq, CreatedAt: now, UpdatedAt: now}
+	s.subs[sub.Name] = c
+	return copySubscription(c), nil
+}
+
+func (s *MemoryStore) LeaseSubscription(ctx context.Context, name, owner string, ttl time.Duration) (*eng.EventSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[name]
+	now := eng.Now()
+	if !ok || (sub.LeaseOwner != "" && sub.LeaseOwner != owner && sub.LeasedUntil != nil && !sub.LeasedUntil.Before(now)) {
+		return nil, nil
+	}
+	sub.LeaseOwner, sub.LeasedUntil = owner, timePtr(now.Add(ttl))
+	return copySubscription(sub), nil
+}
+
+func (s *MemoryStore) AckSubscription(ctx context.Context, name, owner string, seq int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[name]
+	if !ok || sub.LeaseOwner != owner || sub.AckedSeq >= seq {
+		return false, nil
+	}
+	sub.AckedSeq, sub.UpdatedAt = seq, eng.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseSubscription(ctx context.Context, name, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[name]; ok && sub.LeaseOwner == owner {
+		sub.LeaseOwner, sub.LeasedUntil = "", nil
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListSubscriptions(ctx context.Context) ([]*eng.EventSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*eng.EventSubscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		out = append(out, copySubscription(sub))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *MemoryStore) DeleteSubscription(ctx context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subs[name]
+	delete(s.subs, name)
+	return ok, nil
+}
@@ -0,0 +1,12 @@
+package store
+
+import (
+	"testing"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store/storetest"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) eng.StateStore { return NewMemoryStore() })
+}
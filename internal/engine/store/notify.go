@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// StepsReadyChannel is the Postgres NOTIFY channel signalled whenever steps
+// become pending, so schedulers can claim them without waiting for a poll.
+const StepsReadyChannel = "workflow_steps_ready"
+
+// notifyStepsReady issues pg_notify on tx. Notifications are delivered when
+// the surrounding transaction commits.
+func notifyStepsReady(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_notify(?, '')", StepsReadyChannel).Error
+}
+
+// ListenForSteps opens a dedicated LISTEN connection on StepsReadyChannel and
+// returns a channel that receives a value for every notification (coalesced
+// while the receiver is busy) and after every reconnect, since notifications
+// may have been missed while disconnected. The connection is closed when ctx
+// is cancelled.
+func ListenForSteps(ctx context.Context, databaseURL string) (<-chan struct{}, error) {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			eng.DefaultLogger().Warn("store: listener event", eng.F("event", ev), eng.Err(err))
+		}
+	})
+	if err := listener.Listen(StepsReadyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	wake := make(chan struct{}, 1)
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// a nil notification means the connection was re-established
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			case <-time.After(90 * time.Second):
+				// keep the connection healthy while idle
+				go func() { _ = listener.Ping() }()
+			}
+		}
+	}()
+	return wake, nil
+}
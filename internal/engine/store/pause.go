@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pausedRun matches workflow_steps rows (aliased ws) of paused runs or runs
+// of a paused workflow type.
+const pausedRun = `EXISTS (
+  SELECT 1 FROM workflow_runs r
+  WHERE r.id = ws.run_id
+    AND (r.paused_at IS NOT NULL OR r.workflow_type IN (SELECT workflow_type FROM workflow_pauses))
+)`
+
+func (s *PostgresStore) PauseRun(ctx context.Context, runID string, paused bool) (bool, error) {
+	updated := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		var pausedAt interface{}
+		q := tx.Model(&entity.WorkflowRun{}).Where("id = ? AND status = ?", runID, eng.RunStatusRunning)
+		if paused {
+			pausedAt = time.Now()
+			q = q.Where("paused_at IS NULL")
+		} else {
+			q = q.Where("paused_at IS NOT NULL")
+		}
+		res := q.Updates(map[string]interface{}{"paused_at": pausedAt, "updated_at": time.Now()})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		updated = true
+		if paused {
+			return nil
+		}
+		return notifyStepsReady(tx)
+	})
+	return updated, err
+}
+
+func (s *PostgresStore) PauseWorkflow(ctx context.Context, p *eng.WorkflowPause) error {
+	ent := &entity.WorkflowPause{WorkflowType: p.WorkflowType, Reason: p.Reason, PausedBy: p.PausedBy}
+	err := s.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workflow_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "paused_by"}),
+	}).Create(ent).Error
+	if err != nil {
+		return err
+	}
+	p.CreatedAt = ent.CreatedAt
+	return nil
+}
+
+func (s *PostgresStore) ResumeWorkflow(ctx context.Context, workflowType string) (bool, error) {
+	resumed := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&entity.WorkflowPause{}, "workflow_type = ?", workflowType)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		resumed = true
+		return notifyStepsReady(tx)
+	})
+	return resumed, err
+}
+
+func (s *PostgresStore) ListWorkflowPauses(ctx context.Context) ([]*eng.WorkflowPause, error) {
+	var ents []entity.WorkflowPause
+	if err := s.conn(ctx).Order("workflow_type").Find(&ents).Error; err != nil {
+		return nil, err
+	}
+	pauses := make([]*eng.WorkflowPause, 0, len(ents))
+	for _, e := range ents {
+		pauses = append(pauses, &eng.WorkflowPause{WorkflowType: e.WorkflowType, Reason: e.Reason, PausedBy: e.PausedBy, CreatedAt: e.CreatedAt})
+	}
+	return pauses, nil
+}
+
+func (s *PostgresStore) ListExpiredRuns(ctx context.Context, limit int) ([]*eng.WorkflowRun, error) {
+	var ents []entity.WorkflowRun
+	err := s.conn(ctx).
+		Where("status = ? AND deadline IS NOT NULL AND deadline <= now()", eng.RunStatusRunning).
+		Order("deadline").Limit(limit).Find(&ents).Error
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*eng.WorkflowRun, 0, len(ents))
+	for i := range ents {
+		runs = append(runs, toEngineRun(&ents[i]))
+	}
+	return runs, nil
+}
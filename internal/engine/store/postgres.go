@@ -2,57 +2,251 @@ package store
 
 import (
 	"context"
-	"log"
+	"encoding/json"
 	"time"
 
 	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PostgresStore is a GORM-backed StateStore implementation (scaffolded).
 type PostgresStore struct {
-	db *gorm.DB
+	db     *gorm.DB
+	limits eng.ConcurrencyLimits
 }
 
 func NewPostgresStore(db *gorm.DB) *PostgresStore {
 	return &PostgresStore{db: db}
 }
 
+// WithLimits sets the concurrency caps enforced when steps are claimed.
+func (s *PostgresStore) WithLimits(limits eng.ConcurrencyLimits) *PostgresStore {
+	s.limits = limits
+	return s
+}
+
+// txKey carries the transaction of an Atomic call in its context.
+type txKey struct{}
+
+func (s *PostgresStore) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// conn returns the transaction of the Atomic call ctx belongs to, or the
+// database outside one.
+func (s *PostgresStore) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
 func toEngineRun(e *entity.WorkflowRun) *eng.WorkflowRun {
 	if e == nil {
 		return nil
 	}
-	return &eng.WorkflowRun{
+	run := &eng.WorkflowRun{
 		ID: e.ID, WorkflowType: e.WorkflowType, WorkflowVersion: e.WorkflowVersion,
-		Status: e.Status, Payload: e.Payload, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt,
+		Status: e.Status, Payload: e.Payload, TraceContext: decodeCarrier(e.TraceContext),
+		Compensation: e.Compensation, Priority: e.Priority, PausedAt: e.PausedAt, Deadline: e.Deadline,
+		CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt,
+	}
+	if e.WorkspaceID != nil {
+		run.WorkspaceID = *e.WorkspaceID
 	}
+	if e.ParentRunID != nil {
+		run.ParentRunID = *e.ParentRunID
+	}
+	if e.ParentStepID != nil {
+		run.ParentStepID = *e.ParentStepID
+	}
+	return run
 }
 
 func toEntityRun(r *eng.WorkflowRun) *entity.WorkflowRun {
 	if r == nil {
 		return nil
 	}
-	return &entity.WorkflowRun{
+	ent := &entity.WorkflowRun{
 		ID: r.ID, WorkflowType: r.WorkflowType, WorkflowVersion: r.WorkflowVersion,
-		Status: r.Status, Payload: r.Payload,
+		Status: r.Status, Payload: r.Payload, TraceContext: encodeCarrier(r.TraceContext),
+		Compensation: r.Compensation, Priority: r.Priority, PausedAt: r.PausedAt, Deadline: r.Deadline,
+	}
+	if r.WorkspaceID != "" {
+		ent.WorkspaceID = &r.WorkspaceID
 	}
+	if r.ParentRunID != "" {
+		ent.ParentRunID = &r.ParentRunID
+	}
+	if r.ParentStepID != "" {
+		ent.ParentStepID = &r.ParentStepID
+	}
+	return ent
+}
+
+// encodeCarrier stores a trace context carrier as JSON; empty carriers are
+// stored as NULL.
+func encodeCarrier(c map[string]string) []byte {
+	if len(c) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(c)
+	return b
+}
+
+func decodeCarrier(b []byte) map[string]string {
+	if len(b) == 0 {
+		return nil
+	}
+	var c map[string]string
+	_ = json.Unmarshal(b, &c)
+	return c
+}
+
+func toEngineStep(e *entity.WorkflowStep) *eng.WorkflowStepRecord {
+	if e == nil {
+		return nil
+	}
+	rec := &eng.WorkflowStepRecord{
+		ID: e.ID, RunID: e.RunID, StepName: e.StepName, Kind: e.Kind, Seq: e.Seq, Status: e.Status,
+		Input: e.Input, InputTemplate: e.InputTemplate, Result: e.Result, Attempts: e.Attempts, MaxAttempts: e.MaxAttempts,
+		NextAttemptAt: e.NextAttemptAt, ClaimedAt: e.ClaimedAt, LastHeartbeat: e.LastHeartbeat,
+		LockOwner: e.LockOwner, IdempotencyKey: e.IdempotencyKey, Error: e.Error, CompensatesID: e.CompensatesID,
+		ParentStepID: e.ParentStepID, Priority: e.Priority, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt,
+	}
+	if len(e.RetryPolicy) > 0 {
+		var p eng.RetryPolicy
+		if err := json.Unmarshal(e.RetryPolicy, &p); err == nil {
+			rec.RetryPolicy = &p
+		}
+	}
+	if len(e.Compensation) > 0 {
+		var c eng.Compensation
+		if err := json.Unmarshal(e.Compensation, &c); err == nil {
+			rec.Compensation = &c
+		}
+	}
+	return rec
+}
+
+func toEngineSteps(ents []entity.WorkflowStep) []*eng.WorkflowStepRecord {
+	steps := make([]*eng.WorkflowStepRecord, 0, len(ents))
+	for i := range ents {
+		steps = append(steps, toEngineStep(&ents[i]))
+	}
+	return steps
 }
 
 func (s *PostgresStore) CreateRun(ctx context.Context, run *eng.WorkflowRun) error {
 	ent := toEntityRun(run)
-	return s.db.WithContext(ctx).Create(ent).Error
+	return s.conn(ctx).Create(ent).Error
 }
 
 func (s *PostgresStore) LoadRun(ctx context.Context, runID string) (*eng.WorkflowRun, error) {
 	var ent entity.WorkflowRun
-	if err := s.db.WithContext(ctx).First(&ent, "id = ?", runID).Error; err != nil {
+	if err := s.conn(ctx).First(&ent, "id = ?", runID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return toEngineRun(&ent), nil
+	run := toEngineRun(&ent)
+
+	var steps []entity.WorkflowStep
+	if err := s.conn(ctx).Where("run_id = ?", runID).Order("seq, created_at").Find(&steps).Error; err != nil {
+		return nil, err
+	}
+	run.Steps = make([]eng.WorkflowStepRecord, 0, len(steps))
+	for i := range steps {
+		run.Steps = append(run.Steps, *toEngineStep(&steps[i]))
+	}
+	return run, nil
+}
+
+func (s *PostgresStore) UpdateRun(ctx context.Context, run *eng.WorkflowRun) error {
+	updates := map[string]interface{}{
+		"status":       run.Status,
+		"compensation": run.Compensation,
+		"updated_at":   time.Now(),
+	}
+	return s.conn(ctx).Model(&entity.WorkflowRun{ID: run.ID}).Updates(updates).Error
+}
+
+func (s *PostgresStore) MigrateRun(ctx context.Context, run *eng.WorkflowRun, from string) (bool, error) {
+	busy := s.db.Model(&entity.WorkflowStep{}).Select("1").
+		Where("run_id = ? AND status = ?", run.ID, eng.StepStatusInProgress)
+	res := s.conn(ctx).Model(&entity.WorkflowRun{}).
+		Where("id = ? AND status = ? AND workflow_version = ?", run.ID, eng.RunStatusRunning, from).
+		Where("NOT EXISTS (?)", busy).
+		Updates(map[string]interface{}{
+			"workflow_version": run.WorkflowVersion,
+			"payload":          []byte(run.Payload),
+			"updated_at":       time.Now(),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) ListRuns(ctx context.Context, filter eng.RunFilter) ([]*eng.WorkflowRun, int64, error) {
+	q := s.conn(ctx).Model(&entity.WorkflowRun{})
+	if filter.WorkspaceID != "" {
+		q = q.Where("workspace_id = ?", filter.WorkspaceID)
+	}
+	if filter.WorkflowType != "" {
+		q = q.Where("workflow_type = ?", filter.WorkflowType)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+	if filter.ParentRunID != "" {
+		q = q.Where("parent_run_id = ?", filter.ParentRunID)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var ents []entity.WorkflowRun
+	q = q.Order("created_at DESC")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		q = q.Offset(filter.Offset)
+	}
+	if err := q.Find(&ents).Error; err != nil {
+		return nil, 0, err
+	}
+	runs := make([]*eng.WorkflowRun, 0, len(ents))
+	for i := range ents {
+		runs = append(runs, toEngineRun(&ents[i]))
+	}
+	return runs, total, nil
+}
+
+func (s *PostgresStore) CancelRun(ctx context.Context, runID string) (bool, error) {
+	cancelled := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&entity.WorkflowRun{}).
+			Where("id = ? AND status = ?", runID, eng.RunStatusRunning).
+			Updates(map[string]interface{}{"status": eng.RunStatusCancelled, "updated_at": time.Now()})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		cancelled = true
+		return tx.Model(&entity.WorkflowStep{}).
+			Where("run_id = ? AND status IN ?", runID, []string{eng.StepStatusPending, eng.StepStatusHeld, eng.StepStatusWaitingForChildren, eng.StepStatusWaitingForSignal}).
+			Updates(map[string]interface{}{"status": eng.StepStatusCancelled, "updated_at": time.Now()}).Error
+	})
+	return cancelled, err
 }
 
 func (s *PostgresStore) InsertSteps(ctx context.Context, steps []*eng.WorkflowStepRecord) error {
@@ -62,45 +256,123 @@ func (s *PostgresStore) InsertSteps(ctx context.Context, steps []*eng.WorkflowSt
 	ents := make([]*entity.WorkflowStep, 0, len(steps))
 	now := time.Now()
 	for _, st := range steps {
-		ents = append(ents, &entity.WorkflowStep{
-			ID: st.ID, RunID: st.RunID, StepName: st.StepName, Seq: st.Seq,
-			Status: st.Status, Input: st.Input, Result: st.Result, Attempts: st.Attempts,
-			MaxAttempts: st.MaxAttempts, NextAttemptAt: st.NextAttemptAt, CreatedAt: now, UpdatedAt: now,
-		})
+		ent := &entity.WorkflowStep{
+			ID: st.ID, RunID: st.RunID, StepName: st.StepName, Kind: st.Kind, Seq: st.Seq, IdempotencyKey: st.IdempotencyKey,
+			Status: st.Status, Input: st.Input, InputTemplate: st.InputTemplate, Result: st.Result, Attempts: st.Attempts,
+			MaxAttempts: st.MaxAttempts, NextAttemptAt: st.NextAttemptAt, CompensatesID: st.CompensatesID,
+			ParentStepID: st.ParentStepID, Priority: st.Priority, CreatedAt: now, UpdatedAt: now,
+		}
+		if st.RetryPolicy != nil {
+			b, err := json.Marshal(st.RetryPolicy)
+			if err != nil {
+				return err
+			}
+			ent.RetryPolicy = b
+		}
+		if st.Compensation != nil {
+			b, err := json.Marshal(st.Compensation)
+			if err != nil {
+				return err
+			}
+			ent.Compensation = b
+		}
+		ents = append(ents, ent)
 	}
-	return s.db.WithContext(ctx).Create(&ents).Error
+	return s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		ents, err := numberSteps(tx, steps, ents)
+		if err != nil || len(ents) == 0 {
+			return err
+		}
+		if err := tx.Create(&ents).Error; err != nil {
+			return err
+		}
+		return notifyStepsReady(tx)
+	})
+}
+
+// numberSteps allocates the seqs of steps inserted without one, numbering
+// them after the last step of their run, and drops those whose idempotency
+// key a step of the run already has. The run row is locked until tx ends,
+// so concurrent planners of a run are serialized here. steps and ents are
+// parallel; the allocated seq and key are set on both.
+func numberSteps(tx *gorm.DB, steps []*eng.WorkflowStepRecord, ents []*entity.WorkflowStep) ([]*entity.WorkflowStep, error) {
+	out := make([]*entity.WorkflowStep, 0, len(ents))
+	next := make(map[string]int)
+	for i, ent := range ents {
+		if ent.Seq != 0 {
+			out = append(out, ent)
+			continue
+		}
+		if _, ok := next[ent.RunID]; !ok {
+			var runID string
+			if err := tx.Raw(`SELECT id FROM workflow_runs WHERE id = ? FOR UPDATE`, ent.RunID).Scan(&runID).Error; err != nil {
+				return nil, err
+			}
+			var last int
+			if err := tx.Raw(`SELECT COALESCE(MAX(seq), 0) FROM workflow_steps WHERE run_id = ?`, ent.RunID).Scan(&last).Error; err != nil {
+				return nil, err
+			}
+			next[ent.RunID] = last + 1
+		}
+		if ent.IdempotencyKey != nil {
+			var n int64
+			if err := tx.Model(&entity.WorkflowStep{}).Where("run_id = ? AND idempotency_key = ?", ent.RunID, *ent.IdempotencyKey).Count(&n).Error; err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				continue
+			}
+		}
+		ent.Seq = next[ent.RunID]
+		next[ent.RunID]++
+		steps[i].Seq = ent.Seq
+		if ent.IdempotencyKey == nil {
+			key := eng.StepKey(steps[i])
+			ent.IdempotencyKey, steps[i].IdempotencyKey = &key, &key
+		}
+		out = append(out, ent)
+	}
+	return out, nil
 }
 
 func (s *PostgresStore) ClaimNextStep(ctx context.Context, workerID string) (*eng.WorkflowStepRecord, error) {
+	steps, err := s.ClaimNextSteps(ctx, workerID, 1)
+	if err != nil || len(steps) == 0 {
+		return nil, err
+	}
+	return steps[0], nil
+}
+
+func (s *PostgresStore) ClaimNextSteps(ctx context.Context, workerID string, n int) ([]*eng.WorkflowStepRecord, error) {
+	if n <= 0 {
+		return nil, nil
+	}
 	// Use a transaction and raw SQL to perform SELECT ... FOR UPDATE SKIP LOCKED + UPDATE ... RETURNING
-	var out entity.WorkflowStep
-	tx := s.db.WithContext(ctx).Begin()
+	var out []claimedStep
+	tx := s.conn(ctx).Begin()
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
+	// claims are serialized while caps apply, so concurrent schedulers do
+	// not both fill the last free slot
+	if s.limits.Enabled() {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", claimLockKey).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
 
-	// raw SQL using WITH c as (...) update ... returning *
-	query := `WITH c AS (
-  SELECT id FROM workflow_steps
-  WHERE status = 'pending' AND (next_attempt_at IS NULL OR next_attempt_at <= now())
-  ORDER BY seq, created_at
-  FOR UPDATE SKIP LOCKED
-  LIMIT 1
-)
-UPDATE workflow_steps ws
-SET status = 'in_progress', lock_owner = ?, claimed_at = now(), last_heartbeat = now(), updated_at = now()
-FROM c
-WHERE ws.id = c.id
-RETURNING ws.*;`
-
-	// Execute the query via GORM and scan into the entity struct.
-	// Use RowsAffected to detect no-result case and return (nil, nil).
-	res := tx.Raw(query, workerID).Scan(&out)
+	workspaces, _ := json.Marshal(s.limits.Workspaces)
+	workflows, _ := json.Marshal(s.limits.Workflows)
+	res := tx.Raw(claimQuery, map[string]interface{}{
+		"n": n, "worker": workerID, "workspace_cap": s.limits.Workspace,
+		"workspaces": string(workspaces), "workflows": string(workflows),
+	}).Scan(&out)
 	if res.Error != nil {
 		tx.Rollback()
 		return nil, res.Error
 	}
-	if res.RowsAffected == 0 {
+	if len(out) == 0 {
 		tx.Rollback()
 		return nil, nil
 	}
@@ -110,19 +382,83 @@ RETURNING ws.*;`
 	}
 
 	// map to engine model
-	rec := &eng.WorkflowStepRecord{
-		ID: out.ID, RunID: out.RunID, StepName: out.StepName, Seq: out.Seq, Status: out.Status,
-		Input: out.Input, Result: out.Result, Attempts: out.Attempts, MaxAttempts: out.MaxAttempts,
-		NextAttemptAt: out.NextAttemptAt, ClaimedAt: out.ClaimedAt, LastHeartbeat: out.LastHeartbeat,
-		LockOwner: out.LockOwner, IdempotencyKey: out.IdempotencyKey, Error: out.Error,
-		CreatedAt: out.CreatedAt, UpdatedAt: out.UpdatedAt,
+	steps := make([]*eng.WorkflowStepRecord, 0, len(out))
+	for i := range out {
+		rec := toEngineStep(&out[i].WorkflowStep)
+		rec.WorkflowType = out[i].WorkflowType
+		steps = append(steps, rec)
 	}
-	return rec, nil
+	return steps, nil
 }
 
-func (s *PostgresStore) UpdateStep(ctx context.Context, step *eng.WorkflowStepRecord) error {
-	// Map to entity and update
-	ent := &entity.WorkflowStep{ID: step.ID}
+// claimLockKey is the advisory lock held by capped claims.
+const claimLockKey = 0x656e67696e65
+
+// claimQuery claims up to @n ready steps. Steps of paused runs and paused
+// workflow types are skipped. The rest are ranked within their workspace
+// and workflow type by priority, seq and age, and a step is only eligible
+// while its rank plus the steps already in progress stays within the
+// workspace and workflow caps (NULL or 0 meaning none). Eligible steps are
+// taken highest priority first and round-robin across workspaces, so a
+// workspace with a large backlog cannot starve the others.
+const claimQuery = `WITH running AS (
+  SELECT r.workspace_id, r.workflow_type, count(*) AS n
+  FROM workflow_steps s JOIN workflow_runs r ON r.id = s.run_id
+  WHERE s.status = 'in_progress'
+  GROUP BY r.workspace_id, r.workflow_type
+), ranked AS (
+  SELECT ws.id, ws.priority, ws.seq, ws.created_at, r.workspace_id, r.workflow_type,
+    row_number() OVER (PARTITION BY r.workspace_id ORDER BY ws.priority DESC, ws.seq, ws.created_at) AS workspace_rank,
+    row_number() OVER (PARTITION BY r.workflow_type ORDER BY ws.priority DESC, ws.seq, ws.created_at) AS workflow_rank
+  FROM workflow_steps ws JOIN workflow_runs r ON r.id = ws.run_id
+  WHERE ws.status = 'pending' AND (ws.next_attempt_at IS NULL OR ws.next_attempt_at <= now())
+    AND NOT ` + pausedRun + `
+), capped AS (
+  SELECT k.*,
+    NULLIF(COALESCE((CAST(@workspaces AS jsonb) ->> k.workspace_id::text)::int, @workspace_cap), 0) AS workspace_cap,
+    NULLIF((CAST(@workflows AS jsonb) ->> k.workflow_type)::int, 0) AS workflow_cap
+  FROM ranked k
+), eligible AS (
+  SELECT k.* FROM capped k
+  WHERE (k.workspace_cap IS NULL OR k.workspace_rank +
+         (SELECT COALESCE(sum(n), 0) FROM running WHERE workspace_id IS NOT DISTINCT FROM k.workspace_id) <= k.workspace_cap)
+    AND (k.workflow_cap IS NULL OR k.workflow_rank +
+         (SELECT COALESCE(sum(n), 0) FROM running WHERE workflow_type = k.workflow_type) <= k.workflow_cap)
+), c AS (
+  SELECT ws.id FROM workflow_steps ws JOIN eligible e ON e.id = ws.id
+  WHERE ws.status = 'pending'
+  ORDER BY e.priority DESC, e.workspace_rank, e.seq, e.created_at
+  FOR UPDATE OF ws SKIP LOCKED
+  LIMIT @n
+)
+UPDATE workflow_steps ws
+SET status = 'in_progress', lock_owner = @worker, claimed_at = now(), last_heartbeat = now(), updated_at = now()
+FROM c
+WHERE ws.id = c.id
+RETURNING ws.*, (SELECT workflow_type FROM workflow_runs WHERE id = ws.run_id) AS workflow_type;`
+
+// claimedStep is a claimed step row together with its run's workflow type.
+type claimedStep struct {
+	entity.WorkflowStep
+	WorkflowType string
+}
+
+func (s *PostgresStore) CountPendingSteps(ctx context.Context) ([]eng.PendingCount, error) {
+	var out []eng.PendingCount
+	err := s.conn(ctx).Raw(`
+		SELECT r.workflow_type,
+		       CASE WHEN r.paused_at IS NOT NULL OR p.workflow_type IS NOT NULL THEN 'paused'
+		            WHEN s.next_attempt_at IS NULL OR s.next_attempt_at <= now() THEN 'ready'
+		            ELSE 'delayed' END AS state,
+		       count(*) AS count
+		FROM workflow_steps s JOIN workflow_runs r ON r.id = s.run_id
+		LEFT JOIN workflow_pauses p ON p.workflow_type = r.workflow_type
+		WHERE s.status = ?
+		GROUP BY 1, 2`, eng.StepStatusPending).Scan(&out).Error
+	return out, err
+}
+
+func (s *PostgresStore) UpdateStep(ctx context.Context, step *eng.WorkflowStepRecord, workerID string) (bool, error) {
 	// Use map updates to avoid overwriting fields unintentionally
 	updates := map[string]interface{}{
 		"status":          step.Status,
@@ -133,103 +469,296 @@ func (s *PostgresStore) UpdateStep(ctx context.Context, step *eng.WorkflowStepRe
 		"error":           step.Error,
 		"updated_at":      time.Now(),
 	}
-	return s.db.WithContext(ctx).Model(ent).Updates(updates).Error
+	// the input bound from InputTemplate at claim time
+	if len(step.InputTemplate) > 0 && len(step.Input) > 0 {
+		updates["input"] = step.Input
+	}
+	updated := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		res := leased(tx.Model(&entity.WorkflowStep{}), step, workerID).Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		updated = true
+		if step.Status == eng.StepStatusPending {
+			return notifyStepsReady(tx)
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// leased narrows q to step while workerID still holds the lease it claimed
+// the step with. The claim time tells a lease apart from a later claim of
+// the same step by the same worker.
+func leased(q *gorm.DB, step *eng.WorkflowStepRecord, workerID string) *gorm.DB {
+	q = q.Where("id = ? AND status = ? AND lock_owner = ?", step.ID, eng.StepStatusInProgress, workerID)
+	if step.ClaimedAt != nil {
+		q = q.Where("claimed_at = ?", *step.ClaimedAt)
+	}
+	return q
+}
+
+func (s *PostgresStore) ReleaseSteps(ctx context.Context, workerID string) (int, error) {
+	released := 0
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&entity.WorkflowStep{}).
+			Where("status = ? AND lock_owner = ?", eng.StepStatusInProgress, workerID).
+			Updates(map[string]interface{}{
+				"status":     eng.StepStatusPending,
+				"lock_owner": nil,
+				"claimed_at": nil,
+				"updated_at": time.Now(),
+			})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		released = int(res.RowsAffected)
+		return notifyStepsReady(tx)
+	})
+	return released, err
+}
+
+func (s *PostgresStore) TransitionStep(ctx context.Context, step *eng.WorkflowStepRecord, from string) (bool, error) {
+	updated := false
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&entity.WorkflowStep{}).
+			Where("id = ? AND status = ?", step.ID, from).
+			Updates(map[string]interface{}{
+				"status":          step.Status,
+				"result":          step.Result,
+				"next_attempt_at": step.NextAttemptAt,
+				"lock_owner":      step.LockOwner,
+				"error":           step.Error,
+				"updated_at":      time.Now(),
+			})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		updated = true
+		if step.Status == eng.StepStatusPending {
+			return notifyStepsReady(tx)
+		}
+		return nil
+	})
+	return updated, err
 }
 
 func (s *PostgresStore) AppendLog(ctx context.Context, logRec *eng.StepLog) error {
 	ent := &entity.StepLog{
 		ID: logRec.ID, StepID: logRec.StepID, Level: logRec.Level, Message: logRec.Message, Meta: logRec.Meta,
 	}
-	return s.db.WithContext(ctx).Create(ent).Error
+	return s.conn(ctx).Create(ent).Error
+}
+
+func (s *PostgresStore) ListRunLogs(ctx context.Context, runID string) ([]*eng.StepLog, error) {
+	var ents []entity.StepLog
+	err := s.conn(ctx).
+		Joins("JOIN workflow_steps ON workflow_steps.id = step_logs.step_id").
+		Where("workflow_steps.run_id = ?", runID).
+		Order("step_logs.created_at").
+		Find(&ents).Error
+	if err != nil {
+		return nil, err
+	}
+	logs := make([]*eng.StepLog, 0, len(ents))
+	for _, e := range ents {
+		logs = append(logs, &eng.StepLog{
+			ID: e.ID, StepID: e.StepID, Level: e.Level, Message: e.Message, Meta: e.Meta, CreatedAt: e.CreatedAt,
+		})
+	}
+	return logs, nil
 }
 
 func (s *PostgresStore) EnqueueEvent(ctx context.Context, ev *eng.OutboxEvent) error {
 	ent := &entity.OutboxEvent{
 		ID: ev.ID, EventType: ev.EventType, Payload: ev.Payload, State: ev.State, Published: ev.Published, IdempotencyKey: ev.IdempotencyKey,
+		TraceContext: encodeCarrier(ev.TraceContext),
+	}
+	// events enqueued by a traced step are published in the same trace
+	if ent.TraceContext == nil {
+		ent.TraceContext = encodeCarrier(tracing.Inject(ctx))
 	}
-	return s.db.WithContext(ctx).Create(ent).Error
+	return s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		// take the transaction ID before the event takes its seq, so the
+		// horizon of any event inserted later covers this transaction (see
+		// ReadEvents)
+		if err := tx.Exec("SELECT pg_current_xact_id()").Error; err != nil {
+			return err
+		}
+		db := tx
+		if ev.IdempotencyKey != nil {
+			db = db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "idempotency_key"}}, DoNothing: true})
+		}
+		res := db.Create(ent)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		ev.Seq = ent.Seq
+		return tx.Exec("UPDATE outbox_events SET horizon = pg_snapshot_xmax(pg_current_snapshot()) WHERE seq = ?", ent.Seq).Error
+	})
 }
 
-// RequeueStaleSteps finds workflow_steps stuck in 'in_progress' whose
-// last_heartbeat is older than heartbeatTTLSeconds, increments attempts,
-// sets next_attempt_at (simple linear backoff), and moves them back to
-// 'pending' unless attempts >= max_attempts in which case mark failed.
-func (s *PostgresStore) RequeueStaleSteps(ctx context.Context, heartbeatTTLSeconds int, limit int) (int, error) {
-	if limit <= 0 {
-		limit = 100
+func (s *PostgresStore) LoadEventByKey(ctx context.Context, key string) (*eng.OutboxEvent, error) {
+	var ent entity.OutboxEvent
+	if err := s.conn(ctx).First(&ent, "idempotency_key = ?", key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
 	}
-	// Run inside a transaction to select-for-update the candidates
-	tx := s.db.WithContext(ctx).Begin()
-	if tx.Error != nil {
-		return 0, tx.Error
-	}
-
-	var rows []entity.WorkflowStep
-	sel := tx.Raw(`
-		SELECT * FROM workflow_steps
-		WHERE status = 'in_progress' AND (last_heartbeat IS NULL OR last_heartbeat < now() - (? * INTERVAL '1 second'))
-		ORDER BY last_heartbeat
-		LIMIT ?
-		FOR UPDATE SKIP LOCKED
-	`, heartbeatTTLSeconds, limit).Scan(&rows)
-	if sel.Error != nil {
-		tx.Rollback()
-		return 0, sel.Error
+	return toEngineEvent(&ent), nil
+}
+
+// staleStepError is recorded on steps failed because their worker stopped
+// heartbeating.
+const staleStepError = "step abandoned: worker stopped heartbeating"
+
+// maxStaleBackoff caps the backoff of abandoned steps without a retry
+// policy.
+const maxStaleBackoff = time.Hour
+
+// staleBackoff returns how long a step abandoned by its worker waits before
+// its next attempt, or false when it fails. attempts counts the abandoned
+// attempt, and the step fails once it reaches the limit the scheduler's
+// retries use. The abandonment counts as a transient error: a step with a
+// retry policy is retried only if the policy allows that class, after the
+// policy's backoff. Other steps back off from the heartbeat TTL, doubling
+// per attempt.
+func staleBackoff(st *eng.WorkflowStepRecord, attempts, heartbeatTTLSeconds int) (time.Duration, bool) {
+	maxAttempts := eng.DefaultRetryPolicy.MaxAttempts
+	if st.RetryPolicy != nil {
+		maxAttempts = st.RetryPolicy.MaxAttempts
 	}
-	if len(rows) == 0 {
-		tx.Rollback()
-		return 0, nil
+	if st.MaxAttempts > 0 {
+		maxAttempts = st.MaxAttempts
+	}
+	if attempts >= maxAttempts {
+		return 0, false
+	}
+	if p := st.RetryPolicy; p != nil {
+		if !p.Allows(eng.ErrorClassTransient) {
+			return 0, false
+		}
+		return p.Backoff(attempts), true
 	}
+	base := heartbeatTTLSeconds
+	if base <= 0 {
+		base = 5
+	}
+	backoff := time.Duration(base) * time.Second
+	for i := 1; i < attempts && backoff < maxStaleBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxStaleBackoff {
+		backoff = maxStaleBackoff
+	}
+	return backoff, true
+}
 
-	now := time.Now()
+// RequeueStaleSteps finds workflow_steps stuck in 'in_progress' whose
+// last_heartbeat is older than heartbeatTTLSeconds, increments attempts and
+// moves them back to 'pending' after the backoff staleBackoff picks. A step
+// whose attempt limit is reached, or whose retry policy does not retry
+// transient errors, is marked failed instead.
+func (s *PostgresStore) RequeueStaleSteps(ctx context.Context, heartbeatTTLSeconds int, limit int) (int, []*eng.WorkflowStepRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
 	requeued := 0
-	const maxBackoffSeconds = 3600 // 1 hour cap
-
-	for _, r := range rows {
-		newAttempts := r.Attempts + 1
-		// determine max attempts (use entity default if zero)
-		maxAttempts := r.MaxAttempts
-		if maxAttempts == 0 {
-			maxAttempts = 5
+	var failed []*eng.WorkflowStepRecord
+	// select-for-update the candidates so concurrent monitors skip them
+	err := s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []claimedStep
+		sel := tx.Raw(`
+			SELECT ws.*, r.workflow_type FROM workflow_steps ws
+			JOIN workflow_runs r ON r.id = ws.run_id
+			WHERE ws.status = 'in_progress' AND (ws.last_heartbeat IS NULL OR ws.last_heartbeat < now() - (? * INTERVAL '1 second'))
+			ORDER BY ws.last_heartbeat
+			LIMIT ?
+			FOR UPDATE OF ws SKIP LOCKED
+		`, heartbeatTTLSeconds, limit).Scan(&rows)
+		if sel.Error != nil {
+			return sel.Error
 		}
 
-		if newAttempts > maxAttempts {
-			// mark failed/dead-letter
-			if err := tx.Exec(`UPDATE workflow_steps SET status='failed', attempts = ?, updated_at = ? WHERE id = ?`, newAttempts, now, r.ID).Error; err != nil {
-				tx.Rollback()
-				return requeued, err
+		now := time.Now()
+		for i := range rows {
+			r := toEngineStep(&rows[i].WorkflowStep)
+			r.WorkflowType = rows[i].WorkflowType
+			newAttempts := r.Attempts + 1
+			backoff, retry := staleBackoff(r, newAttempts, heartbeatTTLSeconds)
+			if !retry {
+				if err := tx.Exec(`UPDATE workflow_steps SET status='failed', attempts = ?, error = ?, lock_owner = NULL, updated_at = ? WHERE id = ?`, newAttempts, staleStepError, now, r.ID).Error; err != nil {
+					return err
+				}
+				msg := staleStepError
+				r.Status, r.Attempts, r.Error, r.LockOwner, r.UpdatedAt = eng.StepStatusFailed, newAttempts, &msg, nil, now
+				failed = append(failed, r)
+				continue
 			}
-			continue
-		}
 
-		// exponential backoff: base * 2^(attempts-1)
-		base := heartbeatTTLSeconds
-		if base <= 0 {
-			base = 5
-		}
-		backoff := base * (1 << (newAttempts - 1))
-		if backoff > maxBackoffSeconds {
-			backoff = maxBackoffSeconds
+			if err := tx.Exec(`UPDATE workflow_steps SET status='pending', attempts = ?, lock_owner = NULL, claimed_at = NULL, next_attempt_at = now() + (? * INTERVAL '1 second'), updated_at = ? WHERE id = ?`, newAttempts, backoff.Seconds(), now, r.ID).Error; err != nil {
+				return err
+			}
+			requeued++
 		}
 
-		if err := tx.Exec(`UPDATE workflow_steps SET status='pending', attempts = ?, next_attempt_at = now() + (? * INTERVAL '1 second'), updated_at = ? WHERE id = ?`, newAttempts, backoff, now, r.ID).Error; err != nil {
-			tx.Rollback()
-			return requeued, err
+		if requeued > 0 {
+			return notifyStepsReady(tx)
 		}
-		requeued++
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
 	}
+	return requeued, failed, nil
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return requeued, err
+func (s *PostgresStore) UpdateWaitingStep(ctx context.Context, step *eng.WorkflowStepRecord) (bool, error) {
+	updates := map[string]interface{}{
+		"status":          step.Status,
+		"result":          step.Result,
+		"next_attempt_at": step.NextAttemptAt,
+		"updated_at":      time.Now(),
 	}
-	// log summary
-	if requeued > 0 {
-		log.Printf("requeue: requeued %d stale steps (heartbeatTTL=%ds)", requeued, heartbeatTTLSeconds)
+	res := s.conn(ctx).Model(&entity.WorkflowStep{}).
+		Where("id = ? AND status = ?", step.ID, eng.StepStatusWaitingForSignal).
+		Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) ListWaitingSteps(ctx context.Context, workspaceID string) ([]*eng.WorkflowStepRecord, error) {
+	q := s.conn(ctx).Model(&entity.WorkflowStep{}).
+		Where("workflow_steps.status = ?", eng.StepStatusWaitingForSignal)
+	if workspaceID != "" {
+		q = q.Joins("JOIN workflow_runs ON workflow_runs.id = workflow_steps.run_id").
+			Where("workflow_runs.workspace_id = ?", workspaceID)
+	}
+	var ents []entity.WorkflowStep
+	if err := q.Order("workflow_steps.updated_at").Find(&ents).Error; err != nil {
+		return nil, err
+	}
+	return toEngineSteps(ents), nil
+}
+
+func (s *PostgresStore) ListExpiredWaitingSteps(ctx context.Context, limit int) ([]*eng.WorkflowStepRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	var ents []entity.WorkflowStep
+	err := s.conn(ctx).
+		Where("status = ? AND next_attempt_at IS NOT NULL AND next_attempt_at <= now()", eng.StepStatusWaitingForSignal).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&ents).Error
+	if err != nil {
+		return nil, err
 	}
-	return requeued, nil
+	return toEngineSteps(ents), nil
 }
 
-func (s *PostgresStore) HeartbeatStep(ctx context.Context, stepID string) error {
-	// Update last_heartbeat to now()
-	return s.db.WithContext(ctx).Model(&entity.WorkflowStep{}).Where("id = ?", stepID).Update("last_heartbeat", time.Now()).Error
+func (s *PostgresStore) HeartbeatStep(ctx context.Context, step *eng.WorkflowStepRecord, workerID string) (bool, error) {
+	res := leased(s.conn(ctx).Model(&entity.WorkflowStep{}), step, workerID).Update("last_heartbeat", time.Now())
+	return res.RowsAffected > 0, res.Error
 }
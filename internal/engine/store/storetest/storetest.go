@@ -0,0 +1,842 @@
+// Package storetest is a conformance suite for engine.StateStore
+// implementations. Every store must pass it, so workflows tested against the
+// in-memory store behave the same on Postgres.
+//
+// The suite may share its database with other tests: it only looks at the
+// runs, steps and events it created, each under a fresh workflow type.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// Run runs the suite against the stores returned by newStore. It is called
+// once per subtest.
+func Run(t *testing.T, newStore func(t *testing.T) eng.StateStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s eng.StateStore)
+	}{
+		{"RunLifecycle", testRunLifecycle},
+		{"AtomicRollsBack", testAtomicRollsBack},
+		{"StepSeqAllocation", testStepSeqAllocation},
+		{"CancelRun", testCancelRun},
+		{"MigrateRun", testMigrateRun},
+		{"ClaimAndLease", testClaimAndLease},
+		{"ClaimSkipsStepsNotReady", testClaimSkipsStepsNotReady},
+		{"RequeueStaleSteps", testRequeueStaleSteps},
+		{"ReleaseSteps", testReleaseSteps},
+		{"PauseRun", testPauseRun},
+		{"PauseWorkflow", testPauseWorkflow},
+		{"ExpiredRuns", testExpiredRuns},
+		{"WaitingSteps", testWaitingSteps},
+		{"Logs", testLogs},
+		{"OutboxIdempotency", testOutboxIdempotency},
+		{"DeadLetterSteps", testDeadLetterSteps},
+		{"DeadLetterEvents", testDeadLetterEvents},
+		{"Triggers", testTriggers},
+		{"EventSubscriptions", testEventSubscriptions},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, newStore(t)) })
+	}
+}
+
+// fixture is a running run of a fresh workflow type in a fresh workspace.
+type fixture struct {
+	s   eng.StateStore
+	run *eng.WorkflowRun
+}
+
+func newFixture(t *testing.T, s eng.StateStore) *fixture {
+	t.Helper()
+	run := &eng.WorkflowRun{
+		ID: uuid.NewString(), WorkflowType: "storetest_" + uuid.NewString(), WorkflowVersion: "v1",
+		WorkspaceID: uuid.NewString(), Status: eng.RunStatusRunning, Payload: []byte(`{}`),
+	}
+	if err := s.CreateRun(context.Background(), run); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	return &fixture{s: s, run: run}
+}
+
+// step inserts a step of the fixture's run; mutate adjusts it first.
+func (f *fixture) step(t *testing.T, name string, seq int, mutate func(*eng.WorkflowStepRecord)) *eng.WorkflowStepRecord {
+	t.Helper()
+	st := &eng.WorkflowStepRecord{
+		ID: uuid.NewString(), RunID: f.run.ID, StepName: name, Seq: seq,
+		Status: eng.StepStatusPending, Input: []byte(`{}`),
+	}
+	key := eng.StepKey(st)
+	st.IdempotencyKey = &key
+	if mutate != nil {
+		mutate(st)
+	}
+	if err := f.s.InsertSteps(context.Background(), []*eng.WorkflowStepRecord{st}); err != nil {
+		t.Fatalf("insert step %s: %v", name, err)
+	}
+	return st
+}
+
+// claimed claims steps as worker until every step of ids was claimed or no
+// step is left to claim, and returns the claimed steps of ids.
+func claimed(t *testing.T, s eng.StateStore, worker string, ids ...string) map[string]*eng.WorkflowStepRecord {
+	t.Helper()
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	got := make(map[string]*eng.WorkflowStepRecord)
+	for len(got) < len(want) {
+		steps, err := s.ClaimNextSteps(context.Background(), worker, 100)
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if len(steps) == 0 {
+			break
+		}
+		for _, st := range steps {
+			if want[st.ID] {
+				got[st.ID] = st
+			}
+		}
+	}
+	return got
+}
+
+// claim claims stepID as worker and fails when it is not offered.
+func claim(t *testing.T, s eng.StateStore, worker, stepID string) *eng.WorkflowStepRecord {
+	t.Helper()
+	worker = worker + "-" + uuid.NewString()
+	st := claimed(t, s, worker, stepID)[stepID]
+	if st == nil {
+		t.Fatalf("step %s was not claimed", stepID)
+	}
+	return st
+}
+
+func load(t *testing.T, s eng.StateStore, stepID string) *eng.WorkflowStepRecord {
+	t.Helper()
+	st, err := s.LoadStep(context.Background(), stepID)
+	if err != nil || st == nil {
+		t.Fatalf("load step %s = %v, %v", stepID, st, err)
+	}
+	return st
+}
+
+func expectStatus(t *testing.T, s eng.StateStore, stepID, want string) *eng.WorkflowStepRecord {
+	t.Helper()
+	st := load(t, s, stepID)
+	if st.Status != want {
+		t.Fatalf("step %s status = %s, want %s", st.StepName, st.Status, want)
+	}
+	return st
+}
+
+func expectOK(t *testing.T, what string, ok bool, err error, want bool) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if ok != want {
+		t.Fatalf("%s = %v, want %v", what, ok, want)
+	}
+}
+
+// sameJSON reports whether got encodes the same value as want; Postgres
+// stores payloads as jsonb and does not keep their formatting.
+func sameJSON(got []byte, want string) bool {
+	var a, b interface{}
+	if json.Unmarshal(got, &a) != nil || json.Unmarshal([]byte(want), &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func testRunLifecycle(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	second := f.step(t, "second", 2, nil)
+	first := f.step(t, "first", 1, nil)
+
+	run, err := s.LoadRun(ctx, f.run.ID)
+	if err != nil || run == nil {
+		t.Fatalf("load run = %v, %v", run, err)
+	}
+	if run.WorkflowType != f.run.WorkflowType || run.WorkspaceID != f.run.WorkspaceID || run.Status != eng.RunStatusRunning {
+		t.Fatalf("loaded run = %+v", run)
+	}
+	if len(run.Steps) != 2 || run.Steps[0].ID != first.ID || run.Steps[1].ID != second.ID {
+		t.Fatalf("steps are not ordered by seq: %+v", run.Steps)
+	}
+	if run.Steps[0].MaxAttempts != 5 || run.Steps[0].Kind != eng.StepKindTask {
+		t.Fatalf("step defaults = max_attempts %d, kind %q", run.Steps[0].MaxAttempts, run.Steps[0].Kind)
+	}
+	if err := s.InsertSteps(ctx, []*eng.WorkflowStepRecord{{ID: uuid.NewString(), RunID: f.run.ID, StepName: "dup", Seq: 1, Status: eng.StepStatusPending}}); err == nil {
+		t.Fatal("a second step with the same seq must be rejected")
+	}
+
+	run.Status = eng.RunStatusCompleted
+	if err := s.UpdateRun(ctx, run); err != nil {
+		t.Fatalf("update run: %v", err)
+	}
+	runs, total, err := s.ListRuns(ctx, eng.RunFilter{WorkflowType: f.run.WorkflowType, Status: eng.RunStatusCompleted})
+	if err != nil || total != 1 || len(runs) != 1 || runs[0].ID != f.run.ID {
+		t.Fatalf("list runs = %v (total %d), %v", runs, total, err)
+	}
+	if missing, err := s.LoadRun(ctx, uuid.NewString()); err != nil || missing != nil {
+		t.Fatalf("load unknown run = %v, %v", missing, err)
+	}
+}
+
+func testStepSeqAllocation(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	f.step(t, "start", 1, nil)
+
+	// two planners race to plan the same join next to their own branches
+	branch := func(name string, n int) *eng.WorkflowStepRecord {
+		key := eng.BranchStepKey(f.run.ID, name, n)
+		return &eng.WorkflowStepRecord{ID: uuid.NewString(), RunID: f.run.ID, StepName: name, Status: eng.StepStatusPending, Input: []byte(`{}`), IdempotencyKey: &key}
+	}
+	a, joinA := branch("a", 1), branch("join", 1)
+	b, joinB := branch("b", 1), branch("join", 1)
+	if err := s.InsertSteps(ctx, []*eng.WorkflowStepRecord{a, joinA}); err != nil {
+		t.Fatalf("insert first branch: %v", err)
+	}
+	if err := s.InsertSteps(ctx, []*eng.WorkflowStepRecord{b, joinB}); err != nil {
+		t.Fatalf("insert second branch: %v", err)
+	}
+	if a.Seq != 2 || joinA.Seq != 3 || b.Seq != 4 {
+		t.Fatalf("allocated seqs = a %d, join %d, b %d; want 2, 3, 4", a.Seq, joinA.Seq, b.Seq)
+	}
+	run, err := s.LoadRun(ctx, f.run.ID)
+	if err != nil {
+		t.Fatalf("load run: %v", err)
+	}
+	var got []string
+	for _, st := range run.Steps {
+		got = append(got, fmt.Sprintf("%s@%d", st.StepName, st.Seq))
+	}
+	if strings.Join(got, ",") != "start@1,a@2,join@3,b@4" {
+		t.Fatalf("steps = %v, want the join inserted once", got)
+	}
+}
+
+func testCancelRun(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	running := f.step(t, "running", 1, nil)
+	claim(t, s, "worker", running.ID)
+	pending := f.step(t, "pending", 2, nil)
+	review := f.step(t, "review", 3, func(st *eng.WorkflowStepRecord) { st.Status = eng.StepStatusWaitingForSignal })
+
+	ok, err := s.CancelRun(ctx, f.run.ID)
+	expectOK(t, "cancel", ok, err, true)
+	expectStatus(t, s, pending.ID, eng.StepStatusCancelled)
+	// an open review is closed so it leaves the approvals inbox
+	expectStatus(t, s, review.ID, eng.StepStatusCancelled)
+	if waiting, err := s.ListWaitingSteps(ctx, f.run.WorkspaceID); err != nil || len(waiting) != 0 {
+		t.Fatalf("waiting steps of a cancelled run = %v, %v", waiting, err)
+	}
+	// a step in progress finishes its attempt
+	expectStatus(t, s, running.ID, eng.StepStatusInProgress)
+	ok, err = s.CancelRun(ctx, f.run.ID)
+	expectOK(t, "cancel twice", ok, err, false)
+}
+
+func testMigrateRun(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	run := *f.run
+	run.WorkflowVersion, run.Payload = "v2", []byte(`{"migrated":true}`)
+	ok, err := s.MigrateRun(ctx, &run, "v1")
+	expectOK(t, "migrate", ok, err, true)
+	ok, err = s.MigrateRun(ctx, &run, "v1")
+	expectOK(t, "migrate from a stale version", ok, err, false)
+
+	loaded, err := s.LoadRun(ctx, f.run.ID)
+	if err != nil || loaded.WorkflowVersion != "v2" || !sameJSON(loaded.Payload, `{"migrated":true}`) {
+		t.Fatalf("migrated run = %+v, %v", loaded, err)
+	}
+
+	// a run with a step in progress is not migrated
+	st := f.step(t, "busy", 1, nil)
+	claim(t, s, "worker", st.ID)
+	run.WorkflowVersion = "v3"
+	ok, err = s.MigrateRun(ctx, &run, "v2")
+	expectOK(t, "migrate a busy run", ok, err, false)
+}
+
+func testClaimAndLease(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "work", 1, nil)
+
+	got := claim(t, s, "worker-a", st.ID)
+	if got.Status != eng.StepStatusInProgress || got.LockOwner == nil || got.ClaimedAt == nil {
+		t.Fatalf("claimed step = %+v", got)
+	}
+	if got.WorkflowType != f.run.WorkflowType {
+		t.Fatalf("claimed step workflow type = %q, want %q", got.WorkflowType, f.run.WorkflowType)
+	}
+	owner := *got.LockOwner
+
+	ok, err := s.HeartbeatStep(ctx, got, "worker-b")
+	expectOK(t, "heartbeat by another worker", ok, err, false)
+	ok, err = s.HeartbeatStep(ctx, got, owner)
+	expectOK(t, "heartbeat by the owner", ok, err, true)
+
+	done := *got
+	done.Status, done.Result, done.LockOwner = eng.StepStatusCompleted, []byte(`{"ok":true}`), nil
+	ok, err = s.UpdateStep(ctx, &done, "worker-b")
+	expectOK(t, "update by another worker", ok, err, false)
+	ok, err = s.UpdateStep(ctx, &done, owner)
+	expectOK(t, "update by the owner", ok, err, true)
+	ok, err = s.UpdateStep(ctx, &done, owner)
+	expectOK(t, "update after the lease ended", ok, err, false)
+
+	final := expectStatus(t, s, st.ID, eng.StepStatusCompleted)
+	if !sameJSON(final.Result, `{"ok":true}`) {
+		t.Fatalf("result = %s", final.Result)
+	}
+}
+
+func testClaimSkipsStepsNotReady(t *testing.T, s eng.StateStore) {
+	f := newFixture(t, s)
+	later := eng.Now().Add(time.Hour)
+	delayed := f.step(t, "delayed", 1, func(st *eng.WorkflowStepRecord) { st.NextAttemptAt = &later })
+	held := f.step(t, "held", 2, func(st *eng.WorkflowStepRecord) { st.Status = eng.StepStatusHeld })
+	waiting := f.step(t, "waiting", 3, func(st *eng.WorkflowStepRecord) { st.Status = eng.StepStatusWaitingForSignal })
+	ready := f.step(t, "ready", 4, nil)
+
+	got := claimed(t, s, "worker-"+uuid.NewString(), delayed.ID, held.ID, waiting.ID, ready.ID)
+	if len(got) != 1 || got[ready.ID] == nil {
+		t.Fatalf("claimed %d steps, want only the ready one", len(got))
+	}
+}
+
+func testAtomicRollsBack(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "step", 1, nil)
+	run := &eng.WorkflowRun{ID: uuid.NewString(), WorkflowType: f.run.WorkflowType, WorkflowVersion: "v1", Status: eng.RunStatusRunning, Payload: []byte(`{}`)}
+	key := "atomic:" + run.ID
+	abort := errors.New("abort")
+	err := s.Atomic(ctx, func(ctx context.Context) error {
+		if err := s.CreateRun(ctx, run); err != nil {
+			return err
+		}
+		if err := s.EnqueueEvent(ctx, &eng.OutboxEvent{ID: uuid.NewString(), EventType: "run.started", Payload: []byte(`{}`), IdempotencyKey: &key}); err != nil {
+			return err
+		}
+		if _, err := s.CancelRun(ctx, f.run.ID); err != nil {
+			return err
+		}
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("atomic = %v, want the error of fn", err)
+	}
+	if got, _ := s.LoadRun(ctx, run.ID); got != nil {
+		t.Fatal("run created in a rolled back transaction")
+	}
+	if ev, _ := s.LoadEventByKey(ctx, key); ev != nil {
+		t.Fatal("event enqueued in a rolled back transaction")
+	}
+	if got := load(t, s, st.ID); got.Status != eng.StepStatusPending {
+		t.Fatalf("step of a run cancelled in a rolled back transaction = %s", got.Status)
+	}
+
+	// a nested call rolls back on its own, like a savepoint
+	err = s.Atomic(ctx, func(ctx context.Context) error {
+		if err := s.CreateRun(ctx, run); err != nil {
+			return err
+		}
+		if err := s.Atomic(ctx, func(ctx context.Context) error {
+			if err := s.EnqueueEvent(ctx, &eng.OutboxEvent{ID: uuid.NewString(), EventType: "run.started", Payload: []byte(`{}`), IdempotencyKey: &key}); err != nil {
+				return err
+			}
+			return abort
+		}); !errors.Is(err, abort) {
+			t.Errorf("nested atomic = %v, want the error of fn", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("atomic: %v", err)
+	}
+	if got, _ := s.LoadRun(ctx, run.ID); got == nil {
+		t.Fatal("run of a committed transaction not created")
+	}
+	if ev, _ := s.LoadEventByKey(ctx, key); ev != nil {
+		t.Fatal("event enqueued in a rolled back nested transaction")
+	}
+}
+
+func testRequeueStaleSteps(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "stale", 1, nil)
+	old := claim(t, s, "worker", st.ID)
+
+	// with a TTL of zero every step in progress is stale
+	for i := 0; i < 100 && load(t, s, st.ID).Status == eng.StepStatusInProgress; i++ {
+		if _, _, err := s.RequeueStaleSteps(ctx, 0, 100); err != nil {
+			t.Fatalf("requeue: %v", err)
+		}
+	}
+	requeued := expectStatus(t, s, st.ID, eng.StepStatusPending)
+	if requeued.Attempts != 1 || requeued.LockOwner != nil {
+		t.Fatalf("requeued step = attempts %d, lock owner %v", requeued.Attempts, requeued.LockOwner)
+	}
+	if requeued.NextAttemptAt == nil || !requeued.NextAttemptAt.After(eng.Now()) {
+		t.Fatalf("requeued step is not backed off: next attempt at %v", requeued.NextAttemptAt)
+	}
+
+	// the worker that lost the step can no longer record an outcome
+	ok, err := s.HeartbeatStep(ctx, old, *old.LockOwner)
+	expectOK(t, "heartbeat after requeue", ok, err, false)
+	done := *old
+	done.Status = eng.StepStatusCompleted
+	ok, err = s.UpdateStep(ctx, &done, *old.LockOwner)
+	expectOK(t, "update after requeue", ok, err, false)
+
+	// steps with a retry policy follow it
+	slow := f.step(t, "slow", 2, func(st *eng.WorkflowStepRecord) {
+		st.RetryPolicy = &eng.RetryPolicy{MaxAttempts: 3, InitialBackoff: 2 * time.Hour, MaxBackoff: 4 * time.Hour}
+		st.MaxAttempts = 3
+	})
+	limited := f.step(t, "limited", 3, func(st *eng.WorkflowStepRecord) {
+		st.RetryPolicy = &eng.RetryPolicy{MaxAttempts: 3, RetryableClasses: []string{eng.ErrorClassRateLimit}}
+		st.MaxAttempts = 3
+	})
+	// as in the scheduler, a step fails once its attempts reach the limit
+	last := f.step(t, "last", 4, func(st *eng.WorkflowStepRecord) { st.MaxAttempts = 1 })
+	claimed(t, s, "worker", slow.ID, limited.ID, last.ID)
+	reported := make(map[string]int)
+	for i := 0; i < 100 && (load(t, s, slow.ID).Status == eng.StepStatusInProgress || load(t, s, limited.ID).Status == eng.StepStatusInProgress || load(t, s, last.ID).Status == eng.StepStatusInProgress); i++ {
+		_, failed, err := s.RequeueStaleSteps(ctx, 0, 100)
+		if err != nil {
+			t.Fatalf("requeue: %v", err)
+		}
+		for _, st := range failed {
+			if st.Status != eng.StepStatusFailed || st.WorkflowType != f.run.WorkflowType || st.Error == nil {
+				t.Fatalf("failed step = %s of %q, error %v", st.Status, st.WorkflowType, st.Error)
+			}
+			reported[st.ID]++
+		}
+	}
+	// the steps that failed are returned once, for the caller to report
+	if len(reported) != 2 || reported[limited.ID] != 1 || reported[last.ID] != 1 {
+		t.Fatalf("reported failed steps = %v", reported)
+	}
+	if got := expectStatus(t, s, slow.ID, eng.StepStatusPending); got.NextAttemptAt == nil || got.NextAttemptAt.Before(eng.Now().Add(time.Hour)) {
+		t.Fatalf("step with a 2h backoff requeued until %v", got.NextAttemptAt)
+	}
+	// the policy does not retry transient errors
+	if got := expectStatus(t, s, limited.ID, eng.StepStatusFailed); got.Attempts != 1 {
+		t.Fatalf("step retrying only rate limits = attempts %d", got.Attempts)
+	}
+	if got := expectStatus(t, s, last.ID, eng.StepStatusFailed); got.Attempts != 1 {
+		t.Fatalf("step out of attempts = attempts %d", got.Attempts)
+	}
+}
+
+func testReleaseSteps(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "released", 1, nil)
+	got := claim(t, s, "worker", st.ID)
+
+	n, err := s.ReleaseSteps(ctx, *got.LockOwner)
+	if err != nil || n < 1 {
+		t.Fatalf("release = %d, %v", n, err)
+	}
+	released := expectStatus(t, s, st.ID, eng.StepStatusPending)
+	if released.LockOwner != nil || released.Attempts != 0 {
+		t.Fatalf("released step = lock owner %v, attempts %d", released.LockOwner, released.Attempts)
+	}
+	claim(t, s, "worker", st.ID)
+}
+
+func testPauseRun(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "paused", 1, nil)
+
+	ok, err := s.PauseRun(ctx, f.run.ID, true)
+	expectOK(t, "pause", ok, err, true)
+	ok, err = s.PauseRun(ctx, f.run.ID, true)
+	expectOK(t, "pause twice", ok, err, false)
+	if got := claimed(t, s, "worker-"+uuid.NewString(), st.ID); len(got) != 0 {
+		t.Fatal("a step of a paused run was claimed")
+	}
+
+	ok, err = s.PauseRun(ctx, f.run.ID, false)
+	expectOK(t, "resume", ok, err, true)
+	claim(t, s, "worker", st.ID)
+}
+
+func testPauseWorkflow(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "paused", 1, nil)
+
+	if err := s.PauseWorkflow(ctx, &eng.WorkflowPause{WorkflowType: f.run.WorkflowType, Reason: "maintenance"}); err != nil {
+		t.Fatalf("pause workflow: %v", err)
+	}
+	// pausing again updates the reason
+	if err := s.PauseWorkflow(ctx, &eng.WorkflowPause{WorkflowType: f.run.WorkflowType, Reason: "incident"}); err != nil {
+		t.Fatalf("pause workflow again: %v", err)
+	}
+	pauses, err := s.ListWorkflowPauses(ctx)
+	if err != nil {
+		t.Fatalf("list pauses: %v", err)
+	}
+	found := false
+	for _, p := range pauses {
+		if p.WorkflowType == f.run.WorkflowType {
+			found = p.Reason == "incident"
+		}
+	}
+	if !found {
+		t.Fatalf("pause of %s is not listed with the latest reason", f.run.WorkflowType)
+	}
+	if got := claimed(t, s, "worker-"+uuid.NewString(), st.ID); len(got) != 0 {
+		t.Fatal("a step of a paused workflow type was claimed")
+	}
+
+	ok, err := s.ResumeWorkflow(ctx, f.run.WorkflowType)
+	expectOK(t, "resume workflow", ok, err, true)
+	ok, err = s.ResumeWorkflow(ctx, f.run.WorkflowType)
+	expectOK(t, "resume workflow twice", ok, err, false)
+	claim(t, s, "worker", st.ID)
+}
+
+func testExpiredRuns(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	past := eng.Now().Add(-time.Minute)
+	run := &eng.WorkflowRun{
+		ID: uuid.NewString(), WorkflowType: "storetest_" + uuid.NewString(), WorkflowVersion: "v1",
+		WorkspaceID: uuid.NewString(), Status: eng.RunStatusRunning, Payload: []byte(`{}`), Deadline: &past,
+	}
+	if err := s.CreateRun(ctx, run); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	runs, err := s.ListExpiredRuns(ctx, 1000)
+	if err != nil {
+		t.Fatalf("list expired runs: %v", err)
+	}
+	for _, r := range runs {
+		if r.ID == run.ID {
+			return
+		}
+	}
+	t.Fatalf("run past its deadline is not listed")
+}
+
+func testWaitingSteps(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	past := eng.Now().Add(-time.Minute)
+	st := f.step(t, "approve", 1, func(st *eng.WorkflowStepRecord) {
+		st.Status, st.NextAttemptAt = eng.StepStatusWaitingForSignal, &past
+	})
+
+	waiting, err := s.ListWaitingSteps(ctx, f.run.WorkspaceID)
+	if err != nil || len(waiting) != 1 || waiting[0].ID != st.ID {
+		t.Fatalf("waiting steps of the workspace = %v, %v", waiting, err)
+	}
+	expired, err := s.ListExpiredWaitingSteps(ctx, 1000)
+	if err != nil {
+		t.Fatalf("list expired waiting steps: %v", err)
+	}
+	found := false
+	for _, e := range expired {
+		found = found || e.ID == st.ID
+	}
+	if !found {
+		t.Fatal("waiting step past its deadline is not listed")
+	}
+
+	done := *st
+	done.Status, done.Result, done.NextAttemptAt = eng.StepStatusCompleted, []byte(`{"approved":true}`), nil
+	ok, err := s.UpdateWaitingStep(ctx, &done)
+	expectOK(t, "signal", ok, err, true)
+	ok, err = s.UpdateWaitingStep(ctx, &done)
+	expectOK(t, "signal twice", ok, err, false)
+	expectStatus(t, s, st.ID, eng.StepStatusCompleted)
+}
+
+func testLogs(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "logged", 1, nil)
+	for _, msg := range []string{"first", "second"} {
+		if err := s.AppendLog(ctx, &eng.StepLog{ID: uuid.NewString(), StepID: st.ID, Level: "info", Message: msg}); err != nil {
+			t.Fatalf("append log: %v", err)
+		}
+	}
+	logs, err := s.ListRunLogs(ctx, f.run.ID)
+	if err != nil || len(logs) != 2 || logs[0].Message != "first" || logs[1].Message != "second" {
+		t.Fatalf("run logs = %v, %v", logs, err)
+	}
+}
+
+func testOutboxIdempotency(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	key := "storetest:" + uuid.NewString()
+	for i := 0; i < 2; i++ {
+		ev := &eng.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: []byte(`{}`), State: eng.OutboxStatePending, IdempotencyKey: &key}
+		if err := s.EnqueueEvent(ctx, ev); err != nil {
+			t.Fatalf("enqueue #%d: %v", i+1, err)
+		}
+	}
+	ev, err := s.LoadEventByKey(ctx, key)
+	if err != nil || ev == nil || ev.State != eng.OutboxStatePending {
+		t.Fatalf("load event by key = %+v, %v", ev, err)
+	}
+	byID, err := s.LoadEvent(ctx, ev.ID)
+	if err != nil || byID == nil || byID.ID != ev.ID {
+		t.Fatalf("load event = %+v, %v", byID, err)
+	}
+	if missing, err := s.LoadEventByKey(ctx, "storetest:"+uuid.NewString()); err != nil || missing != nil {
+		t.Fatalf("load unknown key = %+v, %v", missing, err)
+	}
+}
+
+func testDeadLetterSteps(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	f := newFixture(t, s)
+	st := f.step(t, "flaky", 1, nil)
+	got := claim(t, s, "worker", st.ID)
+	failed := *got
+	msg := "boom"
+	failed.Status, failed.Error, failed.Attempts, failed.LockOwner = eng.StepStatusFailed, &msg, 5, nil
+	ok, err := s.UpdateStep(ctx, &failed, *got.LockOwner)
+	expectOK(t, "fail step", ok, err, true)
+	run := *f.run
+	run.Status = eng.RunStatusFailed
+	if err := s.UpdateRun(ctx, &run); err != nil {
+		t.Fatalf("fail run: %v", err)
+	}
+
+	steps, total, err := s.ListDeadLetterSteps(ctx, eng.DeadLetterFilter{WorkflowType: f.run.WorkflowType})
+	if err != nil || total != 1 || len(steps) != 1 || steps[0].ID != st.ID {
+		t.Fatalf("dead-letter steps = %v (total %d), %v", steps, total, err)
+	}
+
+	ok, err = s.ReplayStep(ctx, st.ID, []byte(`{"fixed":true}`))
+	expectOK(t, "replay", ok, err, true)
+	replayed := expectStatus(t, s, st.ID, eng.StepStatusPending)
+	if replayed.Attempts != 0 || replayed.Error != nil || !sameJSON(replayed.Input, `{"fixed":true}`) {
+		t.Fatalf("replayed step = attempts %d, error %v, input %s", replayed.Attempts, replayed.Error, replayed.Input)
+	}
+	// the edited step's side effects are not deduplicated against the old input's
+	if want := eng.ReplayStepKey(st, []byte(`{"fixed":true}`)); replayed.IdempotencyKey == nil || *replayed.IdempotencyKey != want {
+		t.Fatalf("replayed step key = %v, want %s", replayed.IdempotencyKey, want)
+	}
+	reopened, err := s.LoadRun(ctx, f.run.ID)
+	if err != nil || reopened.Status != eng.RunStatusRunning {
+		t.Fatalf("replayed run = %+v, %v", reopened, err)
+	}
+	ok, err = s.DiscardStep(ctx, st.ID)
+	expectOK(t, "discard a pending step", ok, err, false)
+
+	// a cancelled run stays cancelled; its failed steps can only be discarded
+	g := newFixture(t, s)
+	other := g.step(t, "flaky", 1, nil)
+	got = claim(t, s, "worker", other.ID)
+	failed = *got
+	failed.Status, failed.LockOwner = eng.StepStatusFailed, nil
+	ok, err = s.UpdateStep(ctx, &failed, *got.LockOwner)
+	expectOK(t, "fail step", ok, err, true)
+	ok, err = s.CancelRun(ctx, g.run.ID)
+	expectOK(t, "cancel", ok, err, true)
+	ok, err = s.ReplayStep(ctx, other.ID, nil)
+	expectOK(t, "replay a step of a cancelled run", ok, err, false)
+	ok, err = s.DiscardStep(ctx, other.ID)
+	expectOK(t, "discard", ok, err, true)
+	expectStatus(t, s, other.ID, eng.StepStatusDiscarded)
+}
+
+func testDeadLetterEvents(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	key := "storetest:" + uuid.NewString()
+	ev := &eng.OutboxEvent{ID: uuid.NewString(), EventType: "email_send", Payload: []byte(`{}`), State: eng.OutboxStateFailed, IdempotencyKey: &key}
+	if err := s.EnqueueEvent(ctx, ev); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	events, _, err := s.ListDeadLetterEvents(ctx, eng.DeadLetterFilter{Limit: 1000})
+	if err != nil {
+		t.Fatalf("list dead-letter events: %v", err)
+	}
+	found := false
+	for _, e := range events {
+		found = found || e.ID == ev.ID
+	}
+	if !found {
+		t.Fatal("failed event is not listed")
+	}
+
+	ok, err := s.ReplayEvent(ctx, ev.ID, []byte(`{"to":"fixed@example.com"}`))
+	expectOK(t, "replay", ok, err, true)
+	replayed, err := s.LoadEvent(ctx, ev.ID)
+	if err != nil || replayed.State != eng.OutboxStatePending || !sameJSON(replayed.Payload, `{"to":"fixed@example.com"}`) {
+		t.Fatalf("replayed event = %+v, %v", replayed, err)
+	}
+	ok, err = s.DiscardEvent(ctx, ev.ID)
+	expectOK(t, "discard a pending event", ok, err, false)
+
+	for _, msg := range []string{"dead-letter replay", "dead-letter discard"} {
+		if err := s.AppendEventAudit(ctx, &eng.EventAudit{ID: uuid.NewString(), EventID: ev.ID, Level: "warn", Message: msg, Meta: []byte(`{"actor":"ops"}`)}); err != nil {
+			t.Fatalf("append event audit: %v", err)
+		}
+	}
+	audits, err := s.ListEventAudits(ctx, ev.ID)
+	if err != nil || len(audits) != 2 || audits[0].Message != "dead-letter replay" || audits[1].EventID != ev.ID || !sameJSON(audits[1].Meta, `{"actor":"ops"}`) {
+		t.Fatalf("event audits = %+v, %v", audits, err)
+	}
+	if audits, err := s.ListEventAudits(ctx, uuid.NewString()); err != nil || len(audits) != 0 {
+		t.Fatalf("audits of another event = %+v, %v", audits, err)
+	}
+}
+
+func testTriggers(t *testing.T, s eng.StateStore) {
+	ctx := context.Background()
+	workspaceID := uuid.NewString()
+	due := eng.Now().Add(-time.Minute).Truncate(time.Microsecond)
+	tr := &eng.WorkflowTrigger{
+		ID: uuid.NewString(), WorkspaceID: workspaceID, WorkflowType: "storetest_" + uuid.NewString(),
+		Schedule: "*/5 * * * *", Payload: []byte(`{}`), Enabled: true, NextRunAt: &due, CreatedBy: uuid.NewString(),
+	}
+	if err := s.CreateTrigger(ctx, tr); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	disabled := &eng.WorkflowTrigger{
+		ID: uuid.NewString(), WorkspaceID: workspaceID, WorkflowType: tr.WorkflowType,
+		Schedule: "*/5 * * * *", Payload: []byte(`{}`), NextRunAt: &due, CreatedBy: tr.CreatedBy,
+	}
+	if err := s.CreateTrigger(ctx, disabled); err != nil {
+		t.Fatalf("create disabled trigger: %v", err)
+	}
+
+	listed, err := s.ListTriggers(ctx, workspaceID)
+	if err != nil || len(listed) != 2 || listed[0].Timezone != "UTC" {
+		t.Fatalf("triggers of the workspace = %v, %v", listed, err)
+	}
+	dueTriggers, err := s.ListDueTriggers(ctx, 1000)
+	if err != nil {
+		t.Fatalf("list due triggers: %v", err)
+	}
+	var scheduled *time.Time
+	for _, d := range dueTriggers {
+		if d.ID == disabled.ID {
+			t.Fatal("a disabled trigger is due")
+		}
+		if d.ID == tr.ID {
+			scheduled = d.NextRunAt
+		}
+	}
+	if scheduled == nil {
+		t.Fatal("trigger past its next run is not due")
+	}
+
+	next := scheduled.Add(5 * time.Minute)
+	runID := uuid.NewString()
+	ok, err := s.MarkTriggerFired(ctx, tr.ID, *scheduled, &next, runID)
+	expectOK(t, "fire", ok, err, true)
+	ok, err = s.MarkTriggerFired(ctx, tr.ID, *scheduled, &next, uuid.NewString())
+	expectOK(t, "fire the same schedule twice", ok, err, false)
+	fired, err := s.LoadTrigger(ctx, tr.ID)
+	if err != nil || fired.LastRunID == nil || *fired.LastRunID != runID || fired.NextRunAt == nil || !fired.NextRunAt.Equal(next) {
+		t.Fatalf("fired trigger = %+v, %v", fired, err)
+	}
+
+	ok, err = s.DeleteTrigger(ctx, tr.ID)
+	expectOK(t, "delete", ok, err, true)
+	if gone, err := s.LoadTrigger(ctx, tr.ID); err != nil || gone != nil {
+		t.Fatalf("deleted trigger = %+v, %v", gone, err)
+	}
+}
+
+func testEventSubscriptions(t *testing.T, s eng.StateStore) {
+	log, ok := s.(eng.EventLog)
+	if !ok {
+		t.Skip("store does not implement engine.EventLog")
+	}
+	ctx := context.Background()
+	topic := "storetest_" + uuid.NewString()
+	start, err := log.LastEventSeq(ctx)
+	if err != nil {
+		t.Fatalf("last event seq: %v", err)
+	}
+	var stored []*eng.OutboxEvent
+	for _, typ := range []string{"run.started", "step.completed", "run.failed"} {
+		key := "storetest:" + uuid.NewString()
+		ev := &eng.OutboxEvent{ID: uuid.NewString(), EventType: topic + "." + typ, Payload: []byte(`{}`), IdempotencyKey: &key}
+		if err := log.EnqueueEvent(ctx, ev); err != nil {
+			t.Fatalf("enqueue %s: %v", typ, err)
+		}
+		if ev.Seq <= start || (len(stored) > 0 && ev.Seq <= stored[len(stored)-1].Seq) {
+			t.Fatalf("%s seq = %d, not after %d", typ, ev.Seq, start)
+		}
+		stored = append(stored, ev)
+	}
+
+	runs, err := log.ReadEvents(ctx, start, topic+".run.*", 100)
+	if err != nil || len(runs) != 2 || runs[0].ID != stored[0].ID || runs[1].ID != stored[2].ID {
+		t.Fatalf("run events = %v, %v", runs, err)
+	}
+	all, err := log.ReadEvents(ctx, stored[0].Seq, topic+".>", 1)
+	if err != nil || len(all) != 1 || all[0].ID != stored[1].ID {
+		t.Fatalf("events after the first, one at a time = %v, %v", all, err)
+	}
+
+	name := "storetest_" + uuid.NewString()
+	sub, err := log.EnsureSubscription(ctx, &eng.EventSubscription{Name: name, Pattern: topic + ".>", AckedSeq: start})
+	if err != nil || sub.AckedSeq != start {
+		t.Fatalf("ensure subscription = %+v, %v", sub, err)
+	}
+	if leased, err := log.LeaseSubscription(ctx, name, "worker-a", time.Minute); err != nil || leased == nil || leased.LeaseOwner != "worker-a" {
+		t.Fatalf("lease = %+v, %v", leased, err)
+	}
+	if leased, err := log.LeaseSubscription(ctx, name, "worker-b", time.Minute); err != nil || leased != nil {
+		t.Fatalf("lease held by another worker = %+v, %v", leased, err)
+	}
+	ok, err = log.AckSubscription(ctx, name, "worker-b", stored[1].Seq)
+	expectOK(t, "ack without the lease", ok, err, false)
+	ok, err = log.AckSubscription(ctx, name, "worker-a", stored[1].Seq)
+	expectOK(t, "ack", ok, err, true)
+	ok, err = log.AckSubscription(ctx, name, "worker-a", stored[0].Seq)
+	expectOK(t, "ack backwards", ok, err, false)
+
+	// ensuring it again keeps the offset
+	sub, err = log.EnsureSubscription(ctx, &eng.EventSubscription{Name: name, Pattern: topic + ".run.*"})
+	if err != nil || sub.AckedSeq != stored[1].Seq || sub.Pattern != topic+".run.*" {
+		t.Fatalf("ensure an existing subscription = %+v, %v", sub, err)
+	}
+	if err := log.ReleaseSubscription(ctx, name, "worker-a"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if leased, err := log.LeaseSubscription(ctx, name, "worker-b", time.Minute); err != nil || leased == nil || leased.AckedSeq != stored[1].Seq {
+		t.Fatalf("lease after release = %+v, %v", leased, err)
+	}
+
+	ok, err = log.DeleteSubscription(ctx, name)
+	expectOK(t, "delete", ok, err, true)
+	if leased, err := log.LeaseSubscription(ctx, name, "worker-b", time.Minute); err != nil || leased != nil {
+		t.Fatalf("lease a deleted subscription = %+v, %v", leased, err)
+	}
+}
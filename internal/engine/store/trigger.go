@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	eng "github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"gorm.io/gorm"
+)
+
+func toEngineTrigger(e *entity.WorkflowTrigger) *eng.WorkflowTrigger {
+	return &eng.WorkflowTrigger{
+		ID: e.ID, WorkspaceID: e.WorkspaceID, WorkflowType: e.WorkflowType, Schedule: e.Schedule,
+		Timezone: e.Timezone, Payload: e.Payload, Enabled: e.Enabled, NextRunAt: e.NextRunAt,
+		LastRunAt: e.LastRunAt, LastRunID: e.LastRunID, CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt,
+	}
+}
+
+func toEngineTriggers(ents []entity.WorkflowTrigger) []*eng.WorkflowTrigger {
+	triggers := make([]*eng.WorkflowTrigger, 0, len(ents))
+	for i := range ents {
+		triggers = append(triggers, toEngineTrigger(&ents[i]))
+	}
+	return triggers
+}
+
+func (s *PostgresStore) CreateTrigger(ctx context.Context, t *eng.WorkflowTrigger) error {
+	ent := &entity.WorkflowTrigger{
+		ID: t.ID, WorkspaceID: t.WorkspaceID, WorkflowType: t.WorkflowType, Schedule: t.Schedule,
+		Timezone: t.Timezone, Payload: t.Payload, Enabled: t.Enabled, NextRunAt: t.NextRunAt,
+		CreatedBy: t.CreatedBy,
+	}
+	if err := s.conn(ctx).Create(ent).Error; err != nil {
+		return err
+	}
+	// gorm skips zero values with a default tag, so persist a disabled trigger explicitly
+	if !t.Enabled {
+		if err := s.conn(ctx).Model(ent).Update("enabled", false).Error; err != nil {
+			return err
+		}
+	}
+	t.CreatedAt, t.UpdatedAt = ent.CreatedAt, ent.UpdatedAt
+	return nil
+}
+
+func (s *PostgresStore) LoadTrigger(ctx context.Context, triggerID string) (*eng.WorkflowTrigger, error) {
+	var ent entity.WorkflowTrigger
+	if err := s.conn(ctx).First(&ent, "id = ?", triggerID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toEngineTrigger(&ent), nil
+}
+
+func (s *PostgresStore) ListTriggers(ctx context.Context, workspaceID string) ([]*eng.WorkflowTrigger, error) {
+	q := s.conn(ctx).Model(&entity.WorkflowTrigger{})
+	if workspaceID != "" {
+		q = q.Where("workspace_id = ?", workspaceID)
+	}
+	var ents []entity.WorkflowTrigger
+	if err := q.Order("created_at").Find(&ents).Error; err != nil {
+		return nil, err
+	}
+	return toEngineTriggers(ents), nil
+}
+
+func (s *PostgresStore) UpdateTrigger(ctx context.Context, t *eng.WorkflowTrigger) error {
+	updates := map[string]interface{}{
+		"schedule":    t.Schedule,
+		"timezone":    t.Timezone,
+		"payload":     []byte(t.Payload),
+		"enabled":     t.Enabled,
+		"next_run_at": t.NextRunAt,
+		"updated_at":  time.Now(),
+	}
+	return s.conn(ctx).Model(&entity.WorkflowTrigger{ID: t.ID}).Updates(updates).Error
+}
+
+func (s *PostgresStore) DeleteTrigger(ctx context.Context, triggerID string) (bool, error) {
+	res := s.conn(ctx).Delete(&entity.WorkflowTrigger{}, "id = ?", triggerID)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *PostgresStore) ListDueTriggers(ctx context.Context, limit int) ([]*eng.WorkflowTrigger, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	var ents []entity.WorkflowTrigger
+	err := s.conn(ctx).
+		Where("enabled = ? AND next_run_at IS NOT NULL AND next_run_at <= now()", true).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&ents).Error
+	if err != nil {
+		return nil, err
+	}
+	return toEngineTriggers(ents), nil
+}
+
+func (s *PostgresStore) MarkTriggerFired(ctx context.Context, triggerID string, scheduled time.Time, next *time.Time, runID string) (bool, error) {
+	res := s.conn(ctx).Model(&entity.WorkflowTrigger{}).
+		Where("id = ? AND next_run_at = ?", triggerID, scheduled).
+		Updates(map[string]interface{}{
+			"next_run_at": next,
+			"last_run_at": scheduled,
+			"last_run_id": runID,
+			"updated_at":  time.Now(),
+		})
+	return res.RowsAffected > 0, res.Error
+}
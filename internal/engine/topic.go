@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Event types are dot-separated topics such as "run.failed". A pattern
+// matches a topic segment by segment: "*" matches any one segment and a
+// trailing ">" matches one or more segments, so "run.*" matches
+// "run.failed" and ">" (or an empty pattern) matches every topic.
+
+// MatchTopic reports whether topic matches pattern.
+func MatchTopic(pattern, topic string) bool {
+	if pattern == "" || pattern == ">" {
+		return true
+	}
+	ps, ts := strings.Split(pattern, "."), strings.Split(topic, ".")
+	for i, p := range ps {
+		if p == ">" && i == len(ps)-1 {
+			return len(ts) > i
+		}
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// TopicRegexp returns a POSIX regular expression matching the same topics as
+// pattern, for stores that filter in SQL.
+func TopicRegexp(pattern string) string {
+	if pattern == "" || pattern == ">" {
+		return "^.+$"
+	}
+	ps := strings.Split(pattern, ".")
+	parts := make([]string, len(ps))
+	for i, p := range ps {
+		switch {
+		case p == ">" && i == len(ps)-1:
+			parts[i] = ".+"
+		case p == "*":
+			parts[i] = "[^.]+"
+		default:
+			parts[i] = regexp.QuoteMeta(p)
+		}
+	}
+	return "^" + strings.Join(parts, `\.`) + "$"
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"run.*", "run.failed", true},
+		{"run.*", "run", false},
+		{"run.*", "run.step.failed", false},
+		{"run.*", "step.completed", false},
+		{"*.completed", "step.completed", true},
+		{"workflow.>", "workflow.run.deadline_exceeded", true},
+		{"workflow.>", "workflow", false},
+		{">", "email_send", true},
+		{"", "run.started", true},
+		{"email_send", "email_send", true},
+		{"email_send", "email_sent", false},
+	}
+	for _, c := range cases {
+		if got := MatchTopic(c.pattern, c.topic); got != c.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+		if got := regexp.MustCompile(TopicRegexp(c.pattern)).MatchString(c.topic); got != c.want {
+			t.Errorf("TopicRegexp(%q) matches %q = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
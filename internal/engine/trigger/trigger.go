@@ -0,0 +1,117 @@
+// Package trigger starts workflow runs from cron-scheduled triggers stored in
+// Postgres. Each activation maps to a deterministic run ID, so an activation
+// creates at most one run even when several schedulers fire it or one crashes
+// halfway.
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/cron"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	"github.com/google/uuid"
+)
+
+// NextRun returns the first activation of t after the given time, in the
+// trigger's timezone. It validates the schedule and timezone.
+func NextRun(t *engine.WorkflowTrigger, after time.Time) (time.Time, error) {
+	sched, err := cron.Parse(t.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("trigger: invalid timezone %q: %w", t.Timezone, err)
+	}
+	next := sched.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("trigger: schedule %q never fires", t.Schedule)
+	}
+	return next.UTC(), nil
+}
+
+// RunID returns the run ID of the activation of triggerID at scheduled.
+func RunID(triggerID string, scheduled time.Time) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(triggerID+"@"+scheduled.UTC().Format(time.RFC3339))).String()
+}
+
+// StartMonitor periodically starts runs for due triggers. Activations missed
+// while no monitor was running fire once and the schedule continues from now.
+func StartMonitor(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, interval time.Duration, batchSize int) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := FireDue(ctx, store, reg, batchSize); err != nil {
+					engine.DefaultLogger().Error("trigger: list due triggers failed", engine.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+// FireDue fires up to batchSize due triggers. Failures to fire a single
+// trigger are logged.
+func FireDue(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, batchSize int) error {
+	triggers, err := store.ListDueTriggers(ctx, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, t := range triggers {
+		if err := Fire(ctx, store, reg, t, engine.Now()); err != nil {
+			engine.DefaultLogger().Error("trigger: fire failed", engine.F("trigger_id", t.ID), engine.F("workflow_type", t.WorkflowType), engine.Err(err))
+		}
+	}
+	return nil
+}
+
+// Fire starts the run of t's due activation and schedules the next one.
+func Fire(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, t *engine.WorkflowTrigger, now time.Time) error {
+	if t.NextRunAt == nil {
+		return nil
+	}
+	scheduled := *t.NextRunAt
+	runID := RunID(t.ID, scheduled)
+
+	existing, err := store.LoadRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		run := &engine.WorkflowRun{ID: runID, WorkflowType: t.WorkflowType, WorkspaceID: t.WorkspaceID, Payload: t.Payload}
+		if err := workflow.StartRun(ctx, store, reg, run); err != nil {
+			return err
+		}
+	} else if len(existing.Steps) == 0 {
+		// a previous attempt created the run but crashed before planning it
+		if err := workflow.Advance(ctx, store, reg, runID); err != nil {
+			return err
+		}
+	}
+
+	var next *time.Time
+	from := scheduled
+	if now.After(from) {
+		from = now
+	}
+	n, err := NextRun(t, from)
+	if err != nil {
+		engine.DefaultLogger().Warn("trigger: no next activation", engine.F("trigger_id", t.ID), engine.Err(err))
+	} else {
+		next = &n
+	}
+	if _, err := store.MarkTriggerFired(ctx, t.ID, scheduled, next, runID); err != nil {
+		return err
+	}
+	return nil
+}
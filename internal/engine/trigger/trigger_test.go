@@ -0,0 +1,183 @@
+package trigger
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/store"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	"github.com/google/uuid"
+)
+
+// clock is a fake engine clock moved by the tests.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// digest sends one digest per run.
+type digest struct{}
+
+func (digest) ID() string      { return "digest" }
+func (digest) Version() string { return "v1" }
+
+func (digest) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	if len(run.Steps) == 0 {
+		return []engine.WorkflowStepDef{{StepName: "send_digest", Input: run.Payload}}, nil
+	}
+	return nil, nil
+}
+
+type fixture struct {
+	store *store.MemoryStore
+	reg   *workflow.Registry
+	clock *clock
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{store: store.NewMemoryStore(), reg: workflow.NewRegistry(), clock: &clock{now: time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC)}}
+	f.reg.Register(digest{})
+	t.Cleanup(engine.SetClock(f.clock))
+	return f
+}
+
+// daily creates a trigger firing at 09:00 UTC every day, first due at the
+// next activation.
+func (f *fixture) daily(t *testing.T) *engine.WorkflowTrigger {
+	t.Helper()
+	tr := &engine.WorkflowTrigger{ID: uuid.NewString(), WorkspaceID: uuid.NewString(), WorkflowType: "digest", Schedule: "0 9 * * *", Timezone: "UTC", Payload: []byte(`{}`), Enabled: true}
+	next, err := NextRun(tr, f.clock.Now())
+	if err != nil {
+		t.Fatalf("next run: %v", err)
+	}
+	tr.NextRunAt = &next
+	if err := f.store.CreateTrigger(context.Background(), tr); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	return tr
+}
+
+func (f *fixture) load(t *testing.T, triggerID string) *engine.WorkflowTrigger {
+	t.Helper()
+	tr, err := f.store.LoadTrigger(context.Background(), triggerID)
+	if err != nil || tr == nil {
+		t.Fatalf("load trigger = %v, %v", tr, err)
+	}
+	return tr
+}
+
+func (f *fixture) fireDue(t *testing.T) {
+	t.Helper()
+	if err := FireDue(context.Background(), f.store, f.reg, 100); err != nil {
+		t.Fatalf("fire due: %v", err)
+	}
+}
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, 1, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestNextRunUsesTriggerTimezone(t *testing.T) {
+	tr := &engine.WorkflowTrigger{Schedule: "0 9 * * *", Timezone: "America/New_York"}
+	next, err := NextRun(tr, at(6, 12, 0))
+	if err != nil {
+		t.Fatalf("next run: %v", err)
+	}
+	if want := at(6, 14, 0); !next.Equal(want) {
+		t.Fatalf("next run = %v, want 09:00 New York (%v)", next, want)
+	}
+	for _, bad := range []*engine.WorkflowTrigger{{Schedule: "0 9 * *", Timezone: "UTC"}, {Schedule: "0 9 * * *", Timezone: "Mars/Olympus"}} {
+		if _, err := NextRun(bad, at(6, 12, 0)); err == nil {
+			t.Fatalf("next run of %q in %q succeeded", bad.Schedule, bad.Timezone)
+		}
+	}
+}
+
+func TestFireDueStartsOneRunPerActivation(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	tr := f.daily(t)
+
+	f.clock.advance(29 * time.Minute)
+	f.fireDue(t)
+	if got := f.load(t, tr.ID); got.LastRunID != nil {
+		t.Fatalf("trigger fired before 09:00: run %s", *got.LastRunID)
+	}
+
+	f.clock.advance(time.Minute)
+	f.fireDue(t)
+	fired := f.load(t, tr.ID)
+	runID := RunID(tr.ID, at(6, 9, 0))
+	if fired.LastRunID == nil || *fired.LastRunID != runID || !fired.NextRunAt.Equal(at(7, 9, 0)) {
+		t.Fatalf("fired trigger = last run %v, next %v", fired.LastRunID, fired.NextRunAt)
+	}
+	run, err := f.store.LoadRun(ctx, runID)
+	if err != nil || run == nil || run.WorkspaceID != tr.WorkspaceID || len(run.Steps) != 1 {
+		t.Fatalf("triggered run = %+v, %v", run, err)
+	}
+
+	// a scheduler that listed the trigger before it fired starts no second run
+	if err := Fire(ctx, f.store, f.reg, tr, f.clock.Now()); err != nil {
+		t.Fatalf("fire a stale trigger: %v", err)
+	}
+	if runs, total, _ := f.store.ListRuns(ctx, engine.RunFilter{}); total != 1 {
+		t.Fatalf("%d runs after firing twice: %v", total, runs)
+	}
+	if got := f.load(t, tr.ID); !got.NextRunAt.Equal(at(7, 9, 0)) {
+		t.Fatalf("stale fire moved the schedule to %v", got.NextRunAt)
+	}
+}
+
+func TestFireCatchesUpMissedActivationsOnce(t *testing.T) {
+	f := newFixture(t)
+	tr := f.daily(t)
+
+	// no monitor ran for three days
+	f.clock.advance(3*24*time.Hour + time.Hour)
+	f.fireDue(t)
+	f.fireDue(t)
+	if _, total, _ := f.store.ListRuns(context.Background(), engine.RunFilter{}); total != 1 {
+		t.Fatalf("%d runs for missed activations, want 1", total)
+	}
+	if got := f.load(t, tr.ID); !got.NextRunAt.Equal(at(10, 9, 0)) {
+		t.Fatalf("next run = %v, want the schedule to continue from now", got.NextRunAt)
+	}
+}
+
+func TestFirePlansRunLeftUnplanned(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	tr := f.daily(t)
+	f.clock.advance(30 * time.Minute)
+
+	// a previous attempt created the run and crashed before planning it
+	runID := RunID(tr.ID, *tr.NextRunAt)
+	if err := f.store.CreateRun(ctx, &engine.WorkflowRun{ID: runID, WorkflowType: "digest", WorkflowVersion: "v1", Status: engine.RunStatusRunning, Payload: tr.Payload}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if err := Fire(ctx, f.store, f.reg, tr, f.clock.Now()); err != nil {
+		t.Fatalf("fire: %v", err)
+	}
+	run, _ := f.store.LoadRun(ctx, runID)
+	if len(run.Steps) != 1 || run.Steps[0].StepName != "send_digest" {
+		t.Fatalf("resumed run has steps %v", run.Steps)
+	}
+	if got := f.load(t, tr.ID); got.LastRunID == nil || *got.LastRunID != runID {
+		t.Fatalf("trigger last run = %v, want %s", got.LastRunID, runID)
+	}
+}
@@ -0,0 +1,312 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// RunChild executes a claimed child-run step: it starts the child run, once,
+// and parks the step until that run ends. The child's ID is derived from the
+// step, so a re-run after a crash finds the run it already started.
+func RunChild(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, run *engine.WorkflowRun, step *engine.WorkflowStepRecord) (engine.StepResult, error) {
+	var in engine.ChildRunInput
+	if err := json.Unmarshal(step.Input, &in); err != nil || in.WorkflowType == "" {
+		return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("workflow: child-run step %q needs a workflow_type", step.StepName))
+	}
+	childID := uuid.NewSHA1(uuid.NameSpaceOID, []byte("child:"+step.ID)).String()
+	existing, err := store.LoadRun(ctx, childID)
+	if err != nil {
+		return engine.StepResult{Success: false}, err
+	}
+	if existing == nil {
+		payload := in.Payload
+		if len(payload) == 0 {
+			payload = json.RawMessage(`{}`)
+		}
+		child := &engine.WorkflowRun{
+			ID: childID, WorkflowType: in.WorkflowType, WorkspaceID: run.WorkspaceID, Payload: payload,
+			ParentRunID: run.ID, ParentStepID: step.ID, Priority: run.Priority,
+		}
+		if err := StartRun(ctx, store, reg, child); err != nil {
+			return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("workflow: start child run: %w", err))
+		}
+	}
+	out, _ := json.Marshal(map[string]string{"run_id": childID})
+	return engine.StepResult{Success: true, Output: out, AwaitChildren: true}, nil
+}
+
+// RunMap executes a claimed map step. The first time it inserts one item
+// step per element, holding those beyond the concurrency cap, and parks the
+// map step until they finish. A map step claimed again after its items
+// completed returns a copy of itself to run as its aggregate handler, with
+// the item outputs as input.
+func RunMap(ctx context.Context, store engine.StateStore, run *engine.WorkflowRun, step *engine.WorkflowStepRecord) (res engine.StepResult, aggregate *engine.WorkflowStepRecord, err error) {
+	var in engine.MapInput
+	if err := json.Unmarshal(step.Input, &in); err != nil || in.ItemStep == "" {
+		return engine.StepResult{Success: false}, nil, engine.Permanent(fmt.Errorf("workflow: map step %q needs an item_step", step.StepName))
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(in.Items, &items); err != nil && len(in.Items) > 0 && string(in.Items) != "null" {
+		return engine.StepResult{Success: false}, nil, engine.Permanent(fmt.Errorf("workflow: items of map step %q are not an array", step.StepName))
+	}
+
+	if children := mapItems(run, step.ID); len(children) > 0 || len(items) == 0 {
+		outputs := make([]json.RawMessage, 0, len(children))
+		for _, it := range children {
+			// re-run after a crash before the step was parked
+			if it.Status != engine.StepStatusCompleted {
+				out, _ := json.Marshal(map[string]int{"items": len(children)})
+				return engine.StepResult{Success: true, Output: out, AwaitChildren: true}, nil, nil
+			}
+			outputs = append(outputs, it.Result)
+		}
+		b, _ := json.Marshal(map[string]interface{}{"items": outputs})
+		if in.Aggregate != "" {
+			agg := *step
+			agg.StepName, agg.Input = in.Aggregate, b
+			return engine.StepResult{}, &agg, nil
+		}
+		return engine.StepResult{Success: true, Output: b}, nil, nil
+	}
+
+	// the store numbers the items after the run's last step, so map steps
+	// running at the same time never collide; keyed by the map step and
+	// index, items of a map step that runs again are not inserted twice
+	recs := make([]*engine.WorkflowStepRecord, 0, len(items))
+	for i, item := range items {
+		input, _ := json.Marshal(map[string]interface{}{"index": i, "item": item})
+		rec := &engine.WorkflowStepRecord{
+			ID:           uuid.NewString(),
+			RunID:        run.ID,
+			StepName:     in.ItemStep,
+			Kind:         engine.StepKindMapItem,
+			Status:       engine.StepStatusPending,
+			Input:        input,
+			ParentStepID: &step.ID,
+			Priority:     step.Priority,
+		}
+		if in.Concurrency > 0 && i >= in.Concurrency {
+			rec.Status = engine.StepStatusHeld
+		}
+		key := fmt.Sprintf("%s:item:%d", engine.StepKey(step), i)
+		rec.IdempotencyKey = &key
+		recs = append(recs, rec)
+	}
+	if err := store.InsertSteps(ctx, recs); err != nil {
+		return engine.StepResult{Success: false}, nil, err
+	}
+	out, _ := json.Marshal(map[string]int{"items": len(items)})
+	return engine.StepResult{Success: true, Output: out, AwaitChildren: true}, nil, nil
+}
+
+// mapItems returns the item steps of a map step in index order.
+func mapItems(run *engine.WorkflowRun, mapStepID string) []*engine.WorkflowStepRecord {
+	var items []*engine.WorkflowStepRecord
+	for i := range run.Steps {
+		if p := run.Steps[i].ParentStepID; p != nil && *p == mapStepID {
+			items = append(items, &run.Steps[i])
+		}
+	}
+	return items
+}
+
+// settle resolves the steps of run that wait for children: a child-run step
+// whose run ended and a map step whose items finished. It also releases held
+// map items as concurrency slots free up. Resolved steps are updated in
+// run.Steps too.
+func settle(ctx context.Context, store engine.StateStore, run *engine.WorkflowRun) error {
+	for i := range run.Steps {
+		st := &run.Steps[i]
+		if st.Status != engine.StepStatusWaitingForChildren {
+			continue
+		}
+		var err error
+		switch st.Kind {
+		case engine.StepKindChildRun:
+			err = settleChild(ctx, store, st)
+		case engine.StepKindMap:
+			err = settleMap(ctx, store, run, st)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func settleChild(ctx context.Context, store engine.StateStore, st *engine.WorkflowStepRecord) error {
+	var ref struct {
+		RunID string `json:"run_id"`
+	}
+	_ = json.Unmarshal(st.Result, &ref)
+	child, err := store.LoadRun(ctx, ref.RunID)
+	if err != nil || child == nil {
+		return err
+	}
+	var output json.RawMessage
+	for _, cs := range child.Steps {
+		if cs.Status == engine.StepStatusCompleted && cs.Kind != engine.StepKindCompensation {
+			output = cs.Result
+		}
+	}
+	next := *st
+	switch child.Status {
+	case engine.RunStatusCompleted:
+		next.Status = engine.StepStatusCompleted
+	case engine.RunStatusFailed, engine.RunStatusCancelled, engine.RunStatusRejected:
+		next.Status = engine.StepStatusFailed
+		msg := fmt.Sprintf("child run %s %s", child.ID, child.Status)
+		next.Error = &msg
+	default:
+		return nil
+	}
+	next.Result, _ = json.Marshal(map[string]interface{}{"run_id": child.ID, "status": child.Status, "output": output})
+	return transition(ctx, store, st, &next)
+}
+
+func settleMap(ctx context.Context, store engine.StateStore, run *engine.WorkflowRun, st *engine.WorkflowStepRecord) error {
+	var in engine.MapInput
+	_ = json.Unmarshal(st.Input, &in)
+	items := mapItems(run, st.ID)
+
+	active, failed := 0, 0
+	var held []*engine.WorkflowStepRecord
+	for _, it := range items {
+		switch it.Status {
+		case engine.StepStatusPending, engine.StepStatusInProgress:
+			active++
+		case engine.StepStatusHeld:
+			held = append(held, it)
+		case engine.StepStatusFailed, engine.StepStatusCancelled, engine.StepStatusDiscarded:
+			failed++
+		}
+	}
+
+	// after a failure the items not yet started are dropped
+	release, drop := held, []*engine.WorkflowStepRecord(nil)
+	if failed > 0 {
+		release, drop = nil, held
+	} else if in.Concurrency > 0 {
+		free := in.Concurrency - active
+		if free < 0 {
+			free = 0
+		}
+		if free < len(release) {
+			release = release[:free]
+		}
+	}
+	for _, it := range release {
+		next := *it
+		next.Status = engine.StepStatusPending
+		if err := transition(ctx, store, it, &next); err != nil {
+			return err
+		}
+		active++
+	}
+	for _, it := range drop {
+		next := *it
+		next.Status = engine.StepStatusCancelled
+		if err := transition(ctx, store, it, &next); err != nil {
+			return err
+		}
+	}
+	if active > 0 || len(held) > len(release)+len(drop) {
+		return nil
+	}
+
+	next := *st
+	switch {
+	case failed > 0:
+		next.Status = engine.StepStatusFailed
+		msg := fmt.Sprintf("%d of %d map items failed", failed, len(items))
+		next.Error = &msg
+	case in.Aggregate != "":
+		// claimed again to run the aggregate handler
+		next.Status = engine.StepStatusPending
+		next.LockOwner = nil
+	default:
+		outputs := make([]json.RawMessage, 0, len(items))
+		for _, it := range items {
+			outputs = append(outputs, it.Result)
+		}
+		next.Status = engine.StepStatusCompleted
+		next.Result, _ = json.Marshal(map[string]interface{}{"items": outputs})
+	}
+	return transition(ctx, store, st, &next)
+}
+
+// transition moves st to next's status if st is unchanged in the store, and
+// mirrors the change into st.
+func transition(ctx context.Context, store engine.StateStore, st, next *engine.WorkflowStepRecord) error {
+	moved := false
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		ok, err := store.TransitionStep(ctx, next, st.Status)
+		if err != nil || !ok {
+			return err
+		}
+		moved = true
+		return emitStep(ctx, next)
+	})
+	if err == nil && moved {
+		*st = *next
+	}
+	return err
+}
+
+// emitStep publishes step.completed or step.failed for a step that reached
+// either status.
+func emitStep(ctx context.Context, st *engine.WorkflowStepRecord) error {
+	switch st.Status {
+	case engine.StepStatusCompleted:
+		return engine.EmitStep(ctx, engine.EventStepCompleted, st)
+	case engine.StepStatusFailed:
+		return engine.EmitStep(ctx, engine.EventStepFailed, st)
+	}
+	return nil
+}
+
+// Cancel cancels a running run and the child runs it started, and starts
+// compensating them. A child cancelled on its own fails its parent's step.
+// It reports whether the run was still running.
+func Cancel(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, runID string) (bool, error) {
+	var run *engine.WorkflowRun
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		cancelled, err := store.CancelRun(ctx, runID)
+		if err != nil || !cancelled {
+			return err
+		}
+		if run, err = store.LoadRun(ctx, runID); err != nil || run == nil {
+			return err
+		}
+		return engine.EmitRun(ctx, engine.EventRunCancelled, run)
+	})
+	if err != nil || run == nil {
+		return false, err
+	}
+	if err := Compensate(ctx, store, runID); err != nil {
+		return true, err
+	}
+	children, _, err := store.ListRuns(ctx, engine.RunFilter{ParentRunID: runID, Status: engine.RunStatusRunning})
+	if err != nil {
+		return true, err
+	}
+	for _, child := range children {
+		if _, err := Cancel(ctx, store, reg, child.ID); err != nil {
+			return true, err
+		}
+	}
+	return true, notifyParent(ctx, store, reg, run)
+}
+
+// notifyParent re-plans the parent of a child run that ended, so the step
+// waiting for it is resolved.
+func notifyParent(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, run *engine.WorkflowRun) error {
+	if run.ParentRunID == "" {
+		return nil
+	}
+	return Advance(ctx, store, reg, run.ParentRunID)
+}
@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+)
+
+// planFunc is a workflow whose Plan is given by a function.
+type planFunc struct {
+	id   string
+	plan func(run *engine.WorkflowRun) []engine.WorkflowStepDef
+}
+
+func (w planFunc) ID() string      { return w.id }
+func (w planFunc) Version() string { return "v1" }
+func (w planFunc) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	return w.plan(run), nil
+}
+
+// firstStep plans def for a new run and nothing afterwards.
+func firstStep(def engine.WorkflowStepDef) func(*engine.WorkflowRun) []engine.WorkflowStepDef {
+	return func(run *engine.WorkflowRun) []engine.WorkflowStepDef {
+		if len(run.Steps) == 0 {
+			return []engine.WorkflowStepDef{def}
+		}
+		return nil
+	}
+}
+
+// claim returns a copy of a stored step as the executor sees it, with its
+// input bound.
+func claim(t *testing.T, store *runStore, runID, stepID string) (*engine.WorkflowRun, *engine.WorkflowStepRecord) {
+	t.Helper()
+	run, _ := store.LoadRun(context.Background(), runID)
+	st := *store.step(runID, stepID)
+	if len(st.InputTemplate) > 0 {
+		input, err := binding.Bind(st.InputTemplate, run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Input = input
+	}
+	return run, &st
+}
+
+// park stores a step as waiting for its children, like the scheduler does.
+func park(t *testing.T, store *runStore, reg engine.WorkflowRegistry, st *engine.WorkflowStepRecord, res engine.StepResult) {
+	t.Helper()
+	if !res.AwaitChildren {
+		t.Fatalf("step %s does not wait for children: %+v", st.StepName, res)
+	}
+	st.Status, st.Result = engine.StepStatusWaitingForChildren, res.Output
+	*store.step(st.RunID, st.ID) = *st
+	if err := Advance(context.Background(), store, reg, st.RunID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func complete(t *testing.T, store *runStore, reg engine.WorkflowRegistry, runID, stepID, result string) {
+	t.Helper()
+	st := store.step(runID, stepID)
+	st.Status, st.Result = engine.StepStatusCompleted, json.RawMessage(result)
+	if err := Advance(context.Background(), store, reg, runID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func statuses(run *engine.WorkflowRun) string {
+	out := ""
+	for _, st := range run.Steps {
+		out += st.Status[:1]
+	}
+	return out
+}
+
+func TestMapRespectsConcurrencyAndAggregates(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(planFunc{id: "campaign", plan: firstStep(engine.Map("enrich", json.RawMessage(`"{{ run.payload.leads }}"`),
+		engine.MapSpec{ItemStep: "enrich_lead", Concurrency: 2, Aggregate: "summarize"}))})
+	store := &runStore{}
+	err := StartRun(context.Background(), store, reg, &engine.WorkflowRun{WorkflowType: "campaign", Payload: json.RawMessage(`{"leads":["a","b","c"]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, mapStep := claim(t, store, store.run.ID, store.run.Steps[0].ID)
+	res, agg, err := RunMap(context.Background(), store, run, mapStep)
+	if err != nil || agg != nil {
+		t.Fatalf("RunMap = %v, %v", agg, err)
+	}
+	park(t, store, reg, mapStep, res)
+	// a map step that runs again does not insert its items twice
+	if _, _, err := RunMap(context.Background(), store, run, mapStep); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.run.Steps); n != 4 {
+		t.Fatalf("%d steps after running the map step twice, want 4", n)
+	}
+	for i, st := range store.run.Steps {
+		if st.Seq != i+1 {
+			t.Fatalf("step %s has seq %d, want %d", st.StepName, st.Seq, i+1)
+		}
+	}
+	// map step waiting, two items pending and one held
+	if got := statuses(store.run); got != "wpph" {
+		t.Fatalf("statuses = %s, want wpph", got)
+	}
+	if in := string(store.run.Steps[3].Input); in != `{"index":2,"item":"c"}` {
+		t.Fatalf("third item input = %s", in)
+	}
+
+	complete(t, store, reg, store.run.ID, store.run.Steps[1].ID, `{"score":1}`)
+	if got := statuses(store.run); got != "wcpp" {
+		t.Fatalf("after first item = %s, want wcpp", got)
+	}
+	complete(t, store, reg, store.run.ID, store.run.Steps[3].ID, `{"score":3}`)
+	complete(t, store, reg, store.run.ID, store.run.Steps[2].ID, `{"score":2}`)
+	if got := statuses(store.run); got != "pccc" {
+		t.Fatalf("after all items = %s, want the map step pending for its aggregate", got)
+	}
+
+	run, mapStep = claim(t, store, store.run.ID, store.run.Steps[0].ID)
+	_, agg, err = RunMap(context.Background(), store, run, mapStep)
+	if err != nil || agg == nil || agg.StepName != "summarize" {
+		t.Fatalf("expected the summarize aggregate, got %v (err=%v)", agg, err)
+	}
+	if string(agg.Input) != `{"items":[{"score":1},{"score":2},{"score":3}]}` {
+		t.Fatalf("aggregate input = %s", agg.Input)
+	}
+	complete(t, store, reg, store.run.ID, mapStep.ID, `{"total":6}`)
+	if store.run.Status != engine.RunStatusCompleted {
+		t.Fatalf("run = %s, want completed", store.run.Status)
+	}
+}
+
+func TestChildRunResolvesParentAndIsCancelledWithIt(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(planFunc{id: "bdr", plan: firstStep(engine.ChildRun("followup", "lead", json.RawMessage(`{"lead":"a"}`)))})
+	reg.Register(planFunc{id: "lead", plan: firstStep(engine.WorkflowStepDef{StepName: "email"})})
+
+	start := func() (*runStore, *engine.WorkflowRun) {
+		store := &runStore{}
+		if err := StartRun(context.Background(), store, reg, &engine.WorkflowRun{WorkflowType: "bdr", WorkspaceID: "ws"}); err != nil {
+			t.Fatal(err)
+		}
+		run, st := claim(t, store, store.run.ID, store.run.Steps[0].ID)
+		res, err := RunChild(context.Background(), store, reg, run, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a re-run finds the child it already started
+		if again, _ := RunChild(context.Background(), store, reg, run, st); string(again.Output) != string(res.Output) || len(store.runs) != 2 {
+			t.Fatalf("re-run started another child: %s vs %s", again.Output, res.Output)
+		}
+		park(t, store, reg, st, res)
+		var ref struct {
+			RunID string `json:"run_id"`
+		}
+		_ = json.Unmarshal(res.Output, &ref)
+		child := store.runs[ref.RunID]
+		if child.ParentRunID != store.run.ID || child.ParentStepID != st.ID || child.WorkspaceID != "ws" {
+			t.Fatalf("child not linked to its parent: %+v", child)
+		}
+		return store, child
+	}
+
+	store, child := start()
+	complete(t, store, reg, child.ID, child.Steps[0].ID, `{"sent":true}`)
+	if child.Status != engine.RunStatusCompleted || store.run.Status != engine.RunStatusCompleted {
+		t.Fatalf("child = %s, parent = %s, want both completed", child.Status, store.run.Status)
+	}
+	var out struct {
+		Status string          `json:"status"`
+		Output json.RawMessage `json:"output"`
+	}
+	_ = json.Unmarshal(store.run.Steps[0].Result, &out)
+	if out.Status != engine.RunStatusCompleted || string(out.Output) != `{"sent":true}` {
+		t.Fatalf("parent step result = %s", store.run.Steps[0].Result)
+	}
+
+	store, child = start()
+	if ok, err := Cancel(context.Background(), store, reg, store.run.ID); !ok || err != nil {
+		t.Fatalf("cancel = %v, %v", ok, err)
+	}
+	if child.Status != engine.RunStatusCancelled || child.Steps[0].Status != engine.StepStatusCancelled {
+		t.Fatalf("child = %s with step %s, want cancelled", child.Status, child.Steps[0].Status)
+	}
+}
@@ -0,0 +1,118 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/google/uuid"
+)
+
+// Compensate starts undoing the completed steps of a failed or cancelled
+// run, e.g. right after it was cancelled. It is a no-op for running runs and
+// for runs whose compensation already finished.
+func Compensate(ctx context.Context, store engine.StateStore, runID string) error {
+	run, err := store.LoadRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return fmt.Errorf("workflow: run %s not found", runID)
+	}
+	switch run.Status {
+	case engine.RunStatusFailed, engine.RunStatusCancelled:
+	default:
+		return nil
+	}
+	if run.Compensation != "" && run.Compensation != engine.CompensationRunning {
+		return nil
+	}
+	return compensate(ctx, store, run)
+}
+
+// compensate schedules the next compensation of a failed or cancelled run.
+// Completed steps with a Compensation are undone one at a time, highest seq
+// first; it is called again whenever a step of the run finishes, until every
+// compensation ran. Steps still in progress, e.g. of a cancelled run, are
+// waited for, since they may complete and need undoing too. The outcome is
+// recorded in run.Compensation: "failed" when any compensation step failed.
+func compensate(ctx context.Context, store engine.StateStore, run *engine.WorkflowRun) error {
+	undone := make(map[string]bool)
+	failed, busy := false, false
+	nextSeq := 1
+	for _, st := range run.Steps {
+		if st.Seq >= nextSeq {
+			nextSeq = st.Seq + 1
+		}
+		if st.Kind != engine.StepKindCompensation {
+			busy = busy || st.Status == engine.StepStatusInProgress
+			continue
+		}
+		switch st.Status {
+		case engine.StepStatusPending, engine.StepStatusInProgress:
+			return nil
+		case engine.StepStatusFailed, engine.StepStatusDiscarded:
+			failed = true
+		}
+		if st.CompensatesID != nil {
+			undone[*st.CompensatesID] = true
+		}
+	}
+
+	var next *engine.WorkflowStepRecord
+	for i := len(run.Steps) - 1; i >= 0 && !busy; i-- {
+		st := &run.Steps[i]
+		if st.Kind == engine.StepKindCompensation || st.Compensation == nil || st.Status != engine.StepStatusCompleted || undone[st.ID] {
+			continue
+		}
+		next = st
+		break
+	}
+
+	state := run.Compensation
+	switch {
+	case busy || next != nil:
+		state = engine.CompensationRunning
+	case run.Compensation == "":
+		// nothing to undo
+	case failed:
+		state = engine.CompensationFailed
+	default:
+		state = engine.CompensationCompleted
+	}
+	if state != run.Compensation {
+		run.Compensation = state
+		if err := store.UpdateRun(ctx, run); err != nil {
+			return err
+		}
+	}
+	if next == nil {
+		return nil
+	}
+
+	rec := &engine.WorkflowStepRecord{
+		ID:            uuid.NewString(),
+		RunID:         run.ID,
+		StepName:      next.Compensation.StepName,
+		Kind:          engine.StepKindCompensation,
+		Seq:           nextSeq,
+		Status:        engine.StepStatusPending,
+		CompensatesID: &next.ID,
+		Priority:      next.Priority,
+	}
+	if len(next.Compensation.Input) > 0 {
+		rec.InputTemplate = next.Compensation.Input
+	} else {
+		input, err := json.Marshal(map[string]interface{}{
+			"step": next.StepName, "step_id": next.ID, "input": next.Input, "output": next.Result,
+		})
+		if err != nil {
+			return err
+		}
+		rec.Input = input
+	}
+	key := engine.StepKey(rec)
+	rec.IdempotencyKey = &key
+	return store.InsertSteps(ctx, []*engine.WorkflowStepRecord{rec})
+}
@@ -0,0 +1,237 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// runStore keeps runs in memory; run is the first one created. Only the
+// methods used by the workflow package are implemented. Like the real
+// stores, it numbers steps inserted without a seq and skips them when the
+// run already has their idempotency key.
+type runStore struct {
+	engine.StateStore
+	runs map[string]*engine.WorkflowRun
+	run  *engine.WorkflowRun
+}
+
+func (s *runStore) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (s *runStore) CreateRun(ctx context.Context, run *engine.WorkflowRun) error {
+	if s.runs == nil {
+		s.runs = make(map[string]*engine.WorkflowRun)
+	}
+	s.runs[run.ID] = run
+	if s.run == nil {
+		s.run = run
+	}
+	return nil
+}
+
+func (s *runStore) LoadRun(ctx context.Context, runID string) (*engine.WorkflowRun, error) {
+	stored, ok := s.runs[runID]
+	if !ok {
+		return nil, nil
+	}
+	run := *stored
+	run.Steps = append([]engine.WorkflowStepRecord(nil), stored.Steps...)
+	return &run, nil
+}
+
+func (s *runStore) UpdateRun(ctx context.Context, run *engine.WorkflowRun) error {
+	stored := s.runs[run.ID]
+	stored.Status, stored.Compensation = run.Status, run.Compensation
+	return nil
+}
+
+func (s *runStore) MigrateRun(ctx context.Context, run *engine.WorkflowRun, from string) (bool, error) {
+	stored := s.runs[run.ID]
+	if stored.Status != engine.RunStatusRunning || stored.WorkflowVersion != from {
+		return false, nil
+	}
+	for _, st := range stored.Steps {
+		if st.Status == engine.StepStatusInProgress {
+			return false, nil
+		}
+	}
+	stored.WorkflowVersion, stored.Payload = run.WorkflowVersion, run.Payload
+	return true, nil
+}
+
+func (s *runStore) ListRuns(ctx context.Context, filter engine.RunFilter) ([]*engine.WorkflowRun, int64, error) {
+	var out []*engine.WorkflowRun
+	for _, run := range s.runs {
+		if run.ParentRunID == filter.ParentRunID && (filter.Status == "" || run.Status == filter.Status) {
+			out = append(out, run)
+		}
+	}
+	return out, int64(len(out)), nil
+}
+
+func (s *runStore) CancelRun(ctx context.Context, runID string) (bool, error) {
+	run := s.runs[runID]
+	if run.Status != engine.RunStatusRunning {
+		return false, nil
+	}
+	run.Status = engine.RunStatusCancelled
+	for i := range run.Steps {
+		switch run.Steps[i].Status {
+		case engine.StepStatusPending, engine.StepStatusHeld, engine.StepStatusWaitingForChildren, engine.StepStatusWaitingForSignal:
+			run.Steps[i].Status = engine.StepStatusCancelled
+		}
+	}
+	return true, nil
+}
+
+func (s *runStore) InsertSteps(ctx context.Context, steps []*engine.WorkflowStepRecord) error {
+	for _, st := range steps {
+		run := s.runs[st.RunID]
+		if st.Seq == 0 {
+			if st.IdempotencyKey != nil && hasKey(run, *st.IdempotencyKey) {
+				continue
+			}
+			for _, prev := range run.Steps {
+				if prev.Seq > st.Seq {
+					st.Seq = prev.Seq
+				}
+			}
+			st.Seq++
+		}
+		run.Steps = append(run.Steps, *st)
+	}
+	return nil
+}
+
+func hasKey(run *engine.WorkflowRun, key string) bool {
+	for _, st := range run.Steps {
+		if st.IdempotencyKey != nil && *st.IdempotencyKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *runStore) TransitionStep(ctx context.Context, step *engine.WorkflowStepRecord, from string) (bool, error) {
+	st := s.step(step.RunID, step.ID)
+	if st.Status != from {
+		return false, nil
+	}
+	*st = *step
+	return true, nil
+}
+
+func (s *runStore) step(runID, stepID string) *engine.WorkflowStepRecord {
+	run := s.runs[runID]
+	for i := range run.Steps {
+		if run.Steps[i].ID == stepID {
+			return &run.Steps[i]
+		}
+	}
+	return nil
+}
+
+// finish sets the status of the latest step and advances the run.
+func (s *runStore) finish(t *testing.T, reg engine.WorkflowRegistry, status string) *engine.WorkflowStepRecord {
+	t.Helper()
+	st := &s.run.Steps[len(s.run.Steps)-1]
+	st.Status = status
+	st.Result = json.RawMessage(`{"event_id":"` + st.StepName + `"}`)
+	if err := Advance(context.Background(), s, reg, s.run.ID); err != nil {
+		t.Fatalf("advance: %v", err)
+	}
+	return st
+}
+
+// sagaWorkflow books a meeting, emails the lead and then updates a CRM.
+type sagaWorkflow struct{}
+
+func (sagaWorkflow) ID() string      { return "saga" }
+func (sagaWorkflow) Version() string { return "v1" }
+
+func (sagaWorkflow) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	next := map[string]engine.WorkflowStepDef{
+		"": {StepName: "book", Compensate: &engine.Compensation{StepName: "cancel_booking"}},
+		"book": {StepName: "email", Compensate: &engine.Compensation{StepName: "apologize",
+			Input: json.RawMessage(`{"thread":"{{ steps.email.output.event_id }}"}`)}},
+		"email": {StepName: "crm"},
+	}
+	last := ""
+	if n := len(run.Steps); n > 0 {
+		if run.Steps[n-1].Status != engine.StepStatusCompleted {
+			return nil, nil
+		}
+		last = run.Steps[n-1].StepName
+	}
+	if def, ok := next[last]; ok {
+		return []engine.WorkflowStepDef{def}, nil
+	}
+	return nil, nil
+}
+
+func TestFailedRunIsCompensatedInReverseOrder(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(sagaWorkflow{})
+	store := &runStore{}
+	if err := StartRun(context.Background(), store, reg, &engine.WorkflowRun{WorkflowType: "saga"}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.finish(t, reg, engine.StepStatusCompleted)
+	email := store.finish(t, reg, engine.StepStatusCompleted)
+	store.finish(t, reg, engine.StepStatusFailed)
+	if store.run.Status != engine.RunStatusFailed || store.run.Compensation != engine.CompensationRunning {
+		t.Fatalf("run = %s/%s, want failed/running", store.run.Status, store.run.Compensation)
+	}
+
+	undo := store.run.Steps[3]
+	if undo.StepName != "apologize" || undo.Kind != engine.StepKindCompensation || *undo.CompensatesID != email.ID {
+		t.Fatalf("first compensation = %+v, want apologize for email", undo)
+	}
+	if string(undo.InputTemplate) != `{"thread":"{{ steps.email.output.event_id }}"}` {
+		t.Fatalf("compensation template = %s", undo.InputTemplate)
+	}
+
+	// a failed compensation does not stop the others
+	store.finish(t, reg, engine.StepStatusFailed)
+	undo = store.run.Steps[4]
+	var in map[string]interface{}
+	_ = json.Unmarshal(undo.Input, &in)
+	if undo.StepName != "cancel_booking" || in["step"] != "book" || in["output"].(map[string]interface{})["event_id"] != "book" {
+		t.Fatalf("second compensation = %s %s", undo.StepName, undo.Input)
+	}
+	store.finish(t, reg, engine.StepStatusCompleted)
+	if store.run.Compensation != engine.CompensationFailed || len(store.run.Steps) != 5 {
+		t.Fatalf("compensation = %s with %d steps, want failed with 5", store.run.Compensation, len(store.run.Steps))
+	}
+}
+
+func TestCancelledRunWaitsForStepsInProgress(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(sagaWorkflow{})
+	store := &runStore{}
+	if err := StartRun(context.Background(), store, reg, &engine.WorkflowRun{WorkflowType: "saga"}); err != nil {
+		t.Fatal(err)
+	}
+	store.run.Steps[0].Status = engine.StepStatusInProgress
+	store.run.Status = engine.RunStatusCancelled
+
+	if err := Compensate(context.Background(), store, store.run.ID); err != nil {
+		t.Fatal(err)
+	}
+	if store.run.Compensation != engine.CompensationRunning || len(store.run.Steps) != 1 {
+		t.Fatalf("compensation started before book finished: %s, %d steps", store.run.Compensation, len(store.run.Steps))
+	}
+	store.finish(t, reg, engine.StepStatusCompleted)
+	if n := len(store.run.Steps); n != 2 || store.run.Steps[1].StepName != "cancel_booking" {
+		t.Fatalf("expected cancel_booking after book completed, got %d steps", n)
+	}
+	store.finish(t, reg, engine.StepStatusCompleted)
+	if store.run.Status != engine.RunStatusCancelled || store.run.Compensation != engine.CompensationCompleted {
+		t.Fatalf("run = %s/%s, want cancelled/completed", store.run.Status, store.run.Compensation)
+	}
+}
@@ -0,0 +1,71 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+var (
+	// ErrUnknownVersion is returned when migrating to a version that is not
+	// registered.
+	ErrUnknownVersion = errors.New("workflow: version is not registered")
+	// ErrNotMigratable is returned when the target version does not
+	// implement engine.Migrator or its Migrate hook refused the run.
+	ErrNotMigratable = errors.New("workflow: run cannot be migrated")
+	// ErrRunBusy is returned when the run is not running, one of its steps
+	// is in progress or it changed version concurrently.
+	ErrRunBusy = errors.New("workflow: run is not running or has a step in progress")
+)
+
+// Migrate moves a running run to another registered version of its
+// workflow. The target version must implement engine.Migrator and accept
+// the run; it may rewrite the run's payload. Steps already planned stay as
+// they are and run with the target version's handlers, and the run is
+// re-planned by the target version from then on. A run with a step in
+// progress is refused, so no step runs across the switch. Migrating to the
+// run's current version is a no-op.
+func Migrate(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, runID, version string) error {
+	run, err := store.LoadRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return fmt.Errorf("workflow: run %s not found", runID)
+	}
+	if run.Status != engine.RunStatusRunning {
+		return ErrRunBusy
+	}
+	wf, ok := reg.GetVersion(run.WorkflowType, version)
+	if !ok || version == "" {
+		return fmt.Errorf("%w: %s version %q", ErrUnknownVersion, run.WorkflowType, version)
+	}
+	from := run.WorkflowVersion
+	if from == version {
+		return nil
+	}
+	m, ok := wf.(engine.Migrator)
+	if !ok {
+		return fmt.Errorf("%w: %s version %s does not accept migrations", ErrNotMigratable, run.WorkflowType, version)
+	}
+	for _, st := range run.Steps {
+		if st.Status == engine.StepStatusInProgress {
+			return ErrRunBusy
+		}
+	}
+	if err := m.Migrate(ctx, run, from); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotMigratable, err)
+	}
+	run.WorkflowVersion = version
+	migrated, err := store.MigrateRun(ctx, run, from)
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		return ErrRunBusy
+	}
+	// the target version may plan steps the old one would not have
+	return Advance(ctx, store, reg, run.ID)
+}
@@ -0,0 +1,94 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// ticketWorkflow drafts a reply and, from v2 on, has it reviewed before
+// sending. v2 accepts runs of v1 and marks their payload as migrated.
+type ticketWorkflow struct{ version string }
+
+func (w ticketWorkflow) ID() string      { return "ticket" }
+func (w ticketWorkflow) Version() string { return w.version }
+
+func (w ticketWorkflow) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
+	order := []string{"draft", "send"}
+	if w.version != "v1" {
+		order = []string{"draft", "review", "send"}
+	}
+	if len(run.Steps) < len(order) {
+		return []engine.WorkflowStepDef{{StepName: order[len(run.Steps)]}}, nil
+	}
+	return nil, nil
+}
+
+type migratingTicketWorkflow struct{ ticketWorkflow }
+
+func (w migratingTicketWorkflow) Migrate(ctx context.Context, run *engine.WorkflowRun, from string) error {
+	if from != "v1" {
+		return fmt.Errorf("cannot migrate from %s", from)
+	}
+	run.Payload = json.RawMessage(`{"migrated_from":"v1"}`)
+	return nil
+}
+
+func TestRunsStayOnTheirVersionUntilMigrated(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry()
+	reg.Register(ticketWorkflow{"v1"})
+	store := &runStore{}
+	if err := StartRun(ctx, store, reg, &engine.WorkflowRun{WorkflowType: "ticket"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// deploying v2 and v10 does not change the running v1 run
+	reg.Register(ticketWorkflow{"v10"})
+	reg.Register(migratingTicketWorkflow{ticketWorkflow{"v2"}})
+	if got := reg.Versions("ticket"); fmt.Sprint(got) != "[v1 v2 v10]" {
+		t.Fatalf("versions = %v", got)
+	}
+	if wf, _ := reg.Get("ticket"); wf.Version() != "v10" {
+		t.Fatalf("latest = %s, want v10", wf.Version())
+	}
+	store.finish(t, reg, engine.StepStatusCompleted)
+	if st := store.run.Steps[len(store.run.Steps)-1]; st.StepName != "send" {
+		t.Fatalf("v1 run planned %s, want send", st.StepName)
+	}
+
+	// a new run starts on the latest version
+	fresh := &engine.WorkflowRun{ID: "fresh", WorkflowType: "ticket"}
+	if err := StartRun(ctx, store, reg, fresh); err != nil || store.runs["fresh"].WorkflowVersion != "v10" {
+		t.Fatalf("new run version = %s, err %v", store.runs["fresh"].WorkflowVersion, err)
+	}
+
+	other := &engine.WorkflowRun{ID: "other", WorkflowType: "ticket", WorkflowVersion: "v1", Status: engine.RunStatusRunning}
+	store.CreateRun(ctx, other)
+	store.InsertSteps(ctx, []*engine.WorkflowStepRecord{{ID: "d", RunID: "other", StepName: "draft", Seq: 1, Status: engine.StepStatusInProgress}})
+	if err := Migrate(ctx, store, reg, "other", "v10"); !errors.Is(err, ErrNotMigratable) {
+		t.Fatalf("migrating to a version without a hook: %v", err)
+	}
+	if err := Migrate(ctx, store, reg, "other", "v3"); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("migrating to an unknown version: %v", err)
+	}
+	if err := Migrate(ctx, store, reg, "other", "v2"); !errors.Is(err, ErrRunBusy) {
+		t.Fatalf("migrating a run with a step in progress: %v", err)
+	}
+
+	store.step("other", "d").Status = engine.StepStatusCompleted
+	if err := Migrate(ctx, store, reg, "other", "v2"); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	migrated := store.runs["other"]
+	if migrated.WorkflowVersion != "v2" || string(migrated.Payload) != `{"migrated_from":"v1"}` {
+		t.Fatalf("migrated run = %s %s", migrated.WorkflowVersion, migrated.Payload)
+	}
+	if st := migrated.Steps[len(migrated.Steps)-1]; st.StepName != "review" {
+		t.Fatalf("migrated run planned %s, want review", st.StepName)
+	}
+}
@@ -0,0 +1,28 @@
+package workflow
+
+import (
+	"context"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+)
+
+// Pause pauses (or, with paused false, resumes) a running run and the child
+// runs it started. Steps of a paused run are not claimed; steps already in
+// progress finish and the run keeps being planned, so its next steps wait
+// as pending until it is resumed. It reports whether the run changed state.
+func Pause(ctx context.Context, store engine.StateStore, runID string, paused bool) (bool, error) {
+	changed, err := store.PauseRun(ctx, runID, paused)
+	if err != nil || !changed {
+		return changed, err
+	}
+	children, _, err := store.ListRuns(ctx, engine.RunFilter{ParentRunID: runID, Status: engine.RunStatusRunning})
+	if err != nil {
+		return true, err
+	}
+	for _, child := range children {
+		if _, err := Pause(ctx, store, child.ID, paused); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,227 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// StartRun persists a new run of a registered workflow and inserts the steps
+// returned by its first Plan call. ID and Status are filled in when empty and
+// WorkflowVersion is pinned to the latest registered version. A run without
+// a Deadline gets the workflow's default one, if it implements
+// engine.RunTimeout. The run
+// records the trace context of a "workflow.start" span so its steps join the
+// caller's trace.
+func StartRun(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, run *engine.WorkflowRun) (err error) {
+	wf, ok := reg.Get(run.WorkflowType)
+	if !ok {
+		return fmt.Errorf("workflow: %q is not registered", run.WorkflowType)
+	}
+	if run.ID == "" {
+		run.ID = uuid.NewString()
+	}
+	ctx, span := tracing.Start(ctx, "workflow.start",
+		attribute.String("workflow.type", run.WorkflowType), attribute.String("workflow.run_id", run.ID))
+	defer func() { tracing.End(span, err) }()
+
+	run.WorkflowVersion = wf.Version()
+	run.Status = engine.RunStatusRunning
+	if rt, ok := wf.(engine.RunTimeout); ok && run.Deadline == nil && rt.RunTimeout() > 0 {
+		deadline := engine.Now().Add(rt.RunTimeout())
+		run.Deadline = &deadline
+	}
+	if run.TraceContext == nil {
+		run.TraceContext = tracing.Inject(ctx)
+	}
+	err = store.Atomic(ctx, func(ctx context.Context) error {
+		if err := store.CreateRun(ctx, run); err != nil {
+			return err
+		}
+		return engine.EmitRun(ctx, engine.EventRunStarted, run)
+	})
+	if err != nil {
+		return err
+	}
+	return Advance(ctx, store, reg, run.ID)
+}
+
+// Advance re-plans a run. It is a no-op while any step of the run is still
+// pending, in progress or waiting for a signal, unless the workflow plans
+// parallel branches (see engine.ParallelPlanner), so it is safe to call
+// after every step update. When Plan returns no further steps and none is
+// in flight the run is marked completed, or failed if any of its steps
+// failed, or rejected if its last step is a rejected review; the completed
+// steps of a failed run are then compensated. For a failed or cancelled run
+// that is being compensated it schedules the next compensation instead.
+// Steps waiting for a child run or map items are resolved first, and a
+// child run that ended re-plans its parent.
+func Advance(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, runID string) error {
+	run, err := store.LoadRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return fmt.Errorf("workflow: run %s not found", runID)
+	}
+	if run.Status != engine.RunStatusRunning {
+		if run.Compensation == engine.CompensationRunning {
+			return compensate(ctx, store, run)
+		}
+		return nil
+	}
+	if err := settle(ctx, store, run); err != nil {
+		return err
+	}
+
+	// a run keeps the version it started with, even after newer ones
+	// are registered
+	wf, ok := reg.GetVersion(run.WorkflowType, run.WorkflowVersion)
+	if !ok {
+		return fmt.Errorf("workflow: %s version %q is not registered", run.WorkflowType, run.WorkflowVersion)
+	}
+	pp, parallel := wf.(engine.ParallelPlanner)
+	parallel = parallel && pp.ParallelBranches()
+
+	failed, inFlight := false, false
+	nextSeq := 1
+	names := make(map[string]int)
+	for _, st := range run.Steps {
+		switch st.Status {
+		case engine.StepStatusPending, engine.StepStatusInProgress, engine.StepStatusWaitingForSignal,
+			engine.StepStatusWaitingForChildren, engine.StepStatusHeld:
+			if !parallel {
+				return nil
+			}
+			inFlight = true
+		case engine.StepStatusFailed:
+			failed = true
+		}
+		if st.Seq >= nextSeq {
+			nextSeq = st.Seq + 1
+		}
+		names[st.StepName]++
+	}
+
+	defs, err := wf.Plan(ctx, run)
+	if err != nil {
+		if endErr := end(ctx, store, reg, run, engine.RunStatusFailed); endErr != nil {
+			return endErr
+		}
+		return fmt.Errorf("workflow: plan %s for run %s: %w", run.WorkflowType, run.ID, err)
+	}
+
+	if len(defs) == 0 {
+		if inFlight {
+			return nil
+		}
+		status := engine.RunStatusCompleted
+		if failed {
+			status = engine.RunStatusFailed
+		} else if rejected(run) {
+			status = engine.RunStatusRejected
+		}
+		return end(ctx, store, reg, run, status)
+	}
+
+	steps := make([]*engine.WorkflowStepRecord, 0, len(defs))
+	for _, def := range defs {
+		// branches planned concurrently get their seq from the store
+		seq := def.Seq
+		if seq == 0 && !parallel {
+			seq = nextSeq
+		}
+		if seq >= nextSeq {
+			nextSeq = seq + 1
+		}
+		kind := def.Kind
+		if kind == "" {
+			kind = engine.StepKindTask
+		}
+		rec := &engine.WorkflowStepRecord{
+			ID:       uuid.NewString(),
+			RunID:    run.ID,
+			StepName: def.StepName,
+			Kind:     kind,
+			Seq:      seq,
+			Status:   engine.StepStatusPending,
+			Input:    def.Input,
+			Priority: run.Priority + def.Priority,
+		}
+		if len(def.Template) > 0 {
+			rec.InputTemplate = def.Template
+		}
+		if def.Compensate != nil {
+			rec.Compensation = def.Compensate
+		}
+		key := engine.StepKey(rec)
+		if seq == 0 {
+			names[def.StepName]++
+			key = engine.BranchStepKey(run.ID, def.StepName, names[def.StepName])
+		}
+		rec.IdempotencyKey = &key
+		if def.Retry != nil {
+			rec.RetryPolicy = def.Retry
+			rec.MaxAttempts = def.Retry.MaxAttempts
+		}
+		if def.Kind == engine.StepKindTimer {
+			if def.WakeAt == nil {
+				return fmt.Errorf("workflow: timer step %q of run %s has no wake time", def.StepName, run.ID)
+			}
+			rec.NextAttemptAt = def.WakeAt
+			if len(rec.Input) == 0 {
+				rec.Input, _ = json.Marshal(map[string]time.Time{"wake_at": *def.WakeAt})
+			}
+		}
+		steps = append(steps, rec)
+	}
+	return store.InsertSteps(ctx, steps)
+}
+
+// end marks run finished with status, compensates it when it failed and
+// re-plans its parent run.
+func end(ctx context.Context, store engine.StateStore, reg engine.WorkflowRegistry, run *engine.WorkflowRun, status string) error {
+	run.Status = status
+	event := engine.EventRunCompleted
+	switch status {
+	case engine.RunStatusFailed:
+		event = engine.EventRunFailed
+	case engine.RunStatusRejected:
+		event = engine.EventRunRejected
+	}
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		if err := store.UpdateRun(ctx, run); err != nil {
+			return err
+		}
+		return engine.EmitRun(ctx, event, run)
+	})
+	if err != nil {
+		return err
+	}
+	if status == engine.RunStatusFailed {
+		if err := compensate(ctx, store, run); err != nil {
+			return err
+		}
+	}
+	return notifyParent(ctx, store, reg, run)
+}
+
+// rejected reports whether the last step of run is a review resolved with
+// a rejection. Resolved reviews keep their wait spec next to the decision.
+func rejected(run *engine.WorkflowRun) bool {
+	if len(run.Steps) == 0 {
+		return false
+	}
+	var d struct {
+		Decision string          `json:"decision"`
+		Wait     json.RawMessage `json:"wait"`
+	}
+	last := run.Steps[len(run.Steps)-1]
+	return json.Unmarshal(last.Result, &d) == nil && len(d.Wait) > 0 && d.Decision == "rejected"
+}
@@ -1,29 +1,114 @@
 package workflow
 
 import (
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/engine"
 )
 
+// Registry keeps every registered version of each workflow. New runs start
+// on the latest version while existing runs stay on the version they were
+// started with, so deploying a new version does not change runs in flight.
 type Registry struct {
 	mu    sync.RWMutex
-	store map[string]engine.Workflow
+	store map[string]map[string]engine.Workflow
 }
 
 func NewRegistry() *Registry {
-	return &Registry{store: make(map[string]engine.Workflow)}
+	return &Registry{store: make(map[string]map[string]engine.Workflow)}
 }
 
+// Register adds w, replacing a workflow registered with the same ID and
+// version.
 func (r *Registry) Register(w engine.Workflow) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.store[w.ID()] = w
+	versions, ok := r.store[w.ID()]
+	if !ok {
+		versions = make(map[string]engine.Workflow)
+		r.store[w.ID()] = versions
+	}
+	versions[w.Version()] = w
 }
 
+// Get returns the latest version of a workflow.
 func (r *Registry) Get(id string) (engine.Workflow, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	w, ok := r.store[id]
+	versions := r.sorted(id)
+	if len(versions) == 0 {
+		return nil, false
+	}
+	return r.store[id][versions[len(versions)-1]], true
+}
+
+// GetVersion returns one version of a workflow. An empty version, as on runs
+// recorded before versions were pinned, means the latest.
+func (r *Registry) GetVersion(id, version string) (engine.Workflow, bool) {
+	if version == "" {
+		return r.Get(id)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	w, ok := r.store[id][version]
 	return w, ok
 }
+
+// Versions returns the registered versions of a workflow, oldest first.
+func (r *Registry) Versions(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sorted(id)
+}
+
+// IDs returns the IDs of all registered workflows in name order.
+func (r *Registry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.store))
+	for id := range r.store {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (r *Registry) sorted(id string) []string {
+	versions := make([]string, 0, len(r.store[id]))
+	for v := range r.store[id] {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	return versions
+}
+
+// compareVersions orders versions such as "v2" < "v10" < "v10.1": a leading
+// "v" is ignored and dot-separated segments compare numerically when both
+// are numbers, as strings otherwise.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		switch {
+		case errX == nil && errY == nil && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		case (errX != nil || errY != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -82,6 +82,26 @@ type DocumentChunk struct {
 	Agent *Agent `json:"agent,omitempty" gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE,-:save,-:update"`
 }
 
+// TrainingUpload holds an uploaded file until a background training job
+// ingests it, so queue messages carry only its ID.
+type TrainingUpload struct {
+	// ID is the unique identifier for the upload
+	ID string `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	// AgentID links this upload to the agent being trained
+	AgentID string `json:"agent_id" gorm:"type:varchar(36);not null;index"`
+	// MimeType is the MIME type of the file
+	MimeType string `json:"mime_type" gorm:"type:varchar(100)"`
+	// Data is the file content
+	Data []byte `json:"-" gorm:"type:bytea;not null"`
+	// CreatedAt timestamp when the file was uploaded
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+// TableName returns the database table name for TrainingUpload
+func (TrainingUpload) TableName() string {
+	return "training_uploads"
+}
+
 // TableName returns the database table name for TrainingDocument
 func (TrainingDocument) TableName() string {
 	return "training_documents"
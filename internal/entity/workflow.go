@@ -4,43 +4,100 @@ import "time"
 
 // GORM entities for orchestration engine
 type WorkflowRun struct {
-	ID              string `gorm:"type:uuid;primaryKey"`
-	WorkflowType    string `gorm:"type:text;not null"`
-	WorkflowVersion string `gorm:"type:text;not null"`
-	Status          string `gorm:"type:text;not null"`
-	Payload         []byte `gorm:"type:jsonb"`
+	ID              string  `gorm:"type:uuid;primaryKey"`
+	WorkflowType    string  `gorm:"type:text;not null"`
+	WorkflowVersion string  `gorm:"type:text;not null"`
+	WorkspaceID     *string `gorm:"type:uuid;index"`
+	Status          string  `gorm:"type:text;not null;index"`
+	Payload         []byte  `gorm:"type:jsonb"`
+	TraceContext    []byte  `gorm:"type:jsonb"`
+	Compensation    string  `gorm:"type:text;not null;default:''"`
+	ParentRunID     *string `gorm:"type:uuid;index"`
+	ParentStepID    *string `gorm:"type:uuid"`
+	Priority        int     `gorm:"not null;default:0"`
+	PausedAt        *time.Time
+	Deadline        *time.Time `gorm:"index"`
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
 type WorkflowStep struct {
 	ID             string `gorm:"type:uuid;primaryKey"`
-	RunID          string `gorm:"type:uuid;index;not null"`
+	RunID          string `gorm:"type:uuid;index;not null;uniqueIndex:ux_workflow_steps_run_seq"`
 	StepName       string `gorm:"type:text;not null"`
-	Seq            int    `gorm:"not null;default:0"`
+	Kind           string `gorm:"type:text;not null;default:'task'"`
+	Seq            int    `gorm:"not null;default:0;uniqueIndex:ux_workflow_steps_run_seq"`
 	Status         string `gorm:"type:text;not null"`
 	Input          []byte `gorm:"type:jsonb"`
+	InputTemplate  []byte `gorm:"type:jsonb"`
 	Result         []byte `gorm:"type:jsonb"`
 	Attempts       int    `gorm:"default:0"`
 	MaxAttempts    int    `gorm:"default:5"`
+	RetryPolicy    []byte `gorm:"type:jsonb"`
 	NextAttemptAt  *time.Time
 	ClaimedAt      *time.Time
 	LastHeartbeat  *time.Time
 	LockOwner      *string `gorm:"type:text"`
 	IdempotencyKey *string `gorm:"type:text;index"`
 	Error          *string `gorm:"type:text"`
+	Compensation   []byte  `gorm:"type:jsonb"`
+	CompensatesID  *string `gorm:"type:uuid"`
+	ParentStepID   *string `gorm:"type:uuid;index"`
+	Priority       int     `gorm:"not null;default:0"`
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 }
 
 type OutboxEvent struct {
-	ID             string  `gorm:"type:uuid;primaryKey"`
-	EventType      string  `gorm:"type:text;not null"`
-	Payload        []byte  `gorm:"type:jsonb"`
-	State          string  `gorm:"type:text;default:'pending'"`
-	IdempotencyKey *string `gorm:"type:text;index"`
-	Published      bool    `gorm:"default:false"`
+	ID             string     `gorm:"type:uuid;primaryKey"`
+	Seq            int64      `gorm:"autoIncrement;uniqueIndex:ux_outbox_events_seq"`
+	EventType      string     `gorm:"type:text;not null"`
+	Payload        []byte     `gorm:"type:jsonb"`
+	State          string     `gorm:"type:text;default:'pending';index:idx_outbox_events_state,priority:1"`
+	IdempotencyKey *string    `gorm:"type:text;uniqueIndex:ux_outbox_events_idempotency_key"`
+	Published      bool       `gorm:"default:false"`
+	Attempts       int        `gorm:"default:0"`
+	Error          *string    `gorm:"type:text"`
+	TraceContext   []byte     `gorm:"type:jsonb"`
+	NextAttemptAt  *time.Time `gorm:"index:idx_outbox_events_state,priority:2"`
+	ClaimedAt      *time.Time
 	CreatedAt      time.Time
+	// Horizon is the next transaction ID when the event was inserted; it is
+	// only used in SQL, to read events in commit order.
+	Horizon *uint64 `gorm:"type:xid8;->:false;<-:false"`
+}
+
+type EventSubscription struct {
+	Name        string  `gorm:"type:text;primaryKey"`
+	Pattern     string  `gorm:"type:text;not null"`
+	AckedSeq    int64   `gorm:"not null;default:0"`
+	LeaseOwner  *string `gorm:"type:text"`
+	LeasedUntil *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type WorkflowTrigger struct {
+	ID           string     `gorm:"type:uuid;primaryKey"`
+	WorkspaceID  string     `gorm:"type:uuid;index;not null"`
+	WorkflowType string     `gorm:"type:text;not null"`
+	Schedule     string     `gorm:"type:text;not null"`
+	Timezone     string     `gorm:"type:text;not null;default:'UTC'"`
+	Payload      []byte     `gorm:"type:jsonb"`
+	Enabled      bool       `gorm:"default:true;index:idx_workflow_triggers_due,priority:1"`
+	NextRunAt    *time.Time `gorm:"index:idx_workflow_triggers_due,priority:2"`
+	LastRunAt    *time.Time
+	LastRunID    *string `gorm:"type:uuid"`
+	CreatedBy    string  `gorm:"type:uuid"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type WorkflowPause struct {
+	WorkflowType string `gorm:"type:text;primaryKey"`
+	Reason       string `gorm:"type:text"`
+	PausedBy     string `gorm:"type:text"`
+	CreatedAt    time.Time
 }
 
 type StepLog struct {
@@ -52,9 +109,26 @@ type StepLog struct {
 	CreatedAt time.Time
 }
 
-type RetryMeta struct {
-	StepID        string `gorm:"type:uuid;primaryKey"`
-	Attempts      int    `gorm:"default:0"`
-	BackoffSecond int    `gorm:"default:0"`
-	DeadLetter    bool   `gorm:"default:false"`
+// OutboxEventAudit audits actions taken on an outbox event.
+type OutboxEventAudit struct {
+	ID        string `gorm:"type:uuid;primaryKey"`
+	EventID   string `gorm:"type:uuid;index"`
+	Level     string `gorm:"type:text"`
+	Message   string `gorm:"type:text"`
+	Meta      []byte `gorm:"type:jsonb"`
+	CreatedAt time.Time
+}
+
+type QueueMessage struct {
+	ID          string    `gorm:"type:uuid;primaryKey"`
+	Queue       string    `gorm:"type:text;not null;index:idx_queue_messages_visible,priority:1"`
+	State       string    `gorm:"type:text;not null;default:'queued';index:idx_queue_messages_visible,priority:2"`
+	VisibleAt   time.Time `gorm:"not null;index:idx_queue_messages_visible,priority:3"`
+	Payload     []byte    `gorm:"type:bytea"`
+	Attempts    int       `gorm:"default:0"`
+	MaxAttempts int       `gorm:"default:5"`
+	Receipt     *string   `gorm:"type:uuid"`
+	LastError   *string   `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
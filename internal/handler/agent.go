@@ -354,6 +354,27 @@ func (h *AgentHandler) GetAgentStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"stats": stats})
 }
 
+// RecalculateAgentStats recomputes an agent's stats from its conversations,
+// or queues the recalculation with ?async=true.
+func (h *AgentHandler) RecalculateAgentStats(c *gin.Context) {
+	agentId := c.Param("agentId")
+	if runAsync(c) {
+		jobID, err := h.agentUsecase.EnqueueStatsRecalculation(c.Request.Context(), agentId)
+		if err != nil {
+			appErrors.HandleError(c, err, "RecalculateAgentStats")
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Stats recalculation queued", "job_id": jobID})
+		return
+	}
+	stats, err := h.agentUsecase.RecalculateAgentStats(agentId)
+	if err != nil {
+		appErrors.HandleError(c, err, "RecalculateAgentStats")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
 func (h *AgentHandler) GetAgentIntegration(c *gin.Context) {
 	agentId := c.Param("agentId")
 	integration, err := h.agentUsecase.GetAgentIntegration(agentId)
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/outbox"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/alpinesboltltd/boltz-ai/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// inboundEmailTolerance is how old a signed inbound email request may be.
+const inboundEmailTolerance = 5 * time.Minute
+
+// SupportHandler hands escalated conversations and inbound emails to the
+// CSR workflow
+type SupportHandler struct {
+	supportUsecase   usecase.SupportUsecase
+	workspaceUsecase usecase.WorkspaceUsecase
+	inboundSecret    string
+}
+
+// NewSupportHandler creates a new support handler. Inbound emails must be
+// signed with inboundSecret like outbox webhooks.
+func NewSupportHandler(supportUsecase usecase.SupportUsecase, workspaceUsecase usecase.WorkspaceUsecase, inboundSecret string) *SupportHandler {
+	return &SupportHandler{
+		supportUsecase:   supportUsecase,
+		workspaceUsecase: workspaceUsecase,
+		inboundSecret:    inboundSecret,
+	}
+}
+
+// EscalateConversation hands a conversation over to human support and starts
+// the CSR run answering it
+func (h *SupportHandler) EscalateConversation(c *gin.Context) {
+	conversationID := c.Param("conversationId")
+	if conversationID == "" {
+		appErrors.HandleError(c, appErrors.NewValidationError("Conversation ID is required"), "EscalateConversation")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// the body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "EscalateConversation")
+		return
+	}
+
+	conv, err := h.supportUsecase.GetConversation(conversationID)
+	if err != nil {
+		appErrors.HandleError(c, err, "EscalateConversation")
+		return
+	}
+
+	if !h.checkAccess(c, conv.AgentId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	run, err := h.supportUsecase.EscalateConversation(c.Request.Context(), conv.Id, req.Reason)
+	if err != nil {
+		appErrors.HandleError(c, err, "EscalateConversation")
+		return
+	}
+
+	c.JSON(http.StatusCreated, run)
+}
+
+// ReceiveEmail starts the CSR run answering an email forwarded by the mail
+// provider. The request carries no user token; it is authenticated by its
+// X-Boltz-Signature.
+func (h *SupportHandler) ReceiveEmail(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request body"), "ReceiveEmail")
+		return
+	}
+
+	if err := outbox.VerifySignature(h.inboundSecret, c.GetHeader(outbox.HeaderSignature), body, inboundEmailTolerance); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	var email usecase.InboundEmail
+	if err := json.Unmarshal(body, &email); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "ReceiveEmail")
+		return
+	}
+
+	run, err := h.supportUsecase.ReceiveEmail(c.Request.Context(), email)
+	if err != nil {
+		appErrors.HandleError(c, err, "ReceiveEmail")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+func (h *SupportHandler) checkAccess(c *gin.Context, agentID string) bool {
+	userID := c.GetString("userID")
+	role := c.GetString("role")
+
+	if role == string(entity.SuperAdmin) {
+		return true
+	}
+
+	workspace, err := h.workspaceUsecase.GetByAgentID(agentID)
+	if err != nil {
+		return false
+	}
+
+	if workspace.OwnerID == userID {
+		return true
+	}
+
+	for _, member := range workspace.Members {
+		if member.UserID == userID {
+			return true
+		}
+	}
+
+	return false
+}
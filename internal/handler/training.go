@@ -47,6 +47,11 @@ func (h *TrainingHandler) TrainWithText(c *gin.Context) {
 		return
 	}
 
+	if runAsync(c) {
+		h.enqueueTraining(c, usecase.TrainingJob{Kind: usecase.TrainingJobText, AgentID: agentID, Title: req.Title, Content: req.Content}, "TrainWithText")
+		return
+	}
+
 	err := h.trainingUsecase.ProcessDocument(agentID, req.Title, req.Content, entity.DocumentTypeText, nil)
 	if err != nil {
 		appErrors.HandleError(c, err, "TrainWithText")
@@ -88,6 +93,10 @@ func (h *TrainingHandler) TrainWithFile(c *gin.Context) {
 	}
 
 	mimeType := header.Header.Get("Content-Type")
+	if runAsync(c) {
+		h.enqueueTraining(c, usecase.TrainingJob{Kind: usecase.TrainingJobFile, AgentID: agentID, Title: title, FileData: fileData, MimeType: mimeType}, "TrainWithFile")
+		return
+	}
 	err = h.trainingUsecase.ProcessFileWithMimeDetection(agentID, title, fileData, mimeType, nil)
 	if err != nil {
 		appErrors.HandleError(c, err, "TrainWithFile")
@@ -234,6 +243,11 @@ func (h *TrainingHandler) TrainWithURL(c *gin.Context) {
 		title = "Content from " + req.URL
 	}
 
+	if runAsync(c) {
+		h.enqueueTraining(c, usecase.TrainingJob{Kind: usecase.TrainingJobURL, AgentID: agentID, Title: title, URL: req.URL, Trace: req.Trace, MaxPages: req.MaxPages}, "TrainWithURL")
+		return
+	}
+
 	err := h.trainingUsecase.ProcessURL(agentID, req.URL, title, req.Trace, req.MaxPages)
 	if err != nil {
 		appErrors.HandleError(c, err, "TrainWithURL")
@@ -281,6 +295,22 @@ func (h *TrainingHandler) GetTrainingStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// runAsync reports whether the request asked for background processing
+// with ?async=true.
+func runAsync(c *gin.Context) bool {
+	return c.Query("async") == "true"
+}
+
+// enqueueTraining queues job and responds 202 with its ID.
+func (h *TrainingHandler) enqueueTraining(c *gin.Context, job usecase.TrainingJob, op string) {
+	jobID, err := h.trainingUsecase.EnqueueTraining(c.Request.Context(), job)
+	if err != nil {
+		appErrors.HandleError(c, err, op)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Training queued", "job_id": jobID})
+}
+
 func (h *TrainingHandler) checkAccess(c *gin.Context, agentID string) bool {
 	userID := c.GetString("userID")
 	role := c.GetString("role")
@@ -38,7 +38,7 @@ func (h *AgentHandler) HandleWebSocket(c *gin.Context) {
 		}
 
 		// Process message
-		response, err := h.chatService.ProcessMessage(msg.AgentID, msg.Message, msg.APIKey)
+		response, err := h.chatService.ProcessMessage(c.Request.Context(), msg.AgentID, msg.Message, msg.APIKey)
 		if err != nil {
 			conn.WriteJSON(gin.H{"error": err.Error()})
 			continue
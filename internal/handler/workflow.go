@@ -0,0 +1,541 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/deadletter"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/alpinesboltltd/boltz-ai/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRunPageSize = 20
+	maxRunPageSize     = 100
+)
+
+// WorkflowHandler handles HTTP requests for orchestration workflow runs
+type WorkflowHandler struct {
+	workflowUsecase  usecase.WorkflowUsecase
+	workspaceUsecase usecase.WorkspaceUsecase
+}
+
+// NewWorkflowHandler creates a new workflow handler
+func NewWorkflowHandler(workflowUsecase usecase.WorkflowUsecase, workspaceUsecase usecase.WorkspaceUsecase) *WorkflowHandler {
+	return &WorkflowHandler{
+		workflowUsecase:  workflowUsecase,
+		workspaceUsecase: workspaceUsecase,
+	}
+}
+
+// StartRun starts a run of a registered workflow
+func (h *WorkflowHandler) StartRun(c *gin.Context) {
+	var req struct {
+		WorkflowType string          `json:"workflow_type" binding:"required"`
+		WorkspaceID  string          `json:"workspace_id" binding:"required"`
+		Payload      json.RawMessage `json:"payload"`
+		// Deadline bounds the whole run; remaining steps are cancelled once
+		// it passes.
+		Deadline *time.Time `json:"deadline"`
+		// Priority moves the run's steps ahead of (or behind) other pending
+		// steps. Raising it above zero is reserved to super admins, as it
+		// takes precedence over other workspaces.
+		Priority int `json:"priority"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "StartRun")
+		return
+	}
+
+	if !h.checkAccess(c, req.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if req.Priority > 0 && c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	run, err := h.workflowUsecase.StartRun(c.Request.Context(), req.WorkflowType, req.WorkspaceID, req.Payload, usecase.StartRunOptions{Deadline: req.Deadline, Priority: req.Priority})
+	if err != nil {
+		appErrors.HandleError(c, err, "StartRun")
+		return
+	}
+
+	c.JSON(http.StatusCreated, run)
+}
+
+// GetRun returns a run with its steps and step logs
+func (h *WorkflowHandler) GetRun(c *gin.Context) {
+	runID := c.Param("runId")
+	if runID == "" {
+		appErrors.HandleError(c, appErrors.NewValidationError("Run ID is required"), "GetRun")
+		return
+	}
+
+	details, err := h.workflowUsecase.GetRun(c.Request.Context(), runID)
+	if err != nil {
+		appErrors.HandleError(c, err, "GetRun")
+		return
+	}
+
+	if !h.checkAccess(c, details.Run.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+// ListRuns lists runs of a workspace filtered by workflow type and status
+func (h *WorkflowHandler) ListRuns(c *gin.Context) {
+	workspaceID := c.Query("workspace_id")
+	if workspaceID == "" && c.GetString("role") != string(entity.SuperAdmin) {
+		appErrors.HandleError(c, appErrors.NewValidationError("workspace_id is required"), "ListRuns")
+		return
+	}
+
+	if workspaceID != "" && !h.checkAccess(c, workspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	page, limit := pagination(c)
+	filter := engine.RunFilter{
+		WorkspaceID:  workspaceID,
+		WorkflowType: c.Query("workflow_type"),
+		Status:       c.Query("status"),
+		ParentRunID:  c.Query("parent_run_id"),
+		Limit:        limit,
+		Offset:       (page - 1) * limit,
+	}
+
+	runs, total, err := h.workflowUsecase.ListRuns(c.Request.Context(), filter)
+	if err != nil {
+		appErrors.HandleError(c, err, "ListRuns")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs, "total": total, "page": page, "limit": limit})
+}
+
+// CancelRun cancels a running run and its pending steps
+func (h *WorkflowHandler) CancelRun(c *gin.Context) {
+	runID := c.Param("runId")
+	if runID == "" {
+		appErrors.HandleError(c, appErrors.NewValidationError("Run ID is required"), "CancelRun")
+		return
+	}
+
+	details, err := h.workflowUsecase.GetRun(c.Request.Context(), runID)
+	if err != nil {
+		appErrors.HandleError(c, err, "CancelRun")
+		return
+	}
+
+	if !h.checkAccess(c, details.Run.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	run, err := h.workflowUsecase.CancelRun(c.Request.Context(), runID)
+	if err != nil {
+		appErrors.HandleError(c, err, "CancelRun")
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// PauseRun stops the steps of a run and its child runs from being claimed
+func (h *WorkflowHandler) PauseRun(c *gin.Context) {
+	h.pauseRun(c, true, "PauseRun")
+}
+
+// ResumeRun lets a paused run and its child runs continue
+func (h *WorkflowHandler) ResumeRun(c *gin.Context) {
+	h.pauseRun(c, false, "ResumeRun")
+}
+
+func (h *WorkflowHandler) pauseRun(c *gin.Context, paused bool, operation string) {
+	details, err := h.workflowUsecase.GetRun(c.Request.Context(), c.Param("runId"))
+	if err != nil {
+		appErrors.HandleError(c, err, operation)
+		return
+	}
+
+	if !h.checkAccess(c, details.Run.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	run, err := h.workflowUsecase.PauseRun(c.Request.Context(), details.Run.ID, paused)
+	if err != nil {
+		appErrors.HandleError(c, err, operation)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// MigrateRun moves a running run to another registered version of its
+// workflow (SuperAdmin only)
+func (h *WorkflowHandler) MigrateRun(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req struct {
+		Version string `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "MigrateRun")
+		return
+	}
+
+	run, err := h.workflowUsecase.MigrateRun(c.Request.Context(), c.Param("runId"), req.Version)
+	if err != nil {
+		appErrors.HandleError(c, err, "MigrateRun")
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// ListWorkflows lists the registered workflows, their versions and pauses
+func (h *WorkflowHandler) ListWorkflows(c *gin.Context) {
+	workflows, err := h.workflowUsecase.ListWorkflows(c.Request.Context())
+	if err != nil {
+		appErrors.HandleError(c, err, "ListWorkflows")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workflows": workflows})
+}
+
+// PauseWorkflow stops runs of a workflow type from making progress (SuperAdmin only)
+func (h *WorkflowHandler) PauseWorkflow(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "PauseWorkflow")
+			return
+		}
+	}
+
+	pause := &engine.WorkflowPause{WorkflowType: c.Param("workflowType"), Reason: req.Reason, PausedBy: c.GetString("userID")}
+	pause, err := h.workflowUsecase.PauseWorkflow(c.Request.Context(), pause)
+	if err != nil {
+		appErrors.HandleError(c, err, "PauseWorkflow")
+		return
+	}
+
+	c.JSON(http.StatusOK, pause)
+}
+
+// ResumeWorkflow lets runs of a paused workflow type continue (SuperAdmin only)
+func (h *WorkflowHandler) ResumeWorkflow(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if err := h.workflowUsecase.ResumeWorkflow(c.Request.Context(), c.Param("workflowType")); err != nil {
+		appErrors.HandleError(c, err, "ResumeWorkflow")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workflow resumed"})
+}
+
+// SignalStep approves, rejects or edits a step waiting for human review
+func (h *WorkflowHandler) SignalStep(c *gin.Context) {
+	runID := c.Param("runId")
+	stepID := c.Param("stepId")
+	if runID == "" || stepID == "" {
+		appErrors.HandleError(c, appErrors.NewValidationError("Run ID and step ID are required"), "SignalStep")
+		return
+	}
+
+	var req struct {
+		Action  string          `json:"action" binding:"required"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "SignalStep")
+		return
+	}
+
+	details, err := h.workflowUsecase.GetRun(c.Request.Context(), runID)
+	if err != nil {
+		appErrors.HandleError(c, err, "SignalStep")
+		return
+	}
+
+	if !h.checkAccess(c, details.Run.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	sig := engine.Signal{Action: req.Action, Payload: req.Payload, Actor: c.GetString("userID")}
+	run, err := h.workflowUsecase.SignalStep(c.Request.Context(), runID, stepID, sig)
+	if err != nil {
+		appErrors.HandleError(c, err, "SignalStep")
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// ListPendingApprovals lists steps of a workspace waiting for a human decision
+func (h *WorkflowHandler) ListPendingApprovals(c *gin.Context) {
+	workspaceID := c.Query("workspace_id")
+	if workspaceID == "" && c.GetString("role") != string(entity.SuperAdmin) {
+		appErrors.HandleError(c, appErrors.NewValidationError("workspace_id is required"), "ListPendingApprovals")
+		return
+	}
+
+	if workspaceID != "" && !h.checkAccess(c, workspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	steps, err := h.workflowUsecase.ListPendingApprovals(c.Request.Context(), workspaceID)
+	if err != nil {
+		appErrors.HandleError(c, err, "ListPendingApprovals")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approvals": steps})
+}
+
+// ListDeadLetterSteps lists failed steps with their error, attempts and input
+func (h *WorkflowHandler) ListDeadLetterSteps(c *gin.Context) {
+	workspaceID := c.Query("workspace_id")
+	if workspaceID == "" && c.GetString("role") != string(entity.SuperAdmin) {
+		appErrors.HandleError(c, appErrors.NewValidationError("workspace_id is required"), "ListDeadLetterSteps")
+		return
+	}
+
+	if workspaceID != "" && !h.checkAccess(c, workspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	page, limit := pagination(c)
+	filter := engine.DeadLetterFilter{
+		WorkspaceID:  workspaceID,
+		WorkflowType: c.Query("workflow_type"),
+		Limit:        limit,
+		Offset:       (page - 1) * limit,
+	}
+
+	steps, total, err := h.workflowUsecase.ListDeadLetterSteps(c.Request.Context(), filter)
+	if err != nil {
+		appErrors.HandleError(c, err, "ListDeadLetterSteps")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"steps": steps, "total": total, "page": page, "limit": limit})
+}
+
+// ReplayStep resets a failed step to pending, optionally with an edited input
+func (h *WorkflowHandler) ReplayStep(c *gin.Context) {
+	req, ok := h.deadLetterRequest(c, "ReplayStep")
+	if !ok || !h.checkStepAccess(c, "ReplayStep") {
+		return
+	}
+
+	step, err := h.workflowUsecase.ReplayStep(c.Request.Context(), c.Param("stepId"), req)
+	if err != nil {
+		appErrors.HandleError(c, err, "ReplayStep")
+		return
+	}
+
+	c.JSON(http.StatusOK, step)
+}
+
+// DiscardStep removes a failed step from the dead-letter queue
+func (h *WorkflowHandler) DiscardStep(c *gin.Context) {
+	req, ok := h.deadLetterRequest(c, "DiscardStep")
+	if !ok || !h.checkStepAccess(c, "DiscardStep") {
+		return
+	}
+
+	step, err := h.workflowUsecase.DiscardStep(c.Request.Context(), c.Param("stepId"), req)
+	if err != nil {
+		appErrors.HandleError(c, err, "DiscardStep")
+		return
+	}
+
+	c.JSON(http.StatusOK, step)
+}
+
+// ListDeadLetterEvents lists failed outbox events (SuperAdmin only)
+func (h *WorkflowHandler) ListDeadLetterEvents(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	page, limit := pagination(c)
+	filter := engine.DeadLetterFilter{Limit: limit, Offset: (page - 1) * limit}
+
+	events, total, err := h.workflowUsecase.ListDeadLetterEvents(c.Request.Context(), filter)
+	if err != nil {
+		appErrors.HandleError(c, err, "ListDeadLetterEvents")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events, "total": total, "page": page, "limit": limit})
+}
+
+// GetEvent returns an outbox event with its replay and discard audit (SuperAdmin only)
+func (h *WorkflowHandler) GetEvent(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	ev, audits, err := h.workflowUsecase.GetEvent(c.Request.Context(), c.Param("eventId"))
+	if err != nil {
+		appErrors.HandleError(c, err, "GetEvent")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"event": ev, "audits": audits})
+}
+
+// ReplayEvent resets a failed outbox event to pending (SuperAdmin only)
+func (h *WorkflowHandler) ReplayEvent(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	req, ok := h.deadLetterRequest(c, "ReplayEvent")
+	if !ok {
+		return
+	}
+
+	ev, err := h.workflowUsecase.ReplayEvent(c.Request.Context(), c.Param("eventId"), req)
+	if err != nil {
+		appErrors.HandleError(c, err, "ReplayEvent")
+		return
+	}
+
+	c.JSON(http.StatusOK, ev)
+}
+
+// DiscardEvent removes a failed outbox event from the dead-letter queue (SuperAdmin only)
+func (h *WorkflowHandler) DiscardEvent(c *gin.Context) {
+	if c.GetString("role") != string(entity.SuperAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	req, ok := h.deadLetterRequest(c, "DiscardEvent")
+	if !ok {
+		return
+	}
+
+	ev, err := h.workflowUsecase.DiscardEvent(c.Request.Context(), c.Param("eventId"), req)
+	if err != nil {
+		appErrors.HandleError(c, err, "DiscardEvent")
+		return
+	}
+
+	c.JSON(http.StatusOK, ev)
+}
+
+// deadLetterRequest binds the optional reason and edited input of a replay or
+// discard. An empty body is allowed.
+func (h *WorkflowHandler) deadLetterRequest(c *gin.Context, operation string) (deadletter.Request, bool) {
+	var req struct {
+		Reason string          `json:"reason"`
+		Input  json.RawMessage `json:"input"`
+	}
+
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), operation)
+			return deadletter.Request{}, false
+		}
+	}
+
+	return deadletter.Request{Actor: c.GetString("userID"), Reason: req.Reason, Input: req.Input}, true
+}
+
+// checkStepAccess checks access to the workspace of the run owning :stepId
+func (h *WorkflowHandler) checkStepAccess(c *gin.Context, operation string) bool {
+	_, run, err := h.workflowUsecase.GetStep(c.Request.Context(), c.Param("stepId"))
+	if err != nil {
+		appErrors.HandleError(c, err, operation)
+		return false
+	}
+
+	if !h.checkAccess(c, run.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return false
+	}
+
+	return true
+}
+
+func pagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultRunPageSize)))
+	if limit < 1 || limit > maxRunPageSize {
+		limit = defaultRunPageSize
+	}
+	return page, limit
+}
+
+func (h *WorkflowHandler) checkAccess(c *gin.Context, workspaceID string) bool {
+	userID := c.GetString("userID")
+	role := c.GetString("role")
+
+	if role == string(entity.SuperAdmin) {
+		return true
+	}
+
+	if workspaceID == "" {
+		return false
+	}
+
+	workspace, err := h.workspaceUsecase.GetWorkspace(workspaceID)
+	if err != nil {
+		return false
+	}
+
+	if workspace.OwnerID == userID {
+		return true
+	}
+
+	for _, member := range workspace.Members {
+		if member.UserID == userID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/alpinesboltltd/boltz-ai/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateTrigger creates a cron trigger that starts runs of a workflow
+func (h *WorkflowHandler) CreateTrigger(c *gin.Context) {
+	var req struct {
+		WorkspaceID  string          `json:"workspace_id" binding:"required"`
+		WorkflowType string          `json:"workflow_type" binding:"required"`
+		Schedule     string          `json:"schedule" binding:"required"`
+		Timezone     string          `json:"timezone"`
+		Payload      json.RawMessage `json:"payload"`
+		Enabled      *bool           `json:"enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "CreateTrigger")
+		return
+	}
+
+	if !h.checkAccess(c, req.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	t := &engine.WorkflowTrigger{
+		WorkspaceID:  req.WorkspaceID,
+		WorkflowType: req.WorkflowType,
+		Schedule:     req.Schedule,
+		Timezone:     req.Timezone,
+		Payload:      req.Payload,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		CreatedBy:    c.GetString("userID"),
+	}
+
+	t, err := h.workflowUsecase.CreateTrigger(c.Request.Context(), t)
+	if err != nil {
+		appErrors.HandleError(c, err, "CreateTrigger")
+		return
+	}
+
+	c.JSON(http.StatusCreated, t)
+}
+
+// ListTriggers lists the triggers of a workspace
+func (h *WorkflowHandler) ListTriggers(c *gin.Context) {
+	workspaceID := c.Query("workspace_id")
+	if workspaceID == "" && c.GetString("role") != string(entity.SuperAdmin) {
+		appErrors.HandleError(c, appErrors.NewValidationError("workspace_id is required"), "ListTriggers")
+		return
+	}
+
+	if workspaceID != "" && !h.checkAccess(c, workspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	triggers, err := h.workflowUsecase.ListTriggers(c.Request.Context(), workspaceID)
+	if err != nil {
+		appErrors.HandleError(c, err, "ListTriggers")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+// GetTrigger returns a trigger with its next and last activation
+func (h *WorkflowHandler) GetTrigger(c *gin.Context) {
+	t, ok := h.loadTrigger(c, "GetTrigger")
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// UpdateTrigger changes the schedule, timezone, payload or enabled flag of a trigger
+func (h *WorkflowHandler) UpdateTrigger(c *gin.Context) {
+	var req usecase.WorkflowTriggerUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErrors.HandleError(c, appErrors.NewValidationError("Invalid request format"), "UpdateTrigger")
+		return
+	}
+
+	if _, ok := h.loadTrigger(c, "UpdateTrigger"); !ok {
+		return
+	}
+
+	t, err := h.workflowUsecase.UpdateTrigger(c.Request.Context(), c.Param("triggerId"), req)
+	if err != nil {
+		appErrors.HandleError(c, err, "UpdateTrigger")
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// DeleteTrigger deletes a trigger; runs it already started are not affected
+func (h *WorkflowHandler) DeleteTrigger(c *gin.Context) {
+	if _, ok := h.loadTrigger(c, "DeleteTrigger"); !ok {
+		return
+	}
+
+	if err := h.workflowUsecase.DeleteTrigger(c.Request.Context(), c.Param("triggerId")); err != nil {
+		appErrors.HandleError(c, err, "DeleteTrigger")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trigger deleted successfully"})
+}
+
+// loadTrigger loads :triggerId and checks access to its workspace
+func (h *WorkflowHandler) loadTrigger(c *gin.Context, operation string) (*engine.WorkflowTrigger, bool) {
+	t, err := h.workflowUsecase.GetTrigger(c.Request.Context(), c.Param("triggerId"))
+	if err != nil {
+		appErrors.HandleError(c, err, operation)
+		return nil, false
+	}
+
+	if !h.checkAccess(c, t.WorkspaceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+
+	return t, true
+}
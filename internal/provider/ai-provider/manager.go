@@ -1,6 +1,8 @@
 package aiprovider
 
 import (
+	"context"
+
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
 )
 
@@ -16,30 +18,39 @@ func NewLLMManager() *LLMManager {
 	}
 }
 
-// GetProviderForAgent returns the appropriate LLM provider for an agent
-func (m *LLMManager) GetProviderForAgent(agent entity.Agent, apiKey string) (LLMProvider, error) {
-	return m.factory.GetProviderFromAgent(agent, apiKey)
+// GetProviderForAgent returns the appropriate LLM provider for an agent. The
+// provider traces its calls as children of the span in ctx.
+func (m *LLMManager) GetProviderForAgent(ctx context.Context, agent entity.Agent, apiKey string) (LLMProvider, error) {
+	p, err := m.factory.GetProviderFromAgent(agent, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return Traced(ctx, p, agent.AiModel.Provider, agent.AiModel.Name), nil
 }
 
 // GetProviderForChat returns provider based on chat context
-func (m *LLMManager) GetProviderForChat(agentId string, agent entity.Agent, apiKey string) (LLMProvider, error) {
+func (m *LLMManager) GetProviderForChat(ctx context.Context, agentId string, agent entity.Agent, apiKey string) (LLMProvider, error) {
 	// You can add logic here to select provider based on:
 	// - Agent configuration
 	// - User preferences
 	// - Load balancing
 	// - Cost optimization
 
-	return m.GetProviderForAgent(agent, apiKey)
+	return m.GetProviderForAgent(ctx, agent, apiKey)
 }
 
 // GetMultimodalProvider returns provider with multimodal capabilities
-func (m *LLMManager) GetMultimodalProvider(agent entity.Agent, apiKey, ttsKey, sttKey string) (LLMProvider, error) {
-	return m.factory.GetMultimodalProvider(agent, apiKey, ttsKey, sttKey)
+func (m *LLMManager) GetMultimodalProvider(ctx context.Context, agent entity.Agent, apiKey, ttsKey, sttKey string) (LLMProvider, error) {
+	p, err := m.factory.GetMultimodalProvider(agent, apiKey, ttsKey, sttKey)
+	if err != nil {
+		return nil, err
+	}
+	return Traced(ctx, p, agent.AiModel.Provider, agent.AiModel.Name), nil
 }
 
 // ProcessMultimodalMessage handles different input types based on agent capabilities
-func (m *LLMManager) ProcessMultimodalMessage(agent entity.Agent, messages []MultimodalMessage, apiKey, ttsKey, sttKey string) (string, error) {
-	provider, err := m.GetMultimodalProvider(agent, apiKey, ttsKey, sttKey)
+func (m *LLMManager) ProcessMultimodalMessage(ctx context.Context, agent entity.Agent, messages []MultimodalMessage, apiKey, ttsKey, sttKey string) (string, error) {
+	provider, err := m.GetMultimodalProvider(ctx, agent, apiKey, ttsKey, sttKey)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,64 @@
+package aiprovider
+
+import (
+	"context"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedProvider records every completion of the wrapped provider as an
+// "llm.<operation>" span under the span of ctx.
+type tracedProvider struct {
+	ctx      context.Context
+	next     LLMProvider
+	provider string
+	model    string
+}
+
+// Traced wraps p so its calls are traced as children of the span in ctx.
+func Traced(ctx context.Context, p LLMProvider, provider, model string) LLMProvider {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &tracedProvider{ctx: ctx, next: p, provider: provider, model: model}
+}
+
+func (t *tracedProvider) start(op string, config map[string]interface{}) trace.Span {
+	model := t.model
+	if m, ok := config["model"].(string); ok && m != "" {
+		model = m
+	}
+	_, span := tracing.Start(t.ctx, "llm."+op,
+		attribute.String("gen_ai.system", t.provider),
+		attribute.String("gen_ai.request.model", model),
+	)
+	return span
+}
+
+func (t *tracedProvider) CompleteConversation(conversation Conversation, config map[string]interface{}) (out string, err error) {
+	span := t.start("complete", config)
+	span.SetAttributes(attribute.Int("gen_ai.request.messages", len(conversation.Messages)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CompleteConversation(conversation, config)
+}
+
+func (t *tracedProvider) CompleteMultimodalConversation(messages []MultimodalMessage, config map[string]interface{}) (out string, err error) {
+	span := t.start("complete_multimodal", config)
+	span.SetAttributes(attribute.Int("gen_ai.request.messages", len(messages)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CompleteMultimodalConversation(messages, config)
+}
+
+func (t *tracedProvider) CompleteConversationStream(conversation Conversation, config map[string]interface{}, callback StreamCallback) (err error) {
+	span := t.start("stream", config)
+	span.SetAttributes(attribute.Int("gen_ai.request.messages", len(conversation.Messages)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CompleteConversationStream(conversation, config, callback)
+}
+
+func (t *tracedProvider) GetCapabilities() entity.ModelCapabilities {
+	return t.next.GetCapabilities()
+}
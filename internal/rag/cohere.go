@@ -6,8 +6,10 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
 	cohere "github.com/cohere-ai/cohere-go/v2"
 	client "github.com/cohere-ai/cohere-go/v2/client"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // CohereClient provides access to Cohere's embedding API using the official Go SDK v2.
@@ -58,6 +60,19 @@ func NewCohereClient(apiKey string) (*CohereClient, error) {
 //
 //	embeddings, err := client.Embed(["Hello world", "Hola mundo", "こんにちは世界"], "search_document")
 func (c *CohereClient) Embed(texts []string, inputType string) ([][]float32, error) {
+	return c.EmbedContext(context.Background(), texts, inputType)
+}
+
+// EmbedContext is Embed recorded as an "rag.embed" span that is a child of the
+// span in ctx.
+func (c *CohereClient) EmbedContext(ctx context.Context, texts []string, inputType string) (embeddings [][]float32, err error) {
+	ctx, span := tracing.Start(ctx, "rag.embed",
+		attribute.String("rag.embedding_model", "embed-multilingual-v3.0"),
+		attribute.String("rag.input_type", inputType),
+		attribute.Int("rag.texts", len(texts)),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	// Convert input type to SDK enum
 	var embedInputType cohere.EmbedInputType
 	switch inputType {
@@ -75,13 +90,12 @@ func (c *CohereClient) Embed(texts []string, inputType string) ([][]float32, err
 		InputType: &embedInputType,
 	}
 
-	resp, err := c.client.Embed(context.Background(), req)
+	resp, err := c.client.Embed(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
 	}
 
 	// Convert response embeddings to float32
-	var embeddings [][]float32
 	for _, embedding := range resp.EmbeddingsFloats.Embeddings {
 		var floatEmbedding []float32
 		for _, val := range embedding {
@@ -2,13 +2,16 @@ package rag
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
 	"github.com/alpinesboltltd/boltz-ai/internal/repository"
+	"github.com/alpinesboltltd/boltz-ai/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // RAGService provides high-level RAG operations for training agents and retrieving context.
@@ -134,6 +137,15 @@ func (r *RAGService) ProcessDocument(agentID, title string, docType entity.Docum
 //   - *entity.RAGResponse: Response with context and individual chunks
 //   - error: Any error that occurred during the query
 func (r *RAGService) Query(query entity.RAGQuery) (*entity.RAGResponse, error) {
+	return r.QueryContext(context.Background(), query)
+}
+
+// QueryContext is Query traced as a "rag.query" span, with child spans for
+// the query embedding and the vector search, under the span in ctx.
+func (r *RAGService) QueryContext(ctx context.Context, query entity.RAGQuery) (resp *entity.RAGResponse, err error) {
+	ctx, span := tracing.Start(ctx, "rag.query", attribute.String("rag.agent_id", query.AgentID))
+	defer func() { tracing.End(span, err) }()
+
 	if query.TopK == 0 {
 		query.TopK = 5
 	}
@@ -142,16 +154,48 @@ func (r *RAGService) Query(query entity.RAGQuery) (*entity.RAGResponse, error) {
 	}
 
 	// Generate embedding for query
-	embeddings, err := r.processor.cohere.Embed([]string{query.Query}, "search_query")
+	embeddings, err := r.processor.cohere.EmbedContext(ctx, []string{query.Query}, "search_query")
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
 	}
 
 	// Search for similar chunks based on vector DB type
-	var chunks []entity.RetrievedChunk
+	chunks, err := r.search(ctx, query, embeddings[0])
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("rag.chunks", len(chunks)))
+
+	// Build context from retrieved chunks
+	var contextParts []string
+	for _, chunk := range chunks {
+		contextParts = append(contextParts, chunk.Content)
+	}
+
+	return &entity.RAGResponse{
+		Context: strings.Join(contextParts, "\n\n"),
+		Chunks:  chunks,
+		Query:   query.Query,
+	}, nil
+}
+
+// search runs the similarity search against the configured vector DB in a
+// "rag.vector_search" span.
+func (r *RAGService) search(ctx context.Context, query entity.RAGQuery, embedding []float32) (chunks []entity.RetrievedChunk, err error) {
+	backend := "pgvector"
 	if r.vectorDBType == "pinecone" && r.vectorDB != nil {
+		backend = "pinecone"
+	}
+	_, span := tracing.Start(ctx, "rag.vector_search",
+		attribute.String("db.system", backend),
+		attribute.Int("rag.top_k", query.TopK),
+		attribute.Float64("rag.threshold", float64(query.Threshold)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if backend == "pinecone" {
 		// Search in Pinecone
-		pineconeResults, err := r.vectorDB.Search(query.AgentID, embeddings[0], query.TopK, query.Threshold)
+		pineconeResults, err := r.vectorDB.Search(query.AgentID, embedding, query.TopK, query.Threshold)
 		if err != nil {
 			return nil, fmt.Errorf("failed to search Pinecone: %w", err)
 		}
@@ -183,23 +227,12 @@ func (r *RAGService) Query(query entity.RAGQuery) (*entity.RAGResponse, error) {
 		}
 	} else {
 		// Search in PostgreSQL (pgvector)
-		chunks, err = r.repo.SearchSimilar(query.AgentID, embeddings[0], query.TopK, query.Threshold)
+		chunks, err = r.repo.SearchSimilar(query.AgentID, embedding, query.TopK, query.Threshold)
 		if err != nil {
 			return nil, fmt.Errorf("failed to search similar chunks: %w", err)
 		}
 	}
-
-	// Build context from retrieved chunks
-	var contextParts []string
-	for _, chunk := range chunks {
-		contextParts = append(contextParts, chunk.Content)
-	}
-
-	return &entity.RAGResponse{
-		Context: strings.Join(contextParts, "\n\n"),
-		Chunks:  chunks,
-		Query:   query.Query,
-	}, nil
+	return chunks, nil
 }
 
 // DeleteAgentDocuments removes all training documents and chunks for an agent.
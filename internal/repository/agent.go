@@ -2,6 +2,7 @@ package repository
 
 import (
 	"errors"
+	"math"
 	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
@@ -166,6 +167,52 @@ func (r *AgentRepository) UpdateAgentStats(stats *entity.AgentStats) error {
 	return r.db.Save(stats).Error
 }
 
+// RecalculateAgentStats recomputes an agent's message counters from its
+// conversations, creating the stats row on first use. ResponseRate is the
+// percentage of conversations the assistant answered. AverageRating and
+// ConversionsCount have no source table and are kept as they are.
+func (r *AgentRepository) RecalculateAgentStats(agent_id string) (*entity.AgentStats, error) {
+	var totalMessages, uniqueUsers, conversations, answered int64
+	if err := r.db.Model(&entity.Message{}).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("conversations.agent_id = ?", agent_id).Count(&totalMessages).Error; err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "count agent messages")
+	}
+	if err := r.db.Model(&entity.Conversation{}).Where("agent_id = ?", agent_id).
+		Distinct("client_id").Count(&uniqueUsers).Error; err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "count agent users")
+	}
+	if err := r.db.Model(&entity.Conversation{}).Where("agent_id = ?", agent_id).Count(&conversations).Error; err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "count agent conversations")
+	}
+	if err := r.db.Model(&entity.Conversation{}).
+		Where("agent_id = ? AND EXISTS (SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id AND messages.role = ?)", agent_id, entity.MessageRole[entity.Assistant]).
+		Count(&answered).Error; err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "count answered conversations")
+	}
+	responseRate := 0.0
+	if conversations > 0 {
+		responseRate = math.Round(float64(answered)/float64(conversations)*10000) / 100
+	}
+
+	now := time.Now().UTC()
+	var stats entity.AgentStats
+	if err := r.db.Where("agent_id = ?", agent_id).First(&stats).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return r.CreateAgentStats(agent_id, int(totalMessages), int(uniqueUsers), 0, 0, responseRate, now)
+		}
+		return nil, appErrors.WrapDatabaseError(err, "get agent stats")
+	}
+	stats.TotalMessages = int(totalMessages)
+	stats.UniqueUsers = int(uniqueUsers)
+	stats.ResponseRate = responseRate
+	stats.LastCalculatedAt = now
+	if err := r.UpdateAgentStats(&stats); err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "update agent stats")
+	}
+	return &stats, nil
+}
+
 func (r *AgentRepository) UpdateAgentChannel(channel *entity.AgentChannel) error {
 	channel.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
 	return r.db.Save(channel).Error
@@ -178,7 +225,7 @@ func (r *AgentRepository) UpdateAgentIntegration(integration *entity.AgentIntegr
 
 func (r *AgentRepository) GetAgent(id string) (*entity.Agent, error) {
 	var agent entity.Agent
-	if err := r.db.Where("id = ?", id).First(&agent).Error; err != nil {
+	if err := r.db.Preload("AiModel").Where("id = ?", id).First(&agent).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, appErrors.NewNotFoundError("Agent not found")
 		}
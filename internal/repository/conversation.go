@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ConversationRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationRepository(db *gorm.DB) ConversationRepositoryInterface {
+	return &ConversationRepository{db: db}
+}
+
+func (r *ConversationRepository) GetConversation(id string) (*entity.Conversation, error) {
+	var conversation entity.Conversation
+	if err := r.db.Where("id = ?", id).First(&conversation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, appErrors.NewNotFoundError("Conversation not found")
+		}
+		return nil, appErrors.WrapDatabaseError(err, "get conversation")
+	}
+	return &conversation, nil
+}
+
+func (r *ConversationRepository) GetMessages(conversationID string) ([]entity.Message, error) {
+	var messages []entity.Message
+	if err := r.db.Where("conversation_id = ?", conversationID).Order("timestamp, id").Find(&messages).Error; err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "get conversation messages")
+	}
+	return messages, nil
+}
+
+// CreateMessage inserts msg unless a message with its ID exists, so a reply
+// delivered twice is stored once.
+func (r *ConversationRepository) CreateMessage(msg *entity.Message) error {
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(msg).Error; err != nil {
+		return appErrors.WrapDatabaseError(err, "create message")
+	}
+	return nil
+}
+
+// EscalateConversation hands a conversation over to human support. Escalating
+// a conversation again only updates the reason.
+func (r *ConversationRepository) EscalateConversation(id, reason string) error {
+	res := r.db.Model(&entity.Conversation{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"escalated_to_human": true, "escalation_reason": reason})
+	if res.Error != nil {
+		return appErrors.WrapDatabaseError(res.Error, "escalate conversation")
+	}
+	if res.RowsAffected == 0 {
+		return appErrors.NewNotFoundError("Conversation not found")
+	}
+	return nil
+}
@@ -39,6 +39,7 @@ func InitDB(databaseURL string) (*gorm.DB, error) {
 		&entity.TrainingData{},
 		&entity.TrainingDocument{},
 		&entity.DocumentChunk{},
+		&entity.TrainingUpload{},
 		&entity.Conversation{},
 		&entity.Message{},
 		&entity.MessageMetadata{},
@@ -49,7 +50,11 @@ func InitDB(databaseURL string) (*gorm.DB, error) {
 		&entity.WorkflowStep{},
 		&entity.OutboxEvent{},
 		&entity.StepLog{},
-		&entity.RetryMeta{},
+		&entity.OutboxEventAudit{},
+		&entity.WorkflowTrigger{},
+		&entity.WorkflowPause{},
+		&entity.QueueMessage{},
+		&entity.EventSubscription{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
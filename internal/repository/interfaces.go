@@ -29,6 +29,7 @@ type AgentRepositoryInterface interface {
 	UpdateAgentBehavior(behavior *entity.AgentBehavior) error
 	UpdateAgentChannel(channel *entity.AgentChannel) error
 	UpdateAgentStats(stats *entity.AgentStats) error
+	RecalculateAgentStats(agent_id string) (*entity.AgentStats, error)
 	UpdateAgentIntegration(integration *entity.AgentIntegration) error
 	GetAgent(id string) (*entity.Agent, error)
 	GetAgentWithDetails(id string) (*entity.Agent, error)
@@ -51,6 +52,13 @@ type AgentRepositoryInterface interface {
 	DeleteTrainingData(id string) error
 }
 
+type ConversationRepositoryInterface interface {
+	GetConversation(id string) (*entity.Conversation, error)
+	GetMessages(conversationID string) ([]entity.Message, error)
+	CreateMessage(msg *entity.Message) error
+	EscalateConversation(id, reason string) error
+}
+
 type SystemRepositoryInterface interface {
 	CreateSystemInstruction(title, content, createdBy string, templateId *string) (*entity.SystemInstruction, error)
 	GetSystemInstruction(id string) (*entity.SystemInstruction, error)
@@ -144,6 +152,28 @@ type RAGRepositoryInterface interface {
 	//   - []entity.DocumentChunk: Array of chunks
 	//   - error: Any error that occurred during retrieval
 	GetChunksByIDs(chunkIDs []string) ([]entity.DocumentChunk, error)
+
+	// CreateTrainingUpload stores an uploaded file until it is ingested.
+	// Parameters:
+	//   - upload: Upload to store
+	// Returns:
+	//   - error: Any error that occurred during creation
+	CreateTrainingUpload(upload *entity.TrainingUpload) error
+
+	// GetTrainingUpload retrieves a stored upload with its content.
+	// Parameters:
+	//   - id: ID of the upload
+	// Returns:
+	//   - *entity.TrainingUpload: The upload, or a not found error
+	//   - error: Any error that occurred during retrieval
+	GetTrainingUpload(id string) (*entity.TrainingUpload, error)
+
+	// DeleteTrainingUpload removes a stored upload once it is ingested.
+	// Parameters:
+	//   - id: ID of the upload to delete
+	// Returns:
+	//   - error: Any error that occurred during deletion
+	DeleteTrainingUpload(id string) error
 }
 
 type TokenRepositoryInterface interface {
@@ -2,10 +2,12 @@ package repository
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
 	"gorm.io/gorm"
 )
 
@@ -108,6 +110,25 @@ func (r *RAGRepository) GetChunksByIDs(chunkIDs []string) ([]entity.DocumentChun
 	return chunks, err
 }
 
+func (r *RAGRepository) CreateTrainingUpload(upload *entity.TrainingUpload) error {
+	return r.db.Create(upload).Error
+}
+
+func (r *RAGRepository) GetTrainingUpload(id string) (*entity.TrainingUpload, error) {
+	var upload entity.TrainingUpload
+	if err := r.db.Where("id = ?", id).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, appErrors.NewNotFoundError("Training upload not found")
+		}
+		return nil, appErrors.WrapDatabaseError(err, "get training upload")
+	}
+	return &upload, nil
+}
+
+func (r *RAGRepository) DeleteTrainingUpload(id string) error {
+	return r.db.Delete(&entity.TrainingUpload{}, "id = ?", id).Error
+}
+
 func formatVector(embedding []float32) string {
 	if len(embedding) == 0 {
 		return "[]"
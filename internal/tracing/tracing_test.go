@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A span started from a stored carrier, e.g. a step executed long after its
+// run was started on another instance, belongs to the run's trace.
+func TestCarrierContinuesTrace(t *testing.T) {
+	if _, err := Init(context.Background(), Config{}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	ctx, start := Start(context.Background(), "workflow.start")
+	carrier := Inject(ctx)
+	End(start, nil)
+	if carrier["traceparent"] == "" {
+		t.Fatalf("carrier = %v, want a traceparent", carrier)
+	}
+
+	_, step := Start(Extract(context.Background(), carrier), "step draft_response")
+	End(step, nil)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[1].SpanContext().TraceID() != spans[0].SpanContext().TraceID() {
+		t.Fatal("step span started a new trace")
+	}
+	if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+		t.Fatal("step span is not a child of the run span")
+	}
+
+	if Inject(context.Background()) != nil {
+		t.Fatal("context without a span must not produce a carrier")
+	}
+	if trace.SpanContextFromContext(Extract(context.Background(), nil)).IsValid() {
+		t.Fatal("empty carrier must not produce a span context")
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	cases := map[string]string{
+		"http://otel-collector:4318":       "http://otel-collector:4318/v1/traces",
+		"https://otel.example.com/":        "https://otel.example.com/v1/traces",
+		"https://otel.example.com/tenant1": "https://otel.example.com/tenant1/v1/traces",
+	}
+	for endpoint, want := range cases {
+		if got, err := tracesURL(endpoint); err != nil || got != want {
+			t.Errorf("tracesURL(%q) = %q, %v; want %q", endpoint, got, err, want)
+		}
+	}
+	for _, bad := range []string{"otel-collector:4318", "grpc://otel-collector:4317", "http://"} {
+		if _, err := tracesURL(bad); err == nil {
+			t.Errorf("tracesURL(%q) succeeded", bad)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package usecase
 
 import (
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
 	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
 	"github.com/alpinesboltltd/boltz-ai/internal/repository"
@@ -8,6 +9,8 @@ import (
 
 type AgentUsecase struct {
 	Agent repository.AgentRepositoryInterface
+	// queue runs stats recalculations in the background; see WithQueue
+	queue engine.Queue
 }
 
 func NewAgentUseCase(agentRepo repository.AgentRepositoryInterface) *AgentUsecase {
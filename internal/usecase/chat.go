@@ -1,6 +1,7 @@
 package usecase
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -24,7 +25,9 @@ func NewChatService(agentRepo repository.AgentRepositoryInterface, systemRepo re
 	}
 }
 
-func (s *ChatService) ProcessMessage(agentID, userMessage, apiKey string) (string, error) {
+// ProcessMessage answers userMessage as the agent. Provider calls are traced
+// under ctx.
+func (s *ChatService) ProcessMessage(ctx context.Context, agentID, userMessage, apiKey string) (string, error) {
 	// Quick cache check
 	cacheKey := agentID + "_" + userMessage[:min(30, len(userMessage))]
 	s.cacheMutex.RLock()
@@ -39,7 +42,7 @@ func (s *ChatService) ProcessMessage(agentID, userMessage, apiKey string) (strin
 		return "", fmt.Errorf("failed to get agent config: %w", err)
 	}
 
-	provider, err := s.llmManager.GetProviderForAgent(config.Agent, apiKey)
+	provider, err := s.llmManager.GetProviderForAgent(ctx, config.Agent, apiKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to get provider: %w", err)
 	}
@@ -73,14 +76,41 @@ func (s *ChatService) ProcessMessage(agentID, userMessage, apiKey string) (strin
 	return result, nil
 }
 
+// Complete answers prompt as the agent: with its model, behavior and system
+// instruction, bypassing the response cache. Provider calls are traced under
+// ctx. Workflow steps use it to draft replies.
+func (s *ChatService) Complete(ctx context.Context, agentID, prompt, apiKey string) (string, error) {
+	config, err := s.agentCache.GetAgentConfig(agentID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get agent config: %w", err)
+	}
+
+	provider, err := s.llmManager.GetProviderForAgent(ctx, config.Agent, apiKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get provider: %w", err)
+	}
+
+	systemContent := config.SystemInstruction.Content
+	if systemContent == "" {
+		systemContent = "You are a helpful assistant."
+	}
+	conversation := aiprovider.Conversation{Messages: []aiprovider.Message{
+		{Role: aiprovider.RoleSystem, Content: systemContent},
+		{Role: aiprovider.RoleUser, Content: prompt},
+	}}
+
+	llmConfig := s.llmManager.BuildConfig(config.Behavior, config.Agent.AiModel.Name)
+	return provider.CompleteConversation(conversation, llmConfig)
+}
+
 // ProcessMessageStream provides streaming responses for sub-500ms initial response
-func (s *ChatService) ProcessMessageStream(agentID, userMessage, apiKey string, callback aiprovider.StreamCallback) error {
+func (s *ChatService) ProcessMessageStream(ctx context.Context, agentID, userMessage, apiKey string, callback aiprovider.StreamCallback) error {
 	config, err := s.agentCache.GetAgentConfig(agentID)
 	if err != nil {
 		return fmt.Errorf("failed to get agent config: %w", err)
 	}
 
-	provider, err := s.llmManager.GetProviderForAgent(config.Agent, apiKey)
+	provider, err := s.llmManager.GetProviderForAgent(ctx, config.Agent, apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to get provider: %w", err)
 	}
@@ -111,13 +141,13 @@ func min(a, b int) int {
 	return b
 }
 
-func (s *ChatService) ProcessConversation(agentID string, messages []aiprovider.Message, apiKey string) (string, error) {
+func (s *ChatService) ProcessConversation(ctx context.Context, agentID string, messages []aiprovider.Message, apiKey string) (string, error) {
 	config, err := s.agentCache.GetAgentConfig(agentID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get agent config: %w", err)
 	}
 
-	provider, err := s.llmManager.GetProviderForAgent(config.Agent, apiKey)
+	provider, err := s.llmManager.GetProviderForAgent(ctx, config.Agent, apiKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to get provider: %w", err)
 	}
@@ -128,7 +158,7 @@ func (s *ChatService) ProcessConversation(agentID string, messages []aiprovider.
 }
 
 // ProcessMultimodalMessage handles text, voice, and vision inputs
-func (s *ChatService) ProcessMultimodalMessage(agentID string, messages []aiprovider.MultimodalMessage, apiKey, ttsKey, sttKey string) (string, error) {
+func (s *ChatService) ProcessMultimodalMessage(ctx context.Context, agentID string, messages []aiprovider.MultimodalMessage, apiKey, ttsKey, sttKey string) (string, error) {
 	config, err := s.agentCache.GetAgentConfig(agentID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get agent config: %w", err)
@@ -140,7 +170,7 @@ func (s *ChatService) ProcessMultimodalMessage(agentID string, messages []aiprov
 	// 	return "", fmt.Errorf("agent does not support required capabilities: %v", requiredCaps)
 	// }
 
-	return s.llmManager.ProcessMultimodalMessage(config.Agent, messages, apiKey, ttsKey, sttKey)
+	return s.llmManager.ProcessMultimodalMessage(ctx, config.Agent, messages, apiKey, ttsKey, sttKey)
 }
 
 func (s *ChatService) GetRequiredCapabilities(messages []aiprovider.MultimodalMessage) []string {
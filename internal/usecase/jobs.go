@@ -0,0 +1,228 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/alpinesboltltd/boltz-ai/internal/utils"
+	"github.com/google/uuid"
+)
+
+// Background job queues. Their messages are handled by the queue workers
+// started in app.Run, whether or not the orchestration engine is enabled.
+const (
+	TrainingQueue   = "training.ingest"
+	AgentStatsQueue = "agent.stats"
+)
+
+// Kinds of training jobs.
+const (
+	TrainingJobText = "text"
+	TrainingJobFile = "file"
+	TrainingJobURL  = "url"
+)
+
+// TrainingJob is the payload of a TrainingQueue message: one document, file
+// or URL to ingest into an agent's knowledge base.
+type TrainingJob struct {
+	Kind    string `json:"kind"`
+	AgentID string `json:"agent_id"`
+	Title   string `json:"title"`
+	Content string `json:"content,omitempty"`
+	// FileData is the uploaded file. It is not queued: EnqueueTraining
+	// stores it as a training upload and queues the UploadID.
+	FileData []byte `json:"-"`
+	UploadID string `json:"upload_id,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Trace    bool   `json:"trace,omitempty"`
+	MaxPages int    `json:"max_pages,omitempty"`
+}
+
+// AgentStatsJob is the payload of an AgentStatsQueue message.
+type AgentStatsJob struct {
+	AgentID string `json:"agent_id"`
+}
+
+// errJobsDisabled is returned when a job is enqueued on a usecase built
+// without a queue.
+var errJobsDisabled = appErrors.NewInternalError("Background jobs are not configured", "")
+
+// enqueueJob marshals job onto queue qname and returns the message ID.
+func enqueueJob(ctx context.Context, q engine.Queue, qname string, job interface{}) (string, error) {
+	if q == nil {
+		return "", errJobsDisabled
+	}
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return "", appErrors.NewInternalError("Failed to encode job", err.Error())
+	}
+	msg, err := q.Enqueue(ctx, qname, payload, engine.EnqueueOptions{})
+	if err != nil {
+		return "", appErrors.WrapDatabaseError(err, "enqueue job")
+	}
+	return msg.ID, nil
+}
+
+// decodeJob unmarshals a message payload into job. A payload that does not
+// decode can never succeed, so the error is permanent.
+func decodeJob(msg *engine.QueueMessage, job interface{}) error {
+	if err := json.Unmarshal(msg.Payload, job); err != nil {
+		return engine.Permanent(fmt.Errorf("decode %s job: %w", msg.Queue, err))
+	}
+	return nil
+}
+
+// jobError marks validation and not-found errors as permanent so the queue
+// dead-letters the job instead of retrying it.
+func jobError(err error) error {
+	var appErr *appErrors.AppError
+	if errors.As(err, &appErr) && (appErr.Type == appErrors.ValidationError || appErr.Type == appErrors.NotFoundError) {
+		return engine.Permanent(err)
+	}
+	return err
+}
+
+// WithQueue lets the usecase ingest training content in the background.
+func (t *TrainingUseCase) WithQueue(q engine.Queue) *TrainingUseCase {
+	t.queue = q
+	return t
+}
+
+// EnqueueTraining validates job and queues it for ingestion. It returns the
+// job ID; the document shows up in the agent's training documents once the
+// job has run.
+func (t *TrainingUseCase) EnqueueTraining(ctx context.Context, job TrainingJob) (string, error) {
+	if job.AgentID == "" {
+		return "", appErrors.NewValidationError("Agent ID is required")
+	}
+	switch job.Kind {
+	case TrainingJobText:
+		if job.Content == "" {
+			return "", appErrors.NewValidationError("Content is required")
+		}
+	case TrainingJobFile:
+		if job.MimeType == "" {
+			job.MimeType = utils.DetectMimeType(job.FileData)
+		}
+		if !utils.ValidateMimeType(job.MimeType) {
+			return "", appErrors.NewValidationError(fmt.Sprintf("unsupported file type: %s", job.MimeType))
+		}
+		if t.queue == nil {
+			return "", errJobsDisabled
+		}
+		upload := &entity.TrainingUpload{ID: uuid.NewString(), AgentID: job.AgentID, MimeType: job.MimeType, Data: job.FileData}
+		if err := t.uploads.CreateTrainingUpload(upload); err != nil {
+			return "", appErrors.WrapDatabaseError(err, "store training upload")
+		}
+		job.UploadID = upload.ID
+		id, err := enqueueJob(ctx, t.queue, TrainingQueue, job)
+		if err != nil {
+			_ = t.uploads.DeleteTrainingUpload(upload.ID)
+		}
+		return id, err
+	case TrainingJobURL:
+		if job.URL == "" {
+			return "", appErrors.NewValidationError("URL is required")
+		}
+	default:
+		return "", appErrors.NewValidationError(fmt.Sprintf("unknown training job kind %q", job.Kind))
+	}
+	return enqueueJob(ctx, t.queue, TrainingQueue, job)
+}
+
+// HandleTrainingJob ingests the content of a TrainingQueue message. The
+// upload of a file job is deleted once it is ingested, or once the message
+// is dead-lettered, since nothing delivers a dead message again; it is kept
+// while the message will be retried.
+func (t *TrainingUseCase) HandleTrainingJob(ctx context.Context, msg *engine.QueueMessage) error {
+	var job TrainingJob
+	if err := decodeJob(msg, &job); err != nil {
+		return err
+	}
+	var err error
+	switch job.Kind {
+	case TrainingJobText:
+		err = t.ProcessDocument(job.AgentID, job.Title, job.Content, entity.DocumentTypeText, nil)
+	case TrainingJobFile:
+		err = t.ingestUpload(job)
+	case TrainingJobURL:
+		err = t.ProcessURL(job.AgentID, job.URL, job.Title, job.Trace, job.MaxPages)
+	default:
+		err = engine.Permanent(fmt.Errorf("unknown training job kind %q", job.Kind))
+	}
+	err = jobError(err)
+	if job.Kind == TrainingJobFile && job.UploadID != "" && (err == nil || lastAttempt(msg, err)) {
+		t.deleteUpload(job.UploadID)
+	}
+	return err
+}
+
+// lastAttempt reports whether the queue dead-letters msg when its handler
+// returns err.
+func lastAttempt(msg *engine.QueueMessage, err error) bool {
+	_, retryable := engine.ClassifyError(err)
+	return !retryable || msg.Attempts >= msg.MaxAttempts
+}
+
+// ingestUpload processes the stored upload of a file job.
+func (t *TrainingUseCase) ingestUpload(job TrainingJob) error {
+	upload, err := t.uploads.GetTrainingUpload(job.UploadID)
+	if err != nil {
+		return err
+	}
+	return t.ProcessFileWithMimeDetection(job.AgentID, job.Title, upload.Data, job.MimeType, nil)
+}
+
+// deleteUpload deletes an upload no job will read again. A leftover upload
+// only takes space, so a failure is logged.
+func (t *TrainingUseCase) deleteUpload(id string) {
+	if err := t.uploads.DeleteTrainingUpload(id); err != nil {
+		engine.DefaultLogger().Warn("training: failed to delete upload", engine.F("upload_id", id), engine.Err(err))
+	}
+}
+
+// WithQueue lets the usecase recalculate stats in the background.
+func (u *AgentUsecase) WithQueue(q engine.Queue) *AgentUsecase {
+	u.queue = q
+	return u
+}
+
+// RecalculateAgentStats recomputes an agent's stats from its conversations.
+func (u *AgentUsecase) RecalculateAgentStats(agentId string) (*entity.AgentStats, error) {
+	if agentId == "" {
+		return nil, appErrors.NewValidationError("Agent ID is required")
+	}
+	if _, err := u.Agent.GetAgent(agentId); err != nil {
+		return nil, err
+	}
+	return u.Agent.RecalculateAgentStats(agentId)
+}
+
+// EnqueueStatsRecalculation queues a recalculation of an agent's stats and
+// returns the job ID.
+func (u *AgentUsecase) EnqueueStatsRecalculation(ctx context.Context, agentId string) (string, error) {
+	if agentId == "" {
+		return "", appErrors.NewValidationError("Agent ID is required")
+	}
+	if _, err := u.Agent.GetAgent(agentId); err != nil {
+		return "", err
+	}
+	return enqueueJob(ctx, u.queue, AgentStatsQueue, AgentStatsJob{AgentID: agentId})
+}
+
+// HandleStatsJob recalculates the stats of the agent of an AgentStatsQueue
+// message.
+func (u *AgentUsecase) HandleStatsJob(ctx context.Context, msg *engine.QueueMessage) error {
+	var job AgentStatsJob
+	if err := decodeJob(msg, &job); err != nil {
+		return err
+	}
+	_, err := u.RecalculateAgentStats(job.AgentID)
+	return jobError(err)
+}
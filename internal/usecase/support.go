@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/alpinesboltltd/boltz-ai/internal/repository"
+	csrworkflow "github.com/alpinesboltltd/boltz-ai/workflows/csr"
+	"github.com/google/uuid"
+)
+
+// InboundEmail is a customer email forwarded by the mail provider to an
+// agent's support inbox.
+type InboundEmail struct {
+	AgentID string `json:"agent_id"`
+	// MessageID is the email's Message-ID header. Forwarding the same email
+	// again returns the run it already started.
+	MessageID string `json:"message_id"`
+	From      string `json:"from"`
+	Subject   string `json:"subject"`
+	Text      string `json:"text"`
+}
+
+// SupportUsecase hands customer requests over to the CSR workflow.
+type SupportUsecase interface {
+	GetConversation(conversationID string) (*entity.Conversation, error)
+	// EscalateConversation marks a conversation as escalated to human
+	// support and starts the CSR run that answers it. Escalating it again
+	// returns the same run.
+	EscalateConversation(ctx context.Context, conversationID, reason string) (*engine.WorkflowRun, error)
+	// ReceiveEmail starts the CSR run that answers an inbound email.
+	ReceiveEmail(ctx context.Context, email InboundEmail) (*engine.WorkflowRun, error)
+}
+
+type supportUsecase struct {
+	conversations repository.ConversationRepositoryInterface
+	agents        repository.AgentRepositoryInterface
+	workflows     WorkflowUsecase
+	reviewerEmail string
+}
+
+// NewSupportUsecase returns a SupportUsecase whose runs notify reviewerEmail
+// (optional) of drafts that need review.
+func NewSupportUsecase(conversations repository.ConversationRepositoryInterface, agents repository.AgentRepositoryInterface, workflows WorkflowUsecase, reviewerEmail string) SupportUsecase {
+	return &supportUsecase{conversations: conversations, agents: agents, workflows: workflows, reviewerEmail: reviewerEmail}
+}
+
+func (u *supportUsecase) GetConversation(conversationID string) (*entity.Conversation, error) {
+	return u.conversations.GetConversation(conversationID)
+}
+
+func (u *supportUsecase) EscalateConversation(ctx context.Context, conversationID, reason string) (*engine.WorkflowRun, error) {
+	conv, err := u.conversations.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	agent, err := u.agents.GetAgent(conv.AgentId)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := u.conversations.GetMessages(conv.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.conversations.EscalateConversation(conv.Id, reason); err != nil {
+		return nil, err
+	}
+	payload := csrworkflow.Payload{
+		TicketID: conv.Id, ConversationID: conv.Id, AgentID: conv.AgentId,
+		Channel: csrworkflow.ChannelConversation, ReviewerEmail: u.reviewerEmail,
+	}
+	// a retried escalation joins the run answering the same customer
+	// message; once the customer writes again, escalating starts a new run
+	key := "conversation:" + conv.Id
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == entity.MessageRole[entity.User] {
+			key += ":" + messages[i].Id
+			break
+		}
+	}
+	return u.startCSR(ctx, agent.WorkspaceID, payload, key)
+}
+
+func (u *supportUsecase) ReceiveEmail(ctx context.Context, email InboundEmail) (*engine.WorkflowRun, error) {
+	if email.AgentID == "" || email.From == "" {
+		return nil, appErrors.NewValidationError("agent_id and from are required")
+	}
+	if strings.TrimSpace(email.Text) == "" && strings.TrimSpace(email.Subject) == "" {
+		return nil, appErrors.NewValidationError("email has no subject or text")
+	}
+	agent, err := u.agents.GetAgent(email.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	ticketID, key := email.MessageID, ""
+	if ticketID == "" {
+		ticketID = uuid.NewString()
+	} else {
+		key = "email:" + agent.ID + ":" + email.MessageID
+	}
+	payload := csrworkflow.Payload{
+		TicketID: ticketID, CustomerEmail: email.From, Subject: email.Subject, Message: email.Text,
+		AgentID: agent.ID, Channel: csrworkflow.ChannelEmail, ReviewerEmail: u.reviewerEmail,
+	}
+	return u.startCSR(ctx, agent.WorkspaceID, payload, key)
+}
+
+// startCSR starts a CSR run in workspaceID. Runs with the same non-empty
+// key share one run ID, so retried requests do not answer a ticket twice.
+func (u *supportUsecase) startCSR(ctx context.Context, workspaceID string, payload csrworkflow.Payload, key string) (*engine.WorkflowRun, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, appErrors.NewInternalError("Failed to encode CSR payload", err.Error())
+	}
+	var opts StartRunOptions
+	if key != "" {
+		opts.RunID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("csr:"+key)).String()
+	}
+	return u.workflows.StartRun(ctx, csrworkflow.New().ID(), workspaceID, b, opts)
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
 	"github.com/alpinesboltltd/boltz-ai/internal/entity"
 	"github.com/alpinesboltltd/boltz-ai/internal/rag"
 	"github.com/alpinesboltltd/boltz-ai/internal/repository"
@@ -25,6 +26,10 @@ type TrainingUseCase struct {
 	agentRepo repository.AgentRepositoryInterface
 	// scraperService provides web scraping capabilities
 	scraperService *scraper.Service
+	// uploads keeps uploaded files until their training job runs
+	uploads repository.RAGRepositoryInterface
+	// queue runs ingestion in the background; see WithQueue
+	queue engine.Queue
 }
 
 // NewTrainingUseCase creates a new training use case with the required dependencies.
@@ -75,6 +80,7 @@ func NewTrainingUseCase(cohereKey, openaiKey, googleKey, pineconeKey, pineconeIn
 		ragService:     ragService,
 		agentRepo:      agentRepo,
 		scraperService: scraper.NewService(nil),
+		uploads:        ragRepo,
 	}, nil
 }
 
@@ -0,0 +1,368 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/deadletter"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/signal"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/workflow"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+)
+
+// WorkflowRunDetails is a run together with its steps and step logs.
+type WorkflowRunDetails struct {
+	Run  *engine.WorkflowRun `json:"run"`
+	Logs []*engine.StepLog   `json:"logs"`
+}
+
+// StartRunOptions are the optional settings of a new run.
+type StartRunOptions struct {
+	// Deadline bounds the whole run.
+	Deadline *time.Time
+	// Priority orders the run's steps against other pending steps; higher
+	// runs first. It must be within MaxRunPriority of zero.
+	Priority int
+	// RunID makes starting idempotent: when a run with this ID exists it is
+	// returned instead of starting another. Empty generates an ID.
+	RunID string
+}
+
+// MaxRunPriority bounds the priority of a run in either direction.
+const MaxRunPriority = 100
+
+type WorkflowUsecase interface {
+	StartRun(ctx context.Context, workflowType, workspaceID string, payload json.RawMessage, opts StartRunOptions) (*engine.WorkflowRun, error)
+	GetRun(ctx context.Context, runID string) (*WorkflowRunDetails, error)
+	ListRuns(ctx context.Context, filter engine.RunFilter) ([]*engine.WorkflowRun, int64, error)
+	CancelRun(ctx context.Context, runID string) (*engine.WorkflowRun, error)
+	MigrateRun(ctx context.Context, runID, version string) (*engine.WorkflowRun, error)
+	PauseRun(ctx context.Context, runID string, paused bool) (*engine.WorkflowRun, error)
+	ListWorkflows(ctx context.Context) ([]WorkflowVersions, error)
+	PauseWorkflow(ctx context.Context, pause *engine.WorkflowPause) (*engine.WorkflowPause, error)
+	ResumeWorkflow(ctx context.Context, workflowType string) error
+	SignalStep(ctx context.Context, runID, stepID string, sig engine.Signal) (*engine.WorkflowRun, error)
+	ListPendingApprovals(ctx context.Context, workspaceID string) ([]*engine.WorkflowStepRecord, error)
+	GetStep(ctx context.Context, stepID string) (*engine.WorkflowStepRecord, *engine.WorkflowRun, error)
+	ListDeadLetterSteps(ctx context.Context, filter engine.DeadLetterFilter) ([]*engine.WorkflowStepRecord, int64, error)
+	ReplayStep(ctx context.Context, stepID string, req deadletter.Request) (*engine.WorkflowStepRecord, error)
+	DiscardStep(ctx context.Context, stepID string, req deadletter.Request) (*engine.WorkflowStepRecord, error)
+	ListDeadLetterEvents(ctx context.Context, filter engine.DeadLetterFilter) ([]*engine.OutboxEvent, int64, error)
+	GetEvent(ctx context.Context, eventID string) (*engine.OutboxEvent, []*engine.EventAudit, error)
+	ReplayEvent(ctx context.Context, eventID string, req deadletter.Request) (*engine.OutboxEvent, error)
+	DiscardEvent(ctx context.Context, eventID string, req deadletter.Request) (*engine.OutboxEvent, error)
+	CreateTrigger(ctx context.Context, trigger *engine.WorkflowTrigger) (*engine.WorkflowTrigger, error)
+	GetTrigger(ctx context.Context, triggerID string) (*engine.WorkflowTrigger, error)
+	ListTriggers(ctx context.Context, workspaceID string) ([]*engine.WorkflowTrigger, error)
+	UpdateTrigger(ctx context.Context, triggerID string, update WorkflowTriggerUpdate) (*engine.WorkflowTrigger, error)
+	DeleteTrigger(ctx context.Context, triggerID string) error
+}
+
+// WorkflowVersions lists the registered versions of a workflow. New runs
+// start on Latest.
+type WorkflowVersions struct {
+	ID       string   `json:"id"`
+	Versions []string `json:"versions"`
+	Latest   string   `json:"latest"`
+	// Paused is set while runs of the workflow are paused.
+	Paused *engine.WorkflowPause `json:"paused,omitempty"`
+}
+
+type workflowUsecase struct {
+	store engine.StateStore
+	reg   engine.WorkflowRegistry
+}
+
+func NewWorkflowUsecase(store engine.StateStore, reg engine.WorkflowRegistry) WorkflowUsecase {
+	return &workflowUsecase{store: store, reg: reg}
+}
+
+func (u *workflowUsecase) StartRun(ctx context.Context, workflowType, workspaceID string, payload json.RawMessage, opts StartRunOptions) (*engine.WorkflowRun, error) {
+	if _, ok := u.reg.Get(workflowType); !ok {
+		return nil, appErrors.NewValidationError(fmt.Sprintf("Unknown workflow type: %s", workflowType))
+	}
+	if opts.Deadline != nil && !opts.Deadline.After(time.Now()) {
+		return nil, appErrors.NewValidationError("deadline must be in the future")
+	}
+	if opts.Priority < -MaxRunPriority || opts.Priority > MaxRunPriority {
+		return nil, appErrors.NewValidationError(fmt.Sprintf("priority must be between %d and %d", -MaxRunPriority, MaxRunPriority))
+	}
+	if len(payload) == 0 {
+		payload = json.RawMessage(`{}`)
+	}
+	if opts.RunID != "" {
+		existing, err := u.store.LoadRun(ctx, opts.RunID)
+		if err != nil {
+			return nil, appErrors.WrapDatabaseError(err, "load workflow run")
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
+	run := &engine.WorkflowRun{ID: opts.RunID, WorkflowType: workflowType, WorkspaceID: workspaceID, Payload: payload, Deadline: opts.Deadline, Priority: opts.Priority}
+	if err := workflow.StartRun(ctx, u.store, u.reg, run); err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "start workflow run")
+	}
+	return u.loadRun(ctx, run.ID)
+}
+
+func (u *workflowUsecase) GetRun(ctx context.Context, runID string) (*WorkflowRunDetails, error) {
+	run, err := u.loadRun(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := u.store.ListRunLogs(ctx, runID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "list workflow run logs")
+	}
+	return &WorkflowRunDetails{Run: run, Logs: logs}, nil
+}
+
+func (u *workflowUsecase) ListRuns(ctx context.Context, filter engine.RunFilter) ([]*engine.WorkflowRun, int64, error) {
+	runs, total, err := u.store.ListRuns(ctx, filter)
+	if err != nil {
+		return nil, 0, appErrors.WrapDatabaseError(err, "list workflow runs")
+	}
+	return runs, total, nil
+}
+
+func (u *workflowUsecase) CancelRun(ctx context.Context, runID string) (*engine.WorkflowRun, error) {
+	if _, err := u.loadRun(ctx, runID); err != nil {
+		return nil, err
+	}
+	// child runs are cancelled too and what the runs already did is undone
+	cancelled, err := workflow.Cancel(ctx, u.store, u.reg, runID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "cancel workflow run")
+	}
+	if !cancelled {
+		return nil, appErrors.NewConflictError("Workflow run is not running")
+	}
+	return u.loadRun(ctx, runID)
+}
+
+// MigrateRun moves a running run to another registered version of its
+// workflow.
+func (u *workflowUsecase) MigrateRun(ctx context.Context, runID, version string) (*engine.WorkflowRun, error) {
+	if _, err := u.loadRun(ctx, runID); err != nil {
+		return nil, err
+	}
+	if err := workflow.Migrate(ctx, u.store, u.reg, runID, version); err != nil {
+		switch {
+		case errors.Is(err, workflow.ErrUnknownVersion), errors.Is(err, workflow.ErrNotMigratable):
+			return nil, appErrors.NewValidationError(err.Error())
+		case errors.Is(err, workflow.ErrRunBusy):
+			return nil, appErrors.NewConflictError("Workflow run is not running or has a step in progress")
+		}
+		return nil, appErrors.WrapDatabaseError(err, "migrate workflow run")
+	}
+	return u.loadRun(ctx, runID)
+}
+
+// PauseRun pauses or resumes a running run and its child runs.
+func (u *workflowUsecase) PauseRun(ctx context.Context, runID string, paused bool) (*engine.WorkflowRun, error) {
+	if _, err := u.loadRun(ctx, runID); err != nil {
+		return nil, err
+	}
+	changed, err := workflow.Pause(ctx, u.store, runID, paused)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "pause workflow run")
+	}
+	if !changed {
+		if paused {
+			return nil, appErrors.NewConflictError("Workflow run is not running or already paused")
+		}
+		return nil, appErrors.NewConflictError("Workflow run is not paused")
+	}
+	return u.loadRun(ctx, runID)
+}
+
+func (u *workflowUsecase) ListWorkflows(ctx context.Context) ([]WorkflowVersions, error) {
+	pauses, err := u.store.ListWorkflowPauses(ctx)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "list workflow pauses")
+	}
+	paused := make(map[string]*engine.WorkflowPause, len(pauses))
+	for _, p := range pauses {
+		paused[p.WorkflowType] = p
+	}
+	ids := u.reg.IDs()
+	out := make([]WorkflowVersions, 0, len(ids))
+	for _, id := range ids {
+		versions := u.reg.Versions(id)
+		out = append(out, WorkflowVersions{ID: id, Versions: versions, Latest: versions[len(versions)-1], Paused: paused[id]})
+	}
+	return out, nil
+}
+
+// PauseWorkflow stops steps of every run of a workflow type from being
+// claimed until ResumeWorkflow is called.
+func (u *workflowUsecase) PauseWorkflow(ctx context.Context, pause *engine.WorkflowPause) (*engine.WorkflowPause, error) {
+	if _, ok := u.reg.Get(pause.WorkflowType); !ok {
+		return nil, appErrors.NewNotFoundError(fmt.Sprintf("Unknown workflow type: %s", pause.WorkflowType))
+	}
+	if err := u.store.PauseWorkflow(ctx, pause); err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "pause workflow")
+	}
+	return pause, nil
+}
+
+func (u *workflowUsecase) ResumeWorkflow(ctx context.Context, workflowType string) error {
+	resumed, err := u.store.ResumeWorkflow(ctx, workflowType)
+	if err != nil {
+		return appErrors.WrapDatabaseError(err, "resume workflow")
+	}
+	if !resumed {
+		return appErrors.NewNotFoundError("Workflow is not paused")
+	}
+	return nil
+}
+
+func (u *workflowUsecase) SignalStep(ctx context.Context, runID, stepID string, sig engine.Signal) (*engine.WorkflowRun, error) {
+	run, err := u.loadRun(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	var step *engine.WorkflowStepRecord
+	for i := range run.Steps {
+		if run.Steps[i].ID == stepID {
+			step = &run.Steps[i]
+			break
+		}
+	}
+	if step == nil {
+		return nil, appErrors.NewNotFoundError("Workflow step not found")
+	}
+
+	if err := signal.Deliver(ctx, u.store, u.reg, step, sig); err != nil {
+		switch {
+		case errors.Is(err, signal.ErrInvalidAction):
+			return nil, appErrors.NewValidationError(err.Error())
+		case errors.Is(err, signal.ErrNotWaiting):
+			return nil, appErrors.NewConflictError("Workflow step is not waiting for a signal")
+		}
+		return nil, appErrors.WrapDatabaseError(err, "signal workflow step")
+	}
+	return u.loadRun(ctx, runID)
+}
+
+func (u *workflowUsecase) ListPendingApprovals(ctx context.Context, workspaceID string) ([]*engine.WorkflowStepRecord, error) {
+	steps, err := u.store.ListWaitingSteps(ctx, workspaceID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "list pending approvals")
+	}
+	return steps, nil
+}
+
+// GetStep returns a step together with the run it belongs to.
+func (u *workflowUsecase) GetStep(ctx context.Context, stepID string) (*engine.WorkflowStepRecord, *engine.WorkflowRun, error) {
+	step, err := u.store.LoadStep(ctx, stepID)
+	if err != nil {
+		return nil, nil, appErrors.WrapDatabaseError(err, "load workflow step")
+	}
+	if step == nil {
+		return nil, nil, appErrors.NewNotFoundError("Workflow step not found")
+	}
+	run, err := u.loadRun(ctx, step.RunID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return step, run, nil
+}
+
+func (u *workflowUsecase) ListDeadLetterSteps(ctx context.Context, filter engine.DeadLetterFilter) ([]*engine.WorkflowStepRecord, int64, error) {
+	steps, total, err := u.store.ListDeadLetterSteps(ctx, filter)
+	if err != nil {
+		return nil, 0, appErrors.WrapDatabaseError(err, "list dead-letter steps")
+	}
+	return steps, total, nil
+}
+
+func (u *workflowUsecase) ReplayStep(ctx context.Context, stepID string, req deadletter.Request) (*engine.WorkflowStepRecord, error) {
+	if err := deadletter.ReplayStep(ctx, u.store, stepID, req); err != nil {
+		return nil, deadLetterError(err, "replay workflow step")
+	}
+	step, _, err := u.GetStep(ctx, stepID)
+	return step, err
+}
+
+func (u *workflowUsecase) DiscardStep(ctx context.Context, stepID string, req deadletter.Request) (*engine.WorkflowStepRecord, error) {
+	if err := deadletter.DiscardStep(ctx, u.store, stepID, req); err != nil {
+		return nil, deadLetterError(err, "discard workflow step")
+	}
+	step, _, err := u.GetStep(ctx, stepID)
+	return step, err
+}
+
+func (u *workflowUsecase) ListDeadLetterEvents(ctx context.Context, filter engine.DeadLetterFilter) ([]*engine.OutboxEvent, int64, error) {
+	events, total, err := u.store.ListDeadLetterEvents(ctx, filter)
+	if err != nil {
+		return nil, 0, appErrors.WrapDatabaseError(err, "list dead-letter events")
+	}
+	return events, total, nil
+}
+
+// GetEvent returns an outbox event with the audit of the actions taken on it.
+func (u *workflowUsecase) GetEvent(ctx context.Context, eventID string) (*engine.OutboxEvent, []*engine.EventAudit, error) {
+	ev, err := u.loadEvent(ctx, eventID)
+	if err != nil {
+		return nil, nil, err
+	}
+	audits, err := u.store.ListEventAudits(ctx, eventID)
+	if err != nil {
+		return nil, nil, appErrors.WrapDatabaseError(err, "list outbox event audits")
+	}
+	return ev, audits, nil
+}
+
+func (u *workflowUsecase) ReplayEvent(ctx context.Context, eventID string, req deadletter.Request) (*engine.OutboxEvent, error) {
+	if err := deadletter.ReplayEvent(ctx, u.store, eventID, req); err != nil {
+		return nil, deadLetterError(err, "replay outbox event")
+	}
+	return u.loadEvent(ctx, eventID)
+}
+
+func (u *workflowUsecase) DiscardEvent(ctx context.Context, eventID string, req deadletter.Request) (*engine.OutboxEvent, error) {
+	if err := deadletter.DiscardEvent(ctx, u.store, eventID, req); err != nil {
+		return nil, deadLetterError(err, "discard outbox event")
+	}
+	return u.loadEvent(ctx, eventID)
+}
+
+func (u *workflowUsecase) loadEvent(ctx context.Context, eventID string) (*engine.OutboxEvent, error) {
+	ev, err := u.store.LoadEvent(ctx, eventID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "load outbox event")
+	}
+	if ev == nil {
+		return nil, appErrors.NewNotFoundError("Outbox event not found")
+	}
+	return ev, nil
+}
+
+func deadLetterError(err error, operation string) error {
+	switch {
+	case errors.Is(err, deadletter.ErrNotFound):
+		return appErrors.NewNotFoundError("Dead-letter entry not found")
+	case errors.Is(err, deadletter.ErrNotDead):
+		return appErrors.NewConflictError("Entry is not in the dead-letter queue")
+	case errors.Is(err, deadletter.ErrInvalidJSON):
+		return appErrors.NewValidationError(err.Error())
+	}
+	return appErrors.WrapDatabaseError(err, operation)
+}
+
+func (u *workflowUsecase) loadRun(ctx context.Context, runID string) (*engine.WorkflowRun, error) {
+	run, err := u.store.LoadRun(ctx, runID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "load workflow run")
+	}
+	if run == nil {
+		return nil, appErrors.NewNotFoundError("Workflow run not found")
+	}
+	return run, nil
+}
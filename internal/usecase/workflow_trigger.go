@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/trigger"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/google/uuid"
+)
+
+// WorkflowTriggerUpdate changes a trigger. Nil fields are left unchanged.
+type WorkflowTriggerUpdate struct {
+	Schedule *string          `json:"schedule"`
+	Timezone *string          `json:"timezone"`
+	Payload  *json.RawMessage `json:"payload"`
+	Enabled  *bool            `json:"enabled"`
+}
+
+func (u *workflowUsecase) CreateTrigger(ctx context.Context, t *engine.WorkflowTrigger) (*engine.WorkflowTrigger, error) {
+	if _, ok := u.reg.Get(t.WorkflowType); !ok {
+		return nil, appErrors.NewValidationError(fmt.Sprintf("Unknown workflow type: %s", t.WorkflowType))
+	}
+	t.ID = uuid.NewString()
+	if t.Timezone == "" {
+		t.Timezone = "UTC"
+	}
+	if len(t.Payload) == 0 {
+		t.Payload = json.RawMessage(`{}`)
+	}
+	if err := scheduleTrigger(t); err != nil {
+		return nil, err
+	}
+	if err := u.store.CreateTrigger(ctx, t); err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "create workflow trigger")
+	}
+	return u.GetTrigger(ctx, t.ID)
+}
+
+func (u *workflowUsecase) GetTrigger(ctx context.Context, triggerID string) (*engine.WorkflowTrigger, error) {
+	t, err := u.store.LoadTrigger(ctx, triggerID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "load workflow trigger")
+	}
+	if t == nil {
+		return nil, appErrors.NewNotFoundError("Workflow trigger not found")
+	}
+	return t, nil
+}
+
+func (u *workflowUsecase) ListTriggers(ctx context.Context, workspaceID string) ([]*engine.WorkflowTrigger, error) {
+	triggers, err := u.store.ListTriggers(ctx, workspaceID)
+	if err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "list workflow triggers")
+	}
+	return triggers, nil
+}
+
+func (u *workflowUsecase) UpdateTrigger(ctx context.Context, triggerID string, update WorkflowTriggerUpdate) (*engine.WorkflowTrigger, error) {
+	t, err := u.GetTrigger(ctx, triggerID)
+	if err != nil {
+		return nil, err
+	}
+	if update.Schedule != nil {
+		t.Schedule = *update.Schedule
+	}
+	if update.Timezone != nil {
+		t.Timezone = *update.Timezone
+	}
+	if update.Payload != nil {
+		t.Payload = *update.Payload
+	}
+	if update.Enabled != nil {
+		t.Enabled = *update.Enabled
+	}
+	if err := scheduleTrigger(t); err != nil {
+		return nil, err
+	}
+	if err := u.store.UpdateTrigger(ctx, t); err != nil {
+		return nil, appErrors.WrapDatabaseError(err, "update workflow trigger")
+	}
+	return u.GetTrigger(ctx, triggerID)
+}
+
+func (u *workflowUsecase) DeleteTrigger(ctx context.Context, triggerID string) error {
+	deleted, err := u.store.DeleteTrigger(ctx, triggerID)
+	if err != nil {
+		return appErrors.WrapDatabaseError(err, "delete workflow trigger")
+	}
+	if !deleted {
+		return appErrors.NewNotFoundError("Workflow trigger not found")
+	}
+	return nil
+}
+
+// scheduleTrigger validates the schedule and timezone of t and sets its next
+// activation, which is cleared while the trigger is disabled.
+func scheduleTrigger(t *engine.WorkflowTrigger) error {
+	next, err := trigger.NextRun(t, time.Now())
+	if err != nil {
+		return appErrors.NewValidationError(err.Error())
+	}
+	t.NextRunAt = nil
+	if t.Enabled {
+		t.NextRunAt = &next
+	}
+	return nil
+}
@@ -2,12 +2,72 @@ package csr
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/signal"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
 )
 
-// CSR workflow scaffold. Implements engine.Workflow and provides a deterministic Plan.
+// Step names of the CSR pipeline in execution order.
+const (
+	StepFetchTicket     = "fetch_ticket"
+	StepRetrieveContext = "retrieve_context"
+	StepDraftResponse   = "draft_response"
+	StepHumanReview     = "human_review"
+	StepSendResponse    = "send_response"
+)
+
+// Channels a CSR reply can be sent over.
+const (
+	ChannelEmail        = "email"
+	ChannelConversation = "conversation"
+)
+
+// Payload is the run payload expected by the CSR workflow. Runs started from
+// an escalated conversation set ConversationID and the ticket is read from
+// its history; runs started from an email carry the message itself.
+type Payload struct {
+	TicketID      string `json:"ticket_id"`
+	CustomerEmail string `json:"customer_email"`
+	Subject       string `json:"subject"`
+	Message       string `json:"message"`
+	AgentID       string `json:"agent_id"`
+	// ConversationID is the escalated conversation the ticket comes from.
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Channel is where the reply is sent. It defaults to ChannelConversation
+	// when ConversationID is set and to ChannelEmail otherwise.
+	Channel string `json:"channel,omitempty"`
+	// ReviewerEmail is notified when a draft needs human review. Reviews
+	// without one are only listed in the approvals inbox.
+	ReviewerEmail string `json:"reviewer_email,omitempty"`
+}
+
+// ReplyChannel returns the channel the reply to p is sent over.
+func (p Payload) ReplyChannel() string {
+	switch {
+	case p.Channel != "":
+		return p.Channel
+	case p.ConversationID != "":
+		return ChannelConversation
+	}
+	return ChannelEmail
+}
+
+// llmRetry retries draft_response on rate limits and timeouts from the model
+// provider with a longer backoff than the engine default.
+var llmRetry = &engine.RetryPolicy{
+	MaxAttempts:      6,
+	InitialBackoff:   10 * time.Second,
+	MaxBackoff:       10 * time.Minute,
+	Jitter:           0.2,
+	RetryableClasses: []string{engine.ErrorClassRateLimit, engine.ErrorClassTimeout, engine.ErrorClassTransient, engine.ErrorClassUnknown},
+}
+
+// CSRWorkflow answers support tickets. It implements engine.Workflow with a
+// deterministic Plan.
 type CSRWorkflow struct{}
 
 func New() *CSRWorkflow { return &CSRWorkflow{} }
@@ -16,11 +76,120 @@ func (w *CSRWorkflow) ID() string { return "csr" }
 
 func (w *CSRWorkflow) Version() string { return "v1" }
 
+// Plan walks fetch_ticket -> retrieve_context -> draft_response ->
+// human_review (only when the draft needs review) -> send_response. Inputs
+// that depend on the payload or on earlier results are planned as templates
+// and bound when the step is claimed. A failed step or a rejected review
+// ends the run.
 func (w *CSRWorkflow) Plan(ctx context.Context, run *engine.WorkflowRun) ([]engine.WorkflowStepDef, error) {
-	// Minimal deterministic plan: create a single fetch_ticket step for a new run.
 	if run == nil {
 		return nil, fmt.Errorf("run is nil")
 	}
-	step := engine.WorkflowStepDef{StepName: "fetch_ticket", Seq: 1, Input: run.Payload}
-	return []engine.WorkflowStepDef{step}, nil
+	if len(run.Steps) == 0 {
+		return []engine.WorkflowStepDef{{StepName: StepFetchTicket, Seq: 1, Input: run.Payload}}, nil
+	}
+
+	last := run.Steps[len(run.Steps)-1]
+	if last.Status != engine.StepStatusCompleted {
+		return nil, nil
+	}
+
+	var payload Payload
+	if len(run.Payload) > 0 {
+		if err := json.Unmarshal(run.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("invalid csr payload: %w", err)
+		}
+	}
+	next := last.Seq + 1
+
+	switch last.StepName {
+	case StepFetchTicket:
+		return single(StepRetrieveContext, next, map[string]string{
+			"query":    "{{ steps." + StepFetchTicket + ".output.ticket.message }}",
+			"agent_id": "{{ steps." + StepFetchTicket + ".output.ticket.agent_id }}",
+		})
+
+	case StepRetrieveContext:
+		prompt := "A customer support ticket needs a reply. Draft the next message to the customer. " +
+			"Answer from the context below; if it does not cover the question, say that a colleague will follow up.\n\n" +
+			"Subject: {{ steps." + StepFetchTicket + ".output.ticket.subject }}\n\n" +
+			"Conversation:\n{{ steps." + StepFetchTicket + ".output.ticket.transcript }}\n\n" +
+			"Relevant context:\n{{ steps." + StepRetrieveContext + ".output.context }}"
+		defs, err := single(StepDraftResponse, next, map[string]string{
+			"prompt":     prompt,
+			"agent_id":   "{{ steps." + StepFetchTicket + ".output.ticket.agent_id }}",
+			"confidence": "{{ steps." + StepRetrieveContext + ".output.confidence }}",
+		})
+		if err != nil {
+			return nil, err
+		}
+		defs[0].Retry = llmRetry
+		return defs, nil
+
+	case StepDraftResponse:
+		var draft struct {
+			NeedsReview *bool `json:"needs_review"`
+		}
+		if err := json.Unmarshal(last.Result, &draft); err != nil {
+			return nil, fmt.Errorf("invalid draft_response result: %w", err)
+		}
+		// drafts of runs started before confidence routing are reviewed
+		// whenever a reviewer was set
+		needsReview := payload.ReviewerEmail != ""
+		if draft.NeedsReview != nil {
+			needsReview = *draft.NeedsReview
+		}
+		if needsReview {
+			return single(StepHumanReview, next, map[string]string{
+				"agent_email": "{{ run.payload.reviewer_email }}",
+				"draft":       "{{ steps." + StepDraftResponse + ".output.draft }}",
+				"ticket_id":   "{{ steps." + StepFetchTicket + ".output.ticket.id }}",
+			})
+		}
+		return single(StepSendResponse, next, replyTemplate(payload, "{{ steps."+StepDraftResponse+".output.draft }}"))
+
+	case StepHumanReview:
+		var review signal.Decision
+		if err := json.Unmarshal(last.Result, &review); err != nil {
+			return nil, fmt.Errorf("invalid human_review result: %w", err)
+		}
+		body := "{{ steps." + StepDraftResponse + ".output.draft }}"
+		switch review.Decision {
+		case "rejected":
+			return nil, nil
+		case "edited":
+			body = "{{ steps." + StepHumanReview + ".output.payload.draft }}"
+		}
+		// a reply a reviewer approved or edited comes from them
+		reply := replyTemplate(payload, body)
+		if payload.ReplyChannel() == ChannelConversation {
+			reply["role"] = entity.MessageRole[entity.Human]
+		}
+		return single(StepSendResponse, next, reply)
+	}
+
+	// send_response (or any unknown step) is terminal
+	return nil, nil
+}
+
+// replyTemplate is the send_response template replying with body over the
+// channel the ticket came from.
+func replyTemplate(p Payload, body string) map[string]string {
+	if p.ReplyChannel() == ChannelConversation {
+		return map[string]string{"channel": ChannelConversation, "conversation_id": "{{ run.payload.conversation_id }}", "body": body}
+	}
+	subject := "Re: {{ run.payload.subject }}"
+	if p.Subject == "" {
+		subject = "Re: your support request {{ run.payload.ticket_id }}"
+	}
+	return map[string]string{"to": "{{ run.payload.customer_email }}", "subject": subject, "body": body}
+}
+
+// single plans one step whose input template is bound at claim time.
+func single(name string, seq int, template interface{}) ([]engine.WorkflowStepDef, error) {
+	b, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.WorkflowStepDef{{StepName: name, Seq: seq, Template: b}}, nil
 }
@@ -0,0 +1,192 @@
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/binding"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/dsl"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+)
+
+// completeNext plans the next step, binds its input and appends it to the
+// run as completed with the given result, mimicking what the executor and
+// scheduler persist.
+func completeNext(t *testing.T, w *CSRWorkflow, run *engine.WorkflowRun, result string) engine.WorkflowStepDef {
+	t.Helper()
+	defs, err := w.Plan(context.Background(), run)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(defs) != 1 {
+		t.Fatalf("expected one step, got %d", len(defs))
+	}
+	def := defs[0]
+	if len(def.Template) > 0 {
+		if def.Input, err = binding.Bind(def.Template, run); err != nil {
+			t.Fatalf("bind %s: %v", def.StepName, err)
+		}
+	}
+	run.Steps = append(run.Steps, engine.WorkflowStepRecord{
+		StepName: def.StepName, Seq: def.Seq, Status: engine.StepStatusCompleted,
+		Input: def.Input, Result: json.RawMessage(result),
+	})
+	return def
+}
+
+// ticketResult is the fetch_ticket output for an email ticket.
+const ticketResult = `{"ticket":{"id":"T-1","channel":"email","agent_id":"a1","subject":"Refund","message":"Where is my refund?","transcript":"Customer: Where is my refund?"}}`
+
+func TestPlanWalksPipeline(t *testing.T) {
+	w := New()
+	payload, _ := json.Marshal(Payload{TicketID: "T-1", CustomerEmail: "c@example.com", Subject: "Refund", Message: "Where is my refund?", AgentID: "a1", ReviewerEmail: "r@example.com"})
+	run := &engine.WorkflowRun{ID: "r1", WorkflowType: "csr", Status: engine.RunStatusRunning, Payload: payload}
+
+	want := []string{StepFetchTicket, StepRetrieveContext, StepDraftResponse, StepHumanReview, StepSendResponse}
+	results := []string{ticketResult, `{"context":"policy","confidence":0.4}`, `{"draft":"Hello","confidence":0.4,"needs_review":true}`, `{"decision":"approved"}`, `{"enqueued":true}`}
+	for i, name := range want {
+		def := completeNext(t, w, run, results[i])
+		if def.StepName != name || def.Seq != i+1 {
+			t.Fatalf("step %d: got %s seq=%d, want %s seq=%d", i, def.StepName, def.Seq, name, i+1)
+		}
+	}
+
+	var retrieve map[string]string
+	_ = json.Unmarshal(run.Steps[1].Input, &retrieve)
+	if retrieve["query"] != "Where is my refund?" || retrieve["agent_id"] != "a1" {
+		t.Fatalf("retrieve_context input not bound: %v", retrieve)
+	}
+
+	var draft draftInput
+	_ = json.Unmarshal(run.Steps[2].Input, &draft)
+	if !strings.Contains(draft.Prompt, "Customer: Where is my refund?") || !strings.Contains(draft.Prompt, "policy") {
+		t.Fatalf("draft_response prompt not bound: %q", draft.Prompt)
+	}
+	if draft.AgentID != "a1" || draft.Confidence == nil || *draft.Confidence != 0.4 {
+		t.Fatalf("draft_response input = %s", run.Steps[2].Input)
+	}
+
+	var review map[string]string
+	_ = json.Unmarshal(run.Steps[3].Input, &review)
+	if review["agent_email"] != "r@example.com" || review["ticket_id"] != "T-1" || review["draft"] != "Hello" {
+		t.Fatalf("unexpected human_review input: %v", review)
+	}
+
+	var send map[string]string
+	if err := json.Unmarshal(run.Steps[4].Input, &send); err != nil {
+		t.Fatalf("send_response input: %v", err)
+	}
+	if send["to"] != "c@example.com" || send["body"] != "Hello" {
+		t.Fatalf("unexpected send_response input: %v", send)
+	}
+
+	defs, err := w.Plan(context.Background(), run)
+	if err != nil || len(defs) != 0 {
+		t.Fatalf("expected run to finish, got %v (err=%v)", defs, err)
+	}
+}
+
+func TestPlanSendsConfidentDraftWithoutReview(t *testing.T) {
+	w := New()
+	payload, _ := json.Marshal(Payload{TicketID: "T-2", CustomerEmail: "c@example.com", Message: "hi", ReviewerEmail: "r@example.com"})
+	run := &engine.WorkflowRun{ID: "r2", WorkflowType: "csr", Status: engine.RunStatusRunning, Payload: payload}
+
+	completeNext(t, w, run, ticketResult)
+	completeNext(t, w, run, `{"context":"policy","confidence":0.9}`)
+	completeNext(t, w, run, `{"draft":"Hi","confidence":0.9,"needs_review":false}`)
+	def := completeNext(t, w, run, `{"enqueued":true}`)
+	if def.StepName != StepSendResponse {
+		t.Fatalf("expected send_response after draft, got %s", def.StepName)
+	}
+	var send map[string]string
+	_ = json.Unmarshal(def.Input, &send)
+	if send["subject"] != "Re: your support request T-2" {
+		t.Fatalf("subject without a payload subject = %q", send["subject"])
+	}
+}
+
+func TestPlanRepliesInConversation(t *testing.T) {
+	w := New()
+	payload, _ := json.Marshal(Payload{TicketID: "c-1", ConversationID: "c-1", AgentID: "a1"})
+	run := &engine.WorkflowRun{ID: "r5", WorkflowType: "csr", Status: engine.RunStatusRunning, Payload: payload}
+
+	completeNext(t, w, run, `{"ticket":{"id":"c-1","channel":"conversation","agent_id":"a1","message":"hi","transcript":"Customer: hi"}}`)
+	completeNext(t, w, run, `{"context":"policy","confidence":0.9}`)
+	completeNext(t, w, run, `{"draft":"Hello there","needs_review":false}`)
+	def := completeNext(t, w, run, `{"enqueued":true}`)
+
+	var send replyInput
+	_ = json.Unmarshal(def.Input, &send)
+	if send.Channel != ChannelConversation || send.ConversationID != "c-1" || send.Body != "Hello there" || send.To != "" || send.Role != "" {
+		t.Fatalf("send_response input = %s", def.Input)
+	}
+
+	// a reviewed reply is sent as the reviewer's
+	run.Steps = run.Steps[:2]
+	completeNext(t, w, run, `{"draft":"Hello there","needs_review":true}`)
+	completeNext(t, w, run, `{"decision":"edited","payload":{"draft":"Hi, sorry for the wait"}}`)
+	def = completeNext(t, w, run, `{"enqueued":true}`)
+	send = replyInput{}
+	_ = json.Unmarshal(def.Input, &send)
+	if send.Body != "Hi, sorry for the wait" || send.Role != entity.MessageRole[entity.Human] {
+		t.Fatalf("reviewed send_response input = %s", def.Input)
+	}
+}
+
+func TestPlanStopsOnFailedStep(t *testing.T) {
+	w := New()
+	run := &engine.WorkflowRun{ID: "r3", WorkflowType: "csr", Status: engine.RunStatusRunning, Payload: json.RawMessage(`{}`)}
+	run.Steps = []engine.WorkflowStepRecord{{StepName: StepFetchTicket, Seq: 1, Status: engine.StepStatusFailed}}
+
+	defs, err := w.Plan(context.Background(), run)
+	if err != nil || len(defs) != 0 {
+		t.Fatalf("expected no steps after failure, got %v (err=%v)", defs, err)
+	}
+}
+
+func TestPlanHonoursReviewDecision(t *testing.T) {
+	w := New()
+	payload, _ := json.Marshal(Payload{TicketID: "T-4", CustomerEmail: "c@example.com", ReviewerEmail: "r@example.com"})
+	newRun := func() *engine.WorkflowRun {
+		run := &engine.WorkflowRun{ID: "r4", WorkflowType: "csr", Status: engine.RunStatusRunning, Payload: payload}
+		completeNext(t, w, run, ticketResult)
+		completeNext(t, w, run, `{"context":"none","confidence":0}`)
+		completeNext(t, w, run, `{"draft":"original","confidence":0,"needs_review":true}`)
+		return run
+	}
+
+	run := newRun()
+	completeNext(t, w, run, `{"decision":"edited","payload":{"draft":"edited"}}`)
+	def := completeNext(t, w, run, `{"enqueued":true}`)
+	var send map[string]string
+	_ = json.Unmarshal(def.Input, &send)
+	if def.StepName != StepSendResponse || send["body"] != "edited" {
+		t.Fatalf("expected edited draft to be sent, got %s %v", def.StepName, send)
+	}
+
+	run = newRun()
+	completeNext(t, w, run, `{"decision":"rejected"}`)
+	defs, err := w.Plan(context.Background(), run)
+	if err != nil || len(defs) != 0 {
+		t.Fatalf("expected rejected review to end the run, got %v (err=%v)", defs, err)
+	}
+}
+
+// The shipped declarative definitions must only use CSR actions.
+func TestDefinitionsValidate(t *testing.T) {
+	defs, err := dsl.LoadDir("../definitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) == 0 {
+		t.Fatal("no definitions found")
+	}
+	for _, def := range defs {
+		if err := dsl.Validate(def, dsl.ActionNames(Actions(Deps{}))); err != nil {
+			t.Error(err)
+		}
+	}
+}
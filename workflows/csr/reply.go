@@ -0,0 +1,57 @@
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/outbox"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+)
+
+// EventConversationReply is the outbox event type of replies sent to an
+// escalated conversation.
+const EventConversationReply = "conversation.reply"
+
+// ReplyPayload is the payload of a conversation.reply event.
+type ReplyPayload struct {
+	ConversationID string `json:"conversation_id"`
+	Body           string `json:"body"`
+	RunID          string `json:"run_id"`
+	// Role is the role the reply is stored with: human for a reply a
+	// reviewer approved or edited, assistant (the default) otherwise.
+	Role string `json:"role,omitempty"`
+}
+
+// ReplyPublisher stores conversation.reply events as messages of their
+// conversation. The message ID is the event ID, so a reply published twice
+// is stored once when CreateMessage ignores duplicates.
+//
+// Replies are not routed by the conversation's platform: clients reading
+// the conversation's messages see them, but platforms such as WhatsApp
+// that need the message pushed to them do not receive them.
+func ReplyPublisher(conversations Conversations) outbox.Publisher {
+	return outbox.PublisherFunc(func(ctx context.Context, ev *engine.OutboxEvent) error {
+		var p ReplyPayload
+		if err := json.Unmarshal(ev.Payload, &p); err != nil {
+			return engine.Permanent(fmt.Errorf("decode conversation reply: %w", err))
+		}
+		if p.ConversationID == "" || p.Body == "" {
+			return engine.Permanent(errors.New("conversation reply has no conversation or body"))
+		}
+		role := p.Role
+		if role == "" {
+			role = entity.MessageRole[entity.Assistant]
+		}
+		return notFoundIsPermanent(conversations.CreateMessage(&entity.Message{
+			Id:             ev.ID,
+			ConversationId: p.ConversationID,
+			Role:           role,
+			Text:           p.Body,
+			Timestamp:      time.Now().UTC(),
+		}))
+	})
+}
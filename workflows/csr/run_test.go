@@ -0,0 +1,139 @@
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/enginetest"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+)
+
+func startReviewedRun(t *testing.T, timeoutAction string) (*enginetest.Harness, *engine.WorkflowRun) {
+	h := enginetest.New(t)
+	Register(h.Registry, h.Handlers, Deps{
+		Store: h.Store,
+		LLM: func(ctx context.Context, input []byte) (string, error) {
+			var in map[string]string
+			_ = json.Unmarshal(input, &in)
+			if !strings.Contains(in["prompt"], "Where is my refund?") {
+				t.Errorf("prompt does not quote the customer: %q", in["prompt"])
+			}
+			return "Your refund is on its way.", nil
+		},
+		ReviewTimeout:       24 * time.Hour,
+		ReviewTimeoutAction: timeoutAction,
+	})
+	run := h.Start("csr", Payload{
+		TicketID: "T-1", CustomerEmail: "c@example.com", Subject: "Refund", Message: "Where is my refund?",
+		ReviewerEmail: "agent@example.com",
+	})
+	h.Drain()
+	h.RequireStepStatus(run.ID, StepHumanReview, engine.StepStatusWaitingForSignal)
+	review := h.RequireEvents("email_send", 1)[0]
+	if !strings.Contains(string(review.Payload), "agent@example.com") {
+		t.Fatalf("review email = %s", review.Payload)
+	}
+	return h, run
+}
+
+func TestRunSendsEditedDraft(t *testing.T) {
+	h, run := startReviewedRun(t, engine.TimeoutReject)
+
+	h.Signal(run.ID, StepHumanReview, engine.Signal{Action: engine.SignalEdit, Payload: []byte(`{"draft":"Your refund was sent today."}`), Actor: "agent@example.com"})
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+	h.RequireSteps(run.ID, StepFetchTicket, StepRetrieveContext, StepDraftResponse, StepHumanReview, StepSendResponse)
+	h.RequireLog(run.ID, "signal edited")
+
+	var reply map[string]string
+	if err := json.Unmarshal(h.RequireEvents("email_send", 2)[1].Payload, &reply); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	if reply["to"] != "c@example.com" || reply["subject"] != "Re: Refund" || reply["body"] != "Your refund was sent today." {
+		t.Fatalf("reply = %v", reply)
+	}
+}
+
+func TestRunRejectsDraftWhenReviewTimesOut(t *testing.T) {
+	h, run := startReviewedRun(t, engine.TimeoutReject)
+
+	h.Advance(23 * time.Hour)
+	h.RequireStepStatus(run.ID, StepHumanReview, engine.StepStatusWaitingForSignal)
+	h.Advance(time.Hour)
+	h.RequireStepStatus(run.ID, StepHumanReview, engine.StepStatusCompleted)
+	h.RequireRunStatus(run.ID, engine.RunStatusRejected)
+	h.RequireSteps(run.ID, StepFetchTicket, StepRetrieveContext, StepDraftResponse, StepHumanReview)
+	h.RequireEvents("email_send", 1)
+}
+
+// retriever answers every query from a single knowledge base chunk, unless
+// it scores below the query threshold, which defaults to 0.7 like in
+// rag.RAGService.
+type retriever struct {
+	score float32
+}
+
+func (r retriever) QueryContext(ctx context.Context, q entity.RAGQuery) (*entity.RAGResponse, error) {
+	if q.Threshold == 0 {
+		q.Threshold = 0.7
+	}
+	if r.score < q.Threshold {
+		return &entity.RAGResponse{Chunks: []entity.RetrievedChunk{}, Query: q.Query}, nil
+	}
+	chunk := entity.RetrievedChunk{Content: "Refunds are issued within 5 days of a lost delivery.", Score: r.score}
+	return &entity.RAGResponse{Context: chunk.Content, Chunks: []entity.RetrievedChunk{chunk}, Query: q.Query}, nil
+}
+
+// An escalated conversation whose answer the knowledge base covers above
+// the minimum confidence is answered in the conversation without review.
+func TestRunRepliesToEscalatedConversation(t *testing.T) {
+	h := enginetest.New(t)
+	conversations := newConversationStore(escalated(), "My parcel is late", "It should arrive tomorrow.", "It never came, I want a refund")
+	Register(h.Registry, h.Handlers, Deps{
+		Store: h.Store, RAG: retriever{score: 0.65}, Conversations: conversations,
+		LLM: func(ctx context.Context, input []byte) (string, error) {
+			var in draftInput
+			_ = json.Unmarshal(input, &in)
+			if in.AgentID != "a1" || !strings.Contains(in.Prompt, "Assistant: It should arrive tomorrow.") || !strings.Contains(in.Prompt, "within 5 days") {
+				t.Errorf("draft input = %s", input)
+			}
+			return "Sorry about that, your refund will arrive within 5 days.", nil
+		},
+	})
+	run := h.Start("csr", Payload{ConversationID: "c-1"})
+	h.Drain()
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+	h.RequireSteps(run.ID, StepFetchTicket, StepRetrieveContext, StepDraftResponse, StepSendResponse)
+	h.RequireEvents("email_send", 0)
+
+	reply := h.RequireEvents(EventConversationReply, 1)[0]
+	if err := ReplyPublisher(conversations).Publish(context.Background(), reply); err != nil {
+		t.Fatalf("publish reply: %v", err)
+	}
+	messages, _ := conversations.GetMessages("c-1")
+	if last := messages[len(messages)-1]; last.Text != "Sorry about that, your refund will arrive within 5 days." || last.Role != entity.MessageRole[entity.Assistant] {
+		t.Fatalf("last message = %+v", last)
+	}
+}
+
+// Drafts the knowledge base does not back are held for review; the reviewer
+// is only listed in the approvals inbox when no reviewer email is set.
+func TestRunHoldsUnsupportedDraftForReview(t *testing.T) {
+	h := enginetest.New(t)
+	Register(h.Registry, h.Handlers, Deps{
+		Store: h.Store, RAG: retriever{score: 0.2},
+		LLM: func(ctx context.Context, input []byte) (string, error) { return "Maybe?", nil },
+	})
+	run := h.Start("csr", Payload{TicketID: "T-9", CustomerEmail: "c@example.com", Subject: "Warranty", Message: "Is my blender covered?", AgentID: "a1"})
+	h.Drain()
+	h.RequireStepStatus(run.ID, StepHumanReview, engine.StepStatusWaitingForSignal)
+	h.RequireLog(run.ID, "csr: draft needs review")
+	h.RequireEvents("email_send", 0)
+
+	h.Signal(run.ID, StepHumanReview, engine.Signal{Action: engine.SignalApprove, Actor: "agent@example.com"})
+	h.RequireRunStatus(run.ID, engine.RunStatusCompleted)
+	h.RequireEvents("email_send", 1)
+}
@@ -0,0 +1,394 @@
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/executor"
+	"github.com/alpinesboltltd/boltz-ai/internal/engine/outbox"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+	"github.com/google/uuid"
+)
+
+// DefaultMinConfidence is the retrieval confidence below which drafts are
+// reviewed by a human.
+const DefaultMinConfidence = 0.6
+
+// retrievalThreshold is the lowest chunk score retrieve_context asks for. It
+// is set well below any useful MinConfidence so that MinConfidence, not the
+// RAG service's default threshold, decides which drafts are reviewed.
+const retrievalThreshold = 0.05
+
+// Retriever searches an agent's knowledge base; *rag.RAGService implements it.
+type Retriever interface {
+	QueryContext(ctx context.Context, query entity.RAGQuery) (*entity.RAGResponse, error)
+}
+
+// Conversations reads the history of escalated conversations and stores the
+// replies sent to them; repository.ConversationRepository implements it.
+type Conversations interface {
+	GetConversation(id string) (*entity.Conversation, error)
+	GetMessages(conversationID string) ([]entity.Message, error)
+	CreateMessage(msg *entity.Message) error
+}
+
+// Deps are the services used by the CSR step handlers. LLM and RAG may be nil,
+// in which case draft_response and retrieve_context return placeholders.
+// Conversations is required for tickets that come from a conversation.
+type Deps struct {
+	// LLM drafts with the agent named by agent_id in its input, which also
+	// carries the prompt.
+	LLM           func(ctx context.Context, input []byte) (string, error)
+	Store         engine.StateStore
+	RAG           Retriever
+	Conversations Conversations
+	// MinConfidence is the retrieval confidence a draft needs to be sent
+	// without review. Zero means DefaultMinConfidence.
+	MinConfidence float64
+	// ReviewTimeout bounds how long human_review waits for a decision (zero
+	// waits forever). ReviewTimeoutAction is engine.TimeoutReject or
+	// engine.TimeoutEscalate; escalation emails EscalateTo.
+	ReviewTimeout       time.Duration
+	ReviewTimeoutAction string
+	EscalateTo          string
+}
+
+// Register adds the CSR workflow to reg and its step handlers to handlers.
+func Register(reg engine.WorkflowRegistry, handlers *executor.HandlerRegistry, deps Deps) {
+	w := New()
+	reg.Register(w)
+	s := &steps{deps: deps}
+	handlers.Register(w.ID(), "", executor.NewHandler(StepFetchTicket, s.fetchTicket))
+	handlers.Register(w.ID(), "", executor.Connector(ConnectorRAG, executor.NewHandler(StepRetrieveContext, s.retrieveContext)))
+	handlers.Register(w.ID(), "", executor.Connector(ConnectorLLM, executor.NewHandler(StepDraftResponse, s.draftResponse)))
+	handlers.Register(w.ID(), "", executor.NewHandler(StepHumanReview, s.humanReview))
+	handlers.Register(w.ID(), "", executor.Connector(ConnectorEmail, executor.NewHandler(StepSendResponse, s.sendResponse)))
+}
+
+// Connectors called by the CSR steps, to which step rate limits apply (see
+// executor.ParseStepRateLimits).
+const (
+	ConnectorLLM   = "llm"
+	ConnectorRAG   = "rag"
+	ConnectorEmail = "email"
+)
+
+// Action names under which the CSR step handlers are offered to declarative
+// workflows (see internal/engine/dsl).
+const (
+	ActionFetchTicket = "ticket.fetch"
+	ActionRetrieve    = "rag.retrieve"
+	ActionDraft       = "llm.draft"
+	ActionHumanReview = "human.review"
+	ActionSendEmail   = "email.send"
+	ActionSendReply   = "reply.send"
+)
+
+// Actions returns the CSR step handlers keyed by action name. Inputs are the
+// same as for the CSR steps: ticket.fetch takes a Payload, rag.retrieve query
+// and agent_id, llm.draft prompt and agent_id, human.review agent_email, draft
+// and ticket_id, and email.send to, subject and body. reply.send is email.send
+// that also takes channel and conversation_id to reply in a conversation.
+func Actions(deps Deps) map[string]engine.StepHandler {
+	s := &steps{deps: deps}
+	return map[string]engine.StepHandler{
+		ActionFetchTicket: executor.NewHandler(ActionFetchTicket, s.fetchTicket),
+		ActionRetrieve:    executor.Connector(ConnectorRAG, executor.NewHandler(ActionRetrieve, s.retrieveContext)),
+		ActionDraft:       executor.Connector(ConnectorLLM, executor.NewHandler(ActionDraft, s.draftResponse)),
+		ActionHumanReview: executor.NewHandler(ActionHumanReview, s.humanReview),
+		ActionSendEmail:   executor.Connector(ConnectorEmail, executor.NewHandler(ActionSendEmail, s.sendResponse)),
+		ActionSendReply:   executor.Connector(ConnectorEmail, executor.NewHandler(ActionSendReply, s.sendResponse)),
+	}
+}
+
+type steps struct {
+	deps Deps
+}
+
+// Ticket is the output of fetch_ticket, under "ticket".
+type Ticket struct {
+	ID            string `json:"id"`
+	Channel       string `json:"channel"`
+	AgentID       string `json:"agent_id"`
+	CustomerEmail string `json:"customer_email,omitempty"`
+	Subject       string `json:"subject"`
+	// Message is the customer's latest message; it is the retrieval query.
+	Message string `json:"message"`
+	// Transcript is the conversation so far, one "Role: text" line per
+	// message, oldest first.
+	Transcript       string `json:"transcript"`
+	EscalationReason string `json:"escalation_reason,omitempty"`
+}
+
+// maxTranscriptMessages bounds the history quoted to the model.
+const maxTranscriptMessages = 20
+
+var transcriptRoles = map[string]string{
+	entity.MessageRole[entity.User]:      "Customer",
+	entity.MessageRole[entity.Assistant]: "Assistant",
+	entity.MessageRole[entity.Human]:     "Support agent",
+}
+
+func (s *steps) fetchTicket(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+	var p Payload
+	if err := json.Unmarshal(ec.Step.Input, &p); err != nil {
+		ec.Logger().Error("csr: fetch_ticket invalid input", engine.Err(err))
+		return engine.StepResult{Success: false}, engine.Permanent(err)
+	}
+	ticket := Ticket{
+		ID: p.TicketID, Channel: p.ReplyChannel(), AgentID: p.AgentID, CustomerEmail: p.CustomerEmail,
+		Subject: p.Subject, Message: p.Message,
+	}
+	if p.ConversationID == "" {
+		if ticket.Message == "" {
+			ticket.Message = p.Subject
+		}
+		if ticket.Message == "" {
+			return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("ticket has no message"))
+		}
+		ticket.Transcript = transcriptRoles[entity.MessageRole[entity.User]] + ": " + ticket.Message
+	} else if err := s.readConversation(ctx, p.ConversationID, &ticket); err != nil {
+		ec.Logger().Error("csr: fetch_ticket conversation error", engine.Err(err), engine.F("conversation_id", p.ConversationID))
+		return engine.StepResult{Success: false}, err
+	}
+	out, _ := json.Marshal(map[string]interface{}{"ticket": ticket})
+	return engine.StepResult{Success: true, Output: out}, nil
+}
+
+// readConversation fills t from the conversation's history. Payload fields
+// already set on t take precedence.
+func (s *steps) readConversation(ctx context.Context, id string, t *Ticket) error {
+	if s.deps.Conversations == nil {
+		return engine.Permanent(fmt.Errorf("conversation store not configured"))
+	}
+	conv, err := s.deps.Conversations.GetConversation(id)
+	if err != nil {
+		return notFoundIsPermanent(err)
+	}
+	messages, err := s.deps.Conversations.GetMessages(id)
+	if err != nil {
+		return err
+	}
+	if t.ID == "" {
+		t.ID = conv.Id
+	}
+	if t.AgentID == "" {
+		t.AgentID = conv.AgentId
+	}
+	if t.Subject == "" {
+		t.Subject = conv.Title
+	}
+	t.EscalationReason = conv.EscalationReason
+
+	if t.Message == "" {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == entity.MessageRole[entity.User] {
+				t.Message = messages[i].Text
+				break
+			}
+		}
+	}
+	if t.Message == "" {
+		return engine.Permanent(fmt.Errorf("conversation %s has no customer message", id))
+	}
+
+	if len(messages) > maxTranscriptMessages {
+		messages = messages[len(messages)-maxTranscriptMessages:]
+	}
+	lines := make([]string, 0, len(messages))
+	for _, m := range messages {
+		role, ok := transcriptRoles[m.Role]
+		if !ok {
+			role = m.Role
+		}
+		lines = append(lines, role+": "+m.Text)
+	}
+	t.Transcript = strings.Join(lines, "\n")
+	return nil
+}
+
+// notFoundIsPermanent stops retries of lookups that cannot succeed.
+func notFoundIsPermanent(err error) error {
+	var appErr *appErrors.AppError
+	if errors.As(err, &appErr) && appErr.Type == appErrors.NotFoundError {
+		return engine.Permanent(err)
+	}
+	return err
+}
+
+type retrieveInput struct {
+	Query   string `json:"query"`
+	AgentID string `json:"agent_id"`
+}
+
+// retrieveContext searches the agent's knowledge base. Its output adds
+// confidence, the best chunk score, to the RAG response.
+func (s *steps) retrieveContext(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+	// If a RAG service is available, call it with the provided query.
+	if s.deps.RAG != nil {
+		var in retrieveInput
+		_ = json.Unmarshal(ec.Step.Input, &in)
+		// fallback: if query empty, use raw input as string
+		if in.Query == "" {
+			in.Query = string(ec.Step.Input)
+		}
+		ragQuery := entity.RAGQuery{Query: in.Query, AgentID: in.AgentID, TopK: 5, Threshold: retrievalThreshold}
+		resp, err := s.deps.RAG.QueryContext(ctx, ragQuery)
+		if err != nil {
+			ec.Logger().Error("csr: rag query error", engine.Err(err))
+			return engine.StepResult{Success: false}, err
+		}
+		var confidence float32
+		for _, c := range resp.Chunks {
+			if c.Score > confidence {
+				confidence = c.Score
+			}
+		}
+		out, _ := json.Marshal(struct {
+			*entity.RAGResponse
+			Confidence float32 `json:"confidence"`
+		}{resp, confidence})
+		return engine.StepResult{Success: true, Output: out}, nil
+	}
+	// Return a simple context object when RAG is not configured.
+	out, _ := json.Marshal(map[string]interface{}{"context": "no_additional_context_available", "confidence": 0})
+	return engine.StepResult{Success: true, Output: out}, nil
+}
+
+type draftInput struct {
+	Prompt     string   `json:"prompt"`
+	AgentID    string   `json:"agent_id"`
+	Confidence *float64 `json:"confidence"`
+}
+
+// draftResponse drafts a reply with the agent's model. Drafts backed by a
+// retrieval confidence below Deps.MinConfidence, or by none, need review.
+func (s *steps) draftResponse(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+	var in draftInput
+	if err := json.Unmarshal(ec.Step.Input, &in); err != nil {
+		ec.Logger().Error("csr: draft_response invalid input", engine.Err(err))
+		return engine.StepResult{Success: false}, engine.Permanent(err)
+	}
+	draft := "(llm disabled)"
+	if s.deps.LLM != nil {
+		resp, err := s.deps.LLM(ctx, ec.Step.Input)
+		if err != nil {
+			ec.Logger().Error("csr: llm draft error", engine.Err(err))
+			return engine.StepResult{Success: false}, err
+		}
+		draft = resp
+	}
+	var confidence float64
+	if in.Confidence != nil {
+		confidence = *in.Confidence
+	}
+	minConfidence := s.deps.MinConfidence
+	if minConfidence == 0 {
+		minConfidence = DefaultMinConfidence
+	}
+	needsReview := confidence < minConfidence || strings.TrimSpace(draft) == ""
+	if needsReview {
+		ec.Logger().Info("csr: draft needs review", engine.F("confidence", confidence), engine.F("min_confidence", minConfidence))
+	}
+	out, _ := json.Marshal(map[string]interface{}{"draft": draft, "confidence": confidence, "needs_review": needsReview})
+	return engine.StepResult{Success: true, Output: out}, nil
+}
+
+func (s *steps) humanReview(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+	if s.deps.Store == nil {
+		return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("state store not configured"))
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(ec.Step.Input, &payload); err != nil {
+		ec.Logger().Error("csr: human_review invalid input", engine.Err(err))
+		return engine.StepResult{Success: false}, engine.Permanent(err)
+	}
+	// Notify the reviewer, if any, by enqueueing an email; the review is
+	// listed in the approvals inbox either way.
+	if payload["agent_email"] != "" {
+		body := "A draft response requires your review.\n\nTicket: " + payload["ticket_id"] + "\n\nDraft:\n" + payload["draft"]
+		b, _ := json.Marshal(outbox.EmailPayload{To: payload["agent_email"], Subject: "CSR Review Required", Body: body})
+		key := ec.IdempotencyKey("review_email")
+		ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: outbox.EventEmailSend, Payload: b, State: "pending", Published: false, IdempotencyKey: &key}
+		if err := s.deps.Store.EnqueueEvent(ctx, ev); err != nil {
+			ec.Logger().Error("csr: enqueue human review email error", engine.Err(err))
+			return engine.StepResult{Success: false}, err
+		}
+	}
+	// park the step until the reviewer approves, edits or rejects the draft
+	wait := &engine.WaitSpec{Reason: "approve csr draft", OnTimeout: s.deps.ReviewTimeoutAction, EscalateTo: s.deps.EscalateTo}
+	if s.deps.ReviewTimeout > 0 {
+		deadline := engine.Now().Add(s.deps.ReviewTimeout)
+		wait.TimeoutSeconds = int(s.deps.ReviewTimeout.Seconds())
+		wait.Deadline = &deadline
+	}
+	return engine.StepResult{Success: true, Wait: wait}, nil
+}
+
+type replyInput struct {
+	// Channel is ChannelEmail (the default) or ChannelConversation.
+	Channel        string `json:"channel"`
+	ConversationID string `json:"conversation_id"`
+	To             string `json:"to"`
+	Subject        string `json:"subject"`
+	Body           string `json:"body"`
+	HTML           string `json:"html"`
+	// Role is the message role of a conversation reply; see ReplyPayload.
+	Role string `json:"role"`
+}
+
+// sendResponse enqueues the reply as an outbox event for the channel: an
+// email_send event, or a conversation.reply event stored in the
+// conversation by ReplyPublisher.
+func (s *steps) sendResponse(ctx context.Context, ec engine.ExecutionContext) (engine.StepResult, error) {
+	if s.deps.Store == nil {
+		return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("no store configured for executor"))
+	}
+	var in replyInput
+	if err := json.Unmarshal(ec.Step.Input, &in); err != nil {
+		ec.Logger().Error("csr: invalid send_response input", engine.Err(err))
+		return engine.StepResult{Success: false}, engine.Permanent(err)
+	}
+	var (
+		eventType string
+		payload   interface{}
+	)
+	switch in.Channel {
+	case "", ChannelEmail:
+		eventType = outbox.EventEmailSend
+		payload = outbox.EmailPayload{To: in.To, Subject: in.Subject, Body: in.Body, HTML: in.HTML}
+	case ChannelConversation:
+		if in.ConversationID == "" {
+			return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("conversation reply has no conversation_id"))
+		}
+		eventType = EventConversationReply
+		payload = ReplyPayload{ConversationID: in.ConversationID, Body: in.Body, RunID: ec.Step.RunID, Role: in.Role}
+	default:
+		return engine.StepResult{Success: false}, engine.Permanent(fmt.Errorf("unknown reply channel %q", in.Channel))
+	}
+	// a re-run after a crash or requeue must not send the reply twice
+	key := ec.IdempotencyKey("reply")
+	prev, err := s.deps.Store.LoadEventByKey(ctx, key)
+	if err != nil {
+		ec.Logger().Error("csr: load previous reply error", engine.Err(err))
+		return engine.StepResult{Success: false}, err
+	}
+	if prev != nil {
+		out, _ := json.Marshal(map[string]interface{}{"enqueued": false, "event_id": prev.ID, "state": prev.State})
+		return engine.StepResult{Success: true, Output: out}, nil
+	}
+	p, _ := json.Marshal(payload)
+	ev := &engine.OutboxEvent{ID: uuid.NewString(), EventType: eventType, Payload: p, State: "pending", Published: false, IdempotencyKey: &key}
+	if err := s.deps.Store.EnqueueEvent(ctx, ev); err != nil {
+		ec.Logger().Error("csr: enqueue outbox error", engine.Err(err))
+		return engine.StepResult{Success: false}, err
+	}
+	out, _ := json.Marshal(map[string]interface{}{"enqueued": true, "event_id": ev.ID})
+	return engine.StepResult{Success: true, Output: out}, nil
+}
@@ -0,0 +1,259 @@
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpinesboltltd/boltz-ai/internal/engine"
+	"github.com/alpinesboltltd/boltz-ai/internal/entity"
+	appErrors "github.com/alpinesboltltd/boltz-ai/internal/errors"
+)
+
+// outboxStore keeps outbox events in memory with the unique idempotency key
+// semantics of PostgresStore.EnqueueEvent.
+type outboxStore struct {
+	engine.StateStore
+	mu     sync.Mutex
+	events []*engine.OutboxEvent
+}
+
+func (s *outboxStore) EnqueueEvent(ctx context.Context, ev *engine.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if ev.IdempotencyKey != nil && e.IdempotencyKey != nil && *e.IdempotencyKey == *ev.IdempotencyKey {
+			return nil
+		}
+	}
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *outboxStore) LoadEventByKey(ctx context.Context, key string) (*engine.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.IdempotencyKey != nil && *e.IdempotencyKey == key {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func sendResponseStep() *engine.WorkflowStepRecord {
+	input, _ := json.Marshal(map[string]string{"to": "c@example.com", "subject": "Re: Refund", "body": "Hello"})
+	return &engine.WorkflowStepRecord{ID: "s5", RunID: "r1", StepName: StepSendResponse, Seq: 5, Status: engine.StepStatusInProgress, Input: input}
+}
+
+// A worker that dies after enqueueing the reply never records the step as
+// completed; the requeued attempt must not enqueue a second email.
+func TestSendResponseSurvivesWorkerCrash(t *testing.T) {
+	store := &outboxStore{}
+	s := &steps{deps: Deps{Store: store}}
+	step := sendResponseStep()
+
+	if _, err := s.sendResponse(context.Background(), engine.ExecutionContext{Step: step}); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	// the worker is killed here; RequeueStaleSteps hands the step to another worker
+	step.Attempts++
+	res, err := s.sendResponse(context.Background(), engine.ExecutionContext{Step: step})
+	if err != nil {
+		t.Fatalf("requeued attempt: %v", err)
+	}
+
+	if len(store.events) != 1 {
+		t.Fatalf("expected 1 outbox event, got %d", len(store.events))
+	}
+	var out struct {
+		Enqueued bool   `json:"enqueued"`
+		EventID  string `json:"event_id"`
+	}
+	_ = json.Unmarshal(res.Output, &out)
+	if out.Enqueued || out.EventID != store.events[0].ID {
+		t.Fatalf("requeued attempt output = %s, want the original event", res.Output)
+	}
+}
+
+// A slow worker that is presumed dead keeps running while the requeued copy
+// of its step starts; both finishing must still yield one email.
+func TestSendResponseConcurrentAttempts(t *testing.T) {
+	store := &outboxStore{}
+	s := &steps{deps: Deps{Store: store}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.sendResponse(context.Background(), engine.ExecutionContext{Step: sendResponseStep()}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(store.events) != 1 {
+		t.Fatalf("expected 1 outbox event, got %d", len(store.events))
+	}
+}
+
+// conversationStore keeps conversations in memory, ignoring messages whose ID
+// exists like ConversationRepository.CreateMessage.
+type conversationStore struct {
+	mu            sync.Mutex
+	conversations map[string]*entity.Conversation
+	messages      []entity.Message
+}
+
+func newConversationStore(conv *entity.Conversation, texts ...string) *conversationStore {
+	s := &conversationStore{conversations: map[string]*entity.Conversation{conv.Id: conv}}
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i, text := range texts {
+		// messages alternate between the customer and the assistant
+		role := entity.MessageRole[entity.User]
+		if i%2 == 1 {
+			role = entity.MessageRole[entity.Assistant]
+		}
+		s.messages = append(s.messages, entity.Message{
+			Id: conv.Id + "-" + strconv.Itoa(i), ConversationId: conv.Id, Role: role, Text: text,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return s
+}
+
+func (s *conversationStore) GetConversation(id string) (*entity.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[id]
+	if !ok {
+		return nil, appErrors.NewNotFoundError("Conversation not found")
+	}
+	return conv, nil
+}
+
+func (s *conversationStore) GetMessages(conversationID string) ([]entity.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []entity.Message
+	for _, m := range s.messages {
+		if m.ConversationId == conversationID {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (s *conversationStore) CreateMessage(msg *entity.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if m.Id == msg.Id {
+			return nil
+		}
+	}
+	s.messages = append(s.messages, *msg)
+	return nil
+}
+
+func escalated() *entity.Conversation {
+	return &entity.Conversation{Id: "c-1", AgentId: "a1", Platform: "web", Title: "Late delivery", EscalatedToHuman: true, EscalationReason: "customer asked for a person"}
+}
+
+func fetch(t *testing.T, s *steps, p Payload) (Ticket, error) {
+	t.Helper()
+	input, _ := json.Marshal(p)
+	res, err := s.fetchTicket(context.Background(), engine.ExecutionContext{Step: &engine.WorkflowStepRecord{StepName: StepFetchTicket, Input: input}})
+	var out struct {
+		Ticket Ticket `json:"ticket"`
+	}
+	_ = json.Unmarshal(res.Output, &out)
+	return out.Ticket, err
+}
+
+func TestFetchTicketReadsConversation(t *testing.T) {
+	s := &steps{deps: Deps{Conversations: newConversationStore(escalated(),
+		"My parcel is late", "It should arrive tomorrow.", "It still has not arrived, I want a refund")}}
+
+	ticket, err := fetch(t, s, Payload{ConversationID: "c-1"})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	want := Ticket{
+		ID: "c-1", Channel: ChannelConversation, AgentID: "a1", Subject: "Late delivery",
+		Message:          "It still has not arrived, I want a refund",
+		Transcript:       "Customer: My parcel is late\nAssistant: It should arrive tomorrow.\nCustomer: It still has not arrived, I want a refund",
+		EscalationReason: "customer asked for a person",
+	}
+	if ticket != want {
+		t.Fatalf("ticket = %+v\nwant %+v", ticket, want)
+	}
+
+	for name, p := range map[string]Payload{"unknown conversation": {ConversationID: "c-2"}, "empty email": {TicketID: "T-1"}} {
+		if _, err := fetch(t, s, p); err == nil {
+			t.Errorf("%s: no error", name)
+		} else if _, retryable := engine.ClassifyError(err); retryable {
+			t.Errorf("%s: %v is retried", name, err)
+		}
+	}
+}
+
+func TestDraftResponseFlagsLowConfidence(t *testing.T) {
+	s := &steps{deps: Deps{
+		MinConfidence: 0.7,
+		LLM:           func(ctx context.Context, input []byte) (string, error) { return "Hello", nil },
+	}}
+	for input, want := range map[string]bool{
+		`{"prompt":"p","agent_id":"a1","confidence":0.9}`: false,
+		`{"prompt":"p","agent_id":"a1","confidence":0.5}`: true,
+		`{"prompt":"p","agent_id":"a1"}`:                  true,
+	} {
+		res, err := s.draftResponse(context.Background(), engine.ExecutionContext{Step: &engine.WorkflowStepRecord{Input: json.RawMessage(input)}})
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		var out struct {
+			Draft       string `json:"draft"`
+			NeedsReview bool   `json:"needs_review"`
+		}
+		_ = json.Unmarshal(res.Output, &out)
+		if out.Draft != "Hello" || out.NeedsReview != want {
+			t.Errorf("%s: output = %s, want needs_review %v", input, res.Output, want)
+		}
+	}
+}
+
+func TestSendResponseRepliesInConversation(t *testing.T) {
+	store, conversations := &outboxStore{}, newConversationStore(escalated(), "My parcel is late")
+	s := &steps{deps: Deps{Store: store}}
+	input, _ := json.Marshal(map[string]string{"channel": ChannelConversation, "conversation_id": "c-1", "body": "A refund is on its way."})
+	step := &engine.WorkflowStepRecord{ID: "s5", RunID: "r1", StepName: StepSendResponse, Seq: 5, Status: engine.StepStatusInProgress, Input: input}
+	if _, err := s.sendResponse(context.Background(), engine.ExecutionContext{Step: step}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(store.events) != 1 || store.events[0].EventType != EventConversationReply {
+		t.Fatalf("events = %v", store.events)
+	}
+
+	// the outbox may publish the reply twice; it is stored once
+	publisher := ReplyPublisher(conversations)
+	for i := 0; i < 2; i++ {
+		if err := publisher.Publish(context.Background(), store.events[0]); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	messages, _ := conversations.GetMessages("c-1")
+	if len(messages) != 2 {
+		t.Fatalf("conversation has %d messages, want the reply once", len(messages))
+	}
+	reply := messages[1]
+	if reply.Role != entity.MessageRole[entity.Assistant] || !strings.Contains(reply.Text, "refund") || reply.Id != store.events[0].ID {
+		t.Fatalf("reply = %+v", reply)
+	}
+}